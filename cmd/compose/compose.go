@@ -62,6 +62,8 @@ const (
 	ComposeRemoveOrphans = "COMPOSE_REMOVE_ORPHANS"
 	// ComposeIgnoreOrphans ignore "orphaned" containers
 	ComposeIgnoreOrphans = "COMPOSE_IGNORE_ORPHANS"
+	// ComposeWatchInitialSync sync all watched files once watch is started
+	ComposeWatchInitialSync = "COMPOSE_WATCH_INITIAL_SYNC"
 )
 
 // Command defines a compose CLI command as a func with args
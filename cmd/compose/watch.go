@@ -24,12 +24,18 @@ import (
 	"github.com/docker/compose/v2/internal/locker"
 
 	"github.com/docker/compose/v2/pkg/api"
+	"github.com/docker/compose/v2/pkg/utils"
 	"github.com/spf13/cobra"
 )
 
 type watchOptions struct {
 	*ProjectOptions
-	quiet bool
+	quiet       bool
+	initialSync bool
+	json        bool
+	index       int
+	containerID string
+	errorPolicy string
 }
 
 func watchCommand(p *ProjectOptions, backend api.Service) *cobra.Command {
@@ -49,6 +55,13 @@ func watchCommand(p *ProjectOptions, backend api.Service) *cobra.Command {
 	}
 
 	cmd.Flags().BoolVar(&opts.quiet, "quiet", false, "hide build output")
+	initialSync := utils.StringToBool(os.Getenv(ComposeWatchInitialSync))
+	cmd.Flags().BoolVar(&opts.initialSync, "initial-sync", initialSync, "sync all watched files when starting to watch")
+	cmd.Flags().BoolVar(&opts.json, "json", false, "write sync and rebuild messages as JSON")
+	cmd.Flags().IntVar(&opts.index, "index", 0, "index of the container to sync into and run commands in, if service has multiple replicas")
+	cmd.Flags().StringVar(&opts.containerID, "container-id", "", "ID of the container to sync into and run commands in, if service has multiple replicas")
+	cmd.Flags().StringVar(&opts.errorPolicy, "error-policy", string(api.WatchErrorPolicyFailFast),
+		`"fail-fast" stops watching all the services on an error, "isolate" only stops watching the failing service`)
 	return cmd
 }
 
@@ -67,5 +80,11 @@ func runWatch(ctx context.Context, backend api.Service, opts watchOptions, servi
 		return fmt.Errorf("cannot take exclusive lock for project %q: %v", project.Name, err)
 	}
 
-	return backend.Watch(ctx, project, services, api.WatchOptions{})
+	return backend.Watch(ctx, project, services, api.WatchOptions{
+		InitialSync: opts.initialSync,
+		JSON:        opts.json,
+		Index:       opts.index,
+		ContainerID: opts.containerID,
+		ErrorPolicy: api.WatchErrorPolicy(opts.errorPolicy),
+	})
 }
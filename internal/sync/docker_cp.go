@@ -21,6 +21,8 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/compose-spec/compose-go/types"
 	"github.com/docker/compose/v2/pkg/api"
@@ -39,6 +41,8 @@ type DockerCopy struct {
 	projectName string
 
 	infoWriter io.Writer
+
+	stats *Stats
 }
 
 var _ Syncer = &DockerCopy{}
@@ -51,6 +55,12 @@ func NewDockerCopy(projectName string, client ComposeClient, infoWriter io.Write
 	}
 }
 
+// WithStats records the files and bytes synced in stats.
+func (d *DockerCopy) WithStats(stats *Stats) *DockerCopy {
+	d.stats = stats
+	return d
+}
+
 func (d *DockerCopy) Sync(ctx context.Context, service types.ServiceConfig, paths []PathMapping) error {
 	var errs []error
 	for i := range paths {
@@ -67,6 +77,21 @@ func (d *DockerCopy) sync(ctx context.Context, service types.ServiceConfig, path
 		scale = int(*service.Deploy.Replicas)
 	}
 
+	if pathMapping.Deleted() {
+		for i := 1; i <= scale; i++ {
+			_, err := d.client.Exec(ctx, d.projectName, api.RunOptions{
+				Service: service.Name,
+				Command: []string{"rm", "-rf", pathMapping.ContainerPath},
+				Index:   i,
+			})
+			if err != nil {
+				logrus.Warnf("failed to delete %q from %s: %v", pathMapping.ContainerPath, service.Name, err)
+			}
+		}
+		fmt.Fprintf(d.infoWriter, "%s deleted from service\n", pathMapping.ContainerPath)
+		return nil
+	}
+
 	if fi, statErr := os.Stat(pathMapping.HostPath); statErr == nil {
 		if fi.IsDir() {
 			for i := 1; i <= scale; i++ {
@@ -79,29 +104,112 @@ func (d *DockerCopy) sync(ctx context.Context, service types.ServiceConfig, path
 					logrus.Warnf("failed to create %q from %s: %v", pathMapping.ContainerPath, service.Name, err)
 				}
 			}
+			if err := d.chown(ctx, service, scale, pathMapping.Chown, pathMapping.ContainerPath); err != nil {
+				return err
+			}
 			fmt.Fprintf(d.infoWriter, "%s created\n", pathMapping.ContainerPath)
 		} else {
+			// `docker cp` doesn't create the parent directories of the destination
+			if dirs := parentDirs([]PathMapping{pathMapping}); len(dirs) != 0 {
+				for i := 1; i <= scale; i++ {
+					_, err := d.client.Exec(ctx, d.projectName, api.RunOptions{
+						Service: service.Name,
+						Command: append([]string{"mkdir", "-p"}, dirs...),
+						Index:   i,
+					})
+					if err != nil {
+						logrus.Warnf("failed to create %q in %s: %v", dirs[0], service.Name, err)
+					}
+				}
+			}
+			source := pathMapping.HostPath
+			if pathMapping.Filter != "" {
+				filtered, err := filteredCopy(ctx, pathMapping, fi.Mode())
+				if err != nil {
+					return err
+				}
+				defer os.Remove(filtered) //nolint:errcheck
+				source = filtered
+			}
+			destination, target := pathMapping.ContainerPath, pathMapping.ContainerPath
+			if strings.HasSuffix(target, "/") {
+				target += filepath.Base(pathMapping.HostPath)
+			}
+			// written is the path the file is copied to in the container
+			written := target
+			if pathMapping.Atomic {
+				destination = atomicTmpPath(target)
+				written = destination
+			}
 			err := d.client.Copy(ctx, d.projectName, api.CopyOptions{
-				Source:      pathMapping.HostPath,
-				Destination: fmt.Sprintf("%s:%s", service.Name, pathMapping.ContainerPath),
+				Source:      source,
+				Destination: fmt.Sprintf("%s:%s", service.Name, destination),
 			})
 			if err != nil {
 				return err
 			}
+			// the file is owned before being moved into place
+			if err := d.chown(ctx, service, scale, pathMapping.Chown, written); err != nil {
+				return err
+			}
+			if pathMapping.Atomic {
+				for i := 1; i <= scale; i++ {
+					_, err := d.client.Exec(ctx, d.projectName, api.RunOptions{
+						Service: service.Name,
+						Command: []string{"mv", "-f", destination, target},
+						Index:   i,
+					})
+					if err != nil {
+						return fmt.Errorf("moving %q into place in %s: %w", pathMapping.ContainerPath, service.Name, err)
+					}
+				}
+			}
+			if copied, err := os.Stat(source); err == nil {
+				d.stats.add(1, copied.Size())
+			}
 			fmt.Fprintf(d.infoWriter, "%s updated\n", pathMapping.ContainerPath)
 		}
-	} else if errors.Is(statErr, fs.ErrNotExist) {
-		for i := 1; i <= scale; i++ {
-			_, err := d.client.Exec(ctx, d.projectName, api.RunOptions{
-				Service: service.Name,
-				Command: []string{"rm", "-rf", pathMapping.ContainerPath},
-				Index:   i,
-			})
-			if err != nil {
-				logrus.Warnf("failed to delete %q from %s: %v", pathMapping.ContainerPath, service.Name, err)
-			}
+	}
+	return nil
+}
+
+// chown changes the owner of the container path in each replica of the service, if an owner is set.
+func (d *DockerCopy) chown(ctx context.Context, service types.ServiceConfig, scale int, owner string, containerPath string) error {
+	if owner == "" {
+		return nil
+	}
+	for i := 1; i <= scale; i++ {
+		_, err := d.client.Exec(ctx, d.projectName, api.RunOptions{
+			Service: service.Name,
+			Command: []string{"chown", "-h", owner, containerPath},
+			Index:   i,
+		})
+		if err != nil {
+			return fmt.Errorf("changing the owner of %q in %s: %w", containerPath, service.Name, err)
 		}
-		fmt.Fprintf(d.infoWriter, "%s deleted from service\n", pathMapping.ContainerPath)
 	}
 	return nil
 }
+
+// filteredCopy writes the filtered content of the mapped host file to a temporary file and
+// returns its path. The caller is responsible for removing it.
+func filteredCopy(ctx context.Context, pathMapping PathMapping, mode fs.FileMode) (string, error) {
+	content, err := filterFile(ctx, pathMapping.Filter, pathMapping.HostPath)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "compose-watch-filter-*")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close() //nolint:errcheck
+	if _, err := f.Write(content); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Chmod(mode.Perm()); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
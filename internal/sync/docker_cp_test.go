@@ -0,0 +1,99 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sync
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/compose-spec/compose-go/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/docker/compose/v2/pkg/api"
+)
+
+type fakeComposeClient struct {
+	// calls are the commands run and the destinations copied to, in order
+	calls [][]string
+}
+
+func (f *fakeComposeClient) Exec(_ context.Context, _ string, options api.RunOptions) (int, error) {
+	f.calls = append(f.calls, options.Command)
+	return 0, nil
+}
+
+func (f *fakeComposeClient) Copy(_ context.Context, _ string, options api.CopyOptions) error {
+	f.calls = append(f.calls, []string{"cp", options.Destination})
+	return nil
+}
+
+func TestDockerCopy_ParentDirs(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("debug: true"), 0o644))
+
+	client := &fakeComposeClient{}
+	err := NewDockerCopy("project", client, io.Discard).Sync(context.Background(), types.ServiceConfig{Name: "web"}, []PathMapping{
+		{HostPath: filepath.Join(dir, "config.yaml"), ContainerPath: "/etc/app/config.yaml"},
+		{HostPath: filepath.Join(dir, "config.yaml"), ContainerPath: "/config.yaml"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"mkdir", "-p", "/etc/app"},
+		{"cp", "web:/etc/app/config.yaml"},
+		{"cp", "web:/config.yaml"},
+	}, client.calls)
+}
+
+func TestDockerCopy_Atomic(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("debug: true"), 0o644))
+
+	client := &fakeComposeClient{}
+	err := NewDockerCopy("project", client, io.Discard).Sync(context.Background(), types.ServiceConfig{Name: "web"}, []PathMapping{
+		{HostPath: filepath.Join(dir, "config.yaml"), ContainerPath: "/config.yaml", Atomic: true},
+		{HostPath: filepath.Join(dir, "config.yaml"), ContainerPath: "/etc/", Atomic: true},
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"cp", "web:/.config.yaml.compose-sync"},
+		{"mv", "-f", "/.config.yaml.compose-sync", "/config.yaml"},
+		{"mkdir", "-p", "/etc"},
+		{"cp", "web:/etc/.config.yaml.compose-sync"},
+		{"mv", "-f", "/etc/.config.yaml.compose-sync", "/etc/config.yaml"},
+	}, client.calls)
+}
+
+func TestDockerCopy_Chown(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("debug: true"), 0o644))
+
+	client := &fakeComposeClient{}
+	err := NewDockerCopy("project", client, io.Discard).Sync(context.Background(), types.ServiceConfig{Name: "web"}, []PathMapping{
+		{HostPath: filepath.Join(dir, "config.yaml"), ContainerPath: "/etc/", Chown: "node:node"},
+		{HostPath: filepath.Join(dir, "config.yaml"), ContainerPath: "/app.yaml", Chown: "1000", Atomic: true},
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"mkdir", "-p", "/etc"},
+		{"cp", "web:/etc/"},
+		{"chown", "-h", "node:node", "/etc/config.yaml"},
+		{"cp", "web:/.app.yaml.compose-sync"},
+		{"chown", "-h", "1000", "/.app.yaml.compose-sync"},
+		{"mv", "-f", "/.app.yaml.compose-sync", "/app.yaml"},
+	}, client.calls)
+}
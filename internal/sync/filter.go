@@ -0,0 +1,81 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/mattn/go-shellwords"
+)
+
+// ValidateFilter checks the filter command line can be parsed and its executable
+// is available on the host.
+func ValidateFilter(command string) error {
+	args, err := parseFilter(command)
+	if err != nil {
+		return err
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return fmt.Errorf("filter command %q: %w", command, err)
+	}
+	return nil
+}
+
+func parseFilter(command string) ([]string, error) {
+	args, err := shellwords.Parse(command)
+	if err != nil {
+		return nil, fmt.Errorf("parsing filter command %q: %w", command, err)
+	}
+	if len(args) == 0 {
+		return nil, errors.New("filter command is empty")
+	}
+	return args, nil
+}
+
+// filterFile runs the filter command on the host with the content of the file at
+// hostPath as stdin, and returns what the command wrote to stdout. The command is
+// killed if ctx is done.
+func filterFile(ctx context.Context, command string, hostPath string) ([]byte, error) {
+	args, err := parseFilter(command)
+	if err != nil {
+		return nil, err
+	}
+	in, err := os.Open(hostPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = in
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("filtering %q: %w: %s", hostPath, err, msg)
+		}
+		return nil, fmt.Errorf("filtering %q: %w", hostPath, err)
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,87 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sync
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveFilteredPath(t *testing.T) {
+	if err := ValidateFilter("tr a-z A-Z"); err != nil {
+		t.Skipf("tr not available: %v", err)
+	}
+	hostPath := filepath.Join(t.TempDir(), "config.txt")
+	require.NoError(t, os.WriteFile(hostPath, []byte("host=localhost\n"), 0o644))
+
+	var buf bytes.Buffer
+	ab := NewArchiveBuilder(&buf)
+	require.NoError(t, ab.ArchivePathsIfExist(context.Background(), []PathMapping{
+		{HostPath: hostPath, ContainerPath: "/app/config.txt", Filter: "tr a-z A-Z"},
+	}))
+	require.NoError(t, ab.Close())
+
+	tr := tar.NewReader(&buf)
+	header, err := tr.Next()
+	require.NoError(t, err)
+	require.Equal(t, "app/config.txt", header.Name)
+	content, err := io.ReadAll(tr)
+	require.NoError(t, err)
+	require.Equal(t, "HOST=LOCALHOST\n", string(content))
+	require.Equal(t, int64(len(content)), header.Size)
+
+	// the source file is left untouched
+	raw, err := os.ReadFile(hostPath)
+	require.NoError(t, err)
+	require.Equal(t, "host=localhost\n", string(raw))
+}
+
+func TestFilterErrors(t *testing.T) {
+	require.ErrorContains(t, ValidateFilter(""), "filter command is empty")
+	require.ErrorContains(t, ValidateFilter("'unterminated"), "parsing filter command")
+	require.Error(t, ValidateFilter("this-command-does-not-exist-anywhere"))
+
+	if err := ValidateFilter("false"); err != nil {
+		t.Skipf("false not available: %v", err)
+	}
+	hostPath := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(hostPath, []byte("content"), 0o644))
+	_, err := filterFile(context.Background(), "false", hostPath)
+	require.ErrorContains(t, err, "filtering")
+}
+
+func TestFilterCancelled(t *testing.T) {
+	if err := ValidateFilter("sleep 60"); err != nil {
+		t.Skipf("sleep not available: %v", err)
+	}
+	hostPath := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(hostPath, []byte("content"), 0o644))
+
+	// a hung filter doesn't block the sync once it's cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := filterFile(ctx, "sleep 60", hostPath)
+	require.ErrorContains(t, err, "filtering")
+	require.Less(t, time.Since(start), 10*time.Second)
+}
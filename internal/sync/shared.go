@@ -16,8 +16,18 @@ package sync
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync/atomic"
 
 	"github.com/compose-spec/compose-go/types"
+
+	"github.com/docker/compose/v2/pkg/watch"
 )
 
 // PathMapping contains the Compose service and modified host system path.
@@ -35,8 +45,119 @@ type PathMapping struct {
 	//	- /workdir/main.go
 	//  - /workdir/subdir
 	ContainerPath string
+	// Filter is an optional command run on the host for each synced file: the
+	// file content is passed on stdin and the command output is synced instead.
+	Filter string
+	// PreserveMode carries the mode bits of the host file through to the container
+	// as-is: the umask of the container user isn't applied when extracting the file,
+	// and Windows hosts keep the mode of the file rather than the 0755 default.
+	PreserveMode bool
+	// Kind of change made to HostPath, a deleted path (recursively for a directory)
+	// is removed from the container.
+	Kind watch.FileEventKind
+	// Atomic writes each file to a temporary path next to ContainerPath and renames it once
+	// fully written, so that the container never observes a partially written file.
+	Atomic bool
+	// Chown is the owner of the synced files in the container, as `user[:group]` with numeric
+	// IDs or names. The files keep the ownership set by the sync backend if empty.
+	Chown string
+}
+
+// Deleted returns true if the host path was reported as deleted, or no longer exists
+// as a change to it may have been missed.
+func (p PathMapping) Deleted() bool {
+	if p.Kind == watch.FileDeleted {
+		return true
+	}
+	_, err := os.Stat(p.HostPath)
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// parentDir returns the container directory the host path is written into, a container path
+// ending with a slash being the directory to write the file into.
+func (p PathMapping) parentDir() string {
+	if strings.HasSuffix(p.ContainerPath, "/") {
+		return path.Clean(p.ContainerPath)
+	}
+	return path.Dir(p.ContainerPath)
+}
+
+// parentDirs returns the container directories the host paths are written into, to be created
+// if they don't exist yet.
+func parentDirs(paths []PathMapping) []string {
+	var dirs []string
+	seen := map[string]bool{}
+	for _, p := range paths {
+		dir := p.parentDir()
+		if dir == "/" || dir == "." || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// ownerPattern matches a numeric ID or a user or group name as accepted by useradd
+var ownerPattern = regexp.MustCompile(`^([0-9]+|[a-zA-Z_][a-zA-Z0-9_.-]*\$?)$`)
+
+// ValidateChown checks the owner is a user, optionally followed by a group, like `1000:1000`
+// or `node:node`.
+func ValidateChown(owner string) error {
+	user, group, hasGroup := strings.Cut(owner, ":")
+	if !ownerPattern.MatchString(user) || (hasGroup && !ownerPattern.MatchString(group)) {
+		return fmt.Errorf("invalid owner %q, must be user[:group] with IDs or names", owner)
+	}
+	return nil
+}
+
+// chownCmds returns the commands changing the owner of the container paths, grouped by owner.
+// Symlinks are changed rather than their target.
+func chownCmds(owners []string, paths map[string][]string) [][]string {
+	cmds := make([][]string, 0, len(owners))
+	for _, owner := range owners {
+		cmds = append(cmds, append([]string{"chown", "-h", owner}, paths[owner]...))
+	}
+	return cmds
 }
 
 type Syncer interface {
 	Sync(ctx context.Context, service types.ServiceConfig, paths []PathMapping) error
 }
+
+// Stats counts the files and bytes synced into the containers, it's safe for concurrent use.
+//
+// A nil Stats doesn't count anything.
+type Stats struct {
+	files atomic.Int64
+	bytes atomic.Int64
+}
+
+// Files returns the number of regular files synced
+func (s *Stats) Files() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.files.Load()
+}
+
+// Bytes returns the number of bytes sent to the containers: the size of the tar stream, or of
+// the copied files with `docker cp`
+func (s *Stats) Bytes() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.bytes.Load()
+}
+
+func (s *Stats) add(files int, bytes int64) {
+	if s == nil {
+		return
+	}
+	s.files.Add(int64(files))
+	s.bytes.Add(bytes)
+}
+
+// ErrNoContainers is returned by a Syncer when the service has no running container to sync
+// the changes into.
+var ErrNoContainers = errors.New("no running containers")
@@ -23,24 +23,31 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 
 	"github.com/hashicorp/go-multierror"
-	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 
 	"github.com/compose-spec/compose-go/types"
 	moby "github.com/docker/docker/api/types"
 	"github.com/docker/docker/pkg/archive"
+
+	"github.com/docker/compose/v2/pkg/watch"
 )
 
 type archiveEntry struct {
 	path   string
 	info   os.FileInfo
 	header *tar.Header
+	filter string
+	// target is the final path in the container of a file written to a temporary path, the
+	// header name, to be renamed once fully written
+	target string
+	// chown is the owner the entry is set to once extracted
+	chown string
 }
 
 type LowLevelClient interface {
@@ -49,10 +56,19 @@ type LowLevelClient interface {
 	Exec(ctx context.Context, containerID string, cmd []string, in io.Reader) error
 }
 
+// VolumeHelper runs commands in a short-lived container mounting named volumes of a service.
+type VolumeHelper interface {
+	RunWithVolumes(ctx context.Context, service types.ServiceConfig, volumes []types.ServiceVolumeConfig, cmd []string, in io.Reader) error
+}
+
 type Tar struct {
 	client LowLevelClient
 
 	projectName string
+
+	volumeHelper VolumeHelper
+
+	stats *Stats
 }
 
 var _ Syncer = &Tar{}
@@ -64,66 +80,314 @@ func NewTar(projectName string, client LowLevelClient) *Tar {
 	}
 }
 
+// WithVolumeHelper enables syncing into the named volumes of services with no running
+// container, so changes are present when the service next starts.
+func (t *Tar) WithVolumeHelper(helper VolumeHelper) *Tar {
+	t.volumeHelper = helper
+	return t
+}
+
+// WithStats records the files and bytes synced in stats.
+func (t *Tar) WithStats(stats *Stats) *Tar {
+	t.stats = stats
+	return t
+}
+
 func (t *Tar) Sync(ctx context.Context, service types.ServiceConfig, paths []PathMapping) error {
 	containers, err := t.client.ContainersForService(ctx, t.projectName, service.Name)
 	if err != nil {
 		return err
 	}
 
-	var pathsToCopy []PathMapping
+	var pathsToCopy, pathsToChmod []PathMapping
 	var pathsToDelete []string
 	for _, p := range paths {
-		if _, err := os.Stat(p.HostPath); err != nil && errors.Is(err, fs.ErrNotExist) {
+		switch {
+		case p.Deleted():
 			pathsToDelete = append(pathsToDelete, p.ContainerPath)
-		} else {
+		case p.Kind == watch.FileChmod:
+			pathsToChmod = append(pathsToChmod, p)
+		default:
 			pathsToCopy = append(pathsToCopy, p)
 		}
 	}
 
+	if len(containers) == 0 {
+		if t.volumeHelper != nil {
+			// the helper container is short-lived, the files are copied again with their mode
+			return t.syncVolumes(ctx, service, append(pathsToCopy, pathsToChmod...), pathsToDelete)
+		}
+		return fmt.Errorf("service %s: %w", service.Name, ErrNoContainers)
+	}
+
 	var deleteCmd []string
 	if len(pathsToDelete) != 0 {
 		deleteCmd = append([]string{"rm", "-rf"}, pathsToDelete...)
 	}
-	copyCmd := []string{"tar", "-v", "-C", "/", "-x", "-f", "-"}
+	chmodCmds, err := chmodCommands(pathsToChmod)
+	if err != nil {
+		return err
+	}
 
+	// a batch of mode changes only leaves the content of the files as is
+	copyFiles := len(pathsToCopy) != 0 || len(pathsToChmod) == 0
+	// the parent directories of the synced paths are created first, e.g. for a target which
+	// doesn't exist in the image, rather than relying on tar to create them
+	var mkdirCmd []string
+	if dirs := parentDirs(pathsToCopy); len(dirs) != 0 {
+		mkdirCmd = append([]string{"mkdir", "-p"}, dirs...)
+	}
+
+	// archived is complete once the archive is extracted, i.e. its end was read by the containers
+	var archived archiveResult
 	var eg multierror.Group
-	writers := make([]*io.PipeWriter, len(containers))
+	writers := make([]*io.PipeWriter, 0, len(containers))
 	for i := range containers {
 		containerID := containers[i].ID
-		r, w := io.Pipe()
-		writers[i] = w
+		var r *io.PipeReader
+		if copyFiles {
+			var w *io.PipeWriter
+			r, w = io.Pipe()
+			writers = append(writers, w)
+		}
 		eg.Go(func() error {
 			if len(deleteCmd) != 0 {
 				if err := t.client.Exec(ctx, containerID, deleteCmd, nil); err != nil {
 					return fmt.Errorf("deleting paths in %s: %w", containerID, err)
 				}
 			}
-			if err := t.client.Exec(ctx, containerID, copyCmd, r); err != nil {
+			for _, cmd := range chmodCmds {
+				if err := t.client.Exec(ctx, containerID, cmd, nil); err != nil {
+					return fmt.Errorf("changing the mode of paths in %s: %w", containerID, err)
+				}
+			}
+			if !copyFiles {
+				return nil
+			}
+			if len(mkdirCmd) != 0 {
+				if err := t.client.Exec(ctx, containerID, mkdirCmd, nil); err != nil {
+					// the pipe is no longer read, the archive is written to the other containers
+					_ = r.CloseWithError(err)
+					return fmt.Errorf("creating parent directories in %s: %w", containerID, err)
+				}
+			}
+			if err := t.client.Exec(ctx, containerID, extractCmd(pathsToCopy), r); err != nil {
 				return fmt.Errorf("copying files to %s: %w", containerID, err)
 			}
+			// the archive is complete once extracted, the files are owned before being moved
+			// into place
+			for _, cmd := range chownCmds(archived.owners, archived.owned) {
+				if err := t.client.Exec(ctx, containerID, cmd, nil); err != nil {
+					return fmt.Errorf("changing the owner of paths in %s: %w", containerID, err)
+				}
+			}
+			if cmd := renameCmd(archived.renames); cmd != nil {
+				if err := t.client.Exec(ctx, containerID, cmd, nil); err != nil {
+					return fmt.Errorf("moving files into place in %s: %w", containerID, err)
+				}
+			}
 			return nil
 		})
 	}
+	if !copyFiles {
+		return eg.Wait().ErrorOrNil()
+	}
 
 	multiWriter := newLossyMultiWriter(writers...)
-	tarReader := tarArchive(pathsToCopy)
+	tarReader := tarArchive(ctx, pathsToCopy, &archived)
 	defer func() {
 		_ = tarReader.Close()
 		multiWriter.Close()
 	}()
-	_, err = io.Copy(multiWriter, tarReader)
+	n, err := io.Copy(multiWriter, tarReader)
 	if err != nil {
 		return err
 	}
 	multiWriter.Close()
 
-	return eg.Wait().ErrorOrNil()
+	if err := eg.Wait().ErrorOrNil(); err != nil {
+		return err
+	}
+	t.stats.add(archived.files, n)
+	return nil
+}
+
+var copyCmd = []string{"tar", "-v", "-C", "/", "-x", "-f", "-"}
+
+// preserveModeCopyCmd extracts the archive with the mode of the archived files as-is, rather
+// than with the umask of the container user applied to it
+var preserveModeCopyCmd = []string{"tar", "-v", "-C", "/", "-x", "-p", "-f", "-"}
+
+// extractCmd returns the command extracting the archive of paths in the container.
+func extractCmd(paths []PathMapping) []string {
+	for _, p := range paths {
+		if p.PreserveMode {
+			return preserveModeCopyCmd
+		}
+	}
+	return copyCmd
+}
+
+// atomicTmpPath returns the temporary path a file is written to before being renamed to p, in
+// the same directory so that the rename is atomic.
+func atomicTmpPath(p string) string {
+	dir, name := path.Split(p)
+	return dir + "." + name + ".compose-sync"
+}
+
+// renameCmd returns the command renaming the files written to temporary paths to their target
+// paths, nil if there are none. Each file is renamed once it's fully written, it requires a
+// shell in the container.
+func renameCmd(renames []atomicRename) []string {
+	if len(renames) == 0 {
+		return nil
+	}
+	cmd := []string{"sh", "-c", `while [ $# -gt 0 ]; do mv -f "$1" "$2" || exit 1; shift 2; done`, "sh"}
+	for _, r := range renames {
+		cmd = append(cmd, r.tmp, r.target)
+	}
+	return cmd
+}
+
+// chmodCommands returns the commands applying the current mode of the host paths to the
+// container paths, without copying their content again. The paths are grouped by mode.
+func chmodCommands(paths []PathMapping) ([][]string, error) {
+	var modes []int64
+	byMode := map[int64][]string{}
+	for _, p := range paths {
+		info, err := os.Stat(p.HostPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		// the mode the file would have if it was copied again in the archive
+		header, err := archive.FileInfoHeader(p.ContainerPath, info, "")
+		if err != nil {
+			return nil, err
+		}
+		mode := header.Mode
+		if p.PreserveMode {
+			mode = tarMode(info.Mode())
+		}
+		if _, ok := byMode[mode]; !ok {
+			modes = append(modes, mode)
+		}
+		byMode[mode] = append(byMode[mode], p.ContainerPath)
+	}
+	cmds := make([][]string, 0, len(modes))
+	for _, mode := range modes {
+		cmds = append(cmds, append([]string{"chmod", fmt.Sprintf("%04o", mode&0o7777)}, byMode[mode]...))
+	}
+	return cmds, nil
+}
+
+// syncVolumes applies the changes located in a named volume of the service through the volume helper.
+func (t *Tar) syncVolumes(ctx context.Context, service types.ServiceConfig, pathsToCopy []PathMapping, pathsToDelete []string) error {
+	var volumes []types.ServiceVolumeConfig
+	for _, v := range service.Volumes {
+		// anonymous volumes can't be mounted again by name
+		if v.Type == types.VolumeTypeVolume && v.Source != "" {
+			volumes = append(volumes, v)
+		}
+	}
+	inVolume := func(containerPath string) bool {
+		for _, v := range volumes {
+			if containerPath == v.Target || strings.HasPrefix(containerPath, strings.TrimSuffix(v.Target, "/")+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	var copies []PathMapping
+	for _, p := range pathsToCopy {
+		if inVolume(p.ContainerPath) {
+			// the helper only runs the extraction, the files are written in place
+			p.Atomic = false
+			copies = append(copies, p)
+		} else {
+			logrus.Debugf("%s is not in a named volume of stopped service %s, skipping", p.ContainerPath, service.Name)
+		}
+	}
+	var deletes []string
+	for _, p := range pathsToDelete {
+		if inVolume(p) {
+			deletes = append(deletes, p)
+		}
+	}
+
+	if len(deletes) != 0 {
+		if err := t.volumeHelper.RunWithVolumes(ctx, service, volumes, append([]string{"rm", "-rf"}, deletes...), nil); err != nil {
+			return fmt.Errorf("deleting paths in volumes of %s: %w", service.Name, err)
+		}
+	}
+	if len(copies) == 0 {
+		return nil
+	}
+	var archived archiveResult
+	tarReader := tarArchive(ctx, copies, &archived)
+	defer func() {
+		_ = tarReader.Close()
+	}()
+	counter := &countingReader{reader: tarReader}
+	if err := t.volumeHelper.RunWithVolumes(ctx, service, volumes, extractCmd(copies), counter); err != nil {
+		return fmt.Errorf("copying files to volumes of %s: %w", service.Name, err)
+	}
+	for _, cmd := range chownCmds(archived.owners, archived.owned) {
+		if err := t.volumeHelper.RunWithVolumes(ctx, service, volumes, cmd, nil); err != nil {
+			return fmt.Errorf("changing the owner of paths in volumes of %s: %w", service.Name, err)
+		}
+	}
+	if counter.eof {
+		// the archive is complete only if it was read to the end
+		t.stats.add(archived.files, counter.n)
+	}
+	return nil
+}
+
+// countingReader counts the bytes read from reader
+type countingReader struct {
+	reader io.Reader
+	n      int64
+	eof    bool
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
 }
 
 type ArchiveBuilder struct {
 	tw *tar.Writer
 	// A shared I/O buffer to help with file copying.
 	copyBuf *bytes.Buffer
+	// files is the number of regular files written to the archive
+	files int
+	// renames are the temporary paths of the regular files written to the archive and the paths
+	// they're renamed to once extracted
+	renames []atomicRename
+	// owned are the container paths written to the archive by owner, in the order of owners
+	owners []string
+	owned  map[string][]string
+}
+
+// atomicRename is a file extracted to a temporary path, to be renamed to its target path.
+type atomicRename struct {
+	tmp, target string
+}
+
+// archiveResult is what was written to an archive once it's complete
+type archiveResult struct {
+	files   int
+	renames []atomicRename
+	owners  []string
+	owned   map[string][]string
 }
 
 func NewArchiveBuilder(writer io.Writer) *ArchiveBuilder {
@@ -139,7 +403,7 @@ func (a *ArchiveBuilder) Close() error {
 }
 
 // ArchivePathsIfExist creates a tar archive of all local files in `paths`. It quietly skips any paths that don't exist.
-func (a *ArchiveBuilder) ArchivePathsIfExist(paths []PathMapping) error {
+func (a *ArchiveBuilder) ArchivePathsIfExist(ctx context.Context, paths []PathMapping) error {
 	// In order to handle overlapping syncs, we
 	// 1) collect all the entries,
 	// 2) de-dupe them, with last-one-wins semantics
@@ -152,7 +416,7 @@ func (a *ArchiveBuilder) ArchivePathsIfExist(paths []PathMapping) error {
 	// mappings work that we're not sure about.
 	var entries []archiveEntry
 	for _, p := range paths {
-		newEntries, err := a.entriesForPath(p.HostPath, p.ContainerPath)
+		newEntries, err := a.entriesForPath(p)
 		if err != nil {
 			return fmt.Errorf("inspecting %q: %w", p.HostPath, err)
 		}
@@ -162,7 +426,7 @@ func (a *ArchiveBuilder) ArchivePathsIfExist(paths []PathMapping) error {
 
 	entries = dedupeEntries(entries)
 	for _, entry := range entries {
-		err := a.writeEntry(entry)
+		err := a.writeEntry(ctx, entry)
 		if err != nil {
 			return fmt.Errorf("archiving %q: %w", entry.path, err)
 		}
@@ -170,7 +434,7 @@ func (a *ArchiveBuilder) ArchivePathsIfExist(paths []PathMapping) error {
 	return nil
 }
 
-func (a *ArchiveBuilder) writeEntry(entry archiveEntry) error {
+func (a *ArchiveBuilder) writeEntry(ctx context.Context, entry archiveEntry) error {
 	pathInTar := entry.path
 	header := entry.header
 
@@ -179,9 +443,14 @@ func (a *ArchiveBuilder) writeEntry(entry archiveEntry) error {
 		if err := a.tw.WriteHeader(header); err != nil {
 			return fmt.Errorf("writing %q header: %w", pathInTar, err)
 		}
+		a.own(entry)
 		return nil
 	}
 
+	if entry.filter != "" {
+		return a.writeFilteredEntry(ctx, entry)
+	}
+
 	file, err := os.Open(pathInTar)
 	if err != nil {
 		// In case the file has been deleted since we last looked at it.
@@ -241,13 +510,62 @@ func (a *ArchiveBuilder) writeEntry(entry archiveEntry) error {
 	if err := a.tw.Flush(); err != nil {
 		return fmt.Errorf("finalizing %q: %w", pathInTar, err)
 	}
+	a.fileWritten(entry)
+	return nil
+}
+
+func (a *ArchiveBuilder) fileWritten(entry archiveEntry) {
+	a.files++
+	if entry.target != "" {
+		a.renames = append(a.renames, atomicRename{tmp: "/" + entry.header.Name, target: "/" + entry.target})
+	}
+	a.own(entry)
+}
+
+// own records the container path of the written entry to be set to its owner, if any.
+func (a *ArchiveBuilder) own(entry archiveEntry) {
+	if entry.chown == "" {
+		return
+	}
+	if a.owned == nil {
+		a.owned = map[string][]string{}
+	}
+	if _, ok := a.owned[entry.chown]; !ok {
+		a.owners = append(a.owners, entry.chown)
+	}
+	a.owned[entry.chown] = append(a.owned[entry.chown], "/"+strings.TrimSuffix(entry.header.Name, "/"))
+}
+
+// writeFilteredEntry writes a regular file entry with the content transformed by the entry filter.
+func (a *ArchiveBuilder) writeFilteredEntry(ctx context.Context, entry archiveEntry) error {
+	content, err := filterFile(ctx, entry.filter, entry.path)
+	if err != nil {
+		// In case the file has been deleted since we last looked at it.
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	header := entry.header
+	header.Size = int64(len(content))
+	if err := a.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("writing %q header: %w", entry.path, err)
+	}
+	if _, err := a.tw.Write(content); err != nil {
+		return fmt.Errorf("copying %q: %w", entry.path, err)
+	}
+	if err := a.tw.Flush(); err != nil {
+		return fmt.Errorf("finalizing %q: %w", entry.path, err)
+	}
+	a.fileWritten(entry)
 	return nil
 }
 
 // tarPath writes the given source path into tarWriter at the given dest (recursively for directories).
 // e.g. tarring my_dir --> dest d: d/file_a, d/file_b
 // If source path does not exist, quietly skips it and returns no err
-func (a *ArchiveBuilder) entriesForPath(localPath, containerPath string) ([]archiveEntry, error) {
+func (a *ArchiveBuilder) entriesForPath(p PathMapping) ([]archiveEntry, error) {
+	localPath, containerPath := p.HostPath, p.ContainerPath
 	localInfo, err := os.Stat(localPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -271,6 +589,10 @@ func (a *ArchiveBuilder) entriesForPath(localPath, containerPath string) ([]arch
 		if err != nil {
 			return fmt.Errorf("walking %q: %w", curLocalPath, err)
 		}
+		if info.Mode()&(os.ModeNamedPipe|os.ModeSocket|os.ModeDevice|os.ModeIrregular) != 0 {
+			logrus.Debugf("skipping %s, special files (%s) aren't synced", curLocalPath, info.Mode().Type())
+			return nil
+		}
 
 		linkname := ""
 		if info.Mode()&os.ModeSymlink != 0 {
@@ -303,11 +625,22 @@ func (a *ArchiveBuilder) entriesForPath(localPath, containerPath string) ([]arch
 			// Mimic the Docker behavior and just skip the file.
 			return nil
 		}
+		if p.PreserveMode {
+			header.Mode = tarMode(info.Mode())
+		}
+		var target string
+		if p.Atomic && header.Typeflag == tar.TypeReg {
+			target = header.Name
+			header.Name = atomicTmpPath(header.Name)
+		}
 
 		result = append(result, archiveEntry{
 			path:   curLocalPath,
 			info:   info,
 			header: header,
+			filter: p.Filter,
+			target: target,
+			chown:  p.Chown,
 		})
 
 		return nil
@@ -318,11 +651,30 @@ func (a *ArchiveBuilder) entriesForPath(localPath, containerPath string) ([]arch
 	return result, nil
 }
 
-func tarArchive(ops []PathMapping) io.ReadCloser {
+// tarMode returns the tar header mode for the host file mode, keeping the permission
+// and the setuid, setgid and sticky bits as-is.
+func tarMode(mode os.FileMode) int64 {
+	m := int64(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= 0o4000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 0o2000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 0o1000
+	}
+	return m
+}
+
+func tarArchive(ctx context.Context, ops []PathMapping, result *archiveResult) io.ReadCloser {
 	pr, pw := io.Pipe()
 	go func() {
 		ab := NewArchiveBuilder(pw)
-		err := ab.ArchivePathsIfExist(ops)
+		err := ab.ArchivePathsIfExist(ctx, ops)
+		// set before closing the pipe, so it's complete once the archive is read
+		result.files, result.renames = ab.files, ab.renames
+		result.owners, result.owned = ab.owners, ab.owned
 		if err != nil {
 			_ = pw.CloseWithError(fmt.Errorf("adding files to tar: %w", err))
 		} else {
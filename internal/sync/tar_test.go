@@ -0,0 +1,387 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sync
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	gosync "sync"
+	"testing"
+
+	"github.com/compose-spec/compose-go/types"
+	moby "github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/docker/compose/v2/pkg/watch"
+)
+
+type fakeLowLevelClient struct {
+	containers []moby.Container
+	mu         gosync.Mutex
+	execs      map[string][][]string
+}
+
+func (f *fakeLowLevelClient) ContainersForService(context.Context, string, string) ([]moby.Container, error) {
+	return f.containers, nil
+}
+
+func (f *fakeLowLevelClient) Exec(_ context.Context, containerID string, cmd []string, in io.Reader) error {
+	f.mu.Lock()
+	if f.execs == nil {
+		f.execs = map[string][][]string{}
+	}
+	f.execs[containerID] = append(f.execs[containerID], cmd)
+	f.mu.Unlock()
+	if in != nil {
+		_, err := io.Copy(io.Discard, in)
+		return err
+	}
+	return nil
+}
+
+type helperRun struct {
+	volumes []types.ServiceVolumeConfig
+	cmd     []string
+	entries []string
+}
+
+type fakeVolumeHelper struct {
+	runs []helperRun
+}
+
+func (f *fakeVolumeHelper) RunWithVolumes(_ context.Context, _ types.ServiceConfig, volumes []types.ServiceVolumeConfig, cmd []string, in io.Reader) error {
+	run := helperRun{volumes: volumes, cmd: cmd}
+	if in != nil {
+		tr := tar.NewReader(in)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			run.entries = append(run.entries, header.Name)
+		}
+	}
+	f.runs = append(f.runs, run)
+	return nil
+}
+
+func TestTarSync_StoppedServiceVolume(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html/>"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0o644))
+
+	service := types.ServiceConfig{
+		Name: "web",
+		Volumes: []types.ServiceVolumeConfig{
+			{Type: types.VolumeTypeVolume, Source: "static", Target: "/var/www"},
+			{Type: types.VolumeTypeVolume, Target: "/anonymous"},
+		},
+	}
+	client := &fakeLowLevelClient{}
+	helper := &fakeVolumeHelper{}
+	syncer := NewTar("project", client).WithVolumeHelper(helper)
+
+	err := syncer.Sync(context.Background(), service, []PathMapping{
+		{HostPath: filepath.Join(dir, "index.html"), ContainerPath: "/var/www/index.html"},
+		// not in a volume, can't be synced while the service is stopped
+		{HostPath: filepath.Join(dir, "main.go"), ContainerPath: "/app/main.go"},
+		{HostPath: filepath.Join(dir, "deleted.html"), ContainerPath: "/var/www/deleted.html"},
+	})
+	require.NoError(t, err)
+	require.Empty(t, client.execs)
+
+	require.Len(t, helper.runs, 2)
+	require.Equal(t, []string{"rm", "-rf", "/var/www/deleted.html"}, helper.runs[0].cmd)
+	require.Equal(t, copyCmd, helper.runs[1].cmd)
+	require.Equal(t, []string{"var/www/index.html"}, helper.runs[1].entries)
+	for _, run := range helper.runs {
+		require.Equal(t, []types.ServiceVolumeConfig{service.Volumes[0]}, run.volumes)
+	}
+}
+
+func TestTarSync_NoContainers(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0o644))
+
+	client := &fakeLowLevelClient{}
+	syncer := NewTar("project", client)
+	err := syncer.Sync(context.Background(), types.ServiceConfig{Name: "web"}, []PathMapping{
+		{HostPath: filepath.Join(dir, "main.go"), ContainerPath: "/app/main.go"},
+	})
+	require.ErrorIs(t, err, ErrNoContainers)
+	require.EqualError(t, err, "service web: no running containers")
+	require.Empty(t, client.execs)
+}
+
+func TestTarSync_Deletions(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html/>"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "recreated.html"), []byte("<html/>"), 0o644))
+
+	client := &fakeLowLevelClient{containers: []moby.Container{{ID: "123"}}}
+	syncer := NewTar("project", client)
+	err := syncer.Sync(context.Background(), types.ServiceConfig{Name: "web"}, []PathMapping{
+		{HostPath: filepath.Join(dir, "index.html"), ContainerPath: "/var/www/index.html"},
+		// directory removed with its content
+		{HostPath: filepath.Join(dir, "assets"), ContainerPath: "/var/www/assets", Kind: watch.FileDeleted},
+		// removal missed by the watcher
+		{HostPath: filepath.Join(dir, "missing.html"), ContainerPath: "/var/www/missing.html"},
+		// the reported kind of change wins over the current state of the host
+		{HostPath: filepath.Join(dir, "recreated.html"), ContainerPath: "/var/www/recreated.html", Kind: watch.FileDeleted},
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"rm", "-rf", "/var/www/assets", "/var/www/missing.html", "/var/www/recreated.html"},
+		{"mkdir", "-p", "/var/www"},
+		copyCmd,
+	}, client.execs["123"])
+}
+
+func TestTarSync_ModeOnly(t *testing.T) {
+	dir := t.TempDir()
+	for name, mode := range map[string]os.FileMode{"run.sh": 0o755, "build.sh": 0o755, "secret.txt": 0o600, "main.go": 0o644} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644))
+		// not subject to the umask
+		require.NoError(t, os.Chmod(filepath.Join(dir, name), mode))
+	}
+
+	client := &fakeLowLevelClient{containers: []moby.Container{{ID: "123"}, {ID: "456"}}}
+	syncer := NewTar("project", client)
+	err := syncer.Sync(context.Background(), types.ServiceConfig{Name: "web"}, []PathMapping{
+		{HostPath: filepath.Join(dir, "run.sh"), ContainerPath: "/app/run.sh", Kind: watch.FileChmod},
+		{HostPath: filepath.Join(dir, "secret.txt"), ContainerPath: "/app/secret.txt", Kind: watch.FileChmod},
+		{HostPath: filepath.Join(dir, "build.sh"), ContainerPath: "/app/build.sh", Kind: watch.FileChmod},
+		// removed since its mode changed
+		{HostPath: filepath.Join(dir, "gone.sh"), ContainerPath: "/app/gone.sh", Kind: watch.FileChmod},
+	})
+	require.NoError(t, err)
+	for _, id := range []string{"123", "456"} {
+		require.Equal(t, [][]string{
+			{"rm", "-rf", "/app/gone.sh"},
+			{"chmod", "0755", "/app/run.sh", "/app/build.sh"},
+			{"chmod", "0600", "/app/secret.txt"},
+		}, client.execs[id], "the content of the files must not be copied again")
+	}
+
+	client = &fakeLowLevelClient{containers: []moby.Container{{ID: "123"}}}
+	err = NewTar("project", client).Sync(context.Background(), types.ServiceConfig{Name: "web"}, []PathMapping{
+		{HostPath: filepath.Join(dir, "run.sh"), ContainerPath: "/app/run.sh", Kind: watch.FileChmod},
+		{HostPath: filepath.Join(dir, "main.go"), ContainerPath: "/app/main.go"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"chmod", "0755", "/app/run.sh"},
+		{"mkdir", "-p", "/app"},
+		copyCmd,
+	}, client.execs["123"])
+}
+
+func TestTarSync_ParentDirs(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "assets", "img"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "assets", "img", "logo.png"), []byte("png"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("debug: true"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0o644))
+
+	client := &fakeLowLevelClient{containers: []moby.Container{{ID: "123"}, {ID: "456"}}}
+	err := NewTar("project", client).Sync(context.Background(), types.ServiceConfig{Name: "web"}, []PathMapping{
+		// a new directory in a directory which doesn't exist yet
+		{HostPath: filepath.Join(dir, "assets"), ContainerPath: "/srv/static/assets"},
+		// a file in a target directory which doesn't exist yet
+		{HostPath: filepath.Join(dir, "config.yaml"), ContainerPath: "/etc/app/"},
+		{HostPath: filepath.Join(dir, "main.go"), ContainerPath: "/main.go"},
+	})
+	require.NoError(t, err)
+	for _, id := range []string{"123", "456"} {
+		require.Equal(t, [][]string{
+			{"mkdir", "-p", "/srv/static", "/etc/app"},
+			copyCmd,
+		}, client.execs[id])
+	}
+}
+
+func TestTarSync_Stats(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src", "lib"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "lib", "util.go"), []byte("package lib"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html/>"), 0o644))
+	paths := []PathMapping{
+		{HostPath: filepath.Join(dir, "src"), ContainerPath: "/app/src"},
+		{HostPath: filepath.Join(dir, "index.html"), ContainerPath: "/app/index.html"},
+		{HostPath: filepath.Join(dir, "deleted.html"), ContainerPath: "/app/deleted.html"},
+	}
+	size, err := io.Copy(io.Discard, tarArchive(context.Background(), paths, new(archiveResult)))
+	require.NoError(t, err)
+
+	client := &fakeLowLevelClient{containers: []moby.Container{{ID: "123"}, {ID: "456"}}}
+	stats := &Stats{}
+	require.NoError(t, NewTar("project", client).WithStats(stats).Sync(context.Background(), types.ServiceConfig{Name: "web"}, paths))
+	require.Equal(t, int64(3), stats.Files())
+	// the tar stream is sent once to all the containers
+	require.Equal(t, size, stats.Bytes())
+
+	require.NoError(t, NewTar("project", client).WithStats(stats).Sync(context.Background(), types.ServiceConfig{Name: "web"}, paths[1:]))
+	require.Equal(t, int64(4), stats.Files())
+}
+
+func archiveHeaders(t *testing.T, paths []PathMapping) map[string]*tar.Header {
+	t.Helper()
+	tr := tar.NewReader(tarArchive(context.Background(), paths, new(archiveResult)))
+	headers := map[string]*tar.Header{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		headers[header.Name] = header
+	}
+	return headers
+}
+
+func TestTarSync_Atomic(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "assets"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "assets", "app.js"), []byte("app"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html/>"), 0o644))
+	paths := []PathMapping{
+		{HostPath: filepath.Join(dir, "assets"), ContainerPath: "/srv/assets", Atomic: true},
+		{HostPath: filepath.Join(dir, "index.html"), ContainerPath: "/srv/", Atomic: true},
+	}
+
+	headers := archiveHeaders(t, paths)
+	require.Contains(t, headers, "srv/assets/", "directories are written in place")
+	require.Contains(t, headers, "srv/assets/.app.js.compose-sync")
+	require.Contains(t, headers, "srv/.index.html.compose-sync")
+	require.NotContains(t, headers, "srv/assets/app.js", "files must not be partially written in place")
+	require.NotContains(t, headers, "srv/index.html")
+
+	client := &fakeLowLevelClient{containers: []moby.Container{{ID: "123"}}}
+	require.NoError(t, NewTar("project", client).Sync(context.Background(), types.ServiceConfig{Name: "web"}, paths))
+	require.Equal(t, [][]string{
+		{"mkdir", "-p", "/srv"},
+		copyCmd,
+		renameCmd([]atomicRename{
+			{tmp: "/srv/assets/.app.js.compose-sync", target: "/srv/assets/app.js"},
+			{tmp: "/srv/.index.html.compose-sync", target: "/srv/index.html"},
+		}),
+	}, client.execs["123"])
+	require.Equal(t, []string{"sh", "-c"}, client.execs["123"][2][:2])
+}
+
+func TestArchive_SkipsSpecialFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("FIFOs aren't supported on Windows")
+	}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0o644))
+	if err := exec.Command("mkfifo", filepath.Join(dir, "events.fifo")).Run(); err != nil {
+		t.Skipf("can't create a FIFO: %v", err)
+	}
+
+	headers := archiveHeaders(t, []PathMapping{{HostPath: dir, ContainerPath: "/app"}})
+	require.Contains(t, headers, "app/main.go")
+	require.NotContains(t, headers, "app/events.fifo")
+}
+
+func TestTarSync_Chown(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "assets"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "assets", "app.js"), []byte("app"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html/>"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0o644))
+
+	client := &fakeLowLevelClient{containers: []moby.Container{{ID: "123"}}}
+	err := NewTar("project", client).Sync(context.Background(), types.ServiceConfig{Name: "web"}, []PathMapping{
+		{HostPath: filepath.Join(dir, "assets"), ContainerPath: "/srv/assets", Chown: "node:node"},
+		{HostPath: filepath.Join(dir, "index.html"), ContainerPath: "/srv/", Chown: "1000:1000", Atomic: true},
+		{HostPath: filepath.Join(dir, "main.go"), ContainerPath: "/main.go"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"mkdir", "-p", "/srv"},
+		copyCmd,
+		{"chown", "-h", "node:node", "/srv/assets", "/srv/assets/app.js"},
+		// owned before being moved into place
+		{"chown", "-h", "1000:1000", "/srv/.index.html.compose-sync"},
+		renameCmd([]atomicRename{{tmp: "/srv/.index.html.compose-sync", target: "/srv/index.html"}}),
+	}, client.execs["123"])
+}
+
+func TestValidateChown(t *testing.T) {
+	for _, owner := range []string{"1000", "1000:1000", "node", "node:node", "www-data:101", "_apt"} {
+		require.NoError(t, ValidateChown(owner), owner)
+	}
+	for _, owner := range []string{"", ":1000", "1000:", "node:node:node", "no de", "-rf"} {
+		require.Error(t, ValidateChown(owner), owner)
+	}
+}
+
+func TestTarSync_PreserveMode(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "run.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\n"), 0o600))
+	require.NoError(t, os.Chmod(script, 0o755))
+	secret := filepath.Join(dir, "secret")
+	require.NoError(t, os.WriteFile(secret, []byte("s3cr3t"), 0o600))
+	require.NoError(t, os.Chmod(secret, 0o600))
+
+	for _, tc := range []struct {
+		name         string
+		preserveMode bool
+		expected     []string
+	}{
+		{name: "default", expected: copyCmd},
+		// extracted without the umask of the container user
+		{name: "preserve mode", preserveMode: true, expected: []string{"tar", "-v", "-C", "/", "-x", "-p", "-f", "-"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &fakeLowLevelClient{containers: []moby.Container{{ID: "123"}}}
+			err := NewTar("project", client).Sync(context.Background(), types.ServiceConfig{Name: "web"}, []PathMapping{
+				{HostPath: script, ContainerPath: "/app/run.sh", PreserveMode: tc.preserveMode},
+				{HostPath: secret, ContainerPath: "/app/secret"},
+			})
+			require.NoError(t, err)
+			require.Equal(t, [][]string{
+				{"mkdir", "-p", "/app"},
+				tc.expected,
+			}, client.execs["123"])
+		})
+	}
+
+	headers := archiveHeaders(t, []PathMapping{
+		{HostPath: script, ContainerPath: "/app/run.sh", PreserveMode: true},
+		{HostPath: secret, ContainerPath: "/app/secret", PreserveMode: true},
+	})
+	require.Contains(t, headers, "app/run.sh")
+	require.Equal(t, int64(0o755), headers["app/run.sh"].Mode)
+	require.Contains(t, headers, "app/secret")
+	require.Equal(t, int64(0o600), headers["app/secret"].Mode)
+}
+
+func TestTarMode(t *testing.T) {
+	require.Equal(t, int64(0o644), tarMode(0o644))
+	require.Equal(t, int64(0o4755), tarMode(0o755|os.ModeSetuid))
+	require.Equal(t, int64(0o3775), tarMode(0o775|os.ModeSetgid|os.ModeSticky))
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/compose-spec/compose-go/types"
@@ -82,6 +83,10 @@ type Service interface {
 	DryRunMode(ctx context.Context, dryRun bool) (context.Context, error)
 	// Watch services' development context and sync/notify/rebuild/restart on changes
 	Watch(ctx context.Context, project *types.Project, services []string, options WatchOptions) error
+	// ForceSync syncs all the files of the service watch triggers, regardless of the detected changes
+	ForceSync(ctx context.Context, project *types.Project, service string, options WatchOptions) error
+	// WatchStatus returns a snapshot of the active watch sessions
+	WatchStatus(ctx context.Context) ([]WatchSession, error)
 	// Viz generates a graphviz graph of the project services
 	Viz(ctx context.Context, project *types.Project, options VizOptions) (string, error)
 	// Wait blocks until at least one of the services' container exits
@@ -108,6 +113,330 @@ type VizOptions struct {
 
 // WatchOptions group options of the Watch API
 type WatchOptions struct {
+	// MaxDuration stops watching after the given duration, zero means no limit
+	MaxDuration time.Duration
+	// IdleTimeout stops watching a service once no changes have been handled for the given
+	// duration, Watch returns when all the services are stopped. Zero means no timeout
+	IdleTimeout time.Duration
+	// SyncStoppedVolumes syncs changes into the named volumes of a service with no running container,
+	// using a short-lived helper container
+	SyncStoppedVolumes bool
+	// EventHandler receives the watch lifecycle events, if set
+	EventHandler func(WatchEvent)
+	// DryRun prints the changes which would be synced and the services which would be rebuilt or
+	// restarted, without acting on them
+	DryRun bool
+	// MaxConcurrentRebuilds limits the number of services rebuilt at the same time, zero uses a
+	// default based on the number of CPUs and a negative value means no limit
+	MaxConcurrentRebuilds int
+	// Strict stops the whole watch session on any watcher error, by default recoverable errors are
+	// only reported and a service is no longer watched after a fatal error. Unknown keys in the
+	// x-develop sections are then errors as well, instead of warnings
+	Strict bool
+	// InitialSync syncs all the files of the sync triggers once the watcher is started, so that the
+	// containers match the host before any change is made
+	InitialSync bool
+	// JSON writes the sync and rebuild messages as newline-delimited JSON objects instead of text
+	JSON bool
+	// Index restricts the sync and exec actions to the container with this replica index, zero
+	// means all the containers of the service
+	Index int
+	// ContainerID restricts the sync and exec actions to the container with this ID or ID prefix,
+	// empty means all the containers of the service
+	ContainerID string
+	// IgnoreMatcher returns the matcher of additional paths to ignore for a service, on top of the
+	// ignore rules of the compose file and of `.dockerignore`. A nil matcher ignores nothing more
+	IgnoreMatcher func(service types.ServiceConfig) (WatchPathMatcher, error)
+	// Control pauses and resumes the handling of changes at runtime, if set
+	Control *WatchControl
+	// Syncer replaces the tar and `docker cp` sync backends, e.g. to sync to a remote host. The
+	// on_sync command of the service still runs after each successful sync
+	Syncer WatchSyncer
+	// Labels selects the services to watch by label, set as "key=value" or as "key" for any value.
+	// A service must have all of them, and be one of the services passed to Watch if any
+	Labels []string
+	// Debounce overrides the quiet period after a change before the batch of changes is
+	// processed, zero uses the debounce of the service `x-develop` section
+	Debounce time.Duration
+	// ErrorPolicy is how an error stopping the watch of a service affects the other services,
+	// defaults to WatchErrorPolicyFailFast
+	ErrorPolicy WatchErrorPolicy
+	// ContainerPathResolver rewrites the container path a changed host path of a service is synced
+	// to, as computed from the target of the watch rule, e.g. for language-specific layouts. The
+	// container paths are kept as is if nil
+	ContainerPathResolver func(service string, hostPath string, containerPath string) string
+}
+
+// WatchErrorPolicy is how an error stopping the watch of a service affects the watch session
+type WatchErrorPolicy string
+
+const (
+	// WatchErrorPolicyFailFast stops watching all the services on the first error
+	WatchErrorPolicyFailFast WatchErrorPolicy = "fail-fast"
+	// WatchErrorPolicyIsolate reports the error and only stops watching the failing service, Watch
+	// returns an error if all the services failed
+	WatchErrorPolicyIsolate WatchErrorPolicy = "isolate"
+)
+
+// Validate returns an error if the options can't be used to watch a project
+func (o WatchOptions) Validate() error {
+	for name, d := range map[string]time.Duration{
+		"max duration": o.MaxDuration,
+		"idle timeout": o.IdleTimeout,
+		"debounce":     o.Debounce,
+	} {
+		if d < 0 {
+			return fmt.Errorf("watch %s can't be negative: %s", name, d)
+		}
+	}
+	if o.Index < 0 {
+		return fmt.Errorf("watch container index can't be negative: %d", o.Index)
+	}
+	switch o.ErrorPolicy {
+	case "", WatchErrorPolicyFailFast:
+	case WatchErrorPolicyIsolate:
+		if o.Strict {
+			return fmt.Errorf("the %q watch error policy can't be used in strict mode", o.ErrorPolicy)
+		}
+	default:
+		return fmt.Errorf("invalid watch error policy %q, must be one of %q or %q",
+			o.ErrorPolicy, WatchErrorPolicyFailFast, WatchErrorPolicyIsolate)
+	}
+	return nil
+}
+
+// WatchOptionsBuilder sets up the WatchOptions of a watch session from code, the defaults
+// being the ones of `compose watch`.
+type WatchOptionsBuilder struct {
+	options WatchOptions
+}
+
+// NewWatchOptionsBuilder returns a builder of the default WatchOptions
+func NewWatchOptionsBuilder() *WatchOptionsBuilder {
+	return &WatchOptionsBuilder{}
+}
+
+// WithSyncer replaces the sync backends with syncer
+func (b *WatchOptionsBuilder) WithSyncer(syncer WatchSyncer) *WatchOptionsBuilder {
+	b.options.Syncer = syncer
+	return b
+}
+
+// WithDebounce overrides the debounce of the watched services, see WatchOptions.Debounce
+func (b *WatchOptionsBuilder) WithDebounce(d time.Duration) *WatchOptionsBuilder {
+	b.options.Debounce = d
+	return b
+}
+
+// WithInitialSync syncs all the files of the sync triggers once the watch is started
+func (b *WatchOptionsBuilder) WithInitialSync() *WatchOptionsBuilder {
+	b.options.InitialSync = true
+	return b
+}
+
+// WithIdleTimeout stops watching a service once no changes have been handled for d
+func (b *WatchOptionsBuilder) WithIdleTimeout(d time.Duration) *WatchOptionsBuilder {
+	b.options.IdleTimeout = d
+	return b
+}
+
+// WithMaxDuration stops watching after d
+func (b *WatchOptionsBuilder) WithMaxDuration(d time.Duration) *WatchOptionsBuilder {
+	b.options.MaxDuration = d
+	return b
+}
+
+// WithMaxConcurrentRebuilds limits the number of services rebuilt at the same time, a negative
+// value meaning no limit
+func (b *WatchOptionsBuilder) WithMaxConcurrentRebuilds(n int) *WatchOptionsBuilder {
+	b.options.MaxConcurrentRebuilds = n
+	return b
+}
+
+// WithEventHandler sends the watch lifecycle events to handler
+func (b *WatchOptionsBuilder) WithEventHandler(handler func(WatchEvent)) *WatchOptionsBuilder {
+	b.options.EventHandler = handler
+	return b
+}
+
+// WithLabels only watches the services with all the labels, see WatchOptions.Labels
+func (b *WatchOptionsBuilder) WithLabels(labels ...string) *WatchOptionsBuilder {
+	b.options.Labels = append(b.options.Labels, labels...)
+	return b
+}
+
+// WithDryRun prints the actions which would be applied to the changes instead of applying them
+func (b *WatchOptionsBuilder) WithDryRun() *WatchOptionsBuilder {
+	b.options.DryRun = true
+	return b
+}
+
+// WithErrorPolicy sets how an error of a service affects the others, see WatchOptions.ErrorPolicy
+func (b *WatchOptionsBuilder) WithErrorPolicy(policy WatchErrorPolicy) *WatchOptionsBuilder {
+	b.options.ErrorPolicy = policy
+	return b
+}
+
+// WithContainerPathResolver rewrites the container paths of the synced files with resolver, see
+// WatchOptions.ContainerPathResolver
+func (b *WatchOptionsBuilder) WithContainerPathResolver(resolver func(service string, hostPath string, containerPath string) string) *WatchOptionsBuilder {
+	b.options.ContainerPathResolver = resolver
+	return b
+}
+
+// Build returns the options, or an error if they're not valid
+func (b *WatchOptionsBuilder) Build() (WatchOptions, error) {
+	if err := b.options.Validate(); err != nil {
+		return WatchOptions{}, err
+	}
+	return b.options, nil
+}
+
+// WatchSyncer syncs the changed files of a service, see WatchOptions.Syncer
+type WatchSyncer interface {
+	Sync(ctx context.Context, service types.ServiceConfig, paths []WatchPathMapping) error
+}
+
+// WatchPathMatcher matches the host paths of a service, see WatchOptions.IgnoreMatcher
+type WatchPathMatcher interface {
+	Matches(path string) (bool, error)
+	// MatchesEntireDir is true if the matcher matches every path under the directory, which
+	// isn't walked then
+	MatchesEntireDir(path string) (bool, error)
+}
+
+// WatchPathMapping is a changed host path and the container path it's synced to
+type WatchPathMapping struct {
+	HostPath      string
+	ContainerPath string
+	// Deleted is true if the host path was deleted, recursively for a directory
+	Deleted bool
+	// Filter is the command of the watch rule transforming the content of the files, if any
+	Filter string
+	// PreserveMode is true if the watch rule syncs the mode bits of the host files
+	PreserveMode bool
+	// Atomic is true if the watch rule syncs each file to a temporary path renamed into place
+	Atomic bool
+	// Chown is the owner of the synced files set by the watch rule, as `user[:group]`, if any
+	Chown string
+}
+
+// WatchControl pauses and resumes a watch session at runtime. While paused, the changes are
+// buffered instead of being synced and the services aren't restarted or rebuilt. They are all
+// handled at once on resume, each changed path being handled once with its latest change.
+type WatchControl struct {
+	mu      sync.Mutex
+	paused  bool
+	resumed chan struct{}
+}
+
+// NewWatchControl returns a control for a watch session which isn't paused
+func NewWatchControl() *WatchControl {
+	return &WatchControl{}
+}
+
+// Pause buffers the changes until Resume is called
+func (c *WatchControl) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		c.paused = true
+		c.resumed = make(chan struct{})
+	}
+}
+
+// Resume handles the changes buffered while paused
+func (c *WatchControl) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		c.paused = false
+		close(c.resumed)
+	}
+}
+
+// Paused returns true while the watch session is paused, a nil control is never paused
+func (c *WatchControl) Paused() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// Resumed returns a channel closed on the next Resume, or an already closed one if not paused
+func (c *WatchControl) Resumed() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		resumed := make(chan struct{})
+		close(resumed)
+		return resumed
+	}
+	return c.resumed
+}
+
+// WatchEventType is the type of a watch lifecycle event
+type WatchEventType string
+
+const (
+	// WatchEventStarted is emitted once a service is being watched
+	WatchEventStarted WatchEventType = "started"
+	// WatchEventBatch is emitted when a batch of changes is received for a service
+	WatchEventBatch WatchEventType = "batch"
+	// WatchEventSyncStarted is emitted before changed files are synced
+	WatchEventSyncStarted WatchEventType = "sync-started"
+	// WatchEventSyncCompleted is emitted once changed files are synced
+	WatchEventSyncCompleted WatchEventType = "sync-completed"
+	// WatchEventRebuildStarted is emitted before a service is rebuilt
+	WatchEventRebuildStarted WatchEventType = "rebuild-started"
+	// WatchEventRebuildCompleted is emitted once a service is rebuilt
+	WatchEventRebuildCompleted WatchEventType = "rebuild-completed"
+	// WatchEventRestartStarted is emitted before a service is restarted
+	WatchEventRestartStarted WatchEventType = "restart-started"
+	// WatchEventRestartCompleted is emitted once a service is restarted
+	WatchEventRestartCompleted WatchEventType = "restart-completed"
+	// WatchEventExecStarted is emitted before the command of an exec trigger is run
+	WatchEventExecStarted WatchEventType = "exec-started"
+	// WatchEventExecCompleted is emitted once the command of an exec trigger has completed
+	WatchEventExecCompleted WatchEventType = "exec-completed"
+	// WatchEventError is emitted when handling changes failed
+	WatchEventError WatchEventType = "error"
+)
+
+// WatchEvent is a watch lifecycle event for a service
+type WatchEvent struct {
+	Type    WatchEventType
+	Service string
+	// Action is the watch action the event relates to, if any
+	Action string
+	// Paths are the affected host paths
+	Paths []string
+	// Err is set for error events, or completion events of failed operations
+	Err error
+}
+
+// WatchSession is the state of a service being watched
+type WatchSession struct {
+	Project string
+	Service string
+	// Paths are the host paths watched for the service
+	Paths []string
+	// Syncs, Execs, Rebuilds and Restarts count the batches of changes which triggered the action
+	Syncs    int
+	Execs    int
+	Rebuilds int
+	Restarts int
+	// Errors counts the batches of changes which failed to be applied
+	Errors int
+	// Batches counts the batches of changes handled
+	Batches int
+	// FilesSynced and BytesSynced count the regular files and bytes synced into the containers
+	FilesSynced int64
+	BytesSynced int64
+	// LastBatch is the time the last batch of changes was received, zero if none yet
+	LastBatch time.Time
 }
 
 // BuildOptions group options of the Build API
@@ -18,6 +18,7 @@ package api
 
 import (
 	"testing"
+	"time"
 
 	"github.com/compose-spec/compose-go/types"
 	"gotest.tools/v3/assert"
@@ -36,3 +37,72 @@ func TestRunOptionsEnvironmentMap(t *testing.T) {
 	assert.Equal(t, *env["ZOT"], "")
 	assert.Check(t, env["QIX"] == nil)
 }
+
+func TestWatchControl(t *testing.T) {
+	var none *WatchControl
+	assert.Check(t, !none.Paused())
+
+	control := NewWatchControl()
+	assert.Check(t, !control.Paused())
+	select {
+	case <-control.Resumed():
+	default:
+		t.Fatal("a watch which isn't paused is resumed")
+	}
+
+	control.Pause()
+	control.Pause()
+	assert.Check(t, control.Paused())
+	resumed := control.Resumed()
+	select {
+	case <-resumed:
+		t.Fatal("a paused watch isn't resumed")
+	default:
+	}
+
+	control.Resume()
+	control.Resume()
+	assert.Check(t, !control.Paused())
+	<-resumed
+}
+
+func TestWatchOptionsBuilder(t *testing.T) {
+	options, err := NewWatchOptionsBuilder().Build()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, options, WatchOptions{})
+
+	var events []WatchEvent
+	options, err = NewWatchOptionsBuilder().
+		WithDebounce(time.Second).
+		WithInitialSync().
+		WithIdleTimeout(10 * time.Minute).
+		WithMaxDuration(time.Hour).
+		WithMaxConcurrentRebuilds(2).
+		WithEventHandler(func(e WatchEvent) { events = append(events, e) }).
+		WithLabels("team=web").
+		WithLabels("dev").
+		Build()
+	assert.NilError(t, err)
+	assert.Equal(t, options.Debounce, time.Second)
+	assert.Check(t, options.InitialSync)
+	assert.Equal(t, options.IdleTimeout, 10*time.Minute)
+	assert.Equal(t, options.MaxDuration, time.Hour)
+	assert.Equal(t, options.MaxConcurrentRebuilds, 2)
+	assert.DeepEqual(t, options.Labels, []string{"team=web", "dev"})
+	options.EventHandler(WatchEvent{Type: WatchEventStarted})
+	assert.Equal(t, len(events), 1)
+
+	_, err = NewWatchOptionsBuilder().WithDebounce(-time.Second).Build()
+	assert.ErrorContains(t, err, "watch debounce can't be negative: -1s")
+	_, err = NewWatchOptionsBuilder().WithIdleTimeout(-time.Second).Build()
+	assert.ErrorContains(t, err, "watch idle timeout can't be negative")
+	assert.ErrorContains(t, WatchOptions{Index: -1}.Validate(), "watch container index can't be negative")
+
+	options, err = NewWatchOptionsBuilder().WithErrorPolicy(WatchErrorPolicyIsolate).Build()
+	assert.NilError(t, err)
+	assert.Equal(t, options.ErrorPolicy, WatchErrorPolicyIsolate)
+	_, err = NewWatchOptionsBuilder().WithErrorPolicy("ignore").Build()
+	assert.ErrorContains(t, err, `invalid watch error policy "ignore"`)
+	err = WatchOptions{ErrorPolicy: WatchErrorPolicyIsolate, Strict: true}.Validate()
+	assert.ErrorContains(t, err, "can't be used in strict mode")
+}
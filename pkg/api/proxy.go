@@ -51,6 +51,8 @@ type ServiceProxy struct {
 	PortFn               func(ctx context.Context, project string, service string, port uint16, options PortOptions) (string, int, error)
 	ImagesFn             func(ctx context.Context, projectName string, options ImagesOptions) ([]ImageSummary, error)
 	WatchFn              func(ctx context.Context, project *types.Project, services []string, options WatchOptions) error
+	ForceSyncFn          func(ctx context.Context, project *types.Project, service string, options WatchOptions) error
+	WatchStatusFn        func(ctx context.Context) ([]WatchSession, error)
 	MaxConcurrencyFn     func(parallel int)
 	DryRunModeFn         func(ctx context.Context, dryRun bool) (context.Context, error)
 	VizFn                func(ctx context.Context, project *types.Project, options VizOptions) (string, error)
@@ -93,6 +95,8 @@ func (s *ServiceProxy) WithService(service Service) *ServiceProxy {
 	s.PortFn = service.Port
 	s.ImagesFn = service.Images
 	s.WatchFn = service.Watch
+	s.ForceSyncFn = service.ForceSync
+	s.WatchStatusFn = service.WatchStatus
 	s.MaxConcurrencyFn = service.MaxConcurrency
 	s.DryRunModeFn = service.DryRunMode
 	s.VizFn = service.Viz
@@ -327,6 +331,22 @@ func (s *ServiceProxy) Watch(ctx context.Context, project *types.Project, servic
 	return s.WatchFn(ctx, project, services, options)
 }
 
+// ForceSync implements Service interface
+func (s *ServiceProxy) ForceSync(ctx context.Context, project *types.Project, service string, options WatchOptions) error {
+	if s.ForceSyncFn == nil {
+		return ErrNotImplemented
+	}
+	return s.ForceSyncFn(ctx, project, service, options)
+}
+
+// WatchStatus implements Service interface
+func (s *ServiceProxy) WatchStatus(ctx context.Context) ([]WatchSession, error) {
+	if s.WatchStatusFn == nil {
+		return nil, ErrNotImplemented
+	}
+	return s.WatchStatusFn(ctx)
+}
+
 // Viz implements Service interface
 func (s *ServiceProxy) Viz(ctx context.Context, project *types.Project, options VizOptions) (string, error) {
 	if s.VizFn == nil {
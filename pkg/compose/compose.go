@@ -63,6 +63,7 @@ func NewComposeService(dockerCli command.Cli) api.Service {
 		clock:          clockwork.NewRealClock(),
 		maxConcurrency: -1,
 		dryRun:         false,
+		watches:        &watchRegistry{},
 	}
 }
 
@@ -71,6 +72,9 @@ type composeService struct {
 	clock          clockwork.Clock
 	maxConcurrency int
 	dryRun         bool
+	watches        *watchRegistry
+	watchOut       watchOutputState
+	rebuilding     serviceLocks
 }
 
 func (s *composeService) apiClient() client.APIClient {
@@ -18,31 +18,149 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
 	"os"
-	"path"
-	"path/filepath"
-	"sort"
+	"os/exec"
 	"strconv"
 	"strings"
+	gosync "sync"
+	"syscall"
 	"time"
 
 	moby "github.com/docker/docker/api/types"
+	containerType "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/stdcopy"
 
 	"github.com/docker/compose/v2/internal/sync"
 
 	"github.com/compose-spec/compose-go/types"
+	"github.com/hashicorp/go-multierror"
 	"github.com/jonboulle/clockwork"
-	"github.com/mitchellh/mapstructure"
+	"github.com/mattn/go-shellwords"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 
 	"github.com/docker/compose/v2/pkg/api"
 	"github.com/docker/compose/v2/pkg/watch"
 )
 
+// DevelopmentConfig is the `x-develop` extension of a service. The `x-develop` extension of the
+// project sets defaults for all the services declaring `x-develop`, each setting of a service
+// replacing the one of the project.
 type DevelopmentConfig struct {
+	// Watch are the watch rules of the service, a change matching several rules (e.g. with
+	// overlapping paths) triggers all of them in declaration order, identical file events
+	// being only handled once
 	Watch []Trigger `json:"watch,omitempty"`
+	// IncludeHidden allows changes in dot-directories (e.g. `.cache`, `.vscode`)
+	// to be watched, they are ignored by default
+	IncludeHidden bool `json:"include_hidden,omitempty"`
+	// GitIgnore also ignores the paths matching the `.gitignore` file at the root of the
+	// build context, on top of `.dockerignore`
+	GitIgnore bool `json:"gitignore,omitempty"`
+	// IgnoreFiles are additional ignore files (e.g. `.composewatchignore`) using the `.dockerignore`
+	// syntax, their patterns are relative to the directory of the file. Relative paths are resolved
+	// from the project directory, or BasePath
+	IgnoreFiles []string `json:"ignore_files,omitempty"`
+	// BasePath is the directory the relative paths of the watch rules are resolved from instead of
+	// the project directory, e.g. the root of a monorepo the compose file is in a subfolder of. A
+	// relative base path is resolved from the project directory
+	BasePath string `json:"base_path,omitempty"`
+	// IncludeOnly filters the changes with the include, ignore and extensions rules of the
+	// triggers in the watcher itself, so that no event is delivered for the files under the
+	// watched paths none of the triggers handle (e.g. with a trigger only including "**/*.go")
+	IncludeOnly bool `json:"include_only,omitempty"`
+	// IncludeGit allows changes in the `.git` directory to be watched (e.g. for tools reading
+	// the current branch in the container), it's ignored by default
+	IncludeGit bool `json:"include_git,omitempty"`
+	// SkipRepeatedBatches skips a batch of changes identical to the previous one, including the
+	// content of the changed files (e.g. editors saving the same file twice)
+	SkipRepeatedBatches bool `json:"skip_repeated_batches,omitempty"`
+	// SkipUnchangedFiles doesn't sync the changed files with the same content as when they were
+	// last synced (e.g. format-on-save not changing anything), at the cost of hashing their content
+	SkipUnchangedFiles bool `json:"skip_unchanged_files,omitempty"`
+	// PersistSyncState saves the digests of the synced files, used by SkipUnchangedFiles, so that
+	// they're not synced again when watching again if they're left unchanged
+	PersistSyncState bool `json:"persist_sync_state,omitempty"`
+	// SyncBackend selects how files are synced into the service containers, overriding the
+	// `COMPOSE_EXPERIMENTAL_WATCH_TAR` environment variable
+	SyncBackend SyncBackend `json:"sync_backend,omitempty"`
+	// Debounce is the quiet period after a change before the batch of changes is processed,
+	// defaults to 500ms
+	Debounce time.Duration `json:"debounce,omitempty"`
+	// DebounceMaxWait is the longest changes wait for a quiet period, a batch is processed at
+	// least this often under a continuous stream of changes. Zero means no limit
+	DebounceMaxWait time.Duration `json:"debounce_max_wait,omitempty"`
+	// MaxBatchSize caps the number of changes handled at once (e.g. on a branch switch), zero
+	// means no limit
+	MaxBatchSize int `json:"max_batch_size,omitempty"`
+	// BatchOverflow is how changes exceeding MaxBatchSize are handled, defaults to "flush"
+	BatchOverflow BatchOverflow `json:"batch_overflow,omitempty"`
+	// OnSync is a command run on the host, from the project directory, once changed files are
+	// synced. The synced host paths are passed as arguments
+	OnSync string `json:"on_sync,omitempty"`
+	// OnSyncRequired fails the sync when the OnSync command fails, applying the on_error policy
+	// of the triggers, a failure is only reported by default
+	OnSyncRequired bool `json:"on_sync_required,omitempty"`
+
+	// unknownKeys are the keys of the x-develop section which aren't part of the config, most
+	// likely typos, e.g. "watch[0].actoin"
+	unknownKeys []string
+}
+
+// BatchOverflow is how a batch of changes exceeding the max batch size is handled
+type BatchOverflow string
+
+const (
+	// BatchOverflowFlush handles the changes in batches of the max size
+	BatchOverflowFlush BatchOverflow = "flush"
+	// BatchOverflowRebuild rebuilds the service once instead of handling each change
+	BatchOverflowRebuild BatchOverflow = "rebuild"
+)
+
+// SyncBackend is the implementation used to sync files into a container
+type SyncBackend string
+
+const (
+	// SyncBackendTar streams a tar archive to the container, it requires `tar` in the container
+	SyncBackendTar SyncBackend = "tar"
+	// SyncBackendCopy uses the equivalent of `docker cp`, which also works for containers without a shell
+	SyncBackendCopy SyncBackend = "cp"
+)
+
+// requiresBuild returns true if a trigger rebuilds the service, sync and restart actions
+// apply to services using a prebuilt image as well.
+func (c DevelopmentConfig) requiresBuild() bool {
+	if c.MaxBatchSize > 0 && c.BatchOverflow == BatchOverflowRebuild {
+		return true
+	}
+	for _, trigger := range c.Watch {
+		if trigger.Action == string(WatchActionRebuild) && len(trigger.RebuildTarget) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// debounceMaxSize returns the size at which batches are flushed early, zero when the changes
+// exceeding the max batch size trigger a rebuild instead.
+func (c DevelopmentConfig) debounceMaxSize() int {
+	if c.BatchOverflow == BatchOverflowRebuild {
+		return 0
+	}
+	return c.MaxBatchSize
+}
+
+// quietPeriod returns the debounce duration configured for the service, or the default one.
+func (c DevelopmentConfig) quietPeriod() time.Duration {
+	if c.Debounce > 0 {
+		return c.Debounce
+	}
+	return quietPeriod
 }
 
 type WatchAction string
@@ -50,50 +168,398 @@ type WatchAction string
 const (
 	WatchActionSync    WatchAction = "sync"
 	WatchActionRebuild WatchAction = "rebuild"
+	WatchActionRestart WatchAction = "restart"
+	WatchActionExec    WatchAction = "exec"
+	// WatchActionSyncRestart syncs the changed files, then restarts the service containers once
+	// per batch, after all the files have been synced
+	WatchActionSyncRestart WatchAction = "sync+restart"
+	// WatchActionExtract copies the content of the container path Target to the host path Path
+	// when it changes, the reverse of sync
+	WatchActionExtract WatchAction = "extract"
+)
+
+// syncsFiles returns true for the actions copying the changed files into the containers.
+func (a WatchAction) syncsFiles() bool {
+	return a == WatchActionSync || a == WatchActionSyncRestart
+}
+
+// WatchOnError is the policy applied when the action of a watch trigger fails.
+type WatchOnError string
+
+const (
+	// WatchOnErrorContinue reports the error and keeps watching
+	WatchOnErrorContinue WatchOnError = "continue"
+	// WatchOnErrorStop stops watching
+	WatchOnErrorStop WatchOnError = "stop"
+	// WatchOnErrorRestart restarts the service and keeps watching
+	WatchOnErrorRestart WatchOnError = "restart"
 )
 
 type Trigger struct {
-	Path   string   `json:"path,omitempty"`
-	Action string   `json:"action,omitempty"`
-	Target string   `json:"target,omitempty"`
+	// Name identifies the trigger in the logs, e.g. when several triggers watch the same paths
+	Name   string `json:"name,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Action string `json:"action,omitempty"`
+	// Actions are several actions applied to the changes of Path, they can also be set as a list
+	// for `action`. They run in the order of a batch: sync, exec, then restart or rebuild
+	Actions []string `json:"actions,omitempty"`
+	// Target is the container path to sync to, a relative path is resolved against the service
+	// working_dir. When Path is a file, a target ending with a slash is the directory to sync it into
+	Target string `json:"target,omitempty"`
+	// Targets are the container paths to sync to when the same sources are used in several
+	// locations, they can also be set as a list for `target`
+	Targets []string `json:"targets,omitempty"`
+	// Ignore patterns are relative to Path, a leading "/" anchoring the pattern to Path like
+	// `.dockerignore` patterns are anchored to the build context. A directory name at any depth
+	// is ignored with a "**/" prefix, e.g. "**/__pycache__"
 	Ignore []string `json:"ignore,omitempty"`
+	// Include restricts the trigger to the paths matching one of the patterns (relative to Path),
+	// a glob pattern set as Path is split into its non-glob prefix and an include pattern
+	Include []string `json:"include,omitempty"`
+	// Extensions restricts the trigger to the files with one of the extensions (e.g. ".go"), as a
+	// simpler alternative to Include patterns. Directories don't have an extension, so changes to
+	// them are ignored
+	Extensions []string `json:"extensions,omitempty"`
+	// Filter is a command run on the host to transform the content of each file before it's synced
+	Filter string `json:"filter,omitempty"`
+	// PreserveMode syncs the files with the mode bits of the host files, only supported by the tar sync backend
+	PreserveMode bool `json:"preserve_mode,omitempty"`
+	// Atomic syncs each file to a temporary path next to its target and renames it into place
+	// once fully written, for services watching their own files. The tar sync backend requires a
+	// shell in the containers to do so
+	Atomic bool `json:"atomic,omitempty"`
+	// Chown is the owner of the synced files in the containers, as `user[:group]` with numeric IDs
+	// or names, e.g. for a service not running as root. The files are owned by root if not set
+	Chown string `json:"chown,omitempty"`
+	// OnError is the policy applied when the trigger action fails, defaults to "continue"
+	OnError string `json:"on_error,omitempty"`
+	// Exec is the command run in the service containers by the exec action
+	Exec *TriggerExec `json:"exec,omitempty"`
+	// Force watches Path even if it's also bind mounted in the service containers, e.g. when the
+	// bind mount is too slow to reflect the changes
+	Force bool `json:"force,omitempty"`
+	// NoCache rebuilds the service image without using the build cache, only for the rebuild action
+	NoCache bool `json:"no_cache,omitempty"`
+	// Wait waits for the rebuilt services to be healthy, or running without a healthcheck, before
+	// handling the next changes, only for the rebuild action
+	Wait bool `json:"wait,omitempty"`
+	// WaitTimeout is how long to wait for the rebuilt services to be healthy, defaults to 1 minute
+	WaitTimeout time.Duration `json:"wait_timeout,omitempty"`
+	// RebuildTarget are the services rebuilt by the rebuild action instead of the watched
+	// service, e.g. when a shared library is watched by the service using it. It can be set as a
+	// single service name
+	RebuildTarget types.StringList `json:"rebuild_target,omitempty"`
+	// When restricts the rebuild action to the changes matching one of the patterns (relative to
+	// Path), e.g. "go.mod". The other changes are synced to the target, or ignored without one. It
+	// can be set as a single pattern
+	When types.StringList `json:"when,omitempty"`
+	// MaxFileSize skips the changes to files larger than the size, e.g. "10mb", to not sync
+	// large generated artifacts. Zero means unlimited
+	MaxFileSize types.UnitBytes `json:"max_file_size,omitempty"`
+	// PollInterval is how often the container path of an extract rule is checked for changes,
+	// defaults to 2s
+	PollInterval time.Duration `json:"poll_interval,omitempty"`
+	// IgnoreDanglingSymlinks skips the changes to symlinks whose target doesn't exist, which are
+	// otherwise handled as deleted paths
+	IgnoreDanglingSymlinks bool `json:"ignore_dangling_symlinks,omitempty"`
+	// Quiet doesn't print the paths synced by the trigger, e.g. for a directory with frequent
+	// temporary writes, they're still synced
+	Quiet bool `json:"quiet,omitempty"`
+}
+
+// TriggerExec is a command run in the service containers when changes are detected.
+type TriggerExec struct {
+	// Command is the command to run, set as a list or as a string split like a shell would
+	Command types.ShellCommand `json:"command,omitempty"`
+	// WorkingDir is the directory the command runs in, defaults to the container working directory
+	WorkingDir string `json:"working_dir,omitempty"`
+	// Tty allocates a pseudo-terminal for the command, e.g. for tools only coloring or line
+	// buffering their output on a terminal. Its standard error is then merged into the output
+	Tty bool `json:"tty,omitempty"`
 }
 
 const quietPeriod = 500 * time.Millisecond
 
+// watchDrainTimeout is how long the batch being handled can take to complete once the watch is stopped
+const watchDrainTimeout = 10 * time.Second
+
+const (
+	// defaultSyncRetries is the number of times a sync failing with a transient error is retried,
+	// it can be overridden with `COMPOSE_WATCH_SYNC_RETRIES`
+	defaultSyncRetries = 3
+	// syncRetryDelay is the delay before the first retry, doubled on each attempt
+	syncRetryDelay = 200 * time.Millisecond
+)
+
+// defaultRebuildWaitTimeout is how long to wait for the rebuilt services to be healthy, when
+// the rebuild trigger waits for them
+const defaultRebuildWaitTimeout = time.Minute
+
+const (
+	// daemonReconnectTimeout is how long to wait for the Docker daemon to come back after the
+	// connection dropped during a sync, e.g. while the daemon restarts
+	daemonReconnectTimeout = time.Minute
+	// daemonReconnectInterval is how often the daemon is pinged while waiting for it
+	daemonReconnectInterval = time.Second
+)
+
+// targets returns all the container paths the trigger syncs to.
+func (t Trigger) targets() []string {
+	if t.Target != "" {
+		return append([]string{t.Target}, t.Targets...)
+	}
+	return t.Targets
+}
+
+// displayName returns the name identifying the trigger in the logs, its action and path if it
+// doesn't have a name.
+func (t Trigger) displayName() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return fmt.Sprintf("%s %s", t.Action, t.Path)
+}
+
 // fileEvent contains the Compose service and modified host system path.
 type fileEvent struct {
 	sync.PathMapping
-	Action WatchAction
+	Action  WatchAction
+	OnError WatchOnError
+	// Exec is the command of the trigger for exec events, events of the same trigger share it
+	Exec *TriggerExec
+	// NoCache is set for the rebuild events of triggers disabling the build cache
+	NoCache bool
+	// WaitTimeout is set for the rebuild events of triggers waiting for the rebuilt services to be
+	// healthy, it's how long to wait
+	WaitTimeout time.Duration
+	// RebuildTargets are the comma separated services rebuilt instead of the watched service,
+	// kept as a string for the events to be comparable
+	RebuildTargets string
+	// Quiet is set for the events of quiet triggers, their paths aren't printed when synced
+	Quiet bool
+	// Trigger is the display name of the trigger producing the event
+	Trigger string
+}
+
+// watchStopError is returned when a failure requires to stop watching the service,
+// according to the on_error policy of the trigger.
+type watchStopError struct {
+	service string
+	err     error
+}
+
+func (e watchStopError) Error() string {
+	return fmt.Sprintf("stopped watching service %s: %v", e.service, e.err)
+}
+
+func (e watchStopError) Unwrap() error {
+	return e.err
+}
+
+// watcherStoppedError is returned when the file watcher of the service failed, the other services
+// keep being watched whatever the error policy.
+type watcherStoppedError struct {
+	err error
+}
+
+func (e watcherStoppedError) Error() string {
+	return fmt.Sprintf("watching files: %v", e.err)
+}
+
+func (e watcherStoppedError) Unwrap() error {
+	return e.err
 }
 
 // getSyncImplementation returns the the tar-based syncer unless it has been explicitly
 // disabled with `COMPOSE_EXPERIMENTAL_WATCH_TAR=0`. Note that the absence of the env
 // var means enabled.
-func (s *composeService) getSyncImplementation(project *types.Project) sync.Syncer {
+//
+// The `sync_backend` of the service development config overrides the env var. The files and bytes
+// synced by the tar and `docker cp` backends are recorded in stats.
+func (s *composeService) getSyncImplementation(project *types.Project, options api.WatchOptions, config DevelopmentConfig, stats *sync.Stats) sync.Syncer {
+	if options.Syncer != nil {
+		return withSyncHook(customSyncer{syncer: options.Syncer}, project, config)
+	}
 	var useTar bool
-	if useTarEnv, ok := os.LookupEnv("COMPOSE_EXPERIMENTAL_WATCH_TAR"); ok {
-		useTar, _ = strconv.ParseBool(useTarEnv)
-	} else {
+	switch config.SyncBackend {
+	case SyncBackendTar:
 		useTar = true
+	case SyncBackendCopy:
+		useTar = false
+	default:
+		if useTarEnv, ok := os.LookupEnv("COMPOSE_EXPERIMENTAL_WATCH_TAR"); ok {
+			useTar, _ = strconv.ParseBool(useTarEnv)
+		} else {
+			useTar = true
+		}
 	}
 	if useTar {
-		return sync.NewTar(project.Name, tarDockerClient{s: s})
+		tarClient := newTarDockerClient(s, project, options)
+		syncer := sync.NewTar(project.Name, runningContainersClient{tarClient}).WithStats(stats)
+		if options.SyncStoppedVolumes {
+			syncer = syncer.WithVolumeHelper(tarClient)
+		}
+		return withSyncHook(syncer, project, config)
+	}
+
+	if options.SyncStoppedVolumes {
+		logrus.Warn("syncing into volumes of stopped services requires the tar sync backend, ignoring")
+	}
+	if options.Index > 0 || options.ContainerID != "" {
+		logrus.Warn("syncing into a subset of the service containers requires the tar sync backend, syncing into all of them")
+	}
+	// the copied files are reported by the JSON messages instead
+	infoWriter := s.watchOutput()
+	if options.JSON {
+		infoWriter = io.Discard
+	}
+	return withSyncHook(sync.NewDockerCopy(project.Name, s, infoWriter).WithStats(stats), project, config)
+}
+
+// syncBackendName returns the name of the backend used by the syncer, as set with sync_backend.
+func syncBackendName(syncer sync.Syncer) string {
+	switch syncer := syncer.(type) {
+	case hookSyncer:
+		return syncBackendName(syncer.Syncer)
+	case *sync.Tar:
+		return string(SyncBackendTar)
+	case *sync.DockerCopy:
+		return string(SyncBackendCopy)
+	default:
+		return "custom syncer"
+	}
+}
+
+// customSyncer adapts the syncer set in the watch options.
+type customSyncer struct {
+	syncer api.WatchSyncer
+}
+
+func (c customSyncer) Sync(ctx context.Context, service types.ServiceConfig, paths []sync.PathMapping) error {
+	mappings := make([]api.WatchPathMapping, len(paths))
+	for i, p := range paths {
+		mappings[i] = api.WatchPathMapping{
+			HostPath:      p.HostPath,
+			ContainerPath: p.ContainerPath,
+			Deleted:       p.Deleted(),
+			Filter:        p.Filter,
+			PreserveMode:  p.PreserveMode,
+			Atomic:        p.Atomic,
+			Chown:         p.Chown,
+		}
+	}
+	return c.syncer.Sync(ctx, service, mappings)
+}
+
+// withSyncHook returns a syncer running the on_sync command of the config after each successful
+// sync, if set.
+func withSyncHook(syncer sync.Syncer, project *types.Project, config DevelopmentConfig) sync.Syncer {
+	if config.OnSync == "" {
+		return syncer
+	}
+	return hookSyncer{
+		Syncer:     syncer,
+		command:    config.OnSync,
+		workingDir: project.WorkingDir,
+		required:   config.OnSyncRequired,
+	}
+}
+
+// hookSyncer runs a command on the host once the files are synced.
+type hookSyncer struct {
+	sync.Syncer
+	command    string
+	workingDir string
+	required   bool
+}
+
+func (h hookSyncer) Sync(ctx context.Context, service types.ServiceConfig, paths []sync.PathMapping) error {
+	if err := h.Syncer.Sync(ctx, service, paths); err != nil {
+		return err
+	}
+	err := h.run(ctx, service.Name, paths)
+	if err != nil && !h.required {
+		logrus.Warnf("service %s: %v", service.Name, err)
+		return nil
 	}
+	return err
+}
 
-	return sync.NewDockerCopy(project.Name, s, s.stdinfo())
+func (h hookSyncer) run(ctx context.Context, serviceName string, paths []sync.PathMapping) error {
+	args, err := shellwords.Parse(h.command)
+	if err != nil {
+		return fmt.Errorf("parsing on_sync command %q: %w", h.command, err)
+	}
+	if len(args) == 0 {
+		return errors.New("on_sync command is empty")
+	}
+	// a path synced to several targets is passed once
+	seen := map[string]bool{}
+	for _, p := range paths {
+		if !seen[p.HostPath] {
+			seen[p.HostPath] = true
+			args = append(args, p.HostPath)
+		}
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = h.workingDir
+	cmd.Env = append(os.Environ(), "COMPOSE_WATCH_SERVICE="+serviceName)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("on_sync command %q failed: %w: %s", h.command, err, msg)
+		}
+		return fmt.Errorf("on_sync command %q failed: %w", h.command, err)
+	}
+	if len(out) > 0 {
+		logrus.Debugf("on_sync command output for service %s:\n%s", serviceName, out)
+	}
+	return nil
 }
 
-func (s *composeService) Watch(ctx context.Context, project *types.Project, services []string, _ api.WatchOptions) error { //nolint: gocyclo
+func (s *composeService) Watch(ctx context.Context, project *types.Project, services []string, options api.WatchOptions) error { //nolint: gocyclo
+	if err := options.Validate(); err != nil {
+		return err
+	}
 	if err := project.ForServices(services); err != nil {
 		return err
 	}
-	syncer := s.getSyncImplementation(project)
+	if len(options.Labels) > 0 {
+		if err := selectServicesByLabels(project, options.Labels); err != nil {
+			return err
+		}
+	}
+	// `--dry-run` applies to watch actions as well
+	options.DryRun = options.DryRun || s.dryRun
+	if options.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		timer := s.clock.AfterFunc(options.MaxDuration, func() {
+			if !options.JSON {
+				fmt.Fprintf(s.watchOutput(), "watch session time limit reached (%s), stopping\n", options.MaxDuration)
+			}
+			cancel()
+		})
+		defer timer.Stop()
+	}
+	rebuilds := newRebuildLimiter(options.MaxConcurrentRebuilds)
+	summary := &watchSummary{}
+	// SIGUSR1 handles the pending changes right away, e.g. for scripts to not wait for the quiet period
+	flusher := newWatchFlusher()
+	notifyWatchFlush(ctx, flusher)
+	var startup *semaphore.Weighted
+	if s.maxConcurrency > 0 {
+		// the services start to be watched concurrently, bound the calls to the engine it implies
+		// like for the other commands
+		startup = semaphore.NewWeighted(int64(s.maxConcurrency))
+	}
 	eg, ctx := errgroup.WithContext(ctx)
 	watching := false
+	failures := &watchFailures{}
 	for i := range project.Services {
 		service := project.Services[i]
-		config, err := loadDevelopmentConfig(service, project)
+		config, err := loadWatchedServiceConfig(service, project, options.Strict)
 		if err != nil {
 			return err
 		}
@@ -102,66 +568,104 @@ func (s *composeService) Watch(ctx context.Context, project *types.Project, serv
 			continue
 		}
 
-		if len(config.Watch) > 0 && service.Build == nil {
-			// service configured with watchers but no build section
-			return fmt.Errorf("can't watch service %q without a build context", service.Name)
-		}
-
-		if len(services) > 0 && service.Build == nil {
-			// service explicitly selected for watch has no build section
-			return fmt.Errorf("can't watch service %q without a build context", service.Name)
+		if extracts := config.extractTriggers(); len(extracts) > 0 {
+			client := runningContainersClient{newTarDockerClient(s, project, options)}
+			for _, trigger := range extracts {
+				trigger := trigger
+				if options.DryRun {
+					// the host files aren't written in dry run mode
+					if !options.JSON {
+						fmt.Fprintf(s.watchOutput(), "(dry run) would extract %s from service %s to %s\n", trigger.Target, service.Name, trigger.Path)
+					}
+					continue
+				}
+				if !options.JSON {
+					fmt.Fprintf(s.watchOutput(), "extracting %s from service %s to %s\n", trigger.Target, service.Name, trigger.Path)
+				}
+				eg.Go(func() error {
+					s.watchExtract(ctx, project.Name, service.Name, options, trigger, client, summary)
+					return nil
+				})
+			}
+			watching = true
 		}
 
-		if len(services) == 0 && service.Build == nil {
-			continue
+		if service.Build == nil {
+			if len(config.Watch) == 0 {
+				continue
+			}
+		} else {
+			// set the service to always be built - watch triggers `Up()` when it receives a rebuild event
+			service.PullPolicy = types.PullPolicyBuild
+			project.Services[i] = service
 		}
 
-		// set the service to always be built - watch triggers `Up()` when it receives a rebuild event
-		service.PullPolicy = types.PullPolicyBuild
-		project.Services[i] = service
-
-		dockerIgnores, err := watch.LoadDockerIgnore(service.Build.Context)
+		ignore, err := watchIgnoreMatcher(project, service, *config, options)
 		if err != nil {
 			return err
 		}
-
-		// add a hardcoded set of ignores on top of what came from .dockerignore
-		// some of this should likely be configurable (e.g. there could be cases
-		// where you want `.git` to be synced) but this is suitable for now
-		dotGitIgnore, err := watch.NewDockerPatternMatcher("/", []string{".git/"})
-		if err != nil {
-			return err
+		if config.IncludeOnly {
+			includeOnly, err := newIncludeOnlyMatcher(config.Watch)
+			if err != nil {
+				return err
+			}
+			ignore = watch.NewCompositeMatcher(ignore, includeOnly)
 		}
-		ignore := watch.NewCompositeMatcher(
-			dockerIgnores,
-			watch.EphemeralPathMatcher(),
-			dotGitIgnore,
-		)
 
 		var paths []string
 		for _, trigger := range config.Watch {
-			if checkIfPathAlreadyBindMounted(trigger.Path, service.Volumes) {
-				logrus.Warnf("path '%s' also declared by a bind mount volume, this path won't be monitored!\n", trigger.Path)
-				continue
+			if s.isLocallyBindMounted(trigger.Path, service.Volumes) {
+				if !trigger.Force {
+					summary.warnf("path '%s' also declared by a bind mount volume, this path won't be monitored!\n", trigger.Path)
+					continue
+				}
+				summary.warnf("path '%s' also declared by a bind mount volume, it's still monitored as the watch rule is forced", trigger.Path)
+			}
+			if isOutsideBuildContext(service, trigger.Path) {
+				logrus.Infof("path '%s' is outside the build context of service %s, .dockerignore rules don't apply to it", trigger.Path, service.Name)
+			}
+			if _, err := os.Stat(trigger.Path); os.IsNotExist(err) {
+				// the native watchers monitor the closest existing parent directory and the polling
+				// watcher rescans the path, so that the path is picked up once created
+				summary.warnf("path '%s' doesn't exist yet, it will be watched once created", trigger.Path)
 			}
 			paths = append(paths, trigger.Path)
 		}
-
-		watcher, err := watch.NewWatcher(paths, ignore)
-		if err != nil {
-			return err
+		if err := checkSyncFeedbackLoop(config.Watch, paths, service.Volumes); err != nil && !s.isRemoteEngine() {
+			return fmt.Errorf("service %s: %w", service.Name, err)
 		}
 
-		fmt.Fprintf(s.stdinfo(), "watching %s\n", paths)
-		err = watcher.Start()
+		state := &serviceWatch{
+			summary:  summary,
+			flusher:  flusher,
+			startup:  startup,
+			rebuilds: rebuilds,
+			stats:    &sync.Stats{},
+		}
+		syncer := s.getSyncImplementation(project, options, *config, state.stats)
+		watcher, err := newServiceWatcher(paths, ignore)
 		if err != nil {
 			return err
 		}
+		// printed once the watcher is started, so that the changes from now on are known to be seen
+		if !options.JSON {
+			fmt.Fprintf(s.watchOutput(), "watching %s for service %s, syncing with %s\n", paths, service.Name, syncBackendName(syncer))
+		}
 		watching = true
+		emitWatchEvent(options, api.WatchEvent{Type: api.WatchEventStarted, Service: service.Name, Paths: paths})
 
+		failures.watched++
 		eg.Go(func() error {
 			defer watcher.Close() //nolint:errcheck
-			return s.watch(ctx, project, service.Name, watcher, syncer, config.Watch)
+			err := s.watch(ctx, project, service.Name, options, watcher, syncer, state, *config)
+			if err != nil && (options.ErrorPolicy == api.WatchErrorPolicyIsolate || errors.As(err, &watcherStoppedError{})) {
+				// not propagated to the group, which would stop watching the other services. The
+				// session fails once none of them is watched anymore
+				logrus.Errorf("stopped watching service %s: %v", service.Name, err)
+				failures.add(service.Name, err)
+				return nil
+			}
+			return err
 		})
 	}
 
@@ -169,195 +673,436 @@ func (s *composeService) Watch(ctx context.Context, project *types.Project, serv
 		return fmt.Errorf("none of the selected services is configured for watch, consider setting an 'x-develop' section")
 	}
 
-	return eg.Wait()
+	if !options.JSON && isTerminal(s.stdinfo()) {
+		// the log entries written while watching clear the status line as well
+		out := logrus.StandardLogger().Out
+		logrus.SetOutput(lockedWriter{w: out, state: &s.watchOut})
+		defer logrus.SetOutput(out)
+	}
+	start := s.clock.Now()
+	err := eg.Wait()
+	if err == nil {
+		err = failures.err()
+	}
+	if !options.JSON {
+		summary.write(s.watchOutput(), s.clock.Since(start))
+	}
+	return err
+}
+
+// serviceWatch is the state of the watch of a service, shared with the handling of its batches.
+type serviceWatch struct {
+	// summary totals the activity of all the services watched by the Watch call
+	summary *watchSummary
+	// flusher forces the pending changes to be handled without waiting for the quiet period
+	flusher *watchFlusher
+	// startup bounds the services doing their startup work concurrently, e.g. the initial
+	// sync, nil doesn't
+	startup *semaphore.Weighted
+	// rebuilds bounds the services rebuilt concurrently
+	rebuilds *rebuildLimiter
+	// stats counts the files and bytes synced by the syncer of the service
+	stats *sync.Stats
+}
+
+// runWithStartupLimit calls fn once a startup slot is available, if they're bounded.
+func (w *serviceWatch) runWithStartupLimit(ctx context.Context, fn func() error) error {
+	if w.startup == nil {
+		return fn()
+	}
+	if err := w.startup.Acquire(ctx, 1); err != nil {
+		return err
+	}
+	defer w.startup.Release(1)
+	return fn()
+}
+
+// watchFailures collects the errors of the services which stopped being watched with the isolate
+// error policy.
+type watchFailures struct {
+	mu      gosync.Mutex
+	watched int
+	errs    []error
+}
+
+func (f *watchFailures) add(service string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errs = append(f.errs, fmt.Errorf("service %s: %w", service, err))
+}
+
+// err returns the errors of the services if all of them failed, nil if some are still watched
+// until the end of the session.
+func (f *watchFailures) err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.errs) == 0 || len(f.errs) < f.watched {
+		return nil
+	}
+	return multierror.Append(nil, f.errs...).ErrorOrNil()
+}
+
+// selectServicesByLabels restricts the project to the services having all the labels, set as
+// "key=value" or as "key" for any value.
+func selectServicesByLabels(project *types.Project, labels []string) error {
+	var selected []string
+	for _, service := range project.Services {
+		if hasLabels(service, labels) {
+			selected = append(selected, service.Name)
+		}
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("none of the selected services has the labels %s", strings.Join(labels, ", "))
+	}
+	return project.ForServices(selected, types.IgnoreDependencies)
+}
+
+func hasLabels(service types.ServiceConfig, labels []string) bool {
+	for _, label := range labels {
+		key, value, hasValue := strings.Cut(label, "=")
+		actual, ok := service.Labels[key]
+		if !ok || (hasValue && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// newServiceWatcher starts the file watcher of the paths of a service, replaced in tests
+var newServiceWatcher = startWatcher
+
+// startWatcher starts the native file watcher for the paths, or the polling watcher if it's
+// selected with `COMPOSE_WATCH_POLL` or the native watcher isn't supported.
+func startWatcher(paths []string, ignore watch.PathMatcher) (watch.Notify, error) {
+	if poll, _ := strconv.ParseBool(os.Getenv(watch.PollEnvVar)); poll {
+		return startPollingWatcher(paths, ignore)
+	}
+	watcher, err := watch.NewWatcher(paths, ignore)
+	if err == nil {
+		err = watcher.Start()
+		if err != nil {
+			_ = watcher.Close()
+		}
+	}
+	if err != nil && watch.IsUnsupportedError(err) {
+		logrus.Warnf("file system events aren't supported for %s (%v), polling for changes instead", paths, err)
+		return startPollingWatcher(paths, ignore)
+	}
+	return watcher, watchLimitError(err)
+}
+
+// watchLimitError returns an actionable error if err is caused by the limit of inotify watches
+// being reached, which fails adding a watch with ENOSPC on Linux rather than with an explicit error.
+func watchLimitError(err error) error {
+	if !errors.Is(err, syscall.ENOSPC) {
+		return err
+	}
+	return fmt.Errorf("reached the system limit of file watches: %w\n"+
+		"Run 'sysctl fs.inotify.max_user_watches' to check the limit, and 'sudo sysctl fs.inotify.max_user_watches=524288' to raise it, "+
+		"or set %s=true to poll for changes instead", err, watch.PollEnvVar)
+}
+
+func startPollingWatcher(paths []string, ignore watch.PathMatcher) (watch.Notify, error) {
+	watcher, err := watch.NewPollingWatcher(paths, ignore, watch.DefaultPollInterval)
+	if err != nil {
+		return nil, err
+	}
+	return watcher, watcher.Start()
 }
 
 func (s *composeService) watch(
 	ctx context.Context,
 	project *types.Project,
 	name string,
+	options api.WatchOptions,
 	watcher watch.Notify,
 	syncer sync.Syncer,
-	triggers []Trigger,
+	state *serviceWatch,
+	config DevelopmentConfig,
 ) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	ignores := make([]watch.PathMatcher, len(triggers))
+	summary := state.summary
+	triggers := config.Watch
+	ignores, err := triggerIgnoreMatchers(triggers)
+	if err != nil {
+		return err
+	}
+	whens, err := triggerWhenMatchers(triggers)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, len(triggers))
 	for i, trigger := range triggers {
-		ignore, err := watch.NewDockerPatternMatcher(trigger.Path, trigger.Ignore)
+		paths[i] = trigger.Path
+	}
+	s.watches.register(project.Name, name, paths)
+	defer s.watches.unregister(project.Name, name)
+
+	if options.InitialSync {
+		service, err := project.GetService(name)
 		if err != nil {
 			return err
 		}
-		ignores[i] = ignore
+		err = state.runWithStartupLimit(ctx, func() error {
+			return s.forceSync(ctx, project, service, config, options, syncer, summary)
+		})
+		if err != nil {
+			summary.warnf("Error syncing initial files for service %s: %v", name, err)
+		}
 	}
 
 	events := make(chan fileEvent)
-	batchEvents := batchDebounceEvents(ctx, s.clock, quietPeriod, events)
+	debounce := config.quietPeriod()
+	if options.Debounce > 0 {
+		debounce = options.Debounce
+	}
+	flush, stopFlush := state.flusher.subscribe()
+	defer stopFlush()
+	batchEvents := batchDebounceEvents(ctx, s.clock, debounce, config.DebounceMaxWait, config.debounceMaxSize(), events, flush)
+	stopErrors := make(chan error, 1)
+	// the batch being handled when the watch is stopped is allowed to complete, as aborting a
+	// sync could leave partially written files in the containers
+	batchCtx, cancelBatches := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelBatches()
+	var inFlight gosync.WaitGroup
+	defer func() {
+		// stop accepting new batches before waiting for the one being handled
+		cancel()
+		s.drainWatchBatches(name, &inFlight, cancelBatches)
+	}()
+	inFlight.Add(1)
 	go func() {
+		defer inFlight.Done()
+		var lastDigest string
+		synced := syncedDigests{}
+		var statePath string
+		if config.PersistSyncState {
+			statePath = s.watchStatePath(project.Name, name)
+			state, err := loadWatchState(statePath)
+			if err != nil {
+				summary.warnf("service %s: ignoring the saved watch state: %v", name, err)
+			}
+			synced = state.digests()
+			if !state.LastBatch.IsZero() {
+				s.watches.update(project.Name, name, func(session *api.WatchSession) {
+					session.LastBatch = state.LastBatch
+				})
+			}
+		}
+		// changes received while the watch is paused
+		var paused []fileEvent
+		var idle clockwork.Timer
+		if options.IdleTimeout > 0 {
+			idle = s.clock.NewTimer(options.IdleTimeout)
+			defer idle.Stop()
+		}
 		for {
+			var idleC <-chan time.Time
+			if idle != nil {
+				// the idle timeout restarts once each batch has been handled
+				if !idle.Stop() {
+					select {
+					case <-idle.Chan():
+					default:
+					}
+				}
+				idle.Reset(options.IdleTimeout)
+				idleC = idle.Chan()
+			}
+			var resumed <-chan struct{}
+			if len(paused) > 0 {
+				resumed = options.Control.Resumed()
+			}
+			var batch []fileEvent
 			select {
 			case <-ctx.Done():
 				return
-			case batch := <-batchEvents:
-				start := time.Now()
-				logrus.Debugf("batch start: service[%s] count[%d]", name, len(batch))
-				if err := s.handleWatchBatch(ctx, project, name, batch, syncer); err != nil {
-					logrus.Warnf("Error handling changed files for service %s: %v", name, err)
+			case <-idleC:
+				if !options.JSON {
+					fmt.Fprintf(s.watchOutput(), "no changes for %s in %s, stopping\n", name, options.IdleTimeout)
+				}
+				stopErrors <- nil
+				return
+			case <-resumed:
+				batch, paused = coalesceEvents(paused), nil
+				logrus.Debugf("watch resumed, handling the changes buffered while paused: service[%s] count[%d]", name, len(batch))
+			case batch = <-batchEvents:
+				if ctx.Err() != nil {
+					return
+				}
+				if options.Control.Paused() {
+					logrus.Debugf("watch paused, buffering changes: service[%s] count[%d]", name, len(batch))
+					paused = append(paused, batch...)
+					continue
+				}
+			}
+			if config.BatchOverflow == BatchOverflowRebuild && config.MaxBatchSize > 0 && len(batch) > config.MaxBatchSize {
+				if !options.JSON {
+					fmt.Fprintf(s.watchOutput(), "%d changes exceed the max batch size of %d, rebuilding %s\n",
+						len(batch), config.MaxBatchSize, name)
+				}
+				batch = overflowRebuildBatch(batch)
+			}
+			if config.SkipRepeatedBatches {
+				digest := batchDigest(batch)
+				if digest == lastDigest {
+					logrus.Debugf("skipping batch identical to the previous one: service[%s] count[%d]", name, len(batch))
+					continue
+				}
+				lastDigest = digest
+			}
+			var digests map[string]string
+			if config.SkipUnchangedFiles {
+				batch, digests = synced.filter(batch)
+				if len(batch) == 0 {
+					logrus.Debugf("skipping batch of files with unchanged content: service[%s]", name)
+					continue
+				}
+			}
+			start := s.clock.Now()
+			logrus.Debugf("batch start: service[%s] count[%d]", name, len(batch))
+			emitWatchEvent(options, api.WatchEvent{Type: api.WatchEventBatch, Service: name, Paths: batchHostPaths(batch)})
+			// the batches are handled one at a time once the initial sync is done, what the syncer
+			// records meanwhile is synced by the batch
+			files, bytes := state.stats.Files(), state.stats.Bytes()
+			err := s.handleWatchBatch(batchCtx, project, name, options, batch, syncer, state.rebuilds)
+			if err == nil && !options.DryRun {
+				synced.update(digests)
+			}
+			lastBatch := s.clock.Now()
+			if statePath != "" && !options.DryRun {
+				if err := saveWatchState(statePath, newWatchState(synced, lastBatch)); err != nil {
+					summary.warnf("service %s: saving the watch state: %v", name, err)
+				}
+			}
+			record := func(session *api.WatchSession) {
+				session.LastBatch = lastBatch
+				countWatchBatch(session, batch)
+				session.FilesSynced += state.stats.Files() - files
+				session.BytesSynced += state.stats.Bytes() - bytes
+				if err != nil {
+					session.Errors++
 				}
-				logrus.Debugf("batch complete: service[%s] duration[%s] count[%d]",
-					name, time.Since(start), len(batch))
 			}
+			s.watches.update(project.Name, name, record)
+			summary.update(record)
+			if err != nil {
+				emitWatchEvent(options, api.WatchEvent{Type: api.WatchEventError, Service: name, Err: err})
+				if errors.As(err, &watchStopError{}) {
+					stopErrors <- err
+					return
+				}
+				logrus.Warnf("Error handling changed files for service %s: %v", name, err)
+			}
+			logrus.Debugf("batch complete: service[%s] duration[%s] count[%d]",
+				name, s.clock.Since(start), len(batch))
 		}
 	}()
 
+	changeLog := newChangeLogSampler(s.clock, changeLogLimit)
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
 		case err := <-watcher.Errors():
+			emitWatchEvent(options, api.WatchEvent{Type: api.WatchEventError, Service: name, Err: err})
+			if options.Strict {
+				return err
+			}
+			if watch.IsRecoverableError(err) {
+				summary.warnf("error watching files for service %s, some changes may have been missed: %v", name, err)
+				continue
+			}
+			// the other services keep being watched
+			return watcherStoppedError{err: err}
+		case err := <-stopErrors:
 			return err
 		case event := <-watcher.Events():
 			hostPath := event.Path()
+			changeLog.log(hostPath, triggers)
+			var changes []fileEvent
 			for i, trigger := range triggers {
-				logrus.Debugf("change for %s - comparing with %s", hostPath, trigger.Path)
-				if fileEvent := maybeFileEvent(trigger, hostPath, ignores[i]); fileEvent != nil {
-					events <- *fileEvent
+				for _, fileEvent := range resolveContainerPaths(options, name, maybeFileEvents(trigger, hostPath, ignores[i], whens[i], summary)) {
+					fileEvent.Kind = event.Kind()
+					changes = append(changes, fileEvent)
 				}
 			}
+			for _, fileEvent := range uniqueFileEvents(changes) {
+				events <- fileEvent
+			}
 		}
 	}
 }
 
-// maybeFileEvent returns a file event object if hostPath is valid for the provided trigger and ignore
-// rules.
-//
-// Any errors are logged as warnings and nil (no file event) is returned.
-func maybeFileEvent(trigger Trigger, hostPath string, ignore watch.PathMatcher) *fileEvent {
-	if !watch.IsChild(trigger.Path, hostPath) {
-		return nil
-	}
-	isIgnored, err := ignore.Matches(hostPath)
-	if err != nil {
-		logrus.Warnf("error ignore matching %q: %v", hostPath, err)
-		return nil
+// drainWatchBatches waits for the batch being handled to complete, cancelling it if it takes
+// longer than watchDrainTimeout.
+func (s *composeService) drainWatchBatches(name string, inFlight *gosync.WaitGroup, cancel context.CancelFunc) {
+	done := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-s.clock.After(watchDrainTimeout):
+		logrus.Warnf("timed out waiting for changes of service %s to be handled, cancelling", name)
+		cancel()
+		<-done
 	}
+}
 
-	if isIgnored {
-		logrus.Debugf("%s is matching ignore pattern", hostPath)
-		return nil
+// isLocallyBindMounted returns true if the path is bind mounted in the service containers from this
+// host. The sources of the bind mounts are paths of the engine host, so a remote engine doesn't
+// reflect the changes made to the path on this host, which has to be watched.
+func (s *composeService) isLocallyBindMounted(watchPath string, volumes []types.ServiceVolumeConfig) bool {
+	if !checkIfPathAlreadyBindMounted(watchPath, volumes) {
+		return false
 	}
-
-	var containerPath string
-	if trigger.Target != "" {
-		rel, err := filepath.Rel(trigger.Path, hostPath)
-		if err != nil {
-			logrus.Warnf("error making %s relative to %s: %v", hostPath, trigger.Path, err)
-			return nil
-		}
-		// always use Unix-style paths for inside the container
-		containerPath = path.Join(trigger.Target, rel)
+	if s.isRemoteEngine() {
+		logrus.Debugf("path '%s' is bind mounted from the remote engine host, not from this host", watchPath)
+		return false
 	}
+	return true
+}
 
-	return &fileEvent{
-		Action: WatchAction(trigger.Action),
-		PathMapping: sync.PathMapping{
-			HostPath:      hostPath,
-			ContainerPath: containerPath,
-		},
-	}
+// isRemoteEngine returns true if the Docker engine of the current context runs on another host.
+// Both sync backends read the files on this host and stream them through the engine API, so
+// they work with remote engines too.
+func (s *composeService) isRemoteEngine() bool {
+	return isRemoteDockerHost(s.dockerCli.DockerEndpoint().Host)
 }
 
-func loadDevelopmentConfig(service types.ServiceConfig, project *types.Project) (*DevelopmentConfig, error) {
-	var config DevelopmentConfig
-	y, ok := service.Extensions["x-develop"]
-	if !ok {
-		return nil, nil
+// isRemoteDockerHost returns true if the Docker host address (e.g. `ssh://user@host`) isn't a
+// local socket or a loopback address.
+func isRemoteDockerHost(host string) bool {
+	if host == "" {
+		return false
 	}
-	err := mapstructure.Decode(y, &config)
+	u, err := url.Parse(host)
 	if err != nil {
-		return nil, err
+		return false
 	}
-	baseDir, err := filepath.EvalSymlinks(project.WorkingDir)
-	if err != nil {
-		return nil, fmt.Errorf("resolving symlink for %q: %w", project.WorkingDir, err)
-	}
-
-	for i, trigger := range config.Watch {
-		if !filepath.IsAbs(trigger.Path) {
-			trigger.Path = filepath.Join(baseDir, trigger.Path)
-		}
-		if p, err := filepath.EvalSymlinks(trigger.Path); err == nil {
-			// this might fail because the path doesn't exist, etc.
-			trigger.Path = p
-		}
-		trigger.Path = filepath.Clean(trigger.Path)
-		if trigger.Path == "" {
-			return nil, errors.New("watch rules MUST define a path")
+	switch u.Scheme {
+	case "unix", "npipe", "fd":
+		return false
+	case "tcp", "http", "https":
+		hostname := u.Hostname()
+		if hostname == "localhost" {
+			return false
 		}
-
-		if trigger.Action == string(WatchActionRebuild) && service.Build == nil {
-			return nil, fmt.Errorf("service %s doesn't have a build section, can't apply 'rebuild' on watch", service.Name)
-		}
-
-		config.Watch[i] = trigger
+		ip := net.ParseIP(hostname)
+		return ip == nil || !ip.IsLoopback()
+	default:
+		return true
 	}
-	return &config, nil
-}
-
-// batchDebounceEvents groups identical file events within a sliding time window and writes the results to the returned
-// channel.
-//
-// The returned channel is closed when the debouncer is stopped via context cancellation or by closing the input channel.
-func batchDebounceEvents(ctx context.Context, clock clockwork.Clock, delay time.Duration, input <-chan fileEvent) <-chan []fileEvent {
-	out := make(chan []fileEvent)
-	go func() {
-		defer close(out)
-		seen := make(map[fileEvent]time.Time)
-		flushEvents := func() {
-			if len(seen) == 0 {
-				return
-			}
-			events := make([]fileEvent, 0, len(seen))
-			for e := range seen {
-				events = append(events, e)
-			}
-			// sort batch by oldest -> newest
-			// (if an event is seen > 1 per batch, it gets the latest timestamp)
-			sort.SliceStable(events, func(i, j int) bool {
-				x := events[i]
-				y := events[j]
-				return seen[x].Before(seen[y])
-			})
-			out <- events
-			seen = make(map[fileEvent]time.Time)
-		}
-
-		t := clock.NewTicker(delay)
-		defer t.Stop()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-t.Chan():
-				flushEvents()
-			case e, ok := <-input:
-				if !ok {
-					// input channel was closed
-					flushEvents()
-					return
-				}
-				seen[e] = time.Now()
-				t.Reset(delay)
-			}
-		}
-	}()
-	return out
 }
 
 func checkIfPathAlreadyBindMounted(watchPath string, volumes []types.ServiceVolumeConfig) bool {
 	for _, volume := range volumes {
-		if volume.Bind != nil && strings.HasPrefix(watchPath, volume.Source) {
+		if volume.Bind != nil && isPathInside(volume.Source, watchPath) {
 			return true
 		}
 	}
@@ -365,7 +1110,21 @@ func checkIfPathAlreadyBindMounted(watchPath string, volumes []types.ServiceVolu
 }
 
 type tarDockerClient struct {
-	s *composeService
+	s       *composeService
+	project *types.Project
+
+	// index and containerID restrict the containers of the service, see api.WatchOptions
+	index       int
+	containerID string
+}
+
+func newTarDockerClient(s *composeService, project *types.Project, options api.WatchOptions) tarDockerClient {
+	return tarDockerClient{
+		s:           s,
+		project:     project,
+		index:       options.Index,
+		containerID: options.ContainerID,
+	}
 }
 
 func (t tarDockerClient) ContainersForService(ctx context.Context, projectName string, serviceName string) ([]moby.Container, error) {
@@ -373,7 +1132,55 @@ func (t tarDockerClient) ContainersForService(ctx context.Context, projectName s
 	if err != nil {
 		return nil, err
 	}
-	return containers, nil
+	if t.index == 0 && t.containerID == "" {
+		return containers, nil
+	}
+	selected := selectWatchContainers(containers, t.index, t.containerID)
+	if len(selected) == 0 && len(containers) > 0 {
+		return nil, fmt.Errorf("service %q has no container matching %s", serviceName, describeWatchContainer(t.index, t.containerID))
+	}
+	return selected, nil
+}
+
+// runningContainersClient only returns the running containers of the service, for the actions
+// which can't apply to stopped containers, e.g. the files can't be synced into them.
+type runningContainersClient struct {
+	tarDockerClient
+}
+
+func (r runningContainersClient) ContainersForService(ctx context.Context, projectName string, serviceName string) ([]moby.Container, error) {
+	containers, err := r.tarDockerClient.ContainersForService(ctx, projectName, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return Containers(containers).filter(isRunning()), nil
+}
+
+// selectWatchContainers returns the containers with the given replica index, if not zero, and
+// whose ID starts with containerID, if not empty.
+func selectWatchContainers(containers []moby.Container, index int, containerID string) []moby.Container {
+	var selected []moby.Container
+	for _, c := range containers {
+		if index > 0 && c.Labels[api.ContainerNumberLabel] != strconv.Itoa(index) {
+			continue
+		}
+		if containerID != "" && !strings.HasPrefix(c.ID, containerID) {
+			continue
+		}
+		selected = append(selected, c)
+	}
+	return selected
+}
+
+func describeWatchContainer(index int, containerID string) string {
+	var criteria []string
+	if index > 0 {
+		criteria = append(criteria, fmt.Sprintf("index %d", index))
+	}
+	if containerID != "" {
+		criteria = append(criteria, fmt.Sprintf("ID %q", containerID))
+	}
+	return strings.Join(criteria, " and ")
 }
 
 func (t tarDockerClient) Exec(ctx context.Context, containerID string, cmd []string, in io.Reader) error {
@@ -384,12 +1191,29 @@ func (t tarDockerClient) Exec(ctx context.Context, containerID string, cmd []str
 		AttachStdin:  in != nil,
 		Tty:          false,
 	}
+	return t.exec(ctx, containerID, execCfg, in, t.s.stdinfo())
+}
+
+// ExecOutput runs a command in the container, its standard output is written to out.
+func (t tarDockerClient) ExecOutput(ctx context.Context, containerID string, cmd []string, out io.Writer) error {
+	execCfg := moby.ExecConfig{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          false,
+	}
+	return t.exec(ctx, containerID, execCfg, nil, out)
+}
+
+// exec runs a command in the container, the standard output is written to stdout and the
+// standard error to stdinfo.
+func (t tarDockerClient) exec(ctx context.Context, containerID string, execCfg moby.ExecConfig, in io.Reader, stdout io.Writer) error {
 	execCreateResp, err := t.s.apiClient().ContainerExecCreate(ctx, containerID, execCfg)
 	if err != nil {
 		return err
 	}
 
-	startCheck := moby.ExecStartCheck{Tty: false, Detach: false}
+	startCheck := moby.ExecStartCheck{Tty: execCfg.Tty, Detach: false}
 	conn, err := t.s.apiClient().ContainerExecAttach(ctx, execCreateResp.ID, startCheck)
 	if err != nil {
 		return err
@@ -407,7 +1231,13 @@ func (t tarDockerClient) Exec(ctx context.Context, containerID string, cmd []str
 		})
 	}
 	eg.Go(func() error {
-		_, err := io.Copy(t.s.stdinfo(), conn.Reader)
+		if execCfg.Tty {
+			// the terminal combines stdout and stderr in a raw stream
+			_, err := io.Copy(stdout, conn.Reader)
+			return err
+		}
+		// without a TTY the output is multiplexed
+		_, err := stdcopy.StdCopy(stdout, t.s.stdinfo(), conn.Reader)
 		return err
 	})
 
@@ -436,82 +1266,94 @@ func (t tarDockerClient) Exec(ctx context.Context, containerID string, cmd []str
 	return nil
 }
 
-func (s *composeService) handleWatchBatch(
+// RunWithVolumes runs cmd in a container created from the service image with the volumes mounted; the
+// container is removed once the command completes.
+func (t tarDockerClient) RunWithVolumes(
 	ctx context.Context,
-	project *types.Project,
-	serviceName string,
-	batch []fileEvent,
-	syncer sync.Syncer,
+	service types.ServiceConfig,
+	volumes []types.ServiceVolumeConfig,
+	cmd []string,
+	in io.Reader,
 ) error {
-	pathMappings := make([]sync.PathMapping, len(batch))
-	for i := range batch {
-		if batch[i].Action == WatchActionRebuild {
-			fmt.Fprintf(
-				s.stdinfo(),
-				"Rebuilding %s after changes were detected:%s\n",
-				serviceName,
-				strings.Join(append([]string{""}, batch[i].HostPath), "\n  - "),
-			)
-			err := s.Up(ctx, project, api.UpOptions{
-				Create: api.CreateOptions{
-					Build: &api.BuildOptions{
-						Pull: false,
-						Push: false,
-						// restrict the build to ONLY this service, not any of its dependencies
-						Services: []string{serviceName},
-					},
-					Services: []string{serviceName},
-					Inherit:  true,
-				},
-				Start: api.StartOptions{
-					Services: []string{serviceName},
-					Project:  project,
-				},
-			})
-			if err != nil {
-				fmt.Fprintf(s.stderr(), "Application failed to start after update\n")
-			}
-			return nil
+	mounts := make([]mount.Mount, len(volumes))
+	for i, v := range volumes {
+		source := v.Source
+		if vol, ok := t.project.Volumes[v.Source]; ok && vol.Name != "" {
+			source = vol.Name
+		}
+		mounts[i] = mount.Mount{
+			Type:   mount.TypeVolume,
+			Source: source,
+			Target: v.Target,
 		}
-		pathMappings[i] = batch[i].PathMapping
 	}
 
-	writeWatchSyncMessage(s.stdinfo(), serviceName, pathMappings)
+	created, err := t.s.apiClient().ContainerCreate(ctx, &containerType.Config{
+		Image:        api.GetImageNameOrDefault(service, t.project.Name),
+		Entrypoint:   cmd[:1],
+		Cmd:          cmd[1:],
+		User:         service.User,
+		AttachStdin:  in != nil,
+		AttachStderr: true,
+		OpenStdin:    in != nil,
+		StdinOnce:    true,
+	}, &containerType.HostConfig{
+		Mounts: mounts,
+	}, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// use a fresh context so the helper is removed even when the watch is stopping
+		err := t.s.apiClient().ContainerRemove(context.Background(), created.ID, moby.ContainerRemoveOptions{Force: true})
+		if err != nil {
+			logrus.Warnf("failed to remove sync helper container %s: %v", created.ID, err)
+		}
+	}()
 
-	service, err := project.GetService(serviceName)
+	conn, err := t.s.apiClient().ContainerAttach(ctx, created.ID, moby.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  in != nil,
+		Stderr: true,
+	})
 	if err != nil {
 		return err
 	}
-	if err := syncer.Sync(ctx, service, pathMappings); err != nil {
+	defer conn.Close()
+
+	resultC, errC := t.s.apiClient().ContainerWait(ctx, created.ID, containerType.WaitConditionNextExit)
+	if err := t.s.apiClient().ContainerStart(ctx, created.ID, moby.ContainerStartOptions{}); err != nil {
 		return err
 	}
-	return nil
-}
 
-// writeWatchSyncMessage prints out a message about the sync for the changed paths.
-func writeWatchSyncMessage(w io.Writer, serviceName string, pathMappings []sync.PathMapping) {
-	const maxPathsToShow = 10
-	if len(pathMappings) <= maxPathsToShow || logrus.IsLevelEnabled(logrus.DebugLevel) {
-		hostPathsToSync := make([]string, len(pathMappings))
-		for i := range pathMappings {
-			hostPathsToSync[i] = pathMappings[i].HostPath
-		}
-		fmt.Fprintf(
-			w,
-			"Syncing %s after changes were detected:%s\n",
-			serviceName,
-			strings.Join(append([]string{""}, hostPathsToSync...), "\n  - "),
-		)
-	} else {
-		hostPathsToSync := make([]string, len(pathMappings))
-		for i := range pathMappings {
-			hostPathsToSync[i] = pathMappings[i].HostPath
-		}
-		fmt.Fprintf(
-			w,
-			"Syncing %s after %d changes were detected\n",
-			serviceName,
-			len(pathMappings),
-		)
+	var eg errgroup.Group
+	if in != nil {
+		eg.Go(func() error {
+			defer func() {
+				_ = conn.CloseWrite()
+			}()
+			_, err := io.Copy(conn.Conn, in)
+			return err
+		})
+	}
+	eg.Go(func() error {
+		_, err := stdcopy.StdCopy(io.Discard, t.s.stdinfo(), conn.Reader)
+		return err
+	})
+
+	select {
+	case result := <-resultC:
+		if err := eg.Wait(); err != nil {
+			return err
+		}
+		if result.Error != nil {
+			return errors.New(result.Error.Message)
+		}
+		if result.StatusCode != 0 {
+			return fmt.Errorf("exit code %d", result.StatusCode)
+		}
+		return nil
+	case err := <-errC:
+		return err
 	}
 }
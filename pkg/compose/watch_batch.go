@@ -0,0 +1,972 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compose
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+
+	moby "github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+
+	"github.com/docker/compose/v2/internal/sync"
+
+	"github.com/compose-spec/compose-go/types"
+	"github.com/jonboulle/clockwork"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/docker/compose/v2/pkg/api"
+	"github.com/docker/compose/v2/pkg/watch"
+)
+
+// debounceKey identifies the file events superseding each other within a debounce window.
+type debounceKey struct {
+	hostPath      string
+	containerPath string
+	action        WatchAction
+	exec          *TriggerExec
+	rebuild       string
+}
+
+func debounceKeyOf(e fileEvent) debounceKey {
+	return debounceKey{hostPath: e.HostPath, containerPath: e.ContainerPath, action: e.Action, exec: e.Exec, rebuild: e.RebuildTargets}
+}
+
+// supersedingEvent returns the event replacing previous for the same key. A change of mode only
+// doesn't supersede a change of content, as syncing the content syncs the mode as well.
+func supersedingEvent(previous, next fileEvent) fileEvent {
+	if next.Kind == watch.FileChmod && previous.Kind != watch.FileChmod {
+		next.Kind = previous.Kind
+	}
+	return next
+}
+
+// batchDebounceEvents groups file events for the same path and action within a sliding time window and writes the
+// results to the returned channel. A batch is written as soon as it reaches maxSize events, if set, or once its
+// first event waited for maxWait, if set, so that a continuous stream of changes doesn't delay the batch forever.
+// Changes received while the previous batch is handled, e.g. during a rebuild, are all added to the next batch.
+// Each value received from flush makes the pending batch due without waiting for the end of the window.
+//
+// The returned channel is closed when the debouncer is stopped via context cancellation or by closing the input channel.
+func batchDebounceEvents(ctx context.Context, clock clockwork.Clock, delay time.Duration, maxWait time.Duration, maxSize int, input <-chan fileEvent, flush <-chan struct{}) <-chan []fileEvent {
+	out := make(chan []fileEvent)
+	go func() {
+		defer close(out)
+		type seenEvent struct {
+			event fileEvent
+			// seq is the order in which the last event for this key was received, unlike
+			// timestamps it never ties so the order of the batch is deterministic
+			seq int
+		}
+		// events are coalesced by path and action, so a file deleted then re-created within
+		// the window (e.g. atomic save by editors) results in a single event for its final state
+		seen := make(map[debounceKey]seenEvent)
+		seq := 0
+		// the max wait timer fires once the first event of the pending batch waited for maxWait
+		var maxWaitTimer clockwork.Timer
+		var maxWaitC <-chan time.Time
+		defer func() {
+			if maxWaitTimer != nil {
+				maxWaitTimer.Stop()
+			}
+		}()
+		// ready is set once the pending batch is due. Until it's received (e.g. while the previous
+		// batch triggers a rebuild), the changes keep being added to it, so that they are all
+		// handled at once rather than in several batches
+		ready := false
+		// batch is the pending batch once due, nil when it has to be built again
+		var batch []fileEvent
+		pendingBatch := func() []fileEvent {
+			entries := make([]seenEvent, 0, len(seen))
+			for _, e := range seen {
+				entries = append(entries, e)
+			}
+			// sort batch by oldest -> newest
+			// (if an event is seen > 1 per batch, it gets the latest position)
+			sort.Slice(entries, func(i, j int) bool {
+				return entries[i].seq < entries[j].seq
+			})
+			events := make([]fileEvent, len(entries))
+			for i, e := range entries {
+				events[i] = e.event
+			}
+			return events
+		}
+		flushEvents := func() {
+			if len(seen) > 0 {
+				ready = true
+			}
+		}
+		sent := func() {
+			seen = make(map[debounceKey]seenEvent)
+			ready, batch = false, nil
+			if maxWaitTimer != nil {
+				maxWaitTimer.Stop()
+				maxWaitTimer, maxWaitC = nil, nil
+			}
+		}
+
+		t := clock.NewTicker(delay)
+		defer t.Stop()
+		for {
+			var outC chan<- []fileEvent
+			inputC := input
+			if ready {
+				if batch == nil {
+					batch = pendingBatch()
+				}
+				outC = out
+				if maxSize > 0 && len(seen) >= maxSize {
+					// the batch is full, the next changes wait for it to be received
+					inputC = nil
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case outC <- batch:
+				sent()
+			case <-t.Chan():
+				flushEvents()
+			case <-maxWaitC:
+				flushEvents()
+			case <-flush:
+				flushEvents()
+			case e, ok := <-inputC:
+				if !ok {
+					// input channel was closed
+					if len(seen) > 0 {
+						select {
+						case out <- pendingBatch():
+						case <-ctx.Done():
+						}
+					}
+					return
+				}
+				if len(seen) == 0 && maxWait > 0 {
+					maxWaitTimer = clock.NewTimer(maxWait)
+					maxWaitC = maxWaitTimer.Chan()
+				}
+				seq++
+				key := debounceKeyOf(e)
+				if previous, ok := seen[key]; ok {
+					e = supersedingEvent(previous.event, e)
+				}
+				seen[key] = seenEvent{event: e, seq: seq}
+				batch = nil
+				if maxSize > 0 && len(seen) >= maxSize {
+					flushEvents()
+				}
+				t.Reset(delay)
+			}
+		}
+	}()
+	return out
+}
+
+// overflowRebuildBatch replaces the events of the batch with rebuild events for the same host
+// paths, as rebuilding the service supersedes syncing or restarting it.
+func overflowRebuildBatch(batch []fileEvent) []fileEvent {
+	events := make([]fileEvent, len(batch))
+	for i, e := range batch {
+		events[i] = fileEvent{
+			Action:      WatchActionRebuild,
+			OnError:     e.OnError,
+			NoCache:     e.NoCache,
+			PathMapping: sync.PathMapping{HostPath: e.HostPath},
+		}
+		if e.Action == WatchActionRebuild {
+			// the changes of the other actions rebuild the watched service
+			events[i].RebuildTargets = e.RebuildTargets
+		}
+	}
+	return uniqueFileEvents(events)
+}
+
+// batchDigest returns a digest of the batch events, including the content of the changed files.
+func batchDigest(batch []fileEvent) string {
+	lines := make([]string, len(batch))
+	for i, e := range batch {
+		lines[i] = fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%s", e.Action, e.HostPath, e.ContainerPath, fileDigest(e.HostPath), fileMode(e.HostPath))
+	}
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, l := range lines {
+		_, _ = io.WriteString(h, l+"\n")
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// coalesceEvents returns the last event for each path and action, in the order of these last
+// events, like the debouncing of changes does.
+func coalesceEvents(events []fileEvent) []fileEvent {
+	seen := map[debounceKey]int{}
+	var coalesced []fileEvent
+	for i := len(events) - 1; i >= 0; i-- {
+		key := debounceKeyOf(events[i])
+		if j, ok := seen[key]; ok {
+			coalesced[j] = supersedingEvent(events[i], coalesced[j])
+			continue
+		}
+		seen[key] = len(coalesced)
+		coalesced = append(coalesced, events[i])
+	}
+	slices.Reverse(coalesced)
+	return coalesced
+}
+
+// syncedDigests are the content digests of the files last synced for a service, by host path.
+type syncedDigests map[string]string
+
+// filter returns the batch without the sync events of the files with the same content as when
+// they were last synced, and the digests of the files left to sync.
+func (d syncedDigests) filter(batch []fileEvent) ([]fileEvent, map[string]string) {
+	digests := map[string]string{}
+	filtered := make([]fileEvent, 0, len(batch))
+	for _, e := range batch {
+		if !e.Action.syncsFiles() {
+			filtered = append(filtered, e)
+			continue
+		}
+		digest, ok := digests[e.HostPath]
+		if !ok {
+			digest = fileDigest(e.HostPath)
+			digests[e.HostPath] = digest
+		}
+		// the digest doesn't cover the mode of the file
+		if last, ok := d[e.HostPath]; ok && last == digest && e.Kind != watch.FileChmod {
+			logrus.Debugf("skipping sync of %s, content unchanged since last sync", e.HostPath)
+			continue
+		}
+		filtered = append(filtered, e)
+	}
+	return filtered, digests
+}
+
+// update records the digests of the synced files, deleted files are forgotten.
+func (d syncedDigests) update(digests map[string]string) {
+	for p, digest := range digests {
+		if digest == missingFileDigest || digest == unreadableFileDigest {
+			delete(d, p)
+			continue
+		}
+		d[p] = digest
+	}
+}
+
+const (
+	missingFileDigest    = "missing"
+	unreadableFileDigest = "unreadable"
+)
+
+// fileMode returns the mode of the file, empty if it can't be read.
+func fileMode(p string) string {
+	info, err := os.Stat(p)
+	if err != nil {
+		return ""
+	}
+	return info.Mode().String()
+}
+
+// fileDigest returns a digest of the file content, or a marker for anything else than a
+// readable regular file.
+func fileDigest(p string) string {
+	info, err := os.Stat(p)
+	if err != nil {
+		return missingFileDigest
+	}
+	if !info.Mode().IsRegular() {
+		return info.Mode().Type().String()
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return unreadableFileDigest
+	}
+	defer f.Close() //nolint:errcheck
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return unreadableFileDigest
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *composeService) handleWatchBatch(
+	ctx context.Context,
+	project *types.Project,
+	serviceName string,
+	options api.WatchOptions,
+	batch []fileEvent,
+	syncer sync.Syncer,
+	rebuilds *rebuildLimiter,
+) error {
+	// announced are the mappings printed when synced, i.e. not from quiet triggers
+	var pathMappings, announced []sync.PathMapping
+	var execs []*TriggerExec
+	// the on_error policy of a failing action comes from the events of that action only
+	var syncEvents, restartEvents []fileEvent
+	restart, rebuild := false, false
+	for i := range batch {
+		if batch[i].Action == WatchActionSync || batch[i].Action == WatchActionSyncRestart {
+			logrus.Debugf("syncing %s to %s in service %s for watch rule %s",
+				batch[i].HostPath, batch[i].ContainerPath, serviceName, batch[i].Trigger)
+			if !batch[i].Quiet {
+				announced = append(announced, batch[i].PathMapping)
+			}
+		}
+		switch batch[i].Action {
+		case WatchActionRebuild:
+			rebuild = true
+		case WatchActionRestart:
+			restart = true
+			restartEvents = append(restartEvents, batch[i])
+		case WatchActionSyncRestart:
+			pathMappings = append(pathMappings, batch[i].PathMapping)
+			restart = true
+			syncEvents = append(syncEvents, batch[i])
+			restartEvents = append(restartEvents, batch[i])
+		case WatchActionExec:
+			if !slices.Contains(execs, batch[i].Exec) {
+				execs = append(execs, batch[i].Exec)
+			}
+		default:
+			pathMappings = append(pathMappings, batch[i].PathMapping)
+			syncEvents = append(syncEvents, batch[i])
+		}
+	}
+
+	if options.DryRun {
+		if len(pathMappings) > 0 {
+			writeWatchDryRunSyncMessage(s.watchOutput(), serviceName, pathMappings)
+		}
+		for _, exec := range execs {
+			fmt.Fprintf(s.watchOutput(), "(dry run) would run %q in %s after changes were detected\n",
+				strings.Join(exec.Command, " "), serviceName)
+		}
+		switch {
+		case rebuild:
+			fmt.Fprintf(s.watchOutput(), "(dry run) would rebuild %s after changes were detected:%s\n",
+				strings.Join(rebuildServices(serviceName, batch), ", "),
+				strings.Join(append([]string{""}, batchActionHostPaths(batch, WatchActionRebuild)...), "\n  - "))
+		case restart:
+			fmt.Fprintf(s.watchOutput(), "(dry run) would restart %s after changes were detected\n", serviceName)
+		}
+		return nil
+	}
+
+	if len(pathMappings) > 0 {
+		syncPaths := make([]string, len(pathMappings))
+		for i := range pathMappings {
+			syncPaths[i] = pathMappings[i].HostPath
+		}
+		emitWatchEvent(options, api.WatchEvent{
+			Type:    api.WatchEventSyncStarted,
+			Service: serviceName,
+			Action:  string(WatchActionSync),
+			Paths:   syncPaths,
+		})
+		var prose func(w io.Writer)
+		if len(announced) > 0 {
+			prose = func(w io.Writer) {
+				writeWatchSyncMessage(w, serviceName, announced)
+			}
+		}
+		s.writeWatchMessage(options, watchMessage{
+			Service: serviceName,
+			Action:  WatchActionSync,
+			Status:  watchMessageStarted,
+			Paths:   syncPaths,
+		}, prose)
+
+		service, err := project.GetService(serviceName)
+		if err != nil {
+			return err
+		}
+		err = s.syncWithRetry(ctx, syncer, service, pathMappings)
+		if err != nil && client.IsErrConnectionFailed(err) && ctx.Err() == nil {
+			if !options.JSON {
+				fmt.Fprintf(s.watchOutput(), "lost the connection to the Docker daemon while syncing %s, waiting for it to come back\n", serviceName)
+			}
+			err = s.resyncAfterReconnect(ctx, project, service, options, syncer, pathMappings)
+		}
+		emitWatchEvent(options, api.WatchEvent{
+			Type:    api.WatchEventSyncCompleted,
+			Service: serviceName,
+			Action:  string(WatchActionSync),
+			Paths:   syncPaths,
+			Err:     err,
+		})
+		s.writeWatchMessage(options, completedWatchMessage(serviceName, WatchActionSync, syncPaths, 0, err), nil)
+		switch {
+		case errors.Is(err, sync.ErrNoContainers):
+			// not a failure the on_error policy could recover from, the other actions of the batch
+			// (e.g. a rebuild) still apply
+			if !options.JSON {
+				fmt.Fprintf(s.watchOutput(), "no running containers for service %s; changes not synced\n", serviceName)
+			}
+		case err != nil:
+			return s.applyOnErrorPolicy(ctx, project, serviceName, options, batchOnErrorPolicy(syncEvents), err)
+		}
+	}
+
+	for _, exec := range execs {
+		if err := s.execWatchCommand(ctx, project, serviceName, options, exec); err != nil {
+			return s.applyOnErrorPolicy(ctx, project, serviceName, options, batchOnErrorPolicy(execEvents(batch, exec)), err)
+		}
+	}
+
+	// a rebuild recreates the service container, which supersedes a restart
+	if rebuild {
+		return s.rebuildWatchedService(ctx, project, serviceName, options, batch, rebuilds)
+	}
+
+	if restart {
+		emitWatchEvent(options, api.WatchEvent{
+			Type:    api.WatchEventRestartStarted,
+			Service: serviceName,
+			Action:  string(WatchActionRestart),
+		})
+		if !options.JSON {
+			fmt.Fprintf(s.watchOutput(), "Restarting %s after changes were detected\n", serviceName)
+		}
+		err := s.restartWatchedService(ctx, project, serviceName)
+		emitWatchEvent(options, api.WatchEvent{
+			Type:    api.WatchEventRestartCompleted,
+			Service: serviceName,
+			Action:  string(WatchActionRestart),
+			Err:     err,
+		})
+		if err != nil {
+			return s.applyOnErrorPolicy(ctx, project, serviceName, options, batchOnErrorPolicy(restartEvents), err)
+		}
+	}
+	return nil
+}
+
+// execWatchCommand runs the command of an exec trigger in the service containers.
+func (s *composeService) execWatchCommand(
+	ctx context.Context,
+	project *types.Project,
+	serviceName string,
+	options api.WatchOptions,
+	exec *TriggerExec,
+) error {
+	emitWatchEvent(options, api.WatchEvent{
+		Type:    api.WatchEventExecStarted,
+		Service: serviceName,
+		Action:  string(WatchActionExec),
+	})
+	if !options.JSON {
+		fmt.Fprintf(s.watchOutput(), "Running %q in %s after changes were detected\n", strings.Join(exec.Command, " "), serviceName)
+	}
+	err := s.execInServiceContainers(ctx, project, serviceName, options, exec)
+	emitWatchEvent(options, api.WatchEvent{
+		Type:    api.WatchEventExecCompleted,
+		Service: serviceName,
+		Action:  string(WatchActionExec),
+		Err:     err,
+	})
+	return err
+}
+
+func (s *composeService) execInServiceContainers(
+	ctx context.Context,
+	project *types.Project,
+	serviceName string,
+	options api.WatchOptions,
+	exec *TriggerExec,
+) error {
+	tarClient := newTarDockerClient(s, project, options)
+	containers, err := tarClient.ContainersForService(ctx, project.Name, serviceName)
+	if err != nil {
+		return err
+	}
+	eg, ctx := errgroup.WithContext(ctx)
+	for i := range containers {
+		containerID := containers[i].ID
+		eg.Go(func() error {
+			if err := tarClient.exec(ctx, containerID, triggerExecConfig(exec), nil, s.stdinfo()); err != nil {
+				return fmt.Errorf("running %q in %s: %w", strings.Join(exec.Command, " "), containerID, err)
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+// triggerExecConfig returns the config of the exec running the command of an exec trigger.
+func triggerExecConfig(exec *TriggerExec) moby.ExecConfig {
+	return moby.ExecConfig{
+		Cmd:          exec.Command,
+		WorkingDir:   exec.WorkingDir,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          exec.Tty,
+	}
+}
+
+// rebuildWatchedService rebuilds and recreates the service after changes to the paths of the rebuild events of
+// the batch.
+func (s *composeService) rebuildWatchedService(
+	ctx context.Context,
+	project *types.Project,
+	serviceName string,
+	options api.WatchOptions,
+	batch []fileEvent,
+	rebuilds *rebuildLimiter,
+) error {
+	var rebuildEvents []fileEvent
+	noCache := false
+	var waitTimeout time.Duration
+	for _, e := range batch {
+		if e.Action == WatchActionRebuild {
+			rebuildEvents = append(rebuildEvents, e)
+			noCache = noCache || e.NoCache
+			waitTimeout = max(waitTimeout, e.WaitTimeout)
+		}
+	}
+	rebuildPaths := batchHostPaths(rebuildEvents)
+	services := rebuildServices(serviceName, batch)
+	rebuilt := strings.Join(services, ", ")
+	emitWatchEvent(options, api.WatchEvent{
+		Type:    api.WatchEventRebuildStarted,
+		Service: serviceName,
+		Action:  string(WatchActionRebuild),
+		Paths:   rebuildPaths,
+	})
+	s.writeWatchMessage(options, watchMessage{
+		Service: serviceName,
+		Action:  WatchActionRebuild,
+		Status:  watchMessageStarted,
+		Paths:   rebuildPaths,
+	}, func(w io.Writer) {
+		fmt.Fprintf(
+			w,
+			"Rebuilding %s after changes were detected:%s\n",
+			rebuilt,
+			strings.Join(append([]string{""}, rebuildPaths...), "\n  - "),
+		)
+	})
+	start := s.clock.Now()
+	// the rebuild_target of the triggers of another service may rebuild the same services
+	unlock, err := s.rebuilding.lock(ctx, rebuildLockKeys(project.Name, services))
+	if err == nil {
+		err = rebuilds.run(ctx, rebuilt, func() error {
+			return s.Up(ctx, project, watchRebuildUpOptions(project, services, noCache))
+		})
+		if err == nil && waitTimeout > 0 {
+			err = s.waitForRebuiltServices(ctx, project, services, waitTimeout)
+		}
+		unlock()
+	}
+	emitWatchEvent(options, api.WatchEvent{
+		Type:    api.WatchEventRebuildCompleted,
+		Service: serviceName,
+		Action:  string(WatchActionRebuild),
+		Paths:   rebuildPaths,
+		Err:     err,
+	})
+	duration := s.clock.Since(start)
+	s.writeWatchMessage(options, completedWatchMessage(serviceName, WatchActionRebuild, rebuildPaths, duration, err), func(w io.Writer) {
+		writeWatchRebuildSummary(w, rebuilt, len(rebuildPaths), duration, err)
+	})
+	if err == nil && waitTimeout > 0 && !options.JSON {
+		fmt.Fprintf(s.watchOutput(), "%s ready\n", rebuilt)
+	}
+	if err != nil {
+		if !options.JSON {
+			fmt.Fprintf(s.watchOutput(), "Application failed to start after update\n")
+		}
+		// returned as-is for the continue policy, so that it's counted and reported like the
+		// failures of the other actions
+		return s.applyOnErrorPolicy(ctx, project, serviceName, options, batchOnErrorPolicy(rebuildEvents), err)
+	}
+	return nil
+}
+
+// rebuildServices returns the services rebuilt for the rebuild events of the batch, the rebuild
+// targets of their triggers or the watched service.
+func rebuildServices(serviceName string, batch []fileEvent) []string {
+	var services []string
+	for _, e := range batch {
+		if e.Action != WatchActionRebuild {
+			continue
+		}
+		targets := []string{serviceName}
+		if e.RebuildTargets != "" {
+			targets = strings.Split(e.RebuildTargets, ",")
+		}
+		for _, target := range targets {
+			if !slices.Contains(services, target) {
+				services = append(services, target)
+			}
+		}
+	}
+	return services
+}
+
+// watchRebuildUpOptions returns the options to rebuild and recreate the services after changes.
+func watchRebuildUpOptions(project *types.Project, services []string, noCache bool) api.UpOptions {
+	return api.UpOptions{
+		Create: api.CreateOptions{
+			Build: &api.BuildOptions{
+				Pull:    false,
+				Push:    false,
+				NoCache: noCache,
+				// restrict the build to ONLY these services, not any of their dependencies
+				Services: services,
+			},
+			Services: services,
+			Inherit:  true,
+		},
+		Start: api.StartOptions{
+			Services: services,
+			Project:  project,
+		},
+	}
+}
+
+// waitForRebuiltServices waits for the containers of the services to be healthy, or running if
+// they don't have a healthcheck, like `up --wait`.
+func (s *composeService) waitForRebuiltServices(ctx context.Context, project *types.Project, services []string, timeout time.Duration) error {
+	containers, err := s.getContainers(ctx, project.Name, oneOffExclude, true, services...)
+	if err != nil {
+		return err
+	}
+	depends := types.DependsOnConfig{}
+	for _, name := range services {
+		service, err := project.GetService(name)
+		if err != nil {
+			return err
+		}
+		depends[name] = types.ServiceDependency{
+			Condition: getDependencyCondition(service, project),
+			Required:  true,
+		}
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := s.waitDependencies(waitCtx, project, depends, containers); err != nil {
+		return err
+	}
+	// the wait stops without an error once the context is done
+	if waitCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s not healthy after %s", strings.Join(services, ", "), timeout)
+	}
+	return nil
+}
+
+// rebuildLimiter bounds the number of services rebuilt concurrently, a nil limiter doesn't.
+type rebuildLimiter struct {
+	sem *semaphore.Weighted
+}
+
+// newRebuildLimiter returns a limiter for the given number of concurrent rebuilds: zero uses a default based
+// on GOMAXPROCS, a negative limit means no limit.
+func newRebuildLimiter(limit int) *rebuildLimiter {
+	switch {
+	case limit < 0:
+		return nil
+	case limit == 0:
+		limit = max(1, runtime.GOMAXPROCS(0)/2)
+	}
+	return &rebuildLimiter{sem: semaphore.NewWeighted(int64(limit))}
+}
+
+// run calls rebuild once a rebuild slot is available.
+func (l *rebuildLimiter) run(ctx context.Context, serviceName string, rebuild func() error) error {
+	if l == nil {
+		return rebuild()
+	}
+	if !l.sem.TryAcquire(1) {
+		logrus.Debugf("waiting for other rebuilds to complete before rebuilding %s", serviceName)
+		if err := l.sem.Acquire(ctx, 1); err != nil {
+			return err
+		}
+	}
+	defer l.sem.Release(1)
+	return rebuild()
+}
+
+// serviceLocks serializes the rebuilds of each service, so that a rebuild never overlaps an
+// in-flight rebuild of the same service. The zero value is ready to use.
+type serviceLocks struct {
+	mu    gosync.Mutex
+	locks map[string]chan struct{}
+}
+
+// lock waits for the locks of all the keys, in order so that two callers locking the same keys
+// can't deadlock, and returns the function releasing them.
+func (l *serviceLocks) lock(ctx context.Context, keys []string) (func(), error) {
+	keys = slices.Clone(keys)
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+	var held []chan struct{}
+	unlock := func() {
+		for _, c := range held {
+			<-c
+		}
+	}
+	for _, key := range keys {
+		c := l.get(key)
+		select {
+		case c <- struct{}{}:
+			held = append(held, c)
+		default:
+			logrus.Debugf("waiting for the in-flight rebuild of %s to complete", key)
+			select {
+			case c <- struct{}{}:
+				held = append(held, c)
+			case <-ctx.Done():
+				unlock()
+				return nil, ctx.Err()
+			}
+		}
+	}
+	return unlock, nil
+}
+
+func (l *serviceLocks) get(key string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locks == nil {
+		l.locks = map[string]chan struct{}{}
+	}
+	c, ok := l.locks[key]
+	if !ok {
+		c = make(chan struct{}, 1)
+		l.locks[key] = c
+	}
+	return c
+}
+
+// rebuildLockKeys returns the keys locked while rebuilding the services of the project.
+func rebuildLockKeys(projectName string, services []string) []string {
+	keys := make([]string, 0, len(services))
+	for _, service := range services {
+		keys = append(keys, projectName+"/"+service)
+	}
+	return keys
+}
+
+// syncWithRetry syncs the path mappings, retrying with an exponential backoff when the sync fails
+// with a transient error (e.g. the container is restarting).
+func (s *composeService) syncWithRetry(
+	ctx context.Context,
+	syncer sync.Syncer,
+	service types.ServiceConfig,
+	pathMappings []sync.PathMapping,
+) error {
+	retries := syncRetries()
+	delay := syncRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := syncer.Sync(ctx, service, pathMappings)
+		if err == nil || attempt >= retries || !isRetryableSyncError(err) {
+			return err
+		}
+		logrus.Debugf("sync failed for service %s, retrying in %s: %v", service.Name, delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-s.clock.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// resyncAfterReconnect waits for the Docker daemon to be reachable again, then syncs the path
+// mappings again to the containers of the service, which are resolved again as they may have been
+// recreated while the daemon was down.
+func (s *composeService) resyncAfterReconnect(
+	ctx context.Context,
+	project *types.Project,
+	service types.ServiceConfig,
+	options api.WatchOptions,
+	syncer sync.Syncer,
+	pathMappings []sync.PathMapping,
+) error {
+	if err := s.waitForDaemon(ctx); err != nil {
+		return err
+	}
+	containers, err := runningContainersClient{newTarDockerClient(s, project, options)}.ContainersForService(ctx, project.Name, service.Name)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return sync.ErrNoContainers
+	}
+	logrus.Debugf("reconnected to the Docker daemon, resyncing %d path(s) to %d container(s) of service %s",
+		len(pathMappings), len(containers), service.Name)
+	return s.syncWithRetry(ctx, syncer, service, pathMappings)
+}
+
+// waitForDaemon pings the Docker daemon until it answers, for up to daemonReconnectTimeout.
+func (s *composeService) waitForDaemon(ctx context.Context) error {
+	timeout := s.clock.After(daemonReconnectTimeout)
+	for {
+		_, err := s.apiClient().Ping(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			return errors.Wrapf(err, "the Docker daemon is still unreachable after %s", daemonReconnectTimeout)
+		case <-s.clock.After(daemonReconnectInterval):
+		}
+	}
+}
+
+// syncRetries returns the number of retries for transient sync errors.
+func syncRetries() int {
+	v, ok := os.LookupEnv("COMPOSE_WATCH_SYNC_RETRIES")
+	if !ok {
+		return defaultSyncRetries
+	}
+	retries, err := strconv.Atoi(v)
+	if err != nil || retries < 0 {
+		logrus.Warnf("invalid COMPOSE_WATCH_SYNC_RETRIES value %q, using %d", v, defaultSyncRetries)
+		return defaultSyncRetries
+	}
+	return retries
+}
+
+// isRetryableSyncError reports whether a sync error is likely transient, as opposed to errors
+// which will fail again (e.g. invalid configuration).
+func isRetryableSyncError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var (
+		conflict    errdefs.ErrConflict
+		notFound    errdefs.ErrNotFound
+		unavailable errdefs.ErrUnavailable
+	)
+	return errors.As(err, &conflict) || errors.As(err, &notFound) || errors.As(err, &unavailable) ||
+		client.IsErrConnectionFailed(err)
+}
+
+// emitWatchEvent sends the event to the handler of the watch options, if any.
+func emitWatchEvent(options api.WatchOptions, event api.WatchEvent) {
+	if options.EventHandler != nil {
+		options.EventHandler(event)
+	}
+}
+
+// batchHostPaths returns the host paths of the batch events.
+func batchHostPaths(batch []fileEvent) []string {
+	paths := make([]string, len(batch))
+	for i := range batch {
+		paths[i] = batch[i].HostPath
+	}
+	return paths
+}
+
+// batchActionHostPaths returns the host paths of the batch events for the action.
+func batchActionHostPaths(batch []fileEvent, action WatchAction) []string {
+	var paths []string
+	for _, e := range batch {
+		if e.Action == action {
+			paths = append(paths, e.HostPath)
+		}
+	}
+	return paths
+}
+
+// batchOnErrorPolicy returns the most restrictive on_error policy of the batch events.
+func batchOnErrorPolicy(batch []fileEvent) WatchOnError {
+	policy := WatchOnErrorContinue
+	for _, e := range batch {
+		switch e.OnError {
+		case WatchOnErrorStop:
+			return WatchOnErrorStop
+		case WatchOnErrorRestart:
+			policy = WatchOnErrorRestart
+		}
+	}
+	return policy
+}
+
+// execEvents returns the events of the batch running the exec trigger command.
+func execEvents(batch []fileEvent, exec *TriggerExec) []fileEvent {
+	var events []fileEvent
+	for _, e := range batch {
+		if e.Action == WatchActionExec && e.Exec == exec {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// applyOnErrorPolicy handles the failure of a watch action according to the on_error policy.
+//
+// The error is returned as-is for the `continue` policy, so it gets reported by the caller.
+func (s *composeService) applyOnErrorPolicy(
+	ctx context.Context,
+	project *types.Project,
+	serviceName string,
+	options api.WatchOptions,
+	policy WatchOnError,
+	err error,
+) error {
+	switch policy {
+	case WatchOnErrorStop:
+		return watchStopError{service: serviceName, err: err}
+	case WatchOnErrorRestart:
+		if !options.JSON {
+			fmt.Fprintf(s.watchOutput(), "Restarting %s after error: %v\n", serviceName, err)
+		}
+		if err := s.restartWatchedService(ctx, project, serviceName); err != nil {
+			return fmt.Errorf("restarting service %s: %w", serviceName, err)
+		}
+		return nil
+	default:
+		return err
+	}
+}
+
+// restartWatchedService restarts the containers of the service.
+//
+// Restart alters the project it's given, so it runs against a project only made of the service.
+func (s *composeService) restartWatchedService(ctx context.Context, project *types.Project, serviceName string) error {
+	service, err := project.GetService(serviceName)
+	if err != nil {
+		return err
+	}
+	service.DependsOn = nil
+	return s.Restart(ctx, project.Name, api.RestartOptions{
+		Project: &types.Project{
+			Name:       project.Name,
+			WorkingDir: project.WorkingDir,
+			Services:   types.Services{service},
+		},
+		Services: []string{serviceName},
+	})
+}
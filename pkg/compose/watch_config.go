@@ -0,0 +1,668 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compose
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+
+	"github.com/docker/compose/v2/internal/sync"
+
+	"github.com/compose-spec/compose-go/types"
+	"github.com/hashicorp/go-multierror"
+	"github.com/mattn/go-shellwords"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/docker/compose/v2/pkg/watch"
+)
+
+// ValidateWatchConfig checks the x-develop section of the selected services, as Watch would
+// before watching them, and returns all the problems found rather than only the first one.
+//
+// The checks depending on the Docker engine, e.g. of the paths also bind mounted, aren't run.
+// Unknown keys are reported as errors, like Watch does in strict mode.
+func ValidateWatchConfig(project *types.Project, services []string) error {
+	if err := project.ForServices(services); err != nil {
+		return err
+	}
+	var errs []error
+	watched := false
+	for _, service := range project.Services {
+		// unknown keys are problems of the config as well
+		config, err := loadWatchedServiceConfig(service, project, true)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("service %s: %w", service.Name, err))
+			continue
+		}
+		watched = watched || (config != nil && (service.Build != nil || len(config.Watch) > 0))
+	}
+	if len(errs) == 0 && !watched {
+		return fmt.Errorf("none of the selected services is configured for watch, consider setting an 'x-develop' section")
+	}
+	return multierror.Append(nil, errs...).ErrorOrNil()
+}
+
+// loadWatchedServiceConfig loads the development config of the service and checks it can be
+// watched, the config is nil if the service doesn't declare one. Unknown keys in the config are
+// reported as warnings, or as an error if strict.
+func loadWatchedServiceConfig(service types.ServiceConfig, project *types.Project, strict bool) (*DevelopmentConfig, error) {
+	config, err := loadDevelopmentConfig(service, project)
+	if err != nil || config == nil {
+		return config, err
+	}
+	if len(config.unknownKeys) > 0 {
+		if strict {
+			return nil, fmt.Errorf("unknown x-develop key(s) for service %s: %s", service.Name, strings.Join(config.unknownKeys, ", "))
+		}
+		for _, key := range config.unknownKeys {
+			logrus.Warnf("service %s: unknown x-develop key %q, it's ignored", service.Name, key)
+		}
+	}
+	if service.Build == nil && config.requiresBuild() {
+		// service configured with rebuild watchers but no build section
+		return nil, fmt.Errorf("can't watch service %q without a build context", service.Name)
+	}
+	return config, nil
+}
+
+// resolveTriggerTargets resolves the relative targets of the trigger against the service working
+// directory, as the working directory of the image isn't known before the container is created.
+func resolveTriggerTargets(trigger *Trigger, service types.ServiceConfig) error {
+	resolve := func(target string) (string, error) {
+		if target == "" || path.IsAbs(target) {
+			return target, nil
+		}
+		if !path.IsAbs(service.WorkingDir) {
+			return "", fmt.Errorf("watch rule for %s: target %q must be an absolute path, or service %s must set an absolute working_dir",
+				trigger.Path, target, service.Name)
+		}
+		resolved := path.Join(service.WorkingDir, target)
+		if strings.HasSuffix(target, "/") {
+			// keep the trailing slash of a directory target, see triggerContainerPath
+			resolved += "/"
+		}
+		return resolved, nil
+	}
+	var err error
+	if trigger.Target, err = resolve(trigger.Target); err != nil {
+		return err
+	}
+	for i := range trigger.Targets {
+		if trigger.Targets[i], err = resolve(trigger.Targets[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadDevelopmentConfig(service types.ServiceConfig, project *types.Project) (*DevelopmentConfig, error) {
+	var config DevelopmentConfig
+	y, ok := service.Extensions["x-develop"]
+	if !ok {
+		return nil, nil
+	}
+	y, err := mergeDevelopmentExtensions(project.Extensions["x-develop"], y)
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %w", service.Name, err)
+	}
+	config.unknownKeys, err = decodeDevelopmentConfig(y, &config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Debounce < 0 {
+		return nil, fmt.Errorf("service %s: debounce duration can't be negative: %s", service.Name, config.Debounce)
+	}
+	if config.DebounceMaxWait < 0 {
+		return nil, fmt.Errorf("service %s: debounce_max_wait can't be negative: %s", service.Name, config.DebounceMaxWait)
+	}
+	if config.PersistSyncState && !config.SkipUnchangedFiles {
+		return nil, fmt.Errorf("service %s: persist_sync_state requires skip_unchanged_files", service.Name)
+	}
+	if config.OnSync != "" {
+		args, err := shellwords.Parse(config.OnSync)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: parsing on_sync command %q: %w", service.Name, config.OnSync, err)
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("service %s: on_sync command is empty", service.Name)
+		}
+	} else if config.OnSyncRequired {
+		return nil, fmt.Errorf("service %s: on_sync_required requires an on_sync command", service.Name)
+	}
+	if config.MaxBatchSize < 0 {
+		return nil, fmt.Errorf("service %s: max_batch_size can't be negative: %d", service.Name, config.MaxBatchSize)
+	}
+	switch config.BatchOverflow {
+	case "", BatchOverflowFlush:
+	case BatchOverflowRebuild:
+		if service.Build == nil {
+			return nil, fmt.Errorf("service %s doesn't have a build section, can't apply 'rebuild' on batch overflow", service.Name)
+		}
+	default:
+		return nil, fmt.Errorf("service %s: invalid batch_overflow %q, must be one of %q or %q",
+			service.Name, config.BatchOverflow, BatchOverflowFlush, BatchOverflowRebuild)
+	}
+	switch config.SyncBackend {
+	case "", SyncBackendTar, SyncBackendCopy:
+	default:
+		return nil, fmt.Errorf("service %s: invalid sync_backend %q, must be one of %q or %q",
+			service.Name, config.SyncBackend, SyncBackendTar, SyncBackendCopy)
+	}
+	// the project directory is only required to resolve relative paths, a failure to resolve
+	// its symlinks (e.g. because it's momentarily inaccessible) isn't fatal
+	var baseDir string
+	if config.BasePath != "" {
+		baseDir, err = resolveDevelopmentBasePath(config.BasePath, project)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", service.Name, err)
+		}
+	}
+	resolveBaseDir := func() (string, error) {
+		if baseDir != "" {
+			return baseDir, nil
+		}
+		if project.WorkingDir == "" {
+			return "", errors.New("relative watch paths require a project directory")
+		}
+		dir, err := filepath.EvalSymlinks(project.WorkingDir)
+		if err != nil {
+			logrus.Warnf("resolving symlink for %q, using it as is: %v", project.WorkingDir, err)
+			dir = project.WorkingDir
+		}
+		baseDir = dir
+		return baseDir, nil
+	}
+
+	for i, file := range config.IgnoreFiles {
+		if filepath.IsAbs(file) {
+			continue
+		}
+		dir, err := resolveBaseDir()
+		if err != nil {
+			return nil, fmt.Errorf("ignore file %s: %w", file, err)
+		}
+		config.IgnoreFiles[i] = filepath.Join(dir, file)
+	}
+
+	config.Watch, err = expandTriggerActions(config.Watch)
+	if err != nil {
+		return nil, err
+	}
+	for i, trigger := range config.Watch {
+		if root, pattern, ok := splitGlobPath(trigger.Path); ok {
+			trigger.Path = root
+			trigger.Include = append(trigger.Include, pattern)
+		}
+		if !filepath.IsAbs(trigger.Path) {
+			dir, err := resolveBaseDir()
+			if err != nil {
+				return nil, fmt.Errorf("watch rule for %s: %w", trigger.Path, err)
+			}
+			trigger.Path = filepath.Join(dir, trigger.Path)
+		}
+		if p, err := filepath.EvalSymlinks(trigger.Path); err == nil {
+			// this might fail because the path doesn't exist, etc.
+			trigger.Path = p
+		}
+		trigger.Path = filepath.Clean(trigger.Path)
+		if trigger.Path == "" {
+			return nil, errors.New("watch rules MUST define a path")
+		}
+		if err := checkTriggerPathScope(trigger.Path); err != nil {
+			return nil, fmt.Errorf("watch rule for %s: %w", trigger.Path, err)
+		}
+
+		switch WatchAction(trigger.Action) {
+		case WatchActionSync, WatchActionRestart, WatchActionSyncRestart:
+		case WatchActionRebuild:
+			if len(trigger.RebuildTarget) > 0 {
+				if err := checkRebuildTargets(trigger, project); err != nil {
+					return nil, err
+				}
+			} else if service.Build == nil {
+				return nil, fmt.Errorf("service %s doesn't have a build section, can't apply 'rebuild' on watch", service.Name)
+			}
+		case WatchActionExec:
+			if trigger.Exec == nil || len(trigger.Exec.Command) == 0 {
+				return nil, fmt.Errorf("watch rule for %s: 'exec' action requires a command", trigger.Path)
+			}
+		case WatchActionExtract:
+			if trigger.Target == "" || len(trigger.Targets) > 0 {
+				return nil, fmt.Errorf("watch rule for %s: 'extract' action requires a single container path as target", trigger.Path)
+			}
+		default:
+			return nil, fmt.Errorf("watch rule for %s: invalid action %q, must be one of %q, %q, %q, %q, %q or %q",
+				trigger.Path, trigger.Action, WatchActionSync, WatchActionRebuild, WatchActionRestart, WatchActionSyncRestart, WatchActionExec, WatchActionExtract)
+		}
+		if trigger.PollInterval < 0 {
+			return nil, fmt.Errorf("watch rule for %s: poll_interval can't be negative: %s", trigger.Path, trigger.PollInterval)
+		}
+		if trigger.PollInterval > 0 && trigger.Action != string(WatchActionExtract) {
+			return nil, fmt.Errorf("watch rule for %s: poll_interval only applies to the 'extract' action", trigger.Path)
+		}
+		if trigger.NoCache && trigger.Action != string(WatchActionRebuild) {
+			return nil, fmt.Errorf("watch rule for %s: no_cache only applies to the 'rebuild' action", trigger.Path)
+		}
+		if (trigger.Wait || trigger.WaitTimeout != 0) && trigger.Action != string(WatchActionRebuild) {
+			return nil, fmt.Errorf("watch rule for %s: wait only applies to the 'rebuild' action", trigger.Path)
+		}
+		if trigger.WaitTimeout < 0 {
+			return nil, fmt.Errorf("watch rule for %s: wait_timeout can't be negative: %s", trigger.Path, trigger.WaitTimeout)
+		}
+		if trigger.WaitTimeout > 0 && !trigger.Wait {
+			return nil, fmt.Errorf("watch rule for %s: wait_timeout requires wait to be enabled", trigger.Path)
+		}
+		if len(trigger.RebuildTarget) > 0 && trigger.Action != string(WatchActionRebuild) {
+			return nil, fmt.Errorf("watch rule for %s: rebuild_target only applies to the 'rebuild' action", trigger.Path)
+		}
+		if len(trigger.When) > 0 {
+			if trigger.Action != string(WatchActionRebuild) {
+				return nil, fmt.Errorf("watch rule for %s: when only applies to the 'rebuild' action", trigger.Path)
+			}
+			if _, err := triggerWhenMatcher(trigger); err != nil {
+				return nil, fmt.Errorf("watch rule for %s: invalid when pattern: %w", trigger.Path, err)
+			}
+		}
+		if trigger.Exec != nil && trigger.Action != string(WatchActionExec) {
+			return nil, fmt.Errorf("watch rule for %s: exec only applies to the 'exec' action", trigger.Path)
+		}
+		if err := resolveTriggerTargets(&trigger, service); err != nil {
+			return nil, err
+		}
+
+		switch WatchOnError(trigger.OnError) {
+		case "", WatchOnErrorContinue, WatchOnErrorStop, WatchOnErrorRestart:
+		default:
+			return nil, fmt.Errorf("watch rule for %s: invalid on_error policy %q, must be one of %q, %q or %q",
+				trigger.Path, trigger.OnError, WatchOnErrorContinue, WatchOnErrorStop, WatchOnErrorRestart)
+		}
+
+		if trigger.Filter != "" {
+			if !WatchAction(trigger.Action).syncsFiles() {
+				return nil, fmt.Errorf("watch rule for %s: filter only applies to the 'sync' and 'sync+restart' actions", trigger.Path)
+			}
+			if err := sync.ValidateFilter(trigger.Filter); err != nil {
+				return nil, fmt.Errorf("watch rule for %s: %w", trigger.Path, err)
+			}
+		}
+
+		for _, ext := range trigger.Extensions {
+			if !strings.HasPrefix(ext, ".") || len(ext) == 1 || strings.ContainsAny(ext, `/\`) {
+				return nil, fmt.Errorf("watch rule for %s: invalid extension %q, must start with a dot like \".go\"", trigger.Path, ext)
+			}
+		}
+
+		if trigger.MaxFileSize < 0 {
+			return nil, fmt.Errorf("watch rule for %s: max_file_size can't be negative", trigger.Path)
+		}
+
+		if trigger.PreserveMode && !WatchAction(trigger.Action).syncsFiles() {
+			return nil, fmt.Errorf("watch rule for %s: preserve_mode only applies to the 'sync' and 'sync+restart' actions", trigger.Path)
+		}
+		if trigger.Atomic && !WatchAction(trigger.Action).syncsFiles() {
+			return nil, fmt.Errorf("watch rule for %s: atomic only applies to the 'sync' and 'sync+restart' actions", trigger.Path)
+		}
+		if trigger.Chown != "" {
+			if !WatchAction(trigger.Action).syncsFiles() {
+				return nil, fmt.Errorf("watch rule for %s: chown only applies to the 'sync' and 'sync+restart' actions", trigger.Path)
+			}
+			if err := sync.ValidateChown(trigger.Chown); err != nil {
+				return nil, fmt.Errorf("watch rule for %s: %w", trigger.Path, err)
+			}
+		}
+
+		config.Watch[i] = trigger
+	}
+	if err := checkExtractFeedbackLoop(config.Watch); err != nil {
+		return nil, fmt.Errorf("service %s: %w", service.Name, err)
+	}
+	for _, pair := range overlappingTriggers(config.Watch) {
+		first, second := config.Watch[pair[0]], config.Watch[pair[1]]
+		logrus.Warnf("service %s: watch paths %s and %s overlap, changes to both are handled by each %s rule",
+			service.Name, first.Path, second.Path, first.Action)
+	}
+	return &config, nil
+}
+
+// resolveDevelopmentBasePath returns the absolute path of the base_path directory, relative to the
+// project directory.
+func resolveDevelopmentBasePath(basePath string, project *types.Project) (string, error) {
+	dir := basePath
+	if !filepath.IsAbs(dir) {
+		if project.WorkingDir == "" {
+			return "", errors.New("a relative base_path requires a project directory")
+		}
+		dir = filepath.Join(project.WorkingDir, dir)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", fmt.Errorf("base_path %s: %w", basePath, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("base_path %s is not a directory", basePath)
+	}
+	if p, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = p
+	}
+	return filepath.Clean(dir), nil
+}
+
+// checkRebuildTargets returns an error if a service rebuilt by the trigger isn't a service of the
+// project with a build section.
+func checkRebuildTargets(trigger Trigger, project *types.Project) error {
+	for _, name := range trigger.RebuildTarget {
+		target, err := project.GetService(name)
+		if err != nil {
+			return fmt.Errorf("watch rule for %s: rebuild_target %q isn't a service of the project", trigger.Path, name)
+		}
+		if target.Build == nil {
+			return fmt.Errorf("watch rule for %s: service %s doesn't have a build section, can't be a rebuild_target", trigger.Path, name)
+		}
+	}
+	return nil
+}
+
+// triggerActionRanks are the positions of the actions in the handling of a batch of changes.
+var triggerActionRanks = map[WatchAction]int{
+	WatchActionSync:    0,
+	WatchActionExec:    1,
+	WatchActionRestart: 2,
+	WatchActionRebuild: 2,
+}
+
+// allowBroadWatchPathsEnvVar allows watching a filesystem root or the home directory
+const allowBroadWatchPathsEnvVar = "COMPOSE_WATCH_ALLOW_BROAD_PATHS"
+
+// checkTriggerPathScope returns an error if p is a filesystem root or the home directory of the
+// user, most likely a misconfigured path, as watching such a tree would exhaust the watches
+// allowed by the system. It's only a warning if allowed by COMPOSE_WATCH_ALLOW_BROAD_PATHS.
+func checkTriggerPathScope(p string) error {
+	var scope string
+	if filepath.Dir(p) == p {
+		scope = "the filesystem root"
+	} else if home, err := os.UserHomeDir(); err == nil && home != "" {
+		if resolved, err := filepath.EvalSymlinks(home); err == nil {
+			home = resolved
+		}
+		if filepath.Clean(home) == p {
+			scope = "the home directory"
+		}
+	}
+	if scope == "" {
+		return nil
+	}
+	if allow, _ := strconv.ParseBool(os.Getenv(allowBroadWatchPathsEnvVar)); allow {
+		logrus.Warnf("watching %s (%s), this may exhaust the file watches allowed by the system", p, scope)
+		return nil
+	}
+	return fmt.Errorf("refusing to watch %s, set %s=true to watch it anyway", scope, allowBroadWatchPathsEnvVar)
+}
+
+// expandTriggerActions replaces the triggers with several actions by one trigger per action, each
+// keeping the settings which apply to its action.
+func expandTriggerActions(triggers []Trigger) ([]Trigger, error) {
+	expanded := make([]Trigger, 0, len(triggers))
+	for _, trigger := range triggers {
+		if len(trigger.Actions) == 0 {
+			expanded = append(expanded, trigger)
+			continue
+		}
+		if trigger.Action != "" {
+			return nil, fmt.Errorf("watch rule for %s: can't set both 'action' and 'actions'", trigger.Path)
+		}
+		rank := -1
+		var syncs, execs, rebuilds bool
+		for i, action := range trigger.Actions {
+			if slices.Contains(trigger.Actions[:i], action) {
+				return nil, fmt.Errorf("watch rule for %s: action %q is listed twice", trigger.Path, action)
+			}
+			if WatchAction(action) == WatchActionSyncRestart {
+				return nil, fmt.Errorf("watch rule for %s: %q can't be combined with other actions, list %q then %q instead",
+					trigger.Path, action, WatchActionSync, WatchActionRestart)
+			}
+			if WatchAction(action) == WatchActionExtract {
+				return nil, fmt.Errorf("watch rule for %s: %q can't be combined with other actions", trigger.Path, action)
+			}
+			r, ok := triggerActionRanks[WatchAction(action)]
+			if !ok {
+				// reported along with the other invalid actions
+				continue
+			}
+			if r == rank && r == triggerActionRanks[WatchActionRebuild] {
+				return nil, fmt.Errorf("watch rule for %s: %q and %q can't be combined, a rebuild recreates the containers",
+					trigger.Path, WatchActionRestart, WatchActionRebuild)
+			}
+			if r <= rank {
+				return nil, fmt.Errorf("watch rule for %s: invalid order of actions %q, they run as %s, %s, then %s or %s",
+					trigger.Path, trigger.Actions, WatchActionSync, WatchActionExec, WatchActionRestart, WatchActionRebuild)
+			}
+			rank = r
+			syncs = syncs || WatchAction(action) == WatchActionSync
+			execs = execs || WatchAction(action) == WatchActionExec
+			rebuilds = rebuilds || WatchAction(action) == WatchActionRebuild
+		}
+		for _, action := range trigger.Actions {
+			t := trigger
+			t.Action, t.Actions = action, nil
+			// the settings of another action are dropped, unless no action of the list uses them
+			// so that they are reported as invalid
+			if syncs && WatchAction(action) != WatchActionSync {
+				t.Target, t.Targets, t.Filter, t.PreserveMode, t.Atomic, t.Chown = "", nil, "", false, false, ""
+			}
+			if execs && WatchAction(action) != WatchActionExec {
+				t.Exec = nil
+			}
+			if rebuilds && WatchAction(action) != WatchActionRebuild {
+				t.NoCache, t.RebuildTarget, t.When, t.Wait, t.WaitTimeout = false, nil, nil, false, 0
+			}
+			expanded = append(expanded, t)
+		}
+	}
+	return expanded, nil
+}
+
+// overlappingTriggers returns the indexes of the pairs of triggers with the same action, where
+// the path of one contains the path of the other.
+func overlappingTriggers(triggers []Trigger) [][2]int {
+	var pairs [][2]int
+	for i := range triggers {
+		for j := i + 1; j < len(triggers); j++ {
+			if triggers[i].Action != triggers[j].Action {
+				continue
+			}
+			if watch.IsChild(triggers[i].Path, triggers[j].Path) || watch.IsChild(triggers[j].Path, triggers[i].Path) {
+				pairs = append(pairs, [2]int{i, j})
+			}
+		}
+	}
+	return pairs
+}
+
+// mergeDevelopmentExtensions returns the `x-develop` extension of a service on top of the one
+// of the project: the settings of the project apply to all the services declaring `x-develop`,
+// unless the service sets them too. Each setting is replaced as a whole, so a service setting
+// `watch` replaces all the watch rules of the project, along with their ignores.
+func mergeDevelopmentExtensions(project interface{}, service interface{}) (interface{}, error) {
+	if project == nil {
+		return service, nil
+	}
+	defaults, ok := project.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("project x-develop must be a mapping, got %T", project)
+	}
+	merged := make(map[string]interface{}, len(defaults))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	if service == nil {
+		return merged, nil
+	}
+	overrides, ok := service.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("x-develop must be a mapping, got %T", service)
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+// decodeDevelopmentConfig decodes the raw `x-develop` extension into config, using the
+// JSON field names as keys. Durations are parsed with time.ParseDuration, sizes like memory limits.
+func decodeDevelopmentConfig(input interface{}, config *DevelopmentConfig) ([]string, error) {
+	// unlike the rest of the compose file, the x-develop section isn't checked against a schema,
+	// the keys which aren't decoded are reported instead of being silently ignored
+	var metadata mapstructure.Metadata
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		TagName:  "json",
+		Result:   config,
+		Metadata: &metadata,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			unitBytesHookFunc,
+			triggerTargetsHookFunc,
+			shellCommandHookFunc,
+			stringListHookFunc,
+		),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := decoder.Decode(input); err != nil {
+		return nil, err
+	}
+	sort.Strings(metadata.Unused)
+	return metadata.Unused, nil
+}
+
+// unitBytesHookFunc allows a size to be set as a string with a unit, e.g. "10mb".
+func unitBytesHookFunc(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(types.UnitBytes(0)) || from.Kind() != reflect.String {
+		return data, nil
+	}
+	size, err := units.RAMInBytes(data.(string))
+	return types.UnitBytes(size), err
+}
+
+// shellCommandHookFunc allows a command to be set as a string, split like a shell would.
+func shellCommandHookFunc(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(types.ShellCommand{}) || from.Kind() != reflect.String {
+		return data, nil
+	}
+	return shellwords.Parse(data.(string))
+}
+
+// stringListHookFunc allows a list of strings to be set as a single string.
+func stringListHookFunc(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(types.StringList{}) || from.Kind() != reflect.String {
+		return data, nil
+	}
+	return types.StringList{data.(string)}, nil
+}
+
+// triggerTargetsHookFunc allows a list of paths to be set as the trigger `target`, by
+// decoding it as `targets`, and a list of actions as the trigger `action`, by decoding it
+// as `actions`.
+func triggerTargetsHookFunc(_ reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(Trigger{}) {
+		return data, nil
+	}
+	raw, ok := data.(map[string]interface{})
+	if !ok {
+		return data, nil
+	}
+	var remapped map[string]interface{}
+	for single, plural := range map[string]string{"target": "targets", "action": "actions"} {
+		list, ok := raw[single].([]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := raw[plural]; ok {
+			return nil, fmt.Errorf("watch rules can't set both a list of '%s' and '%s'", single, plural)
+		}
+		if remapped == nil {
+			remapped = make(map[string]interface{}, len(raw))
+			for k, v := range raw {
+				remapped[k] = v
+			}
+		}
+		delete(remapped, single)
+		remapped[plural] = list
+	}
+	if remapped == nil {
+		return data, nil
+	}
+	return remapped, nil
+}
+
+// checkSyncFeedbackLoop returns an error if a sync trigger writes to a container path bind
+// mounted from one of the watched host paths: each sync would be seen as a change of the watched
+// path, syncing it again endlessly. The host path written by a sync is resolved through the bind
+// mounts of the service, and compared with the watched paths, either one containing the other, so
+// Watch fails rather than starting the loop.
+func checkSyncFeedbackLoop(triggers []Trigger, watched []string, volumes []types.ServiceVolumeConfig) error {
+	for _, trigger := range triggers {
+		if !WatchAction(trigger.Action).syncsFiles() {
+			continue
+		}
+		for _, target := range trigger.targets() {
+			for _, volume := range volumes {
+				if volume.Bind == nil || !isPathInside(volume.Target, target) {
+					continue
+				}
+				rel := strings.TrimPrefix(strings.TrimPrefix(path.Clean(target), path.Clean(volume.Target)), "/")
+				hostPath := filepath.Join(volume.Source, filepath.FromSlash(rel))
+				if isPathInside(trigger.Path, hostPath) && isPathInside(hostPath, trigger.Path) {
+					// a forced rule syncing a path over its own bind mount writes back the same content
+					continue
+				}
+				for _, w := range watched {
+					if isPathInside(w, hostPath) || isPathInside(hostPath, w) {
+						return fmt.Errorf("watch rule for %s syncs to %s, bind mounted from %s which is watched as %s: "+
+							"each sync would trigger another one", trigger.Path, target, hostPath, w)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isPathInside returns true if p is dir or one of its children. Whole path components are
+// compared, so that a sibling sharing a prefix (e.g. /app-extra for /app) isn't inside dir, and
+// Windows paths are compared case-insensitively with both separators.
+func isPathInside(dir string, p string) bool {
+	windows := runtime.GOOS == "windows" || isWindowsAbs(dir) || isWindowsAbs(p)
+	if windows {
+		dir, p = strings.ReplaceAll(dir, `\`, "/"), strings.ReplaceAll(p, `\`, "/")
+	}
+	dir, p = path.Clean(dir), path.Clean(p)
+	if windows {
+		dir, p = strings.ToLower(dir), strings.ToLower(p)
+	}
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
@@ -0,0 +1,246 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compose
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	moby "github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+
+	"github.com/docker/compose/v2/pkg/api"
+	"github.com/docker/compose/v2/pkg/watch"
+)
+
+// defaultExtractInterval is how often the container path of an extract rule is checked for changes
+const defaultExtractInterval = 2 * time.Second
+
+// extractClient runs the commands reading the content of the service containers.
+type extractClient interface {
+	ContainersForService(ctx context.Context, projectName string, serviceName string) ([]moby.Container, error)
+	ExecOutput(ctx context.Context, containerID string, cmd []string, out io.Writer) error
+}
+
+// extractTriggers removes the extract rules from the config and returns them, as they're not
+// watched on the host.
+func (c *DevelopmentConfig) extractTriggers() []Trigger {
+	var extracts, triggers []Trigger
+	for _, trigger := range c.Watch {
+		if WatchAction(trigger.Action) == WatchActionExtract {
+			extracts = append(extracts, trigger)
+		} else {
+			triggers = append(triggers, trigger)
+		}
+	}
+	c.Watch = triggers
+	return extracts
+}
+
+// checkExtractFeedbackLoop returns an error if the host path of an extract rule is watched by
+// another rule, the extracted files would then be handled as changes made on the host.
+func checkExtractFeedbackLoop(triggers []Trigger) error {
+	for _, extract := range triggers {
+		if WatchAction(extract.Action) != WatchActionExtract {
+			continue
+		}
+		for _, trigger := range triggers {
+			if WatchAction(trigger.Action) == WatchActionExtract {
+				continue
+			}
+			if watch.IsChild(trigger.Path, extract.Path) || watch.IsChild(extract.Path, trigger.Path) {
+				return fmt.Errorf("files extracted to %s would be handled as changes by the %s rule for %s",
+					extract.Path, trigger.Action, trigger.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// watchExtract polls the container path of an extract rule until the context is cancelled, and
+// copies its content to the host path of the rule each time it changes. Changes inside the
+// containers can't be observed from the host, so they're detected by comparing archives of the
+// container path.
+func (s *composeService) watchExtract(ctx context.Context, projectName string, serviceName string, options api.WatchOptions, trigger Trigger, client extractClient, summary *watchSummary) {
+	interval := trigger.PollInterval
+	if interval == 0 {
+		interval = defaultExtractInterval
+	}
+	ticker := s.clock.NewTicker(interval)
+	defer ticker.Stop()
+	var last string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.Chan():
+			digest, err := s.extractOnce(ctx, projectName, serviceName, options, trigger, client, last)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				summary.warnf("Error extracting %s from service %s: %v", trigger.Target, serviceName, err)
+				continue
+			}
+			last = digest
+		}
+	}
+}
+
+// extractOnce copies the content of the container path to the host path if its archive differs
+// from the last extracted one, and returns the digest of the archive.
+func (s *composeService) extractOnce(
+	ctx context.Context,
+	projectName string,
+	serviceName string,
+	options api.WatchOptions,
+	trigger Trigger,
+	client extractClient,
+	last string,
+) (string, error) {
+	containers, err := client.ContainersForService(ctx, projectName, serviceName)
+	if err != nil {
+		return last, err
+	}
+	if len(containers) == 0 {
+		logrus.Debugf("no running containers for service %s, nothing to extract", serviceName)
+		return last, nil
+	}
+	// the replicas of a service are expected to generate the same content
+	var archive bytes.Buffer
+	if err := client.ExecOutput(ctx, containers[0].ID, []string{"tar", "-c", "-C", trigger.Target, "."}, &archive); err != nil {
+		return last, err
+	}
+	sum := sha256.Sum256(archive.Bytes())
+	digest := hex.EncodeToString(sum[:])
+	if digest == last {
+		return last, nil
+	}
+	files, err := extractArchive(&archive, trigger.Path)
+	if err != nil {
+		return last, err
+	}
+	if !options.JSON {
+		fmt.Fprintf(s.watchOutput(), "Extracted %d file(s) from %s in %s to %s\n", files, trigger.Target, serviceName, trigger.Path)
+	}
+	return digest, nil
+}
+
+// extractArchive writes the directories and regular files of the tar archive into dir, and
+// returns the number of files written. Other entries, like symlinks, are skipped. The archive
+// comes from the container, so its entries can't be written outside of dir, including through
+// symlinks on the host.
+func extractArchive(r io.Reader, dir string) (int, error) {
+	files := 0
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, err
+		}
+		name := path.Clean(header.Name)
+		if name == "." {
+			continue
+		}
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return files, fmt.Errorf("invalid path %q in the archive", header.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := checkNoSymlink(dir, name); err != nil {
+				return files, err
+			}
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return files, err
+			}
+		case tar.TypeReg:
+			// the file itself is replaced, rather than written through if it's a symlink
+			if err := checkNoSymlink(dir, path.Dir(name)); err != nil {
+				return files, err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return files, err
+			}
+			if err := writeExtractedFile(target, tr, header.FileInfo().Mode().Perm()); err != nil {
+				return files, err
+			}
+			files++
+		default:
+			logrus.Debugf("skipping %s in the extracted archive, not a regular file", header.Name)
+		}
+	}
+}
+
+// checkNoSymlink returns an error if one of the elements of the slash separated path name in dir
+// is a symlink.
+func checkNoSymlink(dir string, name string) error {
+	p := dir
+	for _, part := range strings.Split(name, "/") {
+		if part == "." {
+			continue
+		}
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			// created by the extraction
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract through the symlink %s", p)
+		}
+	}
+	return nil
+}
+
+// writeExtractedFile writes the file to a temporary file renamed to target once complete, so that
+// a failed extraction doesn't leave target truncated.
+func writeExtractedFile(target string, r io.Reader, mode os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".extract-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) //nolint:errcheck
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), target)
+}
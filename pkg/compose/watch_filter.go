@@ -0,0 +1,569 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compose
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+
+	"github.com/docker/compose/v2/internal/sync"
+
+	"github.com/compose-spec/compose-go/types"
+	"github.com/jonboulle/clockwork"
+	"github.com/sirupsen/logrus"
+
+	"github.com/docker/compose/v2/pkg/api"
+	"github.com/docker/compose/v2/pkg/watch"
+)
+
+// serviceIgnoreMatcher returns the matcher for paths which must not be watched at all for the service.
+//
+// The ignore files are loaded from the build context, or from the project directory for a service
+// without a build section.
+func serviceIgnoreMatcher(project *types.Project, service types.ServiceConfig, config DevelopmentConfig) (watch.PathMatcher, error) {
+	root := project.WorkingDir
+	if service.Build != nil {
+		root = service.Build.Context
+	}
+
+	// add a hardcoded set of ignores on top of what came from .dockerignore,
+	// `.git` is ignored unless explicitly included
+	matchers := []watch.PathMatcher{
+		watch.EphemeralPathMatcher(),
+	}
+	if !config.IncludeGit {
+		dotGitIgnore, err := watch.NewDockerPatternMatcher(root, []string{"**/.git"})
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, dotGitIgnore)
+	}
+
+	if service.Build != nil {
+		dockerignoreDir := root
+		if file := nearestDockerignore(root, project.WorkingDir); file != "" {
+			dockerignoreDir = filepath.Dir(file)
+		}
+		dockerIgnores, err := watch.LoadDockerIgnore(dockerignoreDir)
+		if err != nil {
+			return nil, err
+		}
+		// trigger paths can be outside the build context, where `.dockerignore` doesn't apply
+		matchers = append(matchers, watch.NewScopedMatcher(dockerignoreDir, dockerIgnores))
+	}
+
+	if config.GitIgnore {
+		gitIgnores, err := watch.LoadGitIgnore(root)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, gitIgnores)
+	}
+
+	for _, file := range config.IgnoreFiles {
+		ignores, err := watch.LoadIgnoreFile(file)
+		if os.IsNotExist(err) {
+			logrus.Warnf("ignore file %s of service %s doesn't exist", file, service.Name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, ignores)
+	}
+
+	if !config.IncludeHidden {
+		var allowed []string
+		if config.IncludeGit {
+			allowed = append(allowed, ".git")
+		}
+		hiddenIgnore, err := watch.HiddenDirPathMatcher(root, allowed...)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, hiddenIgnore)
+	}
+	return watch.NewCompositeMatcher(matchers...), nil
+}
+
+// nearestDockerignore returns the `.dockerignore` file of the build context, or if it doesn't have
+// one the closest in its parent directories up to the project directory, e.g. for a monorepo
+// keeping a single `.dockerignore` at its root. It's empty if none is found.
+func nearestDockerignore(context string, projectDir string) string {
+	for dir := filepath.Clean(context); ; {
+		file := filepath.Join(dir, ".dockerignore")
+		if _, err := os.Stat(file); err == nil {
+			if dir != filepath.Clean(context) {
+				logrus.Debugf("using %s for the build context %s", file, context)
+			}
+			return file
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir || !watch.IsChild(projectDir, parent) {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// changeLogLimit is the number of changes logged per second at debug level, all of them are
+// logged at trace level.
+const changeLogLimit = 20
+
+// changeLogSampler limits the debug logs of the changes received from the watcher, so that a
+// storm of changes (e.g. build outputs) doesn't flood the logs and slow down the event loop.
+type changeLogSampler struct {
+	clock   clockwork.Clock
+	limit   int
+	window  time.Time
+	logged  int
+	dropped int
+}
+
+func newChangeLogSampler(clock clockwork.Clock, limit int) *changeLogSampler {
+	return &changeLogSampler{clock: clock, limit: limit}
+}
+
+func (l *changeLogSampler) log(hostPath string, triggers []Trigger) {
+	if logrus.IsLevelEnabled(logrus.TraceLevel) {
+		for _, trigger := range triggers {
+			logrus.Tracef("change for %s - comparing with %s", hostPath, trigger.Path)
+		}
+		return
+	}
+	if !logrus.IsLevelEnabled(logrus.DebugLevel) {
+		return
+	}
+	if now := l.clock.Now(); now.Sub(l.window) >= time.Second {
+		if l.dropped > 0 {
+			logrus.Debugf("%d more change(s) not logged, use the trace log level to log all of them", l.dropped)
+		}
+		l.window, l.logged, l.dropped = now, 0, 0
+	}
+	if l.logged >= l.limit {
+		l.dropped++
+		return
+	}
+	l.logged++
+	logrus.Debugf("change for %s", hostPath)
+}
+
+// isOutsideBuildContext returns true if the service is built from a local context which doesn't
+// contain p.
+func isOutsideBuildContext(service types.ServiceConfig, p string) bool {
+	if service.Build == nil || !filepath.IsAbs(service.Build.Context) {
+		return false
+	}
+	if watch.IsChild(service.Build.Context, p) {
+		return false
+	}
+	resolved, err := filepath.EvalSymlinks(service.Build.Context)
+	return err != nil || !watch.IsChild(resolved, p)
+}
+
+// watchIgnoreMatcher returns the matcher for the paths of the service ignored by the watcher,
+// including the ones ignored by the caller's matcher if any.
+func watchIgnoreMatcher(project *types.Project, service types.ServiceConfig, config DevelopmentConfig, options api.WatchOptions) (watch.PathMatcher, error) {
+	ignore, err := serviceIgnoreMatcher(project, service, config)
+	if err != nil {
+		return nil, err
+	}
+	if options.IgnoreMatcher == nil {
+		return ignore, nil
+	}
+	custom, err := options.IgnoreMatcher(service)
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %w", service.Name, err)
+	}
+	if custom == nil {
+		return ignore, nil
+	}
+	return watch.NewCompositeMatcher(ignore, custom), nil
+}
+
+// includeOnlyMatcher matches the paths none of the triggers handle, for the include_only mode.
+type includeOnlyMatcher struct {
+	triggers []Trigger
+	// ignores are the ignore matchers of the triggers, see triggerIgnoreMatcher
+	ignores []watch.PathMatcher
+	// dirIgnores only match the Ignore patterns of the triggers: an Include pattern (e.g.
+	// "**/*.go") can match files at any depth of a directory it doesn't match
+	dirIgnores []watch.PathMatcher
+}
+
+func newIncludeOnlyMatcher(triggers []Trigger) (includeOnlyMatcher, error) {
+	ignores, err := triggerIgnoreMatchers(triggers)
+	if err != nil {
+		return includeOnlyMatcher{}, err
+	}
+	dirIgnores := make([]watch.PathMatcher, len(triggers))
+	for i, trigger := range triggers {
+		dirIgnores[i], err = watch.NewDockerPatternMatcher(trigger.Path, triggerRelativePatterns(trigger.Path, trigger.Ignore))
+		if err != nil {
+			return includeOnlyMatcher{}, err
+		}
+	}
+	return includeOnlyMatcher{triggers: triggers, ignores: ignores, dirIgnores: dirIgnores}, nil
+}
+
+func (m includeOnlyMatcher) Matches(f string) (bool, error) {
+	for i, trigger := range m.triggers {
+		hostPath, ok := triggerHostPath(trigger.Path, f)
+		if !ok {
+			continue
+		}
+		if len(trigger.Extensions) > 0 && !slices.Contains(trigger.Extensions, filepath.Ext(hostPath)) {
+			continue
+		}
+		ignored, err := m.ignores[i].Matches(hostPath)
+		if err != nil {
+			return false, err
+		}
+		if !ignored {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (m includeOnlyMatcher) MatchesEntireDir(f string) (bool, error) {
+	for i, trigger := range m.triggers {
+		if watch.IsChild(f, trigger.Path) {
+			// the directory has to be watched to reach the trigger path
+			return false, nil
+		}
+		if !watch.IsChild(trigger.Path, f) {
+			continue
+		}
+		ignored, err := m.dirIgnores[i].MatchesEntireDir(f)
+		if err != nil {
+			return false, err
+		}
+		if !ignored {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+var _ watch.PathMatcher = includeOnlyMatcher{}
+
+// triggerIgnoreMatchers returns the matchers for the paths ignored by each trigger.
+func triggerIgnoreMatchers(triggers []Trigger) ([]watch.PathMatcher, error) {
+	ignores := make([]watch.PathMatcher, len(triggers))
+	for i, trigger := range triggers {
+		ignore, err := triggerIgnoreMatcher(trigger)
+		if err != nil {
+			return nil, err
+		}
+		ignores[i] = ignore
+	}
+	return ignores, nil
+}
+
+// triggerIgnoreMatcher returns the matcher for the paths ignored by the trigger: everything but
+// the Include patterns if set, and the Ignore patterns. Both sets are matched separately so that
+// a negated Ignore pattern can only re-include paths which are part of the Include patterns.
+func triggerIgnoreMatcher(trigger Trigger) (watch.PathMatcher, error) {
+	ignore, err := watch.NewDockerPatternMatcher(trigger.Path, triggerRelativePatterns(trigger.Path, trigger.Ignore))
+	if err != nil {
+		return nil, err
+	}
+	if len(trigger.Include) == 0 {
+		return ignore, nil
+	}
+	patterns := []string{"**"}
+	for _, include := range triggerRelativePatterns(trigger.Path, trigger.Include) {
+		patterns = append(patterns, "!"+include)
+	}
+	excluded, err := watch.NewDockerPatternMatcher(trigger.Path, patterns)
+	if err != nil {
+		return nil, err
+	}
+	return watch.NewCompositeMatcher(excluded, ignore), nil
+}
+
+// triggerWhenMatchers returns the matchers for the when patterns of each trigger, nil for the
+// triggers without any.
+func triggerWhenMatchers(triggers []Trigger) ([]watch.PathMatcher, error) {
+	whens := make([]watch.PathMatcher, len(triggers))
+	for i, trigger := range triggers {
+		when, err := triggerWhenMatcher(trigger)
+		if err != nil {
+			return nil, err
+		}
+		whens[i] = when
+	}
+	return whens, nil
+}
+
+// triggerWhenMatcher returns the matcher for the when patterns of the trigger, anchored like the
+// ignore patterns, or nil if it has none.
+func triggerWhenMatcher(trigger Trigger) (watch.PathMatcher, error) {
+	if len(trigger.When) == 0 {
+		return nil, nil
+	}
+	return watch.NewDockerPatternMatcher(trigger.Path, triggerRelativePatterns(trigger.Path, trigger.When))
+}
+
+// triggerRelativePatterns anchors the patterns to the trigger path: a leading "/" refers to the
+// trigger path rather than the host root, unless the pattern is an absolute path below it.
+func triggerRelativePatterns(root string, patterns []string) []string {
+	relative := make([]string, len(patterns))
+	for i, p := range patterns {
+		negated := strings.HasPrefix(p, "!")
+		p = strings.TrimPrefix(p, "!")
+		if strings.HasPrefix(p, "/") && !watch.IsChild(root, filepath.FromSlash(p)) {
+			p = strings.TrimLeft(p, "/")
+		}
+		if negated {
+			p = "!" + p
+		}
+		relative[i] = p
+	}
+	return relative
+}
+
+// splitGlobPath splits a path containing glob patterns into its longest non-glob prefix, which
+// can be watched, and the remaining pattern.
+func splitGlobPath(p string) (string, string, bool) {
+	parts := strings.Split(filepath.ToSlash(p), "/")
+	for i, part := range parts {
+		if !strings.ContainsAny(part, "*?[") {
+			continue
+		}
+		root := strings.Join(parts[:i], "/")
+		if root == "" && i > 0 {
+			root = "/"
+		} else if root == "" {
+			root = "."
+		}
+		return filepath.FromSlash(root), strings.Join(parts[i:], "/"), true
+	}
+	return p, "", false
+}
+
+// uniqueFileEvents removes the duplicates of events produced by overlapping triggers, keeping the
+// first occurrence of each.
+func uniqueFileEvents(events []fileEvent) []fileEvent {
+	seen := make(map[fileEvent]struct{}, len(events))
+	unique := events[:0]
+	for _, e := range events {
+		// the same event from another trigger is still a duplicate
+		key := e
+		key.Trigger = ""
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		unique = append(unique, e)
+	}
+	return unique
+}
+
+// specialFileModes are the types of the files which can't be synced, like sockets or FIFOs
+const specialFileModes = os.ModeNamedPipe | os.ModeSocket | os.ModeDevice | os.ModeIrregular
+
+// maybeFileEvents returns the file events for hostPath if it is valid for the provided trigger and ignore
+// rules, one per trigger target. Special files are skipped, and dangling symlinks too when the trigger
+// ignores them. The changes not matching the when matcher, if set, are synced rather than rebuilt.
+// The skipped changes worth a warning are counted in the summary.
+//
+// Any errors are logged as warnings and nil (no file event) is returned.
+func maybeFileEvents(trigger Trigger, hostPath string, ignore watch.PathMatcher, when watch.PathMatcher, summary *watchSummary) []fileEvent {
+	hostPath, ok := triggerHostPath(trigger.Path, hostPath)
+	if !ok {
+		return nil
+	}
+	isIgnored, err := ignore.Matches(hostPath)
+	if err != nil {
+		summary.warnf("error ignore matching %q: %v", hostPath, err)
+		return nil
+	}
+
+	if isIgnored {
+		logrus.Debugf("%s is matching ignore pattern", hostPath)
+		return nil
+	}
+
+	if len(trigger.Extensions) > 0 && !slices.Contains(trigger.Extensions, filepath.Ext(hostPath)) {
+		logrus.Debugf("%s doesn't have one of the extensions %s", hostPath, strings.Join(trigger.Extensions, ", "))
+		return nil
+	}
+
+	if fi, err := os.Lstat(hostPath); err == nil {
+		if fi.Mode()&specialFileModes != 0 {
+			logrus.Debugf("%s is a special file (%s), the change is skipped", hostPath, fi.Mode().Type())
+			return nil
+		}
+		if trigger.IgnoreDanglingSymlinks && fi.Mode()&os.ModeSymlink != 0 {
+			if _, err := os.Stat(hostPath); os.IsNotExist(err) {
+				logrus.Debugf("%s is a dangling symlink, the change is skipped", hostPath)
+				return nil
+			}
+		}
+	}
+
+	if trigger.MaxFileSize > 0 {
+		if fi, err := os.Stat(hostPath); err == nil && fi.Mode().IsRegular() && fi.Size() > int64(trigger.MaxFileSize) {
+			summary.warnf("%s is larger than the max file size of %s for watch rule %s, the change is skipped",
+				hostPath, units.BytesSize(float64(trigger.MaxFileSize)), trigger.Path)
+			return nil
+		}
+	}
+
+	action := WatchAction(trigger.Action)
+	if when != nil {
+		matches, err := when.Matches(hostPath)
+		if err != nil {
+			summary.warnf("error matching %q with the when patterns: %v", hostPath, err)
+			return nil
+		}
+		if !matches {
+			if len(trigger.targets()) == 0 {
+				logrus.Debugf("%s doesn't match the when patterns of watch rule %s", hostPath, trigger.displayName())
+				return nil
+			}
+			// the change is synced rather than rebuilt
+			action = WatchActionSync
+			trigger.NoCache, trigger.RebuildTarget, trigger.Wait = false, nil, false
+		}
+	}
+
+	var waitTimeout time.Duration
+	if trigger.Wait {
+		waitTimeout = trigger.WaitTimeout
+		if waitTimeout == 0 {
+			waitTimeout = defaultRebuildWaitTimeout
+		}
+	}
+
+	targets := trigger.targets()
+	if len(targets) == 0 {
+		// no target in the container, e.g. for rebuild
+		targets = []string{""}
+	}
+	events := make([]fileEvent, 0, len(targets))
+	for _, target := range targets {
+		var containerPath string
+		if target != "" {
+			rel := "."
+			if filepath.Clean(hostPath) != filepath.Clean(trigger.Path) {
+				var err error
+				rel, err = filepath.Rel(trigger.Path, hostPath)
+				if err != nil {
+					summary.warnf("error making %s relative to %s: %v", hostPath, trigger.Path, err)
+					return nil
+				}
+			}
+			containerPath = triggerContainerPath(target, hostPath, rel)
+			logrus.Debugf("%s matches watch rule %s, synced to %s", hostPath, trigger.displayName(), containerPath)
+		} else {
+			logrus.Debugf("%s matches watch rule %s", hostPath, trigger.displayName())
+		}
+		events = append(events, fileEvent{
+			Action:  action,
+			OnError: WatchOnError(trigger.OnError),
+			Exec:    trigger.Exec,
+			NoCache: trigger.NoCache,
+			Quiet:   trigger.Quiet,
+			Trigger: trigger.displayName(),
+			// the targets are validated service names, which can't contain a comma
+			RebuildTargets: strings.Join(trigger.RebuildTarget, ","),
+			WaitTimeout:    waitTimeout,
+			PathMapping: sync.PathMapping{
+				HostPath:      hostPath,
+				ContainerPath: containerPath,
+				Filter:        trigger.Filter,
+				PreserveMode:  trigger.PreserveMode,
+				Atomic:        trigger.Atomic,
+				Chown:         trigger.Chown,
+			},
+		})
+	}
+	return events
+}
+
+// resolveContainerPaths rewrites the container paths of the sync events with the resolver of the
+// watch options, if set.
+func resolveContainerPaths(options api.WatchOptions, serviceName string, events []fileEvent) []fileEvent {
+	if options.ContainerPathResolver == nil {
+		return events
+	}
+	for i := range events {
+		// no container path for the other actions, e.g. rebuild
+		if events[i].ContainerPath == "" {
+			continue
+		}
+		events[i].ContainerPath = options.ContainerPathResolver(serviceName, events[i].HostPath, events[i].ContainerPath)
+	}
+	return events
+}
+
+// triggerContainerPath returns the container path hostPath is synced to, rel being hostPath
+// relative to the trigger path. A trigger on a single file syncs it to the target file, unless
+// the target ends with a slash: it's then the directory the file is synced into.
+func triggerContainerPath(target string, hostPath string, rel string) string {
+	if rel == "." && strings.HasSuffix(target, "/") {
+		if fi, err := os.Stat(hostPath); err != nil || !fi.IsDir() {
+			return path.Join(target, filepath.Base(hostPath))
+		}
+	}
+	if rel == "." {
+		// the trigger path itself, e.g. a trigger on a single file, is synced to the target itself
+		return path.Clean(target)
+	}
+	// always use Unix-style paths for inside the container
+	return path.Join(target, filepath.ToSlash(rel))
+}
+
+// triggerHostPath returns the path of the changed file below the trigger path, which has its
+// symlinks evaluated by loadDevelopmentConfig. Paths below a symlinked subdirectory of the trigger
+// are kept as is, so they're mapped to the same layout in the container, while paths reported
+// through a symlink of the trigger path or of one of its parents are resolved first.
+func triggerHostPath(triggerPath string, hostPath string) (string, bool) {
+	if watch.IsChild(triggerPath, hostPath) {
+		return hostPath, true
+	}
+	resolved := evalParentSymlinks(hostPath)
+	if resolved != hostPath && watch.IsChild(triggerPath, resolved) {
+		return resolved, true
+	}
+	return "", false
+}
+
+// evalParentSymlinks evaluates the symlinks of the closest existing parent directory of p, the
+// file itself might be a symlink or might have been deleted.
+func evalParentSymlinks(p string) string {
+	dir, tail := filepath.Dir(p), filepath.Base(p)
+	for {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, tail)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return p
+		}
+		tail = filepath.Join(filepath.Base(dir), tail)
+		dir = parent
+	}
+}
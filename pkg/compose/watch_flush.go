@@ -0,0 +1,63 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compose
+
+import (
+	gosync "sync"
+)
+
+// watchFlusher forces the debouncers of the watched services to handle their pending changes
+// without waiting for the quiet period, e.g. for scripts to apply the changes deterministically.
+type watchFlusher struct {
+	mu          gosync.Mutex
+	subscribers map[chan struct{}]struct{}
+}
+
+func newWatchFlusher() *watchFlusher {
+	return &watchFlusher{subscribers: map[chan struct{}]struct{}{}}
+}
+
+// subscribe returns a channel receiving the flush requests, and a function to stop receiving
+// them. A nil flusher returns a nil channel, which never receives.
+func (f *watchFlusher) subscribe() (<-chan struct{}, func()) {
+	if f == nil {
+		return nil, func() {}
+	}
+	// buffered so that a flush requested while the debouncer is busy isn't lost, several
+	// requests until it's received result in a single flush
+	ch := make(chan struct{}, 1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subscribers[ch] = struct{}{}
+	return ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.subscribers, ch)
+	}
+}
+
+// flush requests all the subscribed debouncers to handle their pending changes.
+func (f *watchFlusher) flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
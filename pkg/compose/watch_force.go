@@ -0,0 +1,120 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compose
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/compose-spec/compose-go/types"
+	"github.com/sirupsen/logrus"
+
+	"github.com/docker/compose/v2/internal/sync"
+	"github.com/docker/compose/v2/pkg/api"
+	"github.com/docker/compose/v2/pkg/watch"
+)
+
+// ForceSync syncs all the files of the service sync triggers, regardless of the changes detected (or missed)
+// by the watcher.
+func (s *composeService) ForceSync(ctx context.Context, project *types.Project, serviceName string, options api.WatchOptions) error {
+	options.DryRun = options.DryRun || s.dryRun
+	service, err := project.GetService(serviceName)
+	if err != nil {
+		return err
+	}
+	config, err := loadDevelopmentConfig(service, project)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return fmt.Errorf("service %q doesn't have a watch configuration", serviceName)
+	}
+	return s.forceSync(ctx, project, service, *config, options, s.getSyncImplementation(project, options, *config, nil), nil)
+}
+
+func (s *composeService) forceSync(
+	ctx context.Context,
+	project *types.Project,
+	service types.ServiceConfig,
+	config DevelopmentConfig,
+	options api.WatchOptions,
+	syncer sync.Syncer,
+	summary *watchSummary,
+) error {
+	events, err := s.forceSyncEvents(project, service, config, options, summary)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		logrus.Debugf("no files to sync for service %s", service.Name)
+		return nil
+	}
+	return s.handleWatchBatch(ctx, project, service.Name, options, events, syncer, nil)
+}
+
+// forceSyncEvents returns the sync events for all the files under the sync trigger paths of the service, using
+// the same ignore rules as the watcher.
+func (s *composeService) forceSyncEvents(project *types.Project, service types.ServiceConfig, config DevelopmentConfig, options api.WatchOptions, summary *watchSummary) ([]fileEvent, error) {
+	serviceIgnore, err := watchIgnoreMatcher(project, service, config, options)
+	if err != nil {
+		return nil, err
+	}
+	triggerIgnores, err := triggerIgnoreMatchers(config.Watch)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []fileEvent
+	for i, trigger := range config.Watch {
+		if !WatchAction(trigger.Action).syncsFiles() {
+			continue
+		}
+		if !trigger.Force && s.isLocallyBindMounted(trigger.Path, service.Volumes) {
+			continue
+		}
+		ignore := watch.NewCompositeMatcher(serviceIgnore, triggerIgnores[i])
+		err := filepath.WalkDir(trigger.Path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				skip, err := serviceIgnore.MatchesEntireDir(p)
+				if err != nil {
+					return err
+				}
+				if skip {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			// the when patterns only apply to rebuild triggers, which aren't forced
+			events = append(events, resolveContainerPaths(options, service.Name, maybeFileEvents(trigger, p, ignore, nil, summary))...)
+			return nil
+		})
+		if os.IsNotExist(err) {
+			logrus.Debugf("path '%s' doesn't exist, skipping", trigger.Path)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return uniqueFileEvents(events), nil
+}
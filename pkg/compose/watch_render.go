@@ -0,0 +1,271 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compose
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/docker/compose/v2/internal/sync"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/moby/term"
+	"github.com/sirupsen/logrus"
+
+	"github.com/docker/compose/v2/pkg/api"
+)
+
+// clearLine moves the cursor to the start of the line and erases it
+const clearLine = "\r\x1b[K"
+
+// watchRenderer writes the messages of the watch actions, prose being the text written in the
+// scrolling log.
+type watchRenderer interface {
+	render(message watchMessage, prose func(w io.Writer))
+}
+
+// watchMessageRenderer returns the renderer of the watch messages: JSON lines if requested, a
+// status line updated in place on a terminal, or a scrolling log.
+func (s *composeService) watchMessageRenderer(options api.WatchOptions) watchRenderer {
+	out := s.stdinfo()
+	switch {
+	case options.JSON:
+		return jsonWatchRenderer{out: s.watchOutput(), clock: s.clock}
+	case isTerminal(out):
+		return statusLineRenderer{out: out, state: &s.watchOut}
+	default:
+		return logWatchRenderer{out: s.watchOutput()}
+	}
+}
+
+// isTerminal reports whether w writes to a terminal.
+func isTerminal(w io.Writer) bool {
+	// the streams of the Docker CLI know if they're a terminal
+	if t, ok := w.(interface{ IsTerminal() bool }); ok {
+		return t.IsTerminal()
+	}
+	_, tty := term.GetFdInfo(w)
+	return tty
+}
+
+type jsonWatchRenderer struct {
+	out   io.Writer
+	clock clockwork.Clock
+}
+
+func (r jsonWatchRenderer) render(message watchMessage, _ func(w io.Writer)) {
+	var buf bytes.Buffer
+	message.Timestamp = r.clock.Now().UTC()
+	if err := json.NewEncoder(&buf).Encode(message); err != nil {
+		logrus.Warnf("failed to write watch message: %v", err)
+		return
+	}
+	_, _ = r.out.Write(buf.Bytes())
+}
+
+type logWatchRenderer struct {
+	out io.Writer
+}
+
+func (r logWatchRenderer) render(_ watchMessage, prose func(w io.Writer)) {
+	if prose == nil {
+		return
+	}
+	// the message is written at once, so that it doesn't interleave with the ones of other services
+	var buf bytes.Buffer
+	prose(&buf)
+	_, _ = r.out.Write(buf.Bytes())
+}
+
+// statusLineRenderer replaces the sync messages with a single line updated in place. The other
+// messages, like rebuilds whose output is interleaved with the build progress, and the failures
+// are written in the scrolling log.
+type statusLineRenderer struct {
+	out   io.Writer
+	state *watchOutputState
+}
+
+func (r statusLineRenderer) render(message watchMessage, prose func(w io.Writer)) {
+	var buf bytes.Buffer
+	line := message.Action == WatchActionSync && message.Status != watchMessageFailed
+	switch {
+	case line:
+		buf.WriteString(clearLine)
+		buf.WriteString(watchStatusLine(message))
+	case prose != nil:
+		prose(&buf)
+	default:
+		return
+	}
+
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	if r.state.statusLineShown && !line {
+		_, _ = io.WriteString(r.out, clearLine)
+	}
+	r.state.statusLineShown = line
+	_, _ = r.out.Write(buf.Bytes())
+}
+
+// watchStatusLine returns the status line for the message, which doesn't end with a new line.
+func watchStatusLine(message watchMessage) string {
+	if message.Status == watchMessageStarted {
+		return fmt.Sprintf("%s: syncing %d file(s)...", message.Service, len(message.Paths))
+	}
+	return fmt.Sprintf("%s: synced %d file(s), watching for changes", message.Service, len(message.Paths))
+}
+
+// writeWatchRebuildSummary reports the outcome of a service rebuild triggered by changes.
+func writeWatchRebuildSummary(w io.Writer, serviceName string, changes int, duration time.Duration, err error) {
+	duration = duration.Round(time.Millisecond)
+	if err != nil {
+		fmt.Fprintf(w, "Rebuild of %s failed after %s, triggered by %d change(s): %v\n", serviceName, duration, changes, err)
+		return
+	}
+	fmt.Fprintf(w, "Rebuilt %s in %s, triggered by %d change(s)\n", serviceName, duration, changes)
+}
+
+// watchMessageStatus is the status of the action reported by a watch message
+type watchMessageStatus string
+
+const (
+	watchMessageStarted   watchMessageStatus = "started"
+	watchMessageCompleted watchMessageStatus = "completed"
+	watchMessageFailed    watchMessageStatus = "failed"
+)
+
+// watchMessage is the JSON representation of a sync or rebuild message.
+type watchMessage struct {
+	Timestamp time.Time          `json:"timestamp"`
+	Service   string             `json:"service"`
+	Action    WatchAction        `json:"action"`
+	Status    watchMessageStatus `json:"status"`
+	Paths     []string           `json:"paths,omitempty"`
+	Duration  string             `json:"duration,omitempty"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// completedWatchMessage returns the message for an action which completed, or failed with err.
+func completedWatchMessage(serviceName string, action WatchAction, paths []string, duration time.Duration, err error) watchMessage {
+	message := watchMessage{
+		Service: serviceName,
+		Action:  action,
+		Status:  watchMessageCompleted,
+		Paths:   paths,
+	}
+	if duration > 0 {
+		message.Duration = duration.Round(time.Millisecond).String()
+	}
+	if err != nil {
+		message.Status = watchMessageFailed
+		message.Error = err.Error()
+	}
+	return message
+}
+
+// writeWatchMessage writes the message as a JSON object if requested by the options, or the text
+// written by prose otherwise, if any.
+func (s *composeService) writeWatchMessage(options api.WatchOptions, message watchMessage, prose func(w io.Writer)) {
+	s.watchMessageRenderer(options).render(message, prose)
+}
+
+// watchOutputState serializes the writes of the watch messages, the services being watched from
+// their own goroutines.
+type watchOutputState struct {
+	mu gosync.Mutex
+	// statusLineShown is set while a status line is displayed, it's cleared before anything else
+	// is written to the watch output
+	statusLineShown bool
+}
+
+// watchOutput returns the writer of the watch messages, each write to it is atomic across the
+// watched services. A message written with several writes must be buffered first. The status
+// line is cleared before, if shown.
+func (s *composeService) watchOutput() io.Writer {
+	return lockedWriter{w: s.stdinfo(), state: &s.watchOut}
+}
+
+type lockedWriter struct {
+	w     io.Writer
+	state *watchOutputState
+}
+
+func (l lockedWriter) Write(p []byte) (int, error) {
+	l.state.mu.Lock()
+	defer l.state.mu.Unlock()
+	if l.state.statusLineShown {
+		_, _ = io.WriteString(l.w, clearLine)
+		l.state.statusLineShown = false
+	}
+	return l.w.Write(p)
+}
+
+// writeWatchSyncMessage prints out a message about the sync for the changed paths.
+//
+// With debug logging enabled, all the paths are listed with the container path they're synced to.
+func writeWatchSyncMessage(w io.Writer, serviceName string, pathMappings []sync.PathMapping) {
+	const maxPathsToShow = 10
+	if logrus.IsLevelEnabled(logrus.DebugLevel) {
+		pathsToSync := make([]string, len(pathMappings))
+		for i := range pathMappings {
+			pathsToSync[i] = fmt.Sprintf("%s -> %s", pathMappings[i].HostPath, pathMappings[i].ContainerPath)
+		}
+		fmt.Fprintf(
+			w,
+			"Syncing %s after changes were detected:%s\n",
+			serviceName,
+			strings.Join(append([]string{""}, pathsToSync...), "\n  - "),
+		)
+	} else if len(pathMappings) <= maxPathsToShow {
+		hostPathsToSync := make([]string, len(pathMappings))
+		for i := range pathMappings {
+			hostPathsToSync[i] = pathMappings[i].HostPath
+		}
+		fmt.Fprintf(
+			w,
+			"Syncing %s after changes were detected:%s\n",
+			serviceName,
+			strings.Join(append([]string{""}, hostPathsToSync...), "\n  - "),
+		)
+	} else {
+		fmt.Fprintf(
+			w,
+			"Syncing %s after %d changes were detected\n",
+			serviceName,
+			len(pathMappings),
+		)
+	}
+}
+
+// writeWatchDryRunSyncMessage prints out the mappings which would be synced for the changed paths.
+func writeWatchDryRunSyncMessage(w io.Writer, serviceName string, pathMappings []sync.PathMapping) {
+	pathsToSync := make([]string, len(pathMappings))
+	for i := range pathMappings {
+		pathsToSync[i] = fmt.Sprintf("%s -> %s", pathMappings[i].HostPath, pathMappings[i].ContainerPath)
+	}
+	fmt.Fprintf(
+		w,
+		"(dry run) would sync %s after changes were detected:%s\n",
+		serviceName,
+		strings.Join(append([]string{""}, pathsToSync...), "\n  - "),
+	)
+}
@@ -0,0 +1,120 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compose
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/sirupsen/logrus"
+)
+
+// watchState is the sync state of a watched service saved across watch sessions, so that the
+// files left unchanged aren't synced again when watching again.
+type watchState struct {
+	// Files are the last synced files by host path
+	Files map[string]watchStateFile `json:"files,omitempty"`
+	// LastBatch is when the last batch of changes was handled
+	LastBatch time.Time `json:"last_batch,omitempty"`
+}
+
+type watchStateFile struct {
+	Digest string `json:"digest"`
+	// ModTime is the modification time of the file when the state was saved, the file is synced
+	// again if it changed while not watched
+	ModTime time.Time `json:"mod_time"`
+}
+
+// watchStatePath returns the path of the state file of the service, next to the Docker CLI
+// config file.
+func (s *composeService) watchStatePath(project, service string) string {
+	dir := config.Dir()
+	if file := s.configFile(); file != nil && file.Filename != "" {
+		dir = filepath.Dir(file.Filename)
+	}
+	return filepath.Join(dir, "compose", "watch", project, service+".json")
+}
+
+// newWatchState returns the state of the synced files, with their current modification time.
+func newWatchState(synced syncedDigests, lastBatch time.Time) watchState {
+	state := watchState{Files: map[string]watchStateFile{}, LastBatch: lastBatch}
+	for p, digest := range synced {
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		state.Files[p] = watchStateFile{Digest: digest, ModTime: info.ModTime()}
+	}
+	return state
+}
+
+// digests returns the digests of the synced files, without the files modified or deleted since
+// the state was saved.
+func (w watchState) digests() syncedDigests {
+	synced := syncedDigests{}
+	for p, file := range w.Files {
+		info, err := os.Stat(p)
+		if err != nil || !info.ModTime().Equal(file.ModTime) {
+			logrus.Debugf("%s changed since the watch state was saved, it's synced again", p)
+			continue
+		}
+		synced[p] = file.Digest
+	}
+	return synced
+}
+
+// loadWatchState reads the state saved at path, a missing file is an empty state.
+func loadWatchState(path string) (watchState, error) {
+	var state watchState
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// saveWatchState writes the state at path, through a temporary file so that a watch stopped
+// while saving doesn't leave a truncated state.
+func saveWatchState(path string, state watchState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
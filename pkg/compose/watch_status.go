@@ -0,0 +1,178 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compose
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	gosync "sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/docker/compose/v2/pkg/api"
+)
+
+// watchRegistry tracks the active watch sessions, it's safe for concurrent use.
+//
+// A nil registry doesn't track anything.
+type watchRegistry struct {
+	mu       gosync.Mutex
+	sessions map[watchSessionKey]*api.WatchSession
+}
+
+type watchSessionKey struct {
+	project string
+	service string
+}
+
+func (r *watchRegistry) register(project, service string, paths []string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions == nil {
+		r.sessions = map[watchSessionKey]*api.WatchSession{}
+	}
+	r.sessions[watchSessionKey{project: project, service: service}] = &api.WatchSession{
+		Project: project,
+		Service: service,
+		Paths:   paths,
+	}
+}
+
+func (r *watchRegistry) unregister(project, service string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, watchSessionKey{project: project, service: service})
+}
+
+// update applies fn to the session of the service, if it's still registered.
+func (r *watchRegistry) update(project, service string, fn func(session *api.WatchSession)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if session, ok := r.sessions[watchSessionKey{project: project, service: service}]; ok {
+		fn(session)
+	}
+}
+
+func (r *watchRegistry) snapshot() []api.WatchSession {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sessions := make([]api.WatchSession, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		s := *session
+		s.Paths = append([]string(nil), session.Paths...)
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].Project != sessions[j].Project {
+			return sessions[i].Project < sessions[j].Project
+		}
+		return sessions[i].Service < sessions[j].Service
+	})
+	return sessions
+}
+
+// countWatchBatch increments the session counters for the actions applied by a batch of changes.
+func countWatchBatch(session *api.WatchSession, batch []fileEvent) {
+	session.Batches++
+	var syncs, restarts, rebuilds, execs bool
+	for _, e := range batch {
+		switch e.Action {
+		case WatchActionExec:
+			execs = true
+		case WatchActionRebuild:
+			rebuilds = true
+		case WatchActionSync:
+			syncs = true
+		case WatchActionRestart:
+			restarts = true
+		case WatchActionSyncRestart:
+			syncs, restarts = true, true
+		}
+	}
+	if syncs {
+		session.Syncs++
+	}
+	if execs {
+		session.Execs++
+	}
+	// a rebuild supersedes a restart of the service
+	switch {
+	case rebuilds:
+		session.Rebuilds++
+	case restarts:
+		session.Restarts++
+	}
+}
+
+func (s *composeService) WatchStatus(_ context.Context) ([]api.WatchSession, error) {
+	return s.watches.snapshot(), nil
+}
+
+// watchSummary totals the activity of all the services watched by a Watch call, it's safe for
+// concurrent use.
+//
+// A nil summary doesn't total anything.
+type watchSummary struct {
+	mu     gosync.Mutex
+	totals api.WatchSession
+	// warnings counts the non-fatal issues reported, like changes which were skipped or missed
+	warnings int
+}
+
+// update applies fn to the totals, as it's applied to the session of a service.
+func (w *watchSummary) update(fn func(session *api.WatchSession)) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fn(&w.totals)
+}
+
+// warnf logs a non-fatal issue of the watch session, and counts it.
+func (w *watchSummary) warnf(format string, args ...any) {
+	logrus.Warnf(format, args...)
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.warnings++
+}
+
+// write prints out the summary of the watch session which lasted for duration.
+func (w *watchSummary) write(out io.Writer, duration time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fmt.Fprintf(out, "watch session ended after %s: %d file(s) synced, %d rebuild(s), %d restart(s), %d error(s), %d warning(s)\n",
+		duration.Round(time.Second), w.totals.FilesSynced, w.totals.Rebuilds, w.totals.Restarts, w.totals.Errors, w.warnings)
+}
@@ -15,15 +15,41 @@
 package compose
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/compose-spec/compose-go/types"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/context/docker"
+	"github.com/docker/compose/v2/pkg/api"
 	"github.com/docker/compose/v2/pkg/mocks"
 	moby "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 
 	"github.com/jonboulle/clockwork"
 	"github.com/stretchr/testify/require"
@@ -40,7 +66,7 @@ func TestDebounceBatching(t *testing.T) {
 	ctx, stop := context.WithCancel(context.Background())
 	t.Cleanup(stop)
 
-	eventBatchCh := batchDebounceEvents(ctx, clock, quietPeriod, ch)
+	eventBatchCh := batchDebounceEvents(ctx, clock, quietPeriod, 0, 0, ch, nil)
 	for i := 0; i < 100; i++ {
 		var action WatchAction = "a"
 		if i%2 == 0 {
@@ -72,6 +98,252 @@ func TestDebounceBatching(t *testing.T) {
 	}
 }
 
+func TestDebounceBatching_AtomicSave(t *testing.T) {
+	ch := make(chan fileEvent)
+	clock := clockwork.NewFakeClock()
+	ctx, stop := context.WithCancel(context.Background())
+	t.Cleanup(stop)
+
+	eventBatchCh := batchDebounceEvents(ctx, clock, quietPeriod, 0, 0, ch, nil)
+	file := sync.PathMapping{HostPath: "/src/file.txt", ContainerPath: "/app/file.txt"}
+	tmp := sync.PathMapping{HostPath: "/src/.file.txt.tmp", ContainerPath: "/app/.file.txt.tmp"}
+	// write temp file, delete original, rename temp file as original
+	for _, pm := range []sync.PathMapping{tmp, file, tmp, file} {
+		ch <- fileEvent{PathMapping: pm, Action: WatchActionSync}
+	}
+	ch <- fileEvent{PathMapping: file, Action: WatchActionSync, OnError: WatchOnErrorStop}
+	clock.BlockUntil(1)
+	clock.Advance(quietPeriod)
+	select {
+	case batch := <-eventBatchCh:
+		require.Equal(t, []fileEvent{
+			{PathMapping: tmp, Action: WatchActionSync},
+			{PathMapping: file, Action: WatchActionSync, OnError: WatchOnErrorStop},
+		}, batch)
+	case <-time.After(50 * time.Millisecond):
+		t.Fatal("timed out waiting for events")
+	}
+}
+
+func TestDebounceBatching_Chmod(t *testing.T) {
+	ch := make(chan fileEvent)
+	clock := clockwork.NewFakeClock()
+	ctx, stop := context.WithCancel(context.Background())
+	t.Cleanup(stop)
+
+	eventBatchCh := batchDebounceEvents(ctx, clock, quietPeriod, 0, 0, ch, nil)
+	script := sync.PathMapping{HostPath: "/src/run.sh", ContainerPath: "/app/run.sh"}
+	config := sync.PathMapping{HostPath: "/src/config.yaml", ContainerPath: "/app/config.yaml"}
+	chmod := func(pm sync.PathMapping) fileEvent {
+		pm.Kind = watch.FileChmod
+		return fileEvent{PathMapping: pm, Action: WatchActionSync}
+	}
+	// the script is created then made executable, the config only changes mode
+	ch <- fileEvent{PathMapping: sync.PathMapping{HostPath: script.HostPath, ContainerPath: script.ContainerPath, Kind: watch.FileCreated}, Action: WatchActionSync}
+	ch <- chmod(script)
+	ch <- chmod(config)
+	clock.BlockUntil(1)
+	clock.Advance(quietPeriod)
+	select {
+	case batch := <-eventBatchCh:
+		require.Equal(t, []fileEvent{
+			{PathMapping: sync.PathMapping{HostPath: script.HostPath, ContainerPath: script.ContainerPath, Kind: watch.FileCreated}, Action: WatchActionSync},
+			chmod(config),
+		}, batch)
+	case <-time.After(50 * time.Millisecond):
+		t.Fatal("timed out waiting for events")
+	}
+
+	assert.DeepEqual(t, coalesceEvents([]fileEvent{
+		{PathMapping: script, Action: WatchActionSync},
+		chmod(script),
+		chmod(config),
+	}), []fileEvent{
+		{PathMapping: script, Action: WatchActionSync},
+		chmod(config),
+	})
+}
+
+func TestDebounceBatching_Order(t *testing.T) {
+	var paths []sync.PathMapping
+	for _, name := range []string{"c", "a", "d", "b", "e", "f", "g", "h"} {
+		paths = append(paths, sync.PathMapping{HostPath: "/src/" + name, ContainerPath: "/app/" + name})
+	}
+	// a repeated event takes the position of its last occurrence
+	input := append(append([]sync.PathMapping{}, paths...), paths[1], paths[0])
+	expected := []fileEvent{}
+	for _, pm := range append(append([]sync.PathMapping{}, paths[2:]...), paths[1], paths[0]) {
+		expected = append(expected, fileEvent{PathMapping: pm, Action: WatchActionSync})
+	}
+
+	for run := 0; run < 20; run++ {
+		ch := make(chan fileEvent)
+		clock := clockwork.NewFakeClock()
+		ctx, stop := context.WithCancel(context.Background())
+
+		eventBatchCh := batchDebounceEvents(ctx, clock, quietPeriod, 0, 0, ch, nil)
+		for _, pm := range input {
+			ch <- fileEvent{PathMapping: pm, Action: WatchActionSync}
+		}
+		clock.BlockUntil(1)
+		clock.Advance(quietPeriod)
+		select {
+		case batch := <-eventBatchCh:
+			require.Equal(t, expected, batch, "run %d", run)
+		case <-time.After(50 * time.Millisecond):
+			t.Fatal("timed out waiting for events")
+		}
+		stop()
+	}
+}
+
+func TestDebounceBatching_MaxWait(t *testing.T) {
+	ch := make(chan fileEvent)
+	clock := clockwork.NewFakeClock()
+	ctx, stop := context.WithCancel(context.Background())
+	t.Cleanup(stop)
+
+	// the quiet period never elapses as changes keep coming
+	eventBatchCh := batchDebounceEvents(ctx, clock, time.Hour, 2*time.Second, 0, ch, nil)
+	event := func(i int) fileEvent {
+		return fileEvent{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: fmt.Sprintf("/src/%d.go", i)}}
+	}
+	i := 0
+	for flush := 0; flush < 3; flush++ {
+		var expected []fileEvent
+		// an event every 400ms, two at first so that the first one is known to have started the
+		// max wait timer once the second one is received
+		for elapsed := time.Duration(0); elapsed < 2*time.Second; elapsed += 400 * time.Millisecond {
+			count := 1
+			if elapsed == 0 {
+				count = 2
+			}
+			for j := 0; j < count; j++ {
+				ch <- event(i)
+				expected = append(expected, event(i))
+				i++
+			}
+			clock.Advance(400 * time.Millisecond)
+		}
+		select {
+		case batch := <-eventBatchCh:
+			require.Equal(t, expected, batch, "flush %d", flush)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for flush %d", flush)
+		}
+	}
+}
+
+func TestDebounceBatching_Flush(t *testing.T) {
+	ch := make(chan fileEvent)
+	flusher := newWatchFlusher()
+	flush, stopFlush := flusher.subscribe()
+	t.Cleanup(stopFlush)
+	clock := clockwork.NewFakeClock()
+	ctx, stop := context.WithCancel(context.Background())
+	t.Cleanup(stop)
+
+	eventBatchCh := batchDebounceEvents(ctx, clock, time.Hour, 0, 0, ch, flush)
+	// nothing pending, the flush is a no-op
+	flusher.flush()
+	assert.Check(t, poll(func() bool { return len(flush) == 0 }))
+	event := fileEvent{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/src/main.go"}}
+	ch <- event
+	select {
+	case batch := <-eventBatchCh:
+		t.Fatalf("unexpected batch before the flush: %v", batch)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// the quiet period of an hour isn't waited for
+	flusher.flush()
+	select {
+	case batch := <-eventBatchCh:
+		require.Equal(t, []fileEvent{event}, batch)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the flushed batch")
+	}
+}
+
+func TestWatchFlusher(t *testing.T) {
+	flusher := newWatchFlusher()
+	first, stopFirst := flusher.subscribe()
+	second, stopSecond := flusher.subscribe()
+	t.Cleanup(stopFirst)
+
+	// the requests until a flush is received result in a single flush
+	flusher.flush()
+	flusher.flush()
+	for _, ch := range []<-chan struct{}{first, second} {
+		select {
+		case <-ch:
+		default:
+			t.Fatal("flush not received")
+		}
+		select {
+		case <-ch:
+			t.Fatal("unexpected second flush")
+		default:
+		}
+	}
+
+	stopSecond()
+	flusher.flush()
+	assert.Equal(t, len(first), 1)
+	assert.Equal(t, len(second), 0)
+
+	var none *watchFlusher
+	ch, stop := none.subscribe()
+	stop()
+	assert.Check(t, ch == nil)
+}
+
+func TestDebounceBatching_WhileBusy(t *testing.T) {
+	ch := make(chan fileEvent)
+	clock := clockwork.NewFakeClock()
+	ctx, stop := context.WithCancel(context.Background())
+	t.Cleanup(stop)
+
+	eventBatchCh := batchDebounceEvents(ctx, clock, 10*time.Millisecond, 0, 0, ch, nil)
+	event := func(i int) fileEvent {
+		return fileEvent{Action: WatchActionRebuild, PathMapping: sync.PathMapping{HostPath: fmt.Sprintf("/src/%d.go", i)}}
+	}
+	receive := func() []fileEvent {
+		t.Helper()
+		deadline := time.After(time.Second)
+		for {
+			clock.Advance(10 * time.Millisecond)
+			select {
+			case batch := <-eventBatchCh:
+				return batch
+			case <-deadline:
+				t.Fatal("timed out waiting for a batch")
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	ch <- event(0)
+	require.Equal(t, []fileEvent{event(0)}, receive())
+
+	// the batch isn't received while the rebuild is in progress, even though the quiet period
+	// elapses between the changes
+	var expected []fileEvent
+	for i := 1; i <= 5; i++ {
+		ch <- event(i)
+		expected = append(expected, event(i))
+		clock.Advance(10 * time.Millisecond)
+	}
+	require.Equal(t, expected, receive())
+
+	clock.Advance(10 * time.Millisecond)
+	select {
+	case batch := <-eventBatchCh:
+		t.Fatalf("unexpected batch: %v", batch)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
 type testWatcher struct {
 	events chan watch.FileEvent
 	errors chan error
@@ -99,7 +371,7 @@ func TestWatch_Sync(t *testing.T) {
 	cli.EXPECT().Err().Return(os.Stderr).AnyTimes()
 	apiClient := mocks.NewMockAPIClient(mockCtrl)
 	apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return([]moby.Container{
-		testContainer("test", "123", false),
+		runningContainer("test", "123"),
 	}, nil).AnyTimes()
 	cli.EXPECT().Client().Return(apiClient).AnyTimes()
 
@@ -126,7 +398,7 @@ func TestWatch_Sync(t *testing.T) {
 			dockerCli: cli,
 			clock:     clock,
 		}
-		err := service.watch(ctx, &proj, "test", watcher, syncer, []Trigger{
+		err := service.watch(ctx, &proj, "test", api.WatchOptions{}, watcher, syncer, &serviceWatch{}, DevelopmentConfig{Watch: []Trigger{
 			{
 				Path:   "/sync",
 				Action: "sync",
@@ -137,7 +409,7 @@ func TestWatch_Sync(t *testing.T) {
 				Path:   "/rebuild",
 				Action: "rebuild",
 			},
-		})
+		}})
 		assert.NilError(t, err)
 	}()
 
@@ -171,14 +443,20 @@ func TestWatch_Sync(t *testing.T) {
 
 	watcher.Events() <- watch.NewFileEvent("/rebuild")
 	watcher.Events() <- watch.NewFileEvent("/sync/changed")
-	clock.BlockUntil(4)
-	clock.Advance(quietPeriod)
-	select {
-	case batch := <-syncer.synced:
-		t.Fatalf("received unexpected events: %v", batch)
-	case <-time.After(100 * time.Millisecond):
-		// expected
-	}
+	// changes to sync are not dropped by a rebuild in the same batch
+	var actual []sync.PathMapping
+	assert.Check(t, poll(func() bool {
+		clock.Advance(quietPeriod)
+		select {
+		case actual = <-syncer.synced:
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}), "timed out waiting for events")
+	require.ElementsMatch(t, []sync.PathMapping{
+		{HostPath: "/sync/changed", ContainerPath: "/work/changed"},
+	}, actual)
 	// TODO: there's not a great way to assert that the rebuild attempt happened
 }
 
@@ -196,3 +474,5593 @@ func (f *fakeSyncer) Sync(_ context.Context, _ types.ServiceConfig, paths []sync
 	f.synced <- paths
 	return nil
 }
+
+func TestServiceIgnoreMatcher_HiddenDirectories(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{".cache", ".idea", ".vscode", "src"} {
+		assert.NilError(t, os.Mkdir(filepath.Join(dir, d), 0o755))
+	}
+	service := types.ServiceConfig{
+		Name:  "test",
+		Build: &types.BuildConfig{Context: dir},
+	}
+
+	tests := []struct {
+		name          string
+		includeHidden bool
+		ignored       []string
+		watched       []string
+	}{
+		{
+			name:    "hidden directories ignored by default",
+			ignored: []string{".cache/data", ".idea/workspace.xml", ".vscode/settings.json"},
+			watched: []string{"src/main.go", ".env"},
+		},
+		{
+			name:          "hidden directories included",
+			includeHidden: true,
+			// .idea is always ignored as an IDE ephemeral path
+			ignored: []string{".idea/workspace.xml"},
+			watched: []string{".cache/data", ".vscode/settings.json", "src/main.go", ".env"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher, err := serviceIgnoreMatcher(&types.Project{}, service, DevelopmentConfig{IncludeHidden: tt.includeHidden})
+			assert.NilError(t, err)
+			for _, p := range tt.ignored {
+				ok, err := matcher.Matches(filepath.Join(dir, p))
+				assert.NilError(t, err)
+				assert.Check(t, ok, "%s should be ignored", p)
+			}
+			for _, p := range tt.watched {
+				ok, err := matcher.Matches(filepath.Join(dir, p))
+				assert.NilError(t, err)
+				assert.Check(t, !ok, "%s should be watched", p)
+			}
+		})
+	}
+}
+
+func TestServiceIgnoreMatcher_GitIgnore(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*.log\nnode_modules/\n"), 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("*.tmp\n"), 0o644))
+	service := types.ServiceConfig{
+		Name:  "test",
+		Build: &types.BuildConfig{Context: dir},
+	}
+
+	matcher, err := serviceIgnoreMatcher(&types.Project{}, service, DevelopmentConfig{})
+	assert.NilError(t, err)
+	ok, err := matcher.Matches(filepath.Join(dir, "logs", "debug.log"))
+	assert.NilError(t, err)
+	assert.Check(t, !ok, ".gitignore must not be used unless enabled")
+
+	matcher, err = serviceIgnoreMatcher(&types.Project{}, service, DevelopmentConfig{GitIgnore: true})
+	assert.NilError(t, err)
+	for _, p := range []string{"logs/debug.log", "web/node_modules/lib/index.js", "data.tmp"} {
+		ok, err := matcher.Matches(filepath.Join(dir, p))
+		assert.NilError(t, err)
+		assert.Check(t, ok, "%s should be ignored", p)
+	}
+	ok, err = matcher.Matches(filepath.Join(dir, "src", "main.go"))
+	assert.NilError(t, err)
+	assert.Check(t, !ok, "src/main.go should be watched")
+}
+
+func TestLoadDevelopmentConfig_BasePath(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	workingDir := filepath.Join(root, "deploy", "dev")
+	assert.NilError(t, os.MkdirAll(workingDir, 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(root, "go.mod"), []byte("module example"), 0o644))
+	project := &types.Project{
+		WorkingDir: workingDir,
+		Extensions: map[string]interface{}{
+			"x-develop": map[string]interface{}{"base_path": "../.."},
+		},
+	}
+	service := types.ServiceConfig{
+		Name:  "test",
+		Build: &types.BuildConfig{Context: "."},
+		Extensions: map[string]interface{}{
+			"x-develop": map[string]interface{}{
+				"ignore_files": []interface{}{".watchignore"},
+				"watch": []interface{}{
+					map[string]interface{}{"path": "services/api", "action": "sync", "target": "/app"},
+					map[string]interface{}{"path": "go.mod", "action": "rebuild"},
+					map[string]interface{}{"path": filepath.Join(workingDir, "config"), "action": "restart"},
+				},
+			},
+		},
+	}
+
+	config, err := loadDevelopmentConfig(service, project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.Watch[0].Path, filepath.Join(root, "services", "api"))
+	assert.Equal(t, config.Watch[1].Path, filepath.Join(root, "go.mod"))
+	assert.Equal(t, config.Watch[2].Path, filepath.Join(workingDir, "config"))
+	assert.DeepEqual(t, config.IgnoreFiles, []string{filepath.Join(root, ".watchignore")})
+
+	project.Extensions["x-develop"] = map[string]interface{}{"base_path": "../missing"}
+	_, err = loadDevelopmentConfig(service, project)
+	assert.ErrorContains(t, err, "service test: base_path ../missing:")
+
+	project.Extensions["x-develop"] = map[string]interface{}{"base_path": filepath.Join(root, "go.mod")}
+	_, err = loadDevelopmentConfig(service, project)
+	assert.ErrorContains(t, err, "is not a directory")
+}
+
+func TestServiceIgnoreMatcher_IgnoreFiles(t *testing.T) {
+	var logs lockedBuffer
+	logrus.SetOutput(&logs)
+	t.Cleanup(func() { logrus.SetOutput(os.Stderr) })
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, ".composewatchignore"), []byte("*.log\n/dist\n"), 0o644))
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, "web"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "web", ".watchignore"), []byte("/coverage\n"), 0o644))
+	project := &types.Project{WorkingDir: dir}
+	service := types.ServiceConfig{
+		Name: "test",
+		Extensions: map[string]interface{}{
+			"x-develop": map[string]interface{}{
+				"ignore_files": []interface{}{".composewatchignore", "web/.watchignore", "missing.ignore"},
+			},
+		},
+	}
+
+	config, err := loadDevelopmentConfig(service, project)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, config.IgnoreFiles, []string{
+		filepath.Join(dir, ".composewatchignore"),
+		filepath.Join(dir, "web", ".watchignore"),
+		filepath.Join(dir, "missing.ignore"),
+	})
+
+	matcher, err := serviceIgnoreMatcher(project, service, *config)
+	assert.NilError(t, err)
+	assert.Check(t, strings.Contains(logs.String(), "missing.ignore of service test doesn't exist"), logs.String())
+	for _, p := range []string{"debug.log", "dist/main.js", "web/coverage/index.html"} {
+		ok, err := matcher.Matches(filepath.Join(dir, p))
+		assert.NilError(t, err)
+		assert.Check(t, ok, "%s should be ignored", p)
+	}
+	for _, p := range []string{"main.go", "web/dist/main.js", "coverage/index.html"} {
+		ok, err := matcher.Matches(filepath.Join(dir, p))
+		assert.NilError(t, err)
+		assert.Check(t, !ok, "%s should be watched", p)
+	}
+}
+
+func TestServiceIgnoreMatcher_ParentDockerignore(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	context := filepath.Join(root, "services", "web")
+	assert.NilError(t, os.MkdirAll(context, 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(root, ".dockerignore"), []byte("**/*.log\nservices/web/dist\n"), 0o644))
+	project := &types.Project{WorkingDir: root}
+	service := types.ServiceConfig{Name: "web", Build: &types.BuildConfig{Context: context}}
+
+	matches := func(t *testing.T, p string) bool {
+		t.Helper()
+		matcher, err := serviceIgnoreMatcher(project, service, DevelopmentConfig{})
+		assert.NilError(t, err)
+		ok, err := matcher.Matches(filepath.Join(context, p))
+		assert.NilError(t, err)
+		return ok
+	}
+	assert.Check(t, matches(t, "debug.log"), "the .dockerignore of the project directory applies")
+	assert.Check(t, matches(t, "dist/main.js"))
+	assert.Check(t, !matches(t, "main.go"))
+
+	// the .dockerignore of the build context wins
+	assert.NilError(t, os.WriteFile(filepath.Join(context, ".dockerignore"), []byte("tmp\n"), 0o644))
+	assert.Check(t, matches(t, "tmp/cache"))
+	assert.Check(t, !matches(t, "debug.log"))
+	assert.NilError(t, os.Remove(filepath.Join(context, ".dockerignore")))
+
+	// not searched above the project directory
+	project.WorkingDir = filepath.Join(root, "services")
+	assert.Check(t, !matches(t, "debug.log"))
+}
+
+func TestServiceIgnoreMatcher_IncludeGit(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, ".git", "refs"), 0o755))
+	service := types.ServiceConfig{
+		Name:  "test",
+		Build: &types.BuildConfig{Context: dir},
+	}
+	trigger := Trigger{Path: dir, Action: "sync", Target: "/app"}
+	head := filepath.Join(dir, ".git", "HEAD")
+
+	for _, config := range []DevelopmentConfig{{}, {IncludeHidden: true}} {
+		matcher, err := serviceIgnoreMatcher(&types.Project{}, service, config)
+		assert.NilError(t, err)
+		assert.Equal(t, len(maybeFileEvents(trigger, head, matcher, nil, nil)), 0, ".git must be ignored by default")
+	}
+
+	matcher, err := serviceIgnoreMatcher(&types.Project{}, service, DevelopmentConfig{IncludeGit: true})
+	assert.NilError(t, err)
+	events := maybeFileEvents(trigger, head, matcher, nil, nil)
+	assert.Equal(t, len(events), 1, ".git must be watched when included")
+	assert.Equal(t, events[0].ContainerPath, "/app/.git/HEAD")
+	skip, err := matcher.MatchesEntireDir(filepath.Join(dir, ".git", "refs"))
+	assert.NilError(t, err)
+	assert.Check(t, !skip)
+}
+
+func TestLoadDevelopmentConfig_IncludeGit(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	service := types.ServiceConfig{
+		Name: "test",
+		Extensions: map[string]interface{}{
+			"x-develop": map[string]interface{}{
+				"include_git": true,
+				"watch": []interface{}{
+					map[string]interface{}{"path": ".", "action": "sync", "target": "/app"},
+				},
+			},
+		},
+	}
+	config, err := loadDevelopmentConfig(service, project)
+	assert.NilError(t, err)
+	assert.Check(t, config.IncludeGit)
+}
+
+func TestLoadDevelopmentConfig_IncludeHidden(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	service := types.ServiceConfig{
+		Name: "test",
+		Extensions: map[string]interface{}{
+			"x-develop": map[string]interface{}{
+				"include_hidden": true,
+				"watch": []interface{}{
+					map[string]interface{}{"path": "src", "action": "sync", "target": "/app"},
+				},
+			},
+		},
+	}
+	config, err := loadDevelopmentConfig(service, project)
+	assert.NilError(t, err)
+	assert.Check(t, config.IncludeHidden)
+	assert.Equal(t, len(config.Watch), 1)
+	assert.Equal(t, config.Watch[0].Target, "/app")
+}
+
+func TestLoadDevelopmentConfig_Filter(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	newService := func(trigger map[string]interface{}) types.ServiceConfig {
+		return types.ServiceConfig{
+			Name:  "test",
+			Build: &types.BuildConfig{Context: "."},
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{
+					"watch": []interface{}{trigger},
+				},
+			},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService(map[string]interface{}{
+		"path": "src", "action": "sync", "target": "/app", "filter": "cat",
+	}), project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.Watch[0].Filter, "cat")
+
+	_, err = loadDevelopmentConfig(newService(map[string]interface{}{
+		"path": "src", "action": "sync", "target": "/app", "filter": "this-command-does-not-exist-anywhere",
+	}), project)
+	assert.ErrorContains(t, err, "this-command-does-not-exist-anywhere")
+
+	_, err = loadDevelopmentConfig(newService(map[string]interface{}{
+		"path": "src", "action": "rebuild", "filter": "cat",
+	}), project)
+	assert.ErrorContains(t, err, "filter only applies to the 'sync' and 'sync+restart' actions")
+}
+
+func TestLoadDevelopmentConfig_PreserveMode(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	newService := func(trigger map[string]interface{}) types.ServiceConfig {
+		return types.ServiceConfig{
+			Name:  "test",
+			Build: &types.BuildConfig{Context: "."},
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{
+					"watch": []interface{}{trigger},
+				},
+			},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService(map[string]interface{}{
+		"path": "bin", "action": "sync", "target": "/app/bin", "preserve_mode": true,
+	}), project)
+	assert.NilError(t, err)
+	assert.Check(t, config.Watch[0].PreserveMode)
+
+	events := maybeFileEvents(config.Watch[0], filepath.Join(config.Watch[0].Path, "run.sh"), watch.EmptyMatcher{}, nil, nil)
+	assert.Equal(t, len(events), 1)
+	assert.Check(t, events[0].PreserveMode)
+
+	_, err = loadDevelopmentConfig(newService(map[string]interface{}{
+		"path": "bin", "action": "restart", "preserve_mode": true,
+	}), project)
+	assert.ErrorContains(t, err, "preserve_mode only applies to the 'sync' and 'sync+restart' actions")
+}
+
+func TestLoadDevelopmentConfig_Atomic(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	newService := func(trigger map[string]interface{}) types.ServiceConfig {
+		trigger["path"] = "src"
+		trigger["atomic"] = true
+		return types.ServiceConfig{
+			Name:  "test",
+			Build: &types.BuildConfig{Context: "."},
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{"watch": []interface{}{trigger}},
+			},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService(map[string]interface{}{"action": "sync", "target": "/app"}), project)
+	assert.NilError(t, err)
+	events := maybeFileEvents(config.Watch[0], filepath.Join(config.Watch[0].Path, "index.html"), watch.EmptyMatcher{}, nil, nil)
+	assert.Check(t, len(events) == 1 && events[0].Atomic)
+
+	_, err = loadDevelopmentConfig(newService(map[string]interface{}{"action": "restart"}), project)
+	assert.ErrorContains(t, err, "atomic only applies to the 'sync' and 'sync+restart' actions")
+}
+
+func TestLoadDevelopmentConfig_Chown(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	newService := func(trigger map[string]interface{}) types.ServiceConfig {
+		trigger["path"] = "src"
+		return types.ServiceConfig{
+			Name:  "test",
+			Build: &types.BuildConfig{Context: "."},
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{"watch": []interface{}{trigger}},
+			},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService(map[string]interface{}{"action": "sync", "target": "/app", "chown": "node:node"}), project)
+	assert.NilError(t, err)
+	events := maybeFileEvents(config.Watch[0], filepath.Join(config.Watch[0].Path, "index.html"), watch.EmptyMatcher{}, nil, nil)
+	assert.Check(t, len(events) == 1 && events[0].Chown == "node:node")
+
+	_, err = loadDevelopmentConfig(newService(map[string]interface{}{"action": "sync", "target": "/app", "chown": "node:"}), project)
+	assert.ErrorContains(t, err, `invalid owner "node:"`)
+
+	_, err = loadDevelopmentConfig(newService(map[string]interface{}{"action": "restart", "chown": "1000"}), project)
+	assert.ErrorContains(t, err, "chown only applies to the 'sync' and 'sync+restart' actions")
+}
+
+func TestMaybeFileEvent_Filter(t *testing.T) {
+	trigger := Trigger{Path: "/src", Action: "sync", Target: "/app", Filter: "sed s/prod/dev/"}
+	events := maybeFileEvents(trigger, "/src/config.yaml", watch.EmptyMatcher{}, nil, nil)
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].PathMapping, sync.PathMapping{
+		HostPath:      "/src/config.yaml",
+		ContainerPath: "/app/config.yaml",
+		Filter:        "sed s/prod/dev/",
+	})
+}
+
+func TestSplitGlobPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		root    string
+		pattern string
+		isGlob  bool
+	}{
+		{path: "/src/app", root: "/src/app"},
+		{path: "/src/**/*.go", root: "/src", pattern: "**/*.go", isGlob: true},
+		{path: "/src/cmd/*/main.go", root: "/src/cmd", pattern: "*/main.go", isGlob: true},
+		{path: "/*.go", root: "/", pattern: "*.go", isGlob: true},
+		{path: "src/[ab].go", root: "src", pattern: "[ab].go", isGlob: true},
+		{path: "*.go", root: ".", pattern: "*.go", isGlob: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			root, pattern, ok := splitGlobPath(filepath.FromSlash(tt.path))
+			assert.Equal(t, ok, tt.isGlob)
+			assert.Equal(t, root, filepath.FromSlash(tt.root))
+			assert.Equal(t, pattern, tt.pattern)
+		})
+	}
+}
+
+func TestLoadDevelopmentConfig_GlobPath(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	project := &types.Project{WorkingDir: dir}
+	service := types.ServiceConfig{
+		Name: "test",
+		Extensions: map[string]interface{}{
+			"x-develop": map[string]interface{}{
+				"watch": []interface{}{
+					map[string]interface{}{"path": "src/**/*.go", "action": "sync", "target": "/app"},
+				},
+			},
+		},
+	}
+	config, err := loadDevelopmentConfig(service, project)
+	assert.NilError(t, err)
+	trigger := config.Watch[0]
+	assert.Equal(t, trigger.Path, filepath.Join(dir, "src"))
+	assert.DeepEqual(t, trigger.Include, []string{"**/*.go"})
+
+	ignore, err := triggerIgnoreMatcher(trigger)
+	assert.NilError(t, err)
+	for _, p := range []string{"main.go", "pkg/api/api.go"} {
+		events := maybeFileEvents(trigger, filepath.Join(dir, "src", p), ignore, nil, nil)
+		assert.Equal(t, len(events), 1, "%s should trigger a sync", p)
+		assert.Equal(t, events[0].ContainerPath, path.Join("/app", p))
+	}
+	for _, p := range []string{"notes.txt", "pkg/api/README.txt"} {
+		events := maybeFileEvents(trigger, filepath.Join(dir, "src", p), ignore, nil, nil)
+		assert.Equal(t, len(events), 0, "%s should be ignored", p)
+	}
+}
+
+func TestMaybeFileEvents_NegatedIgnore(t *testing.T) {
+	trigger := Trigger{
+		Path:   "/src",
+		Action: "sync",
+		Target: "/app",
+		Ignore: []string{"node_modules", "!node_modules/my-lib"},
+	}
+	ignore, err := triggerIgnoreMatcher(trigger)
+	assert.NilError(t, err)
+
+	for _, p := range []string{"node_modules/my-lib", "node_modules/my-lib/index.js", "node_modules/my-lib/lib/util.js", "main.js"} {
+		events := maybeFileEvents(trigger, path.Join("/src", p), ignore, nil, nil)
+		if assert.Check(t, len(events) == 1, "%s should be synced", p) {
+			assert.Equal(t, events[0].ContainerPath, path.Join("/app", p))
+		}
+	}
+	for _, p := range []string{"node_modules", "node_modules/other-lib/index.js", "node_modules/my-lib-fork/index.js"} {
+		events := maybeFileEvents(trigger, path.Join("/src", p), ignore, nil, nil)
+		assert.Check(t, len(events) == 0, "%s should be ignored", p)
+	}
+}
+
+func TestMaybeFileEvents_SpecialFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("FIFOs and symlinks need extra privileges on Windows")
+	}
+	dir := t.TempDir()
+	fifo := filepath.Join(dir, "events.fifo")
+	if err := exec.Command("mkfifo", fifo).Run(); err != nil {
+		t.Skipf("can't create a FIFO: %v", err)
+	}
+	dangling := filepath.Join(dir, "current")
+	assert.NilError(t, os.Symlink(filepath.Join(dir, "missing"), dangling))
+	trigger := Trigger{Path: dir, Action: "sync", Target: "/app"}
+	ignore, err := triggerIgnoreMatcher(trigger)
+	assert.NilError(t, err)
+
+	assert.Check(t, len(maybeFileEvents(trigger, fifo, ignore, nil, nil)) == 0, "FIFOs must not be synced")
+	// dangling symlinks are handled as deleted paths by default
+	assert.Check(t, len(maybeFileEvents(trigger, dangling, ignore, nil, nil)) == 1)
+
+	trigger.IgnoreDanglingSymlinks = true
+	assert.Check(t, len(maybeFileEvents(trigger, dangling, ignore, nil, nil)) == 0, "dangling symlinks must be ignored")
+}
+
+func TestTriggerIgnoreMatcher_IncludeAndNegatedIgnore(t *testing.T) {
+	trigger := Trigger{
+		Path:    "/src",
+		Action:  "sync",
+		Target:  "/app",
+		Include: []string{"**/*.go"},
+		Ignore:  []string{"vendor", "!vendor/keep"},
+	}
+	ignore, err := triggerIgnoreMatcher(trigger)
+	assert.NilError(t, err)
+
+	for _, p := range []string{"main.go", "vendor/keep/lib.go"} {
+		events := maybeFileEvents(trigger, path.Join("/src", p), ignore, nil, nil)
+		assert.Check(t, len(events) == 1, "%s should be synced", p)
+	}
+	// negating an ignore pattern must not re-include files outside of the include patterns
+	for _, p := range []string{"README.md", "vendor/lib.go", "vendor/keep/README.md"} {
+		events := maybeFileEvents(trigger, path.Join("/src", p), ignore, nil, nil)
+		assert.Check(t, len(events) == 0, "%s should be ignored", p)
+	}
+}
+
+func TestMaybeFileEvents_IgnoreAnchoring(t *testing.T) {
+	// trigger ignores are relative to the trigger path, like .dockerignore is relative to the build context
+	buildContext := t.TempDir()
+	service := types.ServiceConfig{Name: "test", Build: &types.BuildConfig{Context: buildContext}}
+	trigger := Trigger{Path: filepath.Join(buildContext, "src"), Action: "sync", Target: "/app"}
+
+	tests := []struct {
+		pattern string
+		path    string
+		ignored bool
+	}{
+		{pattern: "*.log", path: "app.log", ignored: true},
+		{pattern: "*.log", path: "lib/app.log", ignored: false},
+		{pattern: "**/*.log", path: "lib/app.log", ignored: true},
+		{pattern: "/build", path: "build/out.js", ignored: true},
+		{pattern: "/build", path: "lib/build/out.js", ignored: false},
+		{pattern: "build", path: "build/out.js", ignored: true},
+		{pattern: "sub/*.tmp", path: "sub/a.tmp", ignored: true},
+		{pattern: "sub/*.tmp", path: "lib/sub/a.tmp", ignored: false},
+		{pattern: "sub/*.tmp", path: "sub/deeper/a.tmp", ignored: false},
+		// a directory name at any depth, the trailing slash is ignored like dockerignore does
+		{pattern: "**/__pycache__/", path: "__pycache__/main.pyc", ignored: true},
+		{pattern: "**/__pycache__/", path: "lib/__pycache__/util.pyc", ignored: true},
+		{pattern: "**/__pycache__/", path: "lib/sub/__pycache__/util.pyc", ignored: true},
+		{pattern: "**/__pycache__/", path: "lib/__pycache__.py", ignored: false},
+		{pattern: "__pycache__/", path: "lib/__pycache__/util.pyc", ignored: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			trigger := trigger
+			trigger.Ignore = []string{tt.pattern}
+			triggerIgnore, err := triggerIgnoreMatcher(trigger)
+			assert.NilError(t, err)
+			events := maybeFileEvents(trigger, filepath.Join(trigger.Path, filepath.FromSlash(tt.path)), triggerIgnore, nil, nil)
+			assert.Check(t, (len(events) == 0) == tt.ignored, "trigger-relative %s", tt.path)
+
+			assert.NilError(t, os.WriteFile(filepath.Join(buildContext, ".dockerignore"), []byte(tt.pattern), 0o644))
+			contextIgnore, err := serviceIgnoreMatcher(&types.Project{}, service, DevelopmentConfig{})
+			assert.NilError(t, err)
+			contextTrigger := Trigger{Path: buildContext, Action: "sync", Target: "/app"}
+			events = maybeFileEvents(contextTrigger, filepath.Join(buildContext, filepath.FromSlash(tt.path)), contextIgnore, nil, nil)
+			assert.Check(t, (len(events) == 0) == tt.ignored, "context-relative %s", tt.path)
+		})
+	}
+
+	// trigger ignores aren't relative to the build context, but can be set as absolute paths
+	out := filepath.Join(trigger.Path, "build", "out.js")
+	for pattern, ignored := range map[string]bool{
+		"src/build": false,
+		filepath.ToSlash(filepath.Join(trigger.Path, "build")): true,
+	} {
+		trigger := trigger
+		trigger.Ignore = []string{pattern}
+		ignore, err := triggerIgnoreMatcher(trigger)
+		assert.NilError(t, err)
+		assert.Check(t, (len(maybeFileEvents(trigger, out, ignore, nil, nil)) == 0) == ignored, pattern)
+	}
+}
+
+func TestIncludeOnlyMatcher(t *testing.T) {
+	triggers := []Trigger{
+		{Path: "/src", Action: "sync", Target: "/app", Include: []string{"**/*.go"}, Ignore: []string{"vendor/"}},
+		{Path: "/src/web", Action: "sync", Target: "/app/web", Extensions: []string{".js"}},
+		{Path: "/assets/logo.png", Action: "sync", Target: "/app/logo.png"},
+	}
+	matcher, err := newIncludeOnlyMatcher(triggers)
+	assert.NilError(t, err)
+
+	for p, expected := range map[string]bool{
+		"/src/main.go":           false,
+		"/src/lib/util.go":       false,
+		"/src/web/index.js":      false,
+		"/assets/logo.png":       false,
+		"/src/README.md":         true,
+		"/src/web/index.css":     true,
+		"/src/vendor/lib/lib.go": true,
+		"/assets/icon.png":       true,
+		"/other/main.go":         true,
+	} {
+		ignored, err := matcher.Matches(p)
+		assert.NilError(t, err)
+		assert.Equal(t, ignored, expected, p)
+	}
+	for p, expected := range map[string]bool{
+		"/":            false,
+		"/assets":      false,
+		"/src/lib":     false,
+		"/src/vendor":  true,
+		"/other":       true,
+		"/assets/icon": true,
+	} {
+		skipped, err := matcher.MatchesEntireDir(p)
+		assert.NilError(t, err)
+		assert.Equal(t, skipped, expected, p)
+	}
+}
+
+func TestWatch_IncludeOnly(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	project := &types.Project{WorkingDir: dir}
+	config, err := loadDevelopmentConfig(types.ServiceConfig{
+		Name: "test",
+		Extensions: map[string]interface{}{
+			"x-develop": map[string]interface{}{
+				"include_only": true,
+				"watch": []interface{}{
+					map[string]interface{}{"path": "**/*.go", "action": "sync", "target": "/app"},
+				},
+			},
+		},
+	}, project)
+	assert.NilError(t, err)
+	assert.Check(t, config.IncludeOnly)
+	ignore, err := newIncludeOnlyMatcher(config.Watch)
+	assert.NilError(t, err)
+
+	watcher, err := startWatcher([]string{dir}, ignore)
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		_ = watcher.Close()
+	})
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0o644))
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, "lib"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "lib", "data.json"), []byte("{}"), 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "lib", "util.go"), []byte("package lib"), 0o644))
+	for {
+		select {
+		case e := <-watcher.Events():
+			assert.Check(t, filepath.Ext(e.Path()) == ".go", "unexpected event for %s", e.Path())
+			if e.Path() == filepath.Join(dir, "lib", "util.go") {
+				return
+			}
+		case err := <-watcher.Errors():
+			t.Fatal(err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the change to be detected")
+		}
+	}
+}
+
+func TestTriggerIgnoreMatcher_DirectoryAtAnyDepth(t *testing.T) {
+	trigger := Trigger{Path: "/src", Action: "sync", Target: "/app", Ignore: []string{"**/__pycache__/"}}
+	ignore, err := triggerIgnoreMatcher(trigger)
+	assert.NilError(t, err)
+	// the directories aren't watched at all
+	for _, dir := range []string{"/src/lib/__pycache__", "/src/lib/sub/__pycache__"} {
+		skipped, err := ignore.MatchesEntireDir(dir)
+		assert.NilError(t, err)
+		assert.Check(t, skipped, dir)
+	}
+	skipped, err := ignore.MatchesEntireDir("/src/lib")
+	assert.NilError(t, err)
+	assert.Check(t, !skipped)
+}
+
+// newWatchProject returns a project with a single service syncing dir to /app.
+func newWatchProject(t *testing.T, dir string) *types.Project {
+	t.Helper()
+	return &types.Project{
+		Name:       "test",
+		WorkingDir: dir,
+		Services: []types.ServiceConfig{
+			{
+				Name:  "test",
+				Build: &types.BuildConfig{Context: dir},
+				Extensions: map[string]interface{}{
+					"x-develop": map[string]interface{}{
+						"watch": []interface{}{
+							map[string]interface{}{"path": dir, "action": "sync", "target": "/app"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// watchSyncerFunc is a custom syncer for the watch options
+type watchSyncerFunc func(ctx context.Context, service types.ServiceConfig, paths []api.WatchPathMapping) error
+
+func (f watchSyncerFunc) Sync(ctx context.Context, service types.ServiceConfig, paths []api.WatchPathMapping) error {
+	return f(ctx, service, paths)
+}
+
+func TestWatch_CustomSyncer(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "main.go"), nil, 0o644))
+	clock := clockwork.NewFakeClock()
+	service := composeService{dockerCli: cli, clock: clock}
+	synced := make(chan []api.WatchPathMapping, 1)
+	syncer := watchSyncerFunc(func(_ context.Context, service types.ServiceConfig, paths []api.WatchPathMapping) error {
+		assert.Equal(t, service.Name, "test")
+		synced <- paths
+		return nil
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- service.Watch(context.Background(), newWatchProject(t, dir), nil, api.WatchOptions{
+			MaxDuration: time.Minute,
+			InitialSync: true,
+			Syncer:      syncer,
+		})
+	}()
+	select {
+	case paths := <-synced:
+		assert.DeepEqual(t, paths, []api.WatchPathMapping{{HostPath: filepath.Join(dir, "main.go"), ContainerPath: "/app/main.go"}})
+	case <-time.After(time.Second):
+		t.Fatal("the custom syncer wasn't used")
+	}
+
+	// session timer + debounce ticker
+	clock.BlockUntil(2)
+	clock.Advance(time.Minute)
+	select {
+	case err := <-done:
+		assert.NilError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("watch didn't stop after the max duration")
+	}
+}
+
+func TestWatch_BuiltOptions(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "main.go"), nil, 0o644))
+	clock := clockwork.NewFakeClock()
+	service := composeService{dockerCli: cli, clock: clock}
+	synced := make(chan []api.WatchPathMapping, 1)
+	started := make(chan api.WatchEvent, 1)
+	options, err := api.NewWatchOptionsBuilder().
+		WithSyncer(watchSyncerFunc(func(_ context.Context, _ types.ServiceConfig, paths []api.WatchPathMapping) error {
+			synced <- paths
+			return nil
+		})).
+		WithInitialSync().
+		WithMaxDuration(time.Minute).
+		WithEventHandler(func(e api.WatchEvent) {
+			if e.Type == api.WatchEventStarted {
+				started <- e
+			}
+		}).
+		Build()
+	assert.NilError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- service.Watch(context.Background(), newWatchProject(t, dir), nil, options)
+	}()
+	select {
+	case e := <-started:
+		assert.Equal(t, e.Service, "test")
+	case <-time.After(time.Second):
+		t.Fatal("the event handler wasn't used")
+	}
+	select {
+	case paths := <-synced:
+		assert.DeepEqual(t, paths, []api.WatchPathMapping{{HostPath: filepath.Join(dir, "main.go"), ContainerPath: "/app/main.go"}})
+	case <-time.After(time.Second):
+		t.Fatal("the syncer wasn't used for the initial sync")
+	}
+
+	// session timer + debounce ticker
+	clock.BlockUntil(2)
+	clock.Advance(time.Minute)
+	select {
+	case err := <-done:
+		assert.NilError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("watch didn't stop after the max duration")
+	}
+
+	err = service.Watch(context.Background(), newWatchProject(t, dir), nil, api.WatchOptions{IdleTimeout: -time.Second})
+	assert.ErrorContains(t, err, "watch idle timeout can't be negative")
+}
+
+func TestWatch_DebounceOption(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	clock := clockwork.NewFakeClock()
+	service := composeService{
+		dockerCli: cli,
+		clock:     clock,
+		watches:   &watchRegistry{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	proj := &types.Project{Name: "myproject", Services: []types.ServiceConfig{{Name: "test"}}}
+	syncer := newFakeSyncer()
+	go func() {
+		err := service.watch(ctx, proj, "test", api.WatchOptions{Debounce: 2 * time.Second}, watcher, syncer, &serviceWatch{}, DevelopmentConfig{
+			Watch: []Trigger{{Path: "/src", Action: "sync", Target: "/app"}},
+		})
+		assert.NilError(t, err)
+	}()
+
+	watcher.Events() <- watch.NewFileEvent("/src/main.go")
+	clock.BlockUntil(1)
+	clock.Advance(quietPeriod)
+	select {
+	case actual := <-syncer.synced:
+		t.Fatalf("changes synced before the debounce of the options: %v", actual)
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.Check(t, poll(func() bool {
+		clock.Advance(2 * time.Second)
+		select {
+		case actual := <-syncer.synced:
+			assert.DeepEqual(t, actual, []sync.PathMapping{{HostPath: "/src/main.go", ContainerPath: "/app/main.go"}})
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}), "changes not synced after the debounce of the options")
+}
+
+func TestWatch_StartupLimit(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "main.go"), nil, 0o644))
+	proj := newWatchProject(t, dir)
+	for _, name := range []string{"web", "api", "worker"} {
+		service := proj.Services[0]
+		service.Name = name
+		proj.Services = append(proj.Services, service)
+	}
+
+	clock := clockwork.NewFakeClock()
+	service := composeService{dockerCli: cli, clock: clock, maxConcurrency: 2}
+	var mu gosync.Mutex
+	active, maxActive := 0, 0
+	started := make(chan struct{}, len(proj.Services))
+	release := make(chan struct{})
+	syncer := watchSyncerFunc(func(context.Context, types.ServiceConfig, []api.WatchPathMapping) error {
+		mu.Lock()
+		active++
+		maxActive = max(maxActive, active)
+		mu.Unlock()
+		started <- struct{}{}
+		<-release
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return nil
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- service.Watch(context.Background(), proj, nil, api.WatchOptions{
+			MaxDuration: time.Minute,
+			InitialSync: true,
+			Syncer:      syncer,
+		})
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatal("the initial syncs didn't start")
+		}
+	}
+	select {
+	case <-started:
+		t.Fatal("more initial syncs than the parallel limit started")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	// session timer + debounce tickers
+	clock.BlockUntil(5)
+	clock.Advance(time.Minute)
+	select {
+	case err := <-done:
+		assert.NilError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("watch didn't stop after the max duration")
+	}
+	assert.Equal(t, maxActive, 2)
+	assert.Equal(t, len(started), 2, "the other initial syncs must eventually run")
+}
+
+func TestWatch_ErrorPolicy(t *testing.T) {
+	type session struct {
+		flakyDir, testDir string
+		synced            chan []api.WatchPathMapping
+		errored           chan string
+		clock             clockwork.FakeClock
+		done              chan error
+	}
+	start := func(t *testing.T, policy api.WatchErrorPolicy) session {
+		mockCtrl := gomock.NewController(t)
+		cli := mocks.NewMockCli(mockCtrl)
+		cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+
+		root, err := filepath.EvalSymlinks(t.TempDir())
+		assert.NilError(t, err)
+		proj := &types.Project{Name: "test", WorkingDir: root}
+		for _, name := range []string{"flaky", "test"} {
+			assert.NilError(t, os.MkdirAll(filepath.Join(root, name), 0o755))
+			proj.Services = append(proj.Services, types.ServiceConfig{
+				Name:  name,
+				Build: &types.BuildConfig{Context: filepath.Join(root, name)},
+				Extensions: map[string]interface{}{
+					"x-develop": map[string]interface{}{
+						"watch": []interface{}{
+							map[string]interface{}{"path": name, "action": "sync", "target": "/app", "on_error": "stop"},
+						},
+					},
+				},
+			})
+		}
+
+		clock := clockwork.NewFakeClock()
+		service := composeService{dockerCli: cli, clock: clock}
+		synced := make(chan []api.WatchPathMapping, 10)
+		started := make(chan string, 2)
+		errored := make(chan string, 10)
+		done := make(chan error, 1)
+		go func() {
+			done <- service.Watch(context.Background(), proj, nil, api.WatchOptions{
+				MaxDuration: time.Hour,
+				ErrorPolicy: policy,
+				Syncer: watchSyncerFunc(func(_ context.Context, service types.ServiceConfig, paths []api.WatchPathMapping) error {
+					if service.Name == "flaky" {
+						return errors.New("sync failed")
+					}
+					synced <- paths
+					return nil
+				}),
+				EventHandler: func(e api.WatchEvent) {
+					switch e.Type {
+					case api.WatchEventStarted:
+						started <- e.Service
+					case api.WatchEventError:
+						errored <- e.Service
+					}
+				},
+			})
+		}()
+		for i := 0; i < 2; i++ {
+			select {
+			case <-started:
+			case <-time.After(time.Second):
+				t.Fatal("the services weren't watched")
+			}
+		}
+		return session{
+			flakyDir: filepath.Join(root, "flaky"),
+			testDir:  filepath.Join(root, "test"),
+			synced:   synced,
+			errored:  errored,
+			clock:    clock,
+			done:     done,
+		}
+	}
+	// advance the debounce tickers until cond is true
+	advanceUntil := func(clock clockwork.FakeClock, cond func() bool) bool {
+		return poll(func() bool {
+			clock.Advance(quietPeriod)
+			return cond()
+		})
+	}
+
+	t.Run("fail-fast stops watching all the services", func(t *testing.T) {
+		w := start(t, api.WatchErrorPolicyFailFast)
+		assert.NilError(t, os.WriteFile(filepath.Join(w.flakyDir, "main.go"), nil, 0o644))
+		var err error
+		assert.Check(t, advanceUntil(w.clock, func() bool {
+			select {
+			case err = <-w.done:
+				return true
+			default:
+				return false
+			}
+		}), "watch didn't stop")
+		assert.ErrorContains(t, err, "stopped watching service flaky: sync failed")
+	})
+
+	t.Run("isolate keeps watching the other services", func(t *testing.T) {
+		w := start(t, api.WatchErrorPolicyIsolate)
+		assert.NilError(t, os.WriteFile(filepath.Join(w.flakyDir, "main.go"), nil, 0o644))
+		assert.Check(t, advanceUntil(w.clock, func() bool { return len(w.errored) > 0 }), "the sync of flaky didn't fail")
+		assert.Equal(t, <-w.errored, "flaky")
+		// the change of flaky is handled, test must still be watched
+		assert.NilError(t, os.WriteFile(filepath.Join(w.testDir, "main.go"), nil, 0o644))
+		var paths []api.WatchPathMapping
+		assert.Check(t, advanceUntil(w.clock, func() bool {
+			select {
+			case paths = <-w.synced:
+				return true
+			default:
+				return false
+			}
+		}), "the change of test wasn't synced")
+		assert.DeepEqual(t, paths, []api.WatchPathMapping{{HostPath: filepath.Join(w.testDir, "main.go"), ContainerPath: "/app/main.go"}})
+		assert.Equal(t, len(w.done), 0, "watch stopped")
+
+		w.clock.Advance(time.Hour)
+		select {
+		case err := <-w.done:
+			assert.NilError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("watch didn't stop after the max duration")
+		}
+	})
+}
+
+func TestWatchFailures(t *testing.T) {
+	failures := &watchFailures{watched: 2}
+	failures.add("web", errors.New("sync failed"))
+	assert.NilError(t, failures.err(), "a service is still watched")
+	failures.add("api", errors.New("rebuild failed"))
+	err := failures.err()
+	assert.ErrorContains(t, err, "service web: sync failed")
+	assert.ErrorContains(t, err, "service api: rebuild failed")
+}
+
+func TestCustomSyncer(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "main.go"), nil, 0o644))
+	var actual []api.WatchPathMapping
+	syncer := customSyncer{syncer: watchSyncerFunc(func(_ context.Context, _ types.ServiceConfig, paths []api.WatchPathMapping) error {
+		actual = paths
+		return nil
+	})}
+	err := syncer.Sync(context.Background(), types.ServiceConfig{Name: "test"}, []sync.PathMapping{
+		{HostPath: filepath.Join(dir, "main.go"), ContainerPath: "/app/main.go", Filter: "gofmt", PreserveMode: true},
+		// removal missed by the watcher
+		{HostPath: filepath.Join(dir, "util.go"), ContainerPath: "/app/util.go"},
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, actual, []api.WatchPathMapping{
+		{HostPath: filepath.Join(dir, "main.go"), ContainerPath: "/app/main.go", Filter: "gofmt", PreserveMode: true},
+		{HostPath: filepath.Join(dir, "util.go"), ContainerPath: "/app/util.go", Deleted: true},
+	})
+}
+
+func TestWatch_MaxDuration(t *testing.T) {
+	for _, jsonOutput := range []bool{false, true} {
+		t.Run(fmt.Sprintf("json=%t", jsonOutput), func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			cli := mocks.NewMockCli(mockCtrl)
+			var stderr bytes.Buffer
+			cli.EXPECT().Err().Return(&stderr).AnyTimes()
+
+			clock := clockwork.NewFakeClock()
+			service := composeService{
+				dockerCli: cli,
+				clock:     clock,
+			}
+
+			done := make(chan error)
+			go func() {
+				done <- service.Watch(context.Background(), newWatchProject(t, t.TempDir()), nil, api.WatchOptions{
+					MaxDuration: time.Minute,
+					JSON:        jsonOutput,
+				})
+			}()
+
+			// session timer + debounce ticker
+			clock.BlockUntil(2)
+			clock.Advance(time.Minute)
+			select {
+			case err := <-done:
+				assert.NilError(t, err)
+			case <-time.After(time.Second):
+				t.Fatal("watch didn't stop after the max duration")
+			}
+			// the JSON output only has JSON messages
+			assert.Check(t, strings.Contains(stderr.String(), "watch session time limit reached") != jsonOutput, stderr.String())
+		})
+	}
+}
+
+func TestWatch_IdleTimeout(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	stderr := &lockedBuffer{}
+	cli.EXPECT().Err().Return(stderr).AnyTimes()
+
+	dir := t.TempDir()
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	syncer := newFakeSyncer()
+	clock := clockwork.NewFakeClock()
+	service := composeService{
+		dockerCli: cli,
+		clock:     clock,
+	}
+	proj := &types.Project{Services: []types.ServiceConfig{{Name: "test"}}}
+	done := make(chan error, 1)
+	go func() {
+		done <- service.watch(context.Background(), proj, "test", api.WatchOptions{IdleTimeout: time.Minute}, watcher, syncer, &serviceWatch{}, DevelopmentConfig{
+			Watch: []Trigger{{Path: dir, Action: "sync", Target: "/app"}},
+		})
+	}()
+
+	// debounce ticker + idle timer
+	clock.BlockUntil(2)
+	for i := 0; i < 3; i++ {
+		clock.Advance(50 * time.Second)
+		watcher.Events() <- watch.NewFileEvent(filepath.Join(dir, "main.go"))
+		assert.Check(t, poll(func() bool {
+			clock.Advance(quietPeriod)
+			select {
+			case <-syncer.synced:
+				return true
+			case <-time.After(10 * time.Millisecond):
+				return false
+			}
+		}), "change wasn't synced")
+		select {
+		case err := <-done:
+			t.Fatalf("watch stopped while changes keep being made: %v", err)
+		default:
+		}
+	}
+
+	// debounce ticker + idle timer restarted after the last batch
+	clock.BlockUntil(2)
+	clock.Advance(time.Minute)
+	select {
+	case err := <-done:
+		assert.NilError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("watch didn't stop after the idle timeout")
+	}
+	assert.Check(t, strings.Contains(stderr.String(), "no changes for test in 1m0s, stopping"), stderr.String())
+}
+
+func TestWatch_StartedMessages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	stderr := &lockedBuffer{}
+	cli.EXPECT().Err().Return(stderr).AnyTimes()
+	clock := clockwork.NewFakeClock()
+	service := composeService{dockerCli: cli, clock: clock}
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	for _, d := range []string{"web", "api"} {
+		assert.NilError(t, os.Mkdir(filepath.Join(dir, d), 0o755))
+	}
+	proj := newWatchProject(t, dir)
+	proj.Services = []types.ServiceConfig{
+		{
+			Name:  "web",
+			Build: &types.BuildConfig{Context: dir},
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{
+					"sync_backend": "cp",
+					"watch":        []interface{}{map[string]interface{}{"path": "web", "action": "sync", "target": "/app"}},
+				},
+			},
+		},
+		{
+			Name:  "api",
+			Build: &types.BuildConfig{Context: dir},
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{
+					"sync_backend": "tar",
+					"watch":        []interface{}{map[string]interface{}{"path": "api", "action": "sync", "target": "/app"}},
+				},
+			},
+		},
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- service.Watch(context.Background(), proj, nil, api.WatchOptions{MaxDuration: time.Minute})
+	}()
+	// session timer + debounce tickers
+	clock.BlockUntil(3)
+	assert.Check(t, strings.Contains(stderr.String(),
+		fmt.Sprintf("watching [%s] for service web, syncing with cp\n", filepath.Join(dir, "web"))), stderr.String())
+	assert.Check(t, strings.Contains(stderr.String(),
+		fmt.Sprintf("watching [%s] for service api, syncing with tar\n", filepath.Join(dir, "api"))), stderr.String())
+
+	clock.Advance(time.Minute)
+	select {
+	case err := <-done:
+		assert.NilError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("watch didn't stop after the max duration")
+	}
+	assert.Check(t, strings.HasSuffix(stderr.String(),
+		"watch session ended after 1m0s: 0 file(s) synced, 0 rebuild(s), 0 restart(s), 0 error(s), 0 warning(s)\n"), stderr.String())
+}
+
+func TestWatchLimitError(t *testing.T) {
+	err := watchLimitError(errors.Wrapf(syscall.ENOSPC, "watcher.Add(%q)", "/src/node_modules"))
+	assert.ErrorContains(t, err, "reached the system limit of file watches")
+	assert.ErrorContains(t, err, "sudo sysctl fs.inotify.max_user_watches=")
+	assert.ErrorContains(t, err, "set COMPOSE_WATCH_POLL=true to poll for changes instead")
+	assert.Check(t, errors.Is(err, syscall.ENOSPC))
+
+	other := errors.New("permission denied")
+	assert.Equal(t, watchLimitError(other), other)
+	assert.NilError(t, watchLimitError(nil))
+}
+
+func TestSyncBackendName(t *testing.T) {
+	proj := &types.Project{Name: "myproject"}
+	assert.Equal(t, syncBackendName(sync.NewTar(proj.Name, discardTarClient{})), "tar")
+	assert.Equal(t, syncBackendName(withSyncHook(sync.NewDockerCopy(proj.Name, nil, io.Discard), proj, DevelopmentConfig{OnSync: "make"})), "cp")
+	assert.Equal(t, syncBackendName(customSyncer{}), "custom syncer")
+}
+
+func TestWatch_ForceBindMountedPath(t *testing.T) {
+	for _, tc := range []struct {
+		force bool
+		host  string
+	}{
+		{force: false, host: "unix:///var/run/docker.sock"},
+		{force: true, host: "unix:///var/run/docker.sock"},
+		// the bind mount is made from the remote host, the local path is watched
+		{force: false, host: "ssh://user@devbox"},
+	} {
+		force := tc.force
+		watched := force || tc.host != "unix:///var/run/docker.sock"
+		t.Run(fmt.Sprintf("force=%t,host=%s", force, tc.host), func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			cli := mocks.NewMockCli(mockCtrl)
+			stderr := &lockedBuffer{}
+			cli.EXPECT().Err().Return(stderr).AnyTimes()
+			cli.EXPECT().DockerEndpoint().Return(docker.Endpoint{EndpointMeta: docker.EndpointMeta{Host: tc.host}}).AnyTimes()
+
+			clock := clockwork.NewFakeClock()
+			service := composeService{
+				dockerCli: cli,
+				clock:     clock,
+			}
+
+			dir, err := filepath.EvalSymlinks(t.TempDir())
+			assert.NilError(t, err)
+			assert.NilError(t, os.WriteFile(filepath.Join(dir, "main.go"), nil, 0o644))
+			proj := newWatchProject(t, dir)
+			proj.Services[0].Volumes = []types.ServiceVolumeConfig{
+				{Type: types.VolumeTypeBind, Source: dir, Target: "/app", Bind: &types.ServiceVolumeBind{}},
+			}
+			proj.Services[0].Extensions["x-develop"] = map[string]interface{}{
+				"watch": []interface{}{
+					map[string]interface{}{"path": dir, "action": "sync", "target": "/app", "force": force},
+				},
+			}
+
+			done := make(chan error)
+			go func() {
+				done <- service.Watch(context.Background(), proj, nil, api.WatchOptions{
+					MaxDuration: time.Minute,
+				})
+			}()
+
+			// session timer + debounce ticker
+			clock.BlockUntil(2)
+			clock.Advance(time.Minute)
+			select {
+			case err := <-done:
+				assert.NilError(t, err)
+			case <-time.After(time.Second):
+				t.Fatal("watch didn't stop after the max duration")
+			}
+			if watched {
+				assert.Check(t, strings.Contains(stderr.String(), fmt.Sprintf("watching [%s]", dir)), stderr.String())
+			} else {
+				assert.Check(t, strings.Contains(stderr.String(), "watching []"), stderr.String())
+			}
+
+			config, err := loadDevelopmentConfig(proj.Services[0], proj)
+			assert.NilError(t, err)
+			events, err := service.forceSyncEvents(proj, proj.Services[0], *config, api.WatchOptions{}, nil)
+			assert.NilError(t, err)
+			assert.Equal(t, len(events) == 1, watched, "only forced or remote bind mounted paths are synced")
+		})
+	}
+}
+
+func TestWatch_SyncOnlyWithoutBuild(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+
+	clock := clockwork.NewFakeClock()
+	service := composeService{
+		dockerCli: cli,
+		clock:     clock,
+	}
+
+	dir := t.TempDir()
+	proj := newWatchProject(t, dir)
+	proj.Services[0].Build = nil
+	proj.Services[0].Image = "nginx"
+
+	done := make(chan error)
+	go func() {
+		done <- service.Watch(context.Background(), proj, []string{"test"}, api.WatchOptions{
+			MaxDuration: time.Minute,
+		})
+	}()
+
+	// session timer + debounce ticker
+	clock.BlockUntil(2)
+	clock.Advance(time.Minute)
+	select {
+	case err := <-done:
+		assert.NilError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("watch didn't stop after the max duration")
+	}
+	assert.Equal(t, proj.Services[0].PullPolicy, "", "pull policy must not be changed for a prebuilt image")
+}
+
+func TestWatch_RebuildWithoutBuild(t *testing.T) {
+	service := composeService{clock: clockwork.NewFakeClock()}
+	dir := t.TempDir()
+	proj := newWatchProject(t, dir)
+	proj.Services[0].Build = nil
+	proj.Services[0].Image = "nginx"
+	proj.Services[0].Extensions["x-develop"] = map[string]interface{}{
+		"watch": []interface{}{
+			map[string]interface{}{"path": dir, "action": "sync", "target": "/app"},
+			map[string]interface{}{"path": filepath.Join(dir, "Dockerfile"), "action": "rebuild"},
+		},
+	}
+	err := service.Watch(context.Background(), proj, []string{"test"}, api.WatchOptions{})
+	assert.ErrorContains(t, err, "doesn't have a build section, can't apply 'rebuild' on watch")
+}
+
+func TestServiceIgnoreMatcher_WithoutBuild(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.Mkdir(filepath.Join(dir, ".cache"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*.log\n"), 0o644))
+	service := types.ServiceConfig{Name: "test", Image: "nginx"}
+
+	matcher, err := serviceIgnoreMatcher(&types.Project{WorkingDir: dir}, service, DevelopmentConfig{GitIgnore: true})
+	assert.NilError(t, err)
+	for _, p := range []string{".cache/data", "debug.log"} {
+		ok, err := matcher.Matches(filepath.Join(dir, p))
+		assert.NilError(t, err)
+		assert.Check(t, ok, "%s should be ignored", p)
+	}
+	ok, err := matcher.Matches(filepath.Join(dir, "index.html"))
+	assert.NilError(t, err)
+	assert.Check(t, !ok)
+}
+
+type failingSyncer struct {
+	err error
+}
+
+func (f failingSyncer) Sync(context.Context, types.ServiceConfig, []sync.PathMapping) error {
+	return f.err
+}
+
+// flakySyncer fails with err the first failures attempts, then records the synced paths.
+type flakySyncer struct {
+	failures int
+	err      error
+	attempts int
+	synced   []sync.PathMapping
+}
+
+func (f *flakySyncer) Sync(_ context.Context, _ types.ServiceConfig, paths []sync.PathMapping) error {
+	f.attempts++
+	if f.attempts <= f.failures {
+		return f.err
+	}
+	f.synced = paths
+	return nil
+}
+
+func TestHandleWatchBatch_SyncRetry(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+	proj := &types.Project{
+		Name:     "myproject",
+		Services: []types.ServiceConfig{{Name: "test"}},
+	}
+	batch := []fileEvent{
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/src/main.go", ContainerPath: "/app/main.go"}},
+	}
+	transient := errdefs.Conflict(errors.New("container is restarting"))
+
+	t.Run("transient errors are retried", func(t *testing.T) {
+		clock := clockwork.NewFakeClock()
+		service := composeService{dockerCli: cli, clock: clock}
+		syncer := &flakySyncer{failures: 2, err: transient}
+		done := make(chan error)
+		go func() {
+			done <- service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{}, batch, syncer, nil)
+		}()
+		for _, delay := range []time.Duration{200 * time.Millisecond, 400 * time.Millisecond} {
+			clock.BlockUntil(1)
+			clock.Advance(delay)
+		}
+		select {
+		case err := <-done:
+			assert.NilError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for sync")
+		}
+		assert.Equal(t, syncer.attempts, 3)
+		assert.DeepEqual(t, syncer.synced, []sync.PathMapping{batch[0].PathMapping})
+	})
+
+	t.Run("retries are bounded", func(t *testing.T) {
+		t.Setenv("COMPOSE_WATCH_SYNC_RETRIES", "1")
+		clock := clockwork.NewFakeClock()
+		service := composeService{dockerCli: cli, clock: clock}
+		syncer := &flakySyncer{failures: 5, err: transient}
+		done := make(chan error)
+		go func() {
+			done <- service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{}, batch, syncer, nil)
+		}()
+		clock.BlockUntil(1)
+		clock.Advance(200 * time.Millisecond)
+		select {
+		case err := <-done:
+			assert.ErrorIs(t, err, transient)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for sync")
+		}
+		assert.Equal(t, syncer.attempts, 2)
+	})
+
+	t.Run("fatal errors are not retried", func(t *testing.T) {
+		service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+		syncErr := errors.New("invalid target")
+		syncer := &flakySyncer{failures: 1, err: syncErr}
+		err := service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{}, batch, syncer, nil)
+		assert.ErrorIs(t, err, syncErr)
+		assert.Equal(t, syncer.attempts, 1)
+	})
+}
+
+func TestHandleWatchBatch_ResyncOnReconnect(t *testing.T) {
+	t.Setenv("COMPOSE_WATCH_SYNC_RETRIES", "0")
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	var stderr bytes.Buffer
+	cli.EXPECT().Err().Return(&stderr).AnyTimes()
+	apiClient := mocks.NewMockAPIClient(mockCtrl)
+	cli.EXPECT().Client().Return(apiClient).AnyTimes()
+	proj := &types.Project{
+		Name:     "myproject",
+		Services: []types.ServiceConfig{{Name: "test"}},
+	}
+	batch := []fileEvent{
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/src/main.go", ContainerPath: "/app/main.go"}},
+	}
+	connErr := client.ErrorConnectionFailed("unix:///var/run/docker.sock")
+
+	t.Run("resync once the daemon is back", func(t *testing.T) {
+		gomock.InOrder(
+			apiClient.EXPECT().Ping(gomock.Any()).Return(moby.Ping{}, connErr),
+			apiClient.EXPECT().Ping(gomock.Any()).Return(moby.Ping{}, nil),
+		)
+		apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return([]moby.Container{{ID: "123", State: ContainerRunning}}, nil)
+		clock := clockwork.NewFakeClock()
+		service := composeService{dockerCli: cli, clock: clock}
+		syncer := &flakySyncer{failures: 1, err: connErr}
+		done := make(chan error)
+		go func() {
+			done <- service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{}, batch, syncer, nil)
+		}()
+		// the reconnect timeout and the ping interval
+		clock.BlockUntil(2)
+		clock.Advance(daemonReconnectInterval)
+		select {
+		case err := <-done:
+			assert.NilError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the resync")
+		}
+		assert.Equal(t, syncer.attempts, 2)
+		assert.DeepEqual(t, syncer.synced, []sync.PathMapping{batch[0].PathMapping})
+		assert.Check(t, strings.Contains(stderr.String(), "lost the connection to the Docker daemon while syncing test"))
+	})
+
+	t.Run("the wait for the daemon is bounded", func(t *testing.T) {
+		apiClient.EXPECT().Ping(gomock.Any()).Return(moby.Ping{}, connErr).AnyTimes()
+		clock := clockwork.NewFakeClock()
+		service := composeService{dockerCli: cli, clock: clock}
+		syncer := &flakySyncer{failures: 1, err: connErr}
+		done := make(chan error)
+		go func() {
+			done <- service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{}, batch, syncer, nil)
+		}()
+		clock.BlockUntil(2)
+		clock.Advance(daemonReconnectTimeout)
+		select {
+		case err := <-done:
+			assert.ErrorContains(t, err, "the Docker daemon is still unreachable after 1m0s")
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the sync")
+		}
+		assert.Equal(t, syncer.attempts, 1)
+	})
+}
+
+func TestIsRetryableSyncError(t *testing.T) {
+	assert.Check(t, isRetryableSyncError(errdefs.Conflict(errors.New("container is restarting"))))
+	assert.Check(t, isRetryableSyncError(fmt.Errorf("copying files to 123: %w", errdefs.NotFound(errors.New("no such container")))))
+	assert.Check(t, !isRetryableSyncError(errors.New("exit code 2")))
+	assert.Check(t, !isRetryableSyncError(context.Canceled))
+}
+
+func TestHandleWatchBatch_OnError(t *testing.T) {
+	syncErr := errors.New("sync failed")
+	proj := &types.Project{
+		Name:     "myproject",
+		Services: []types.ServiceConfig{{Name: "test"}},
+	}
+	batch := func(policy WatchOnError) []fileEvent {
+		return []fileEvent{{
+			Action:      WatchActionSync,
+			OnError:     policy,
+			PathMapping: sync.PathMapping{HostPath: "/sync/changed", ContainerPath: "/work/changed"},
+		}}
+	}
+
+	t.Run("continue", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		cli := mocks.NewMockCli(mockCtrl)
+		cli.EXPECT().Err().Return(os.Stderr).AnyTimes()
+		service := composeService{dockerCli: cli}
+
+		err := service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{}, batch(WatchOnErrorContinue), failingSyncer{syncErr}, nil)
+		assert.Equal(t, err, syncErr)
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		cli := mocks.NewMockCli(mockCtrl)
+		cli.EXPECT().Err().Return(os.Stderr).AnyTimes()
+		service := composeService{dockerCli: cli}
+
+		err := service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{}, batch(WatchOnErrorStop), failingSyncer{syncErr}, nil)
+		assert.Check(t, errors.As(err, &watchStopError{}))
+		assert.Check(t, errors.Is(err, syncErr))
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		cli := mocks.NewMockCli(mockCtrl)
+		cli.EXPECT().Err().Return(os.Stderr).AnyTimes()
+		apiClient := mocks.NewMockAPIClient(mockCtrl)
+		apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return([]moby.Container{
+			testContainer("test", "123", false),
+		}, nil).AnyTimes()
+		apiClient.EXPECT().ContainerRestart(gomock.Any(), "123", gomock.Any()).Return(nil).Times(1)
+		cli.EXPECT().Client().Return(apiClient).AnyTimes()
+		service := composeService{dockerCli: cli}
+
+		err := service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{}, batch(WatchOnErrorRestart), failingSyncer{syncErr}, nil)
+		assert.NilError(t, err)
+	})
+
+	t.Run("policy of the failing action", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		cli := mocks.NewMockCli(mockCtrl)
+		cli.EXPECT().Err().Return(os.Stderr).AnyTimes()
+		service := composeService{dockerCli: cli}
+
+		// the stop policy of the rebuild trigger doesn't apply to the failing sync
+		mixed := append(batch(WatchOnErrorContinue), fileEvent{
+			Action:      WatchActionRebuild,
+			OnError:     WatchOnErrorStop,
+			PathMapping: sync.PathMapping{HostPath: "/sync/go.mod"},
+		})
+		err := service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{}, mixed, failingSyncer{syncErr}, nil)
+		assert.Equal(t, err, syncErr)
+	})
+}
+
+func TestWatch_OnErrorStop(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(os.Stderr).AnyTimes()
+
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	clock := clockwork.NewFakeClock()
+	service := composeService{
+		dockerCli: cli,
+		clock:     clock,
+	}
+	proj := &types.Project{Services: []types.ServiceConfig{{Name: "test"}}}
+
+	done := make(chan error)
+	go func() {
+		done <- service.watch(context.Background(), proj, "test", api.WatchOptions{}, watcher, failingSyncer{errors.New("sync failed")}, &serviceWatch{}, DevelopmentConfig{Watch: []Trigger{
+			{Path: "/sync", Action: "sync", Target: "/work", OnError: "stop"},
+		}})
+	}()
+
+	watcher.Events() <- watch.NewFileEvent("/sync/changed")
+	clock.BlockUntil(1)
+	clock.Advance(quietPeriod)
+	select {
+	case err := <-done:
+		assert.ErrorContains(t, err, "stopped watching service test: sync failed")
+	case <-time.After(time.Second):
+		t.Fatal("watch didn't stop on error")
+	}
+}
+
+func TestLoadDevelopmentConfig_InvalidOnError(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	service := types.ServiceConfig{
+		Name: "test",
+		Extensions: map[string]interface{}{
+			"x-develop": map[string]interface{}{
+				"watch": []interface{}{
+					map[string]interface{}{"path": "src", "action": "sync", "target": "/app", "on_error": "explode"},
+				},
+			},
+		},
+	}
+	_, err := loadDevelopmentConfig(service, project)
+	assert.ErrorContains(t, err, `invalid on_error policy "explode"`)
+}
+
+func TestWatch_SkipRepeatedBatches(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(os.Stderr).AnyTimes()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.go")
+	assert.NilError(t, os.WriteFile(file, []byte("package main"), 0o644))
+
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	syncer := newFakeSyncer()
+	clock := clockwork.NewFakeClock()
+	service := composeService{
+		dockerCli: cli,
+		clock:     clock,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	proj := &types.Project{Services: []types.ServiceConfig{{Name: "test"}}}
+	go func() {
+		err := service.watch(ctx, proj, "test", api.WatchOptions{}, watcher, syncer, &serviceWatch{}, DevelopmentConfig{
+			SkipRepeatedBatches: true,
+			Watch:               []Trigger{{Path: dir, Action: "sync", Target: "/app"}},
+		})
+		assert.NilError(t, err)
+	}()
+
+	expected := []sync.PathMapping{{HostPath: file, ContainerPath: "/app/main.go"}}
+	// sendAndFlush returns the paths synced after the change to file, if any
+	sendAndFlush := func() []sync.PathMapping {
+		watcher.Events() <- watch.NewFileEvent(file)
+		var actual []sync.PathMapping
+		poll(func() bool {
+			clock.Advance(quietPeriod)
+			select {
+			case actual = <-syncer.synced:
+				return true
+			case <-time.After(10 * time.Millisecond):
+				return false
+			}
+		})
+		return actual
+	}
+
+	assert.DeepEqual(t, expected, sendAndFlush())
+
+	// same file saved again with the same content
+	assert.Check(t, sendAndFlush() == nil, "unexpected sync of repeated batch")
+
+	assert.NilError(t, os.WriteFile(file, []byte("package main\n\nfunc main() {}"), 0o644))
+	assert.DeepEqual(t, expected, sendAndFlush())
+}
+
+func TestWatch_SkipUnchangedFiles(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(os.Stderr).AnyTimes()
+
+	dir := t.TempDir()
+	unchanged := filepath.Join(dir, "main.go")
+	changed := filepath.Join(dir, "util.go")
+	assert.NilError(t, os.WriteFile(unchanged, []byte("package main"), 0o644))
+	assert.NilError(t, os.WriteFile(changed, []byte("package main"), 0o644))
+
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	syncer := newFakeSyncer()
+	clock := clockwork.NewFakeClock()
+	service := composeService{
+		dockerCli: cli,
+		clock:     clock,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	proj := &types.Project{Services: []types.ServiceConfig{{Name: "test"}}}
+	go func() {
+		err := service.watch(ctx, proj, "test", api.WatchOptions{}, watcher, syncer, &serviceWatch{}, DevelopmentConfig{
+			SkipUnchangedFiles: true,
+			Watch:              []Trigger{{Path: dir, Action: "sync", Target: "/app"}},
+		})
+		assert.NilError(t, err)
+	}()
+
+	// sendAndFlush returns the paths synced after the change to file, if any
+	sendAndFlush := func(file string) []sync.PathMapping {
+		watcher.Events() <- watch.NewFileEvent(file)
+		var actual []sync.PathMapping
+		poll(func() bool {
+			clock.Advance(quietPeriod)
+			select {
+			case actual = <-syncer.synced:
+				return true
+			case <-time.After(10 * time.Millisecond):
+				return false
+			}
+		})
+		return actual
+	}
+
+	assert.DeepEqual(t, sendAndFlush(unchanged), []sync.PathMapping{{HostPath: unchanged, ContainerPath: "/app/main.go"}})
+	assert.DeepEqual(t, sendAndFlush(changed), []sync.PathMapping{{HostPath: changed, ContainerPath: "/app/util.go"}})
+
+	// both files saved again, only one with a different content
+	assert.NilError(t, os.WriteFile(changed, []byte("package main\n\nfunc util() {}"), 0o644))
+	assert.Check(t, sendAndFlush(unchanged) == nil, "unexpected sync of an unchanged file")
+	assert.DeepEqual(t, sendAndFlush(changed), []sync.PathMapping{{HostPath: changed, ContainerPath: "/app/util.go"}})
+}
+
+func TestWatch_Pause(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(os.Stderr).AnyTimes()
+
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	syncer := newFakeSyncer()
+	clock := clockwork.NewFakeClock()
+	service := composeService{
+		dockerCli: cli,
+		clock:     clock,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	control := api.NewWatchControl()
+	proj := &types.Project{Services: []types.ServiceConfig{{Name: "test"}}}
+	go func() {
+		err := service.watch(ctx, proj, "test", api.WatchOptions{Control: control}, watcher, syncer, &serviceWatch{}, DevelopmentConfig{
+			Watch: []Trigger{{Path: "/src", Action: "sync", Target: "/app"}},
+		})
+		assert.NilError(t, err)
+	}()
+
+	// flush returns the paths synced once the pending changes are debounced, if any
+	flush := func() []sync.PathMapping {
+		var actual []sync.PathMapping
+		poll(func() bool {
+			clock.Advance(quietPeriod)
+			select {
+			case actual = <-syncer.synced:
+				return true
+			case <-time.After(10 * time.Millisecond):
+				return false
+			}
+		})
+		return actual
+	}
+
+	control.Pause()
+	watcher.Events() <- watch.NewFileEvent("/src/main.go")
+	assert.Check(t, flush() == nil, "unexpected sync while paused")
+	watcher.Events() <- watch.NewFileEvent("/src/main.go")
+	assert.Check(t, flush() == nil, "unexpected sync while paused")
+
+	control.Resume()
+	select {
+	case actual := <-syncer.synced:
+		assert.DeepEqual(t, actual, []sync.PathMapping{{HostPath: "/src/main.go", ContainerPath: "/app/main.go"}})
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the changes buffered while paused to be synced")
+	}
+
+	watcher.Events() <- watch.NewFileEvent("/src/util.go")
+	assert.DeepEqual(t, flush(), []sync.PathMapping{{HostPath: "/src/util.go", ContainerPath: "/app/util.go"}})
+}
+
+func TestCoalesceEvents(t *testing.T) {
+	main := fileEvent{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/src/main.go", ContainerPath: "/app/main.go"}}
+	util := fileEvent{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/src/util.go", ContainerPath: "/app/util.go"}}
+	restart := fileEvent{Action: WatchActionRestart, PathMapping: sync.PathMapping{HostPath: "/src/main.go"}}
+	assert.DeepEqual(t, coalesceEvents([]fileEvent{main, util, restart, main}), []fileEvent{util, restart, main})
+}
+
+func TestSyncedDigests(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.go")
+	assert.NilError(t, os.WriteFile(file, []byte("package main"), 0o644))
+	batch := []fileEvent{
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: file, ContainerPath: "/app1/main.go"}},
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: file, ContainerPath: "/app2/main.go"}},
+		{Action: WatchActionRestart, PathMapping: sync.PathMapping{HostPath: file}},
+	}
+
+	synced := syncedDigests{}
+	filtered, digests := synced.filter(batch)
+	assert.DeepEqual(t, filtered, batch)
+	synced.update(digests)
+
+	// the restart isn't affected by the content of the file
+	filtered, _ = synced.filter(batch)
+	assert.DeepEqual(t, filtered, batch[2:])
+
+	// a deleted file is synced, then forgotten
+	assert.NilError(t, os.Remove(file))
+	filtered, digests = synced.filter(batch)
+	assert.DeepEqual(t, filtered, batch)
+	synced.update(digests)
+	assert.Equal(t, len(synced), 0)
+
+	// a change of mode only is synced even though the content is the same
+	assert.NilError(t, os.WriteFile(file, []byte("package main"), 0o644))
+	_, digests = synced.filter(batch)
+	synced.update(digests)
+	chmod := batch[0]
+	chmod.Kind = watch.FileChmod
+	filtered, _ = synced.filter([]fileEvent{batch[1], chmod})
+	assert.DeepEqual(t, filtered, []fileEvent{chmod})
+}
+
+func TestBatchDigest_Mode(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "run.sh")
+	assert.NilError(t, os.WriteFile(file, []byte("#!/bin/sh"), 0o644))
+	batch := []fileEvent{{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: file, ContainerPath: "/app/run.sh"}}}
+	digest := batchDigest(batch)
+	assert.NilError(t, os.Chmod(file, 0o444))
+	assert.Check(t, batchDigest(batch) != digest, "a batch changing the mode of the files isn't a repeated batch")
+}
+
+func TestWatchState(t *testing.T) {
+	dir := t.TempDir()
+	unchanged := filepath.Join(dir, "main.go")
+	modified := filepath.Join(dir, "util.go")
+	deleted := filepath.Join(dir, "deleted.go")
+	for _, file := range []string{unchanged, modified, deleted} {
+		assert.NilError(t, os.WriteFile(file, []byte("package main"), 0o644))
+	}
+	synced := syncedDigests{}
+	for _, file := range []string{unchanged, modified, deleted} {
+		synced[file] = fileDigest(file)
+	}
+
+	lastBatch := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	statePath := filepath.Join(dir, "state", "test.json")
+	assert.NilError(t, saveWatchState(statePath, newWatchState(synced, lastBatch)))
+	state, err := loadWatchState(statePath)
+	assert.NilError(t, err)
+	assert.Check(t, state.LastBatch.Equal(lastBatch))
+	assert.DeepEqual(t, state.digests(), synced)
+
+	// changed while not watched
+	later := time.Now().Add(time.Hour)
+	assert.NilError(t, os.Chtimes(modified, later, later))
+	assert.NilError(t, os.Remove(deleted))
+	state, err = loadWatchState(statePath)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, state.digests(), syncedDigests{unchanged: synced[unchanged]})
+
+	state, err = loadWatchState(filepath.Join(dir, "missing.json"))
+	assert.NilError(t, err)
+	assert.Equal(t, len(state.digests()), 0)
+}
+
+func TestWatch_PersistSyncState(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(os.Stderr).AnyTimes()
+	configDir := t.TempDir()
+	cli.EXPECT().ConfigFile().Return(&configfile.ConfigFile{Filename: filepath.Join(configDir, "config.json")}).AnyTimes()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.go")
+	assert.NilError(t, os.WriteFile(file, []byte("package main"), 0o644))
+	clock := clockwork.NewFakeClock()
+	service := composeService{
+		dockerCli: cli,
+		clock:     clock,
+		watches:   &watchRegistry{},
+	}
+	proj := &types.Project{Name: "myproject", Services: []types.ServiceConfig{{Name: "test"}}}
+
+	// watchOnce returns the paths synced after a change to the file while watching, if any
+	watchOnce := func() []sync.PathMapping {
+		watcher := testWatcher{
+			events: make(chan watch.FileEvent),
+			errors: make(chan error),
+		}
+		syncer := newFakeSyncer()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			err := service.watch(ctx, proj, "test", api.WatchOptions{}, watcher, syncer, &serviceWatch{}, DevelopmentConfig{
+				SkipUnchangedFiles: true,
+				PersistSyncState:   true,
+				Watch:              []Trigger{{Path: dir, Action: "sync", Target: "/app"}},
+			})
+			assert.NilError(t, err)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		watcher.Events() <- watch.NewFileEvent(file)
+		var actual []sync.PathMapping
+		poll(func() bool {
+			clock.Advance(quietPeriod)
+			select {
+			case actual = <-syncer.synced:
+				return true
+			case <-time.After(10 * time.Millisecond):
+				return false
+			}
+		})
+		// let the batch complete, as its state is saved once it's handled
+		poll(func() bool {
+			status, err := service.WatchStatus(ctx)
+			return err == nil && len(status) == 1 && status[0].Batches > 0
+		})
+		return actual
+	}
+
+	assert.DeepEqual(t, watchOnce(), []sync.PathMapping{{HostPath: file, ContainerPath: "/app/main.go"}})
+	_, err := os.Stat(filepath.Join(configDir, "compose", "watch", "myproject", "test.json"))
+	assert.NilError(t, err)
+	// the file was synced by the previous watch
+	assert.Check(t, watchOnce() == nil, "unexpected sync of an unchanged file")
+}
+
+func TestLoadDevelopmentConfig_PersistSyncState(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	service := types.ServiceConfig{
+		Name: "test",
+		Extensions: map[string]interface{}{
+			"x-develop": map[string]interface{}{"persist_sync_state": true},
+		},
+	}
+	_, err := loadDevelopmentConfig(service, project)
+	assert.ErrorContains(t, err, "service test: persist_sync_state requires skip_unchanged_files")
+
+	service.Extensions["x-develop"].(map[string]interface{})["skip_unchanged_files"] = true
+	config, err := loadDevelopmentConfig(service, project)
+	assert.NilError(t, err)
+	assert.Check(t, config.PersistSyncState)
+}
+
+func TestChangeLogSampler(t *testing.T) {
+	level := logrus.GetLevel()
+	var logs bytes.Buffer
+	logrus.SetOutput(&logs)
+	t.Cleanup(func() {
+		logrus.SetLevel(level)
+		logrus.SetOutput(os.Stderr)
+	})
+	triggers := []Trigger{{Path: "/src"}, {Path: "/src/lib"}}
+	lines := func() []string {
+		defer logs.Reset()
+		return strings.Split(strings.TrimSpace(logs.String()), "\n")
+	}
+
+	logrus.SetLevel(logrus.DebugLevel)
+	clock := clockwork.NewFakeClock()
+	sampler := newChangeLogSampler(clock, 3)
+	// a storm of changes within the same second
+	for i := 0; i < 1000; i++ {
+		sampler.log(fmt.Sprintf("/src/out/%d.o", i), triggers)
+	}
+	assert.Equal(t, len(lines()), 3)
+
+	clock.Advance(time.Second)
+	sampler.log("/src/main.go", triggers)
+	logged := lines()
+	assert.Equal(t, len(logged), 2)
+	assert.Check(t, strings.Contains(logged[0], "997 more change(s) not logged"), logged[0])
+	assert.Check(t, strings.Contains(logged[1], "change for /src/main.go"), logged[1])
+
+	logrus.SetLevel(logrus.TraceLevel)
+	for i := 0; i < 10; i++ {
+		sampler.log(fmt.Sprintf("/src/out/%d.o", i), triggers)
+	}
+	assert.Equal(t, len(lines()), 20, "all the changes are logged for each trigger at trace level")
+}
+
+func TestWriteWatchSyncMessage(t *testing.T) {
+	pathMappings := []sync.PathMapping{
+		{HostPath: "/src/main.go", ContainerPath: "/app/main.go"},
+		{HostPath: "/src/go.mod", ContainerPath: "/app/go.mod"},
+	}
+
+	var buf bytes.Buffer
+	writeWatchSyncMessage(&buf, "test", pathMappings)
+	assert.Equal(t, buf.String(), "Syncing test after changes were detected:\n  - /src/main.go\n  - /src/go.mod\n")
+
+	level := logrus.GetLevel()
+	logrus.SetLevel(logrus.DebugLevel)
+	t.Cleanup(func() {
+		logrus.SetLevel(level)
+	})
+	buf.Reset()
+	writeWatchSyncMessage(&buf, "test", pathMappings)
+	assert.Equal(t, buf.String(),
+		"Syncing test after changes were detected:\n  - /src/main.go -> /app/main.go\n  - /src/go.mod -> /app/go.mod\n")
+}
+
+type recordingSyncer struct {
+	synced [][]sync.PathMapping
+}
+
+func (r *recordingSyncer) Sync(_ context.Context, _ types.ServiceConfig, paths []sync.PathMapping) error {
+	r.synced = append(r.synced, paths)
+	return nil
+}
+
+func TestHandleWatchBatch_SyncAndRebuild(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	var stderr bytes.Buffer
+	cli.EXPECT().Err().Return(&stderr).AnyTimes()
+	apiClient := mocks.NewMockAPIClient(mockCtrl)
+	// fail the rebuild as soon as the service containers are listed
+	apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return(nil, errors.New("engine unavailable")).AnyTimes()
+	cli.EXPECT().Client().Return(apiClient).AnyTimes()
+	service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+
+	proj := &types.Project{
+		Name:     "myproject",
+		Services: []types.ServiceConfig{{Name: "test"}},
+	}
+	syncer := &recordingSyncer{}
+	var events watchEventRecorder
+	err := service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{EventHandler: events.handle}, []fileEvent{
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/src/main.go", ContainerPath: "/app/main.go"}},
+		{Action: WatchActionRebuild, PathMapping: sync.PathMapping{HostPath: "/src/go.mod"}},
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/src/util.go", ContainerPath: "/app/util.go"}},
+		{Action: WatchActionRestart, PathMapping: sync.PathMapping{HostPath: "/src/config.yaml"}},
+	}, syncer, nil)
+	assert.ErrorContains(t, err, "engine unavailable")
+	assert.DeepEqual(t, syncer.synced, [][]sync.PathMapping{{
+		{HostPath: "/src/main.go", ContainerPath: "/app/main.go"},
+		{HostPath: "/src/util.go", ContainerPath: "/app/util.go"},
+	}})
+	assert.DeepEqual(t, events.types(), []api.WatchEventType{
+		api.WatchEventSyncStarted,
+		api.WatchEventSyncCompleted,
+		api.WatchEventRebuildStarted,
+		api.WatchEventRebuildCompleted,
+	})
+	assert.Check(t, strings.Contains(stderr.String(), "Rebuild of test failed after 0s, triggered by 1 change(s)"), stderr.String())
+}
+
+func TestMaybeFileEvents_TriggerName(t *testing.T) {
+	level := logrus.GetLevel()
+	var logs lockedBuffer
+	logrus.SetOutput(&logs)
+	logrus.SetLevel(logrus.DebugLevel)
+	t.Cleanup(func() {
+		logrus.SetLevel(level)
+		logrus.SetOutput(os.Stderr)
+	})
+
+	named := Trigger{Name: "frontend sources", Path: "/src", Action: "sync", Target: "/app"}
+	events := maybeFileEvents(named, "/src/web/index.js", watch.EmptyMatcher{}, nil, nil)
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].Trigger, "frontend sources")
+	assert.Check(t, strings.Contains(logs.String(), "/src/web/index.js matches watch rule frontend sources, synced to /app/web/index.js"), logs.String())
+
+	unnamed := Trigger{Path: "/src", Action: "rebuild"}
+	events = maybeFileEvents(unnamed, "/src/go.mod", watch.EmptyMatcher{}, nil, nil)
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].Trigger, "rebuild /src")
+	assert.Check(t, strings.Contains(logs.String(), "/src/go.mod matches watch rule rebuild /src"), logs.String())
+
+	// the same change from several triggers is still handled once
+	other := Trigger{Name: "all sources", Path: "/src", Action: "sync", Target: "/app"}
+	unique := uniqueFileEvents(append(
+		maybeFileEvents(named, "/src/web/index.js", watch.EmptyMatcher{}, nil, nil),
+		maybeFileEvents(other, "/src/web/index.js", watch.EmptyMatcher{}, nil, nil)...))
+	assert.Equal(t, len(unique), 1)
+	assert.Equal(t, unique[0].Trigger, "frontend sources")
+}
+
+func TestHandleWatchBatch_TriggerName(t *testing.T) {
+	level := logrus.GetLevel()
+	var logs lockedBuffer
+	logrus.SetOutput(&logs)
+	logrus.SetLevel(logrus.DebugLevel)
+	t.Cleanup(func() {
+		logrus.SetLevel(level)
+		logrus.SetOutput(os.Stderr)
+	})
+
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+	proj := &types.Project{Name: "myproject", Services: []types.ServiceConfig{{Name: "test"}}}
+	service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+
+	trigger := Trigger{Name: "config", Path: "/src/config.yaml", Action: "sync", Target: "/etc/app/config.yaml"}
+	batch := maybeFileEvents(trigger, "/src/config.yaml", watch.EmptyMatcher{}, nil, nil)
+	err := service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{}, batch, &recordingSyncer{}, nil)
+	assert.NilError(t, err)
+	assert.Check(t, strings.Contains(logs.String(), "syncing /src/config.yaml to /etc/app/config.yaml in service test for watch rule config"), logs.String())
+}
+
+func TestHandleWatchBatch_Quiet(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	var stderr bytes.Buffer
+	cli.EXPECT().Err().Return(&stderr).AnyTimes()
+	service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+
+	proj := &types.Project{
+		Name:     "myproject",
+		Services: []types.ServiceConfig{{Name: "test"}},
+	}
+	quiet := Trigger{Path: "/src/tmp", Action: string(WatchActionSync), Target: "/app/tmp", Quiet: true}
+	events := maybeFileEvents(quiet, "/src/tmp/cache", watch.EmptyMatcher{}, nil, nil)
+	assert.Equal(t, len(events), 1)
+	assert.Check(t, events[0].Quiet)
+
+	syncer := &recordingSyncer{}
+	err := service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{}, events, syncer, nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, syncer.synced, [][]sync.PathMapping{{
+		{HostPath: "/src/tmp/cache", ContainerPath: "/app/tmp/cache"},
+	}})
+	assert.Equal(t, stderr.String(), "")
+
+	// only the paths of the other triggers are printed
+	err = service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{}, append(events,
+		fileEvent{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/src/main.go", ContainerPath: "/app/main.go"}},
+	), syncer, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, len(syncer.synced), 2)
+	assert.Equal(t, len(syncer.synced[1]), 2)
+	assert.Equal(t, stderr.String(), "Syncing test after changes were detected:\n  - /src/main.go\n")
+}
+
+func TestHandleWatchBatch_JSON(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	var stderr bytes.Buffer
+	cli.EXPECT().Err().Return(&stderr).AnyTimes()
+	apiClient := mocks.NewMockAPIClient(mockCtrl)
+	apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return(nil, errors.New("engine unavailable")).AnyTimes()
+	cli.EXPECT().Client().Return(apiClient).AnyTimes()
+	service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+
+	proj := &types.Project{
+		Name:     "myproject",
+		Services: []types.ServiceConfig{{Name: "test"}},
+	}
+	err := service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{JSON: true}, []fileEvent{
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/src/main.go", ContainerPath: "/app/main.go"}},
+		{Action: WatchActionRebuild, PathMapping: sync.PathMapping{HostPath: "/src/go.mod"}},
+	}, &recordingSyncer{}, nil)
+	assert.ErrorContains(t, err, "engine unavailable")
+	assert.Check(t, !strings.Contains(stderr.String(), "Syncing"), stderr.String())
+
+	var messages []map[string]any
+	decoder := json.NewDecoder(&stderr)
+	for decoder.More() {
+		var message map[string]any
+		assert.NilError(t, decoder.Decode(&message))
+		_, err := time.Parse(time.RFC3339Nano, message["timestamp"].(string))
+		assert.NilError(t, err)
+		delete(message, "timestamp")
+		messages = append(messages, message)
+	}
+	assert.DeepEqual(t, messages, []map[string]any{
+		{"service": "test", "action": "sync", "status": "started", "paths": []any{"/src/main.go"}},
+		{"service": "test", "action": "sync", "status": "completed", "paths": []any{"/src/main.go"}},
+		{"service": "test", "action": "rebuild", "status": "started", "paths": []any{"/src/go.mod"}},
+		{"service": "test", "action": "rebuild", "status": "failed", "paths": []any{"/src/go.mod"}, "error": "engine unavailable"},
+	})
+}
+
+func TestHandleWatchBatch_JSONCopyBackend(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	var stderr bytes.Buffer
+	cli.EXPECT().Err().Return(&stderr).AnyTimes()
+	apiClient := mocks.NewMockAPIClient(mockCtrl)
+	// the deletion fails in the containers, the cp backend still reports it
+	apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return(nil, errors.New("engine unavailable")).AnyTimes()
+	cli.EXPECT().Client().Return(apiClient).AnyTimes()
+	service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+
+	proj := &types.Project{
+		Name:     "myproject",
+		Services: []types.ServiceConfig{{Name: "test"}},
+	}
+	options := api.WatchOptions{JSON: true}
+	syncer := service.getSyncImplementation(proj, options, DevelopmentConfig{SyncBackend: SyncBackendCopy}, nil)
+	err := service.handleWatchBatch(context.Background(), proj, "test", options, []fileEvent{{
+		Action:      WatchActionSync,
+		PathMapping: sync.PathMapping{HostPath: filepath.Join(t.TempDir(), "deleted.go"), ContainerPath: "/app/deleted.go"},
+	}}, syncer, nil)
+	assert.NilError(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(stderr.String(), "\n"), "\n")
+	assert.Equal(t, len(lines), 2, stderr.String())
+	for _, line := range lines {
+		var message map[string]any
+		assert.NilError(t, json.Unmarshal([]byte(line), &message), line)
+	}
+}
+
+func TestHandleWatchBatch_NoContainers(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0o644))
+
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	var stderr bytes.Buffer
+	cli.EXPECT().Err().Return(&stderr).AnyTimes()
+	apiClient := mocks.NewMockAPIClient(mockCtrl)
+	// the service crashed, no exec is expected by the mock
+	apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+	cli.EXPECT().Client().Return(apiClient).AnyTimes()
+	service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+
+	proj := &types.Project{
+		Name:     "myproject",
+		Services: []types.ServiceConfig{{Name: "test"}},
+	}
+	syncer := service.getSyncImplementation(proj, api.WatchOptions{}, DevelopmentConfig{SyncBackend: SyncBackendTar}, nil)
+	var events watchEventRecorder
+	err := service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{EventHandler: events.handle}, []fileEvent{{
+		Action:      WatchActionSync,
+		OnError:     WatchOnErrorStop,
+		PathMapping: sync.PathMapping{HostPath: filepath.Join(dir, "main.go"), ContainerPath: "/app/main.go"},
+	}}, syncer, nil)
+	assert.NilError(t, err)
+	assert.Check(t, strings.Contains(stderr.String(), "no running containers for service test; changes not synced"), stderr.String())
+	assert.Check(t, errors.Is(events.events[len(events.events)-1].Err, sync.ErrNoContainers))
+}
+
+func TestHandleWatchBatch_ExitedContainer(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0o644))
+
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	var stderr bytes.Buffer
+	cli.EXPECT().Err().Return(&stderr).AnyTimes()
+	apiClient := mocks.NewMockAPIClient(mockCtrl)
+	exited := testContainer("test", "123", false)
+	exited.State = "exited"
+	// the exited container gets no exec, and the sync isn't retried
+	apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return([]moby.Container{exited}, nil).Times(1)
+	cli.EXPECT().Client().Return(apiClient).AnyTimes()
+	service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+
+	proj := &types.Project{
+		Name:     "myproject",
+		Services: []types.ServiceConfig{{Name: "test"}},
+	}
+	syncer := service.getSyncImplementation(proj, api.WatchOptions{}, DevelopmentConfig{SyncBackend: SyncBackendTar}, nil)
+	err := service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{}, []fileEvent{{
+		Action:      WatchActionSync,
+		PathMapping: sync.PathMapping{HostPath: filepath.Join(dir, "main.go"), ContainerPath: "/app/main.go"},
+	}}, syncer, nil)
+	assert.NilError(t, err)
+	assert.Check(t, strings.Contains(stderr.String(), "no running containers for service test; changes not synced"), stderr.String())
+}
+
+func TestHookSyncer(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skipf("sh not available: %v", err)
+	}
+	dir := t.TempDir()
+	service := types.ServiceConfig{Name: "test"}
+	paths := []sync.PathMapping{
+		{HostPath: "/src/main.go", ContainerPath: "/app1/main.go"},
+		{HostPath: "/src/main.go", ContainerPath: "/app2/main.go"},
+		{HostPath: "/src/util.go", ContainerPath: "/app1/util.go"},
+	}
+	newSyncer := func(inner sync.Syncer, config DevelopmentConfig) sync.Syncer {
+		return withSyncHook(inner, &types.Project{WorkingDir: dir}, config)
+	}
+
+	t.Run("invoked with the synced paths", func(t *testing.T) {
+		inner := &recordingSyncer{}
+		syncer := newSyncer(inner, DevelopmentConfig{
+			OnSync: `sh -c 'echo "$COMPOSE_WATCH_SERVICE" "$@" > hook.out' hook`,
+		})
+		assert.NilError(t, syncer.Sync(context.Background(), service, paths))
+		assert.DeepEqual(t, inner.synced, [][]sync.PathMapping{paths})
+		out, err := os.ReadFile(filepath.Join(dir, "hook.out"))
+		assert.NilError(t, err)
+		assert.Equal(t, string(out), "test /src/main.go /src/util.go\n")
+	})
+
+	t.Run("not invoked when the sync fails", func(t *testing.T) {
+		marker := filepath.Join(dir, "marker")
+		syncer := newSyncer(failingSyncer{errors.New("sync failed")}, DevelopmentConfig{
+			OnSync: "sh -c 'touch marker'",
+		})
+		assert.Error(t, syncer.Sync(context.Background(), service, paths), "sync failed")
+		_, err := os.Stat(marker)
+		assert.Check(t, os.IsNotExist(err))
+	})
+
+	t.Run("failures", func(t *testing.T) {
+		config := DevelopmentConfig{OnSync: "sh -c 'echo reloader unavailable; exit 3'"}
+		assert.NilError(t, newSyncer(&recordingSyncer{}, config).Sync(context.Background(), service, paths))
+
+		config.OnSyncRequired = true
+		err := newSyncer(&recordingSyncer{}, config).Sync(context.Background(), service, paths)
+		assert.ErrorContains(t, err, "exit status 3: reloader unavailable")
+	})
+
+	assert.Check(t, newSyncer(failingSyncer{}, DevelopmentConfig{}) == failingSyncer{}, "no hook without a command")
+}
+
+func TestCompletedWatchMessage(t *testing.T) {
+	message := completedWatchMessage("test", WatchActionSync, []string{"/app/main.go"}, 1234567*time.Microsecond, nil)
+	assert.DeepEqual(t, message, watchMessage{
+		Service:  "test",
+		Action:   WatchActionSync,
+		Status:   watchMessageCompleted,
+		Paths:    []string{"/app/main.go"},
+		Duration: "1.235s",
+	})
+
+	message = completedWatchMessage("test", WatchActionRebuild, nil, 0, errors.New("build failed"))
+	assert.DeepEqual(t, message, watchMessage{
+		Service: "test",
+		Action:  WatchActionRebuild,
+		Status:  watchMessageFailed,
+		Error:   "build failed",
+	})
+}
+
+func TestWriteWatchRebuildSummary(t *testing.T) {
+	var buf bytes.Buffer
+	writeWatchRebuildSummary(&buf, "test", 2, 1234567*time.Microsecond, nil)
+	assert.Equal(t, buf.String(), "Rebuilt test in 1.235s, triggered by 2 change(s)\n")
+
+	buf.Reset()
+	writeWatchRebuildSummary(&buf, "test", 1, 850*time.Millisecond, errors.New("build failed"))
+	assert.Equal(t, buf.String(), "Rebuild of test failed after 850ms, triggered by 1 change(s): build failed\n")
+}
+
+func TestBatchActionHostPaths(t *testing.T) {
+	batch := []fileEvent{
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/src/main.go"}},
+		{Action: WatchActionRebuild, PathMapping: sync.PathMapping{HostPath: "/src/go.mod"}},
+		{Action: WatchActionRebuild, PathMapping: sync.PathMapping{HostPath: "/src/go.sum"}},
+	}
+	assert.DeepEqual(t, batchActionHostPaths(batch, WatchActionRebuild), []string{"/src/go.mod", "/src/go.sum"})
+	assert.Check(t, batchActionHostPaths(batch, WatchActionRestart) == nil)
+}
+
+func TestHandleWatchBatch_SyncThenRestartOnce(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(os.Stderr).AnyTimes()
+	apiClient := mocks.NewMockAPIClient(mockCtrl)
+	apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return([]moby.Container{
+		runningContainer("test", "123"),
+	}, nil).AnyTimes()
+	syncer := &recordingSyncer{}
+	apiClient.EXPECT().ContainerRestart(gomock.Any(), "123", gomock.Any()).Do(func(_, _, _ interface{}) {
+		assert.Equal(t, len(syncer.synced), 1, "sync must happen before restart")
+	}).Return(nil).Times(1)
+	cli.EXPECT().Client().Return(apiClient).AnyTimes()
+	service := composeService{dockerCli: cli}
+
+	proj := &types.Project{
+		Name:     "myproject",
+		Services: []types.ServiceConfig{{Name: "test"}},
+	}
+	err := service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{}, []fileEvent{
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/sync/a", ContainerPath: "/work/a"}},
+		{Action: WatchActionRestart, PathMapping: sync.PathMapping{HostPath: "/config/x"}},
+		{Action: WatchActionRestart, PathMapping: sync.PathMapping{HostPath: "/config/y"}},
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/sync/b", ContainerPath: "/work/b"}},
+	}, syncer, nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, syncer.synced, [][]sync.PathMapping{{
+		{HostPath: "/sync/a", ContainerPath: "/work/a"},
+		{HostPath: "/sync/b", ContainerPath: "/work/b"},
+	}})
+}
+
+func TestHandleWatchBatch_SyncRestartOnce(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(os.Stderr).AnyTimes()
+	apiClient := mocks.NewMockAPIClient(mockCtrl)
+	apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return([]moby.Container{
+		runningContainer("test", "123"),
+	}, nil).AnyTimes()
+	syncer := &recordingSyncer{}
+	apiClient.EXPECT().ContainerRestart(gomock.Any(), "123", gomock.Any()).Do(func(_, _, _ interface{}) {
+		assert.Equal(t, len(syncer.synced), 1, "all the files must be synced before restart")
+	}).Return(nil).Times(1)
+	cli.EXPECT().Client().Return(apiClient).AnyTimes()
+	service := composeService{dockerCli: cli}
+
+	proj := &types.Project{
+		Name:     "myproject",
+		Services: []types.ServiceConfig{{Name: "test"}},
+	}
+	// changes matched by two sync+restart triggers of the service
+	batch := []fileEvent{
+		{Action: WatchActionSyncRestart, PathMapping: sync.PathMapping{HostPath: "/src/a", ContainerPath: "/app/a"}},
+		{Action: WatchActionSyncRestart, PathMapping: sync.PathMapping{HostPath: "/conf/b", ContainerPath: "/etc/app/b"}},
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/static/c", ContainerPath: "/www/c"}},
+	}
+	var events watchEventRecorder
+	err := service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{EventHandler: events.handle}, batch, syncer, nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, syncer.synced, [][]sync.PathMapping{{
+		{HostPath: "/src/a", ContainerPath: "/app/a"},
+		{HostPath: "/conf/b", ContainerPath: "/etc/app/b"},
+		{HostPath: "/static/c", ContainerPath: "/www/c"},
+	}})
+	assert.DeepEqual(t, events.types(), []api.WatchEventType{
+		api.WatchEventSyncStarted,
+		api.WatchEventSyncCompleted,
+		api.WatchEventRestartStarted,
+		api.WatchEventRestartCompleted,
+	})
+
+	session := api.WatchSession{}
+	countWatchBatch(&session, batch)
+	assert.Equal(t, session.Syncs, 1)
+	assert.Equal(t, session.Restarts, 1)
+}
+
+// recordingVolumeHelper records the commands run with the volumes of a stopped service.
+type recordingVolumeHelper struct {
+	cmds [][]string
+}
+
+func (r *recordingVolumeHelper) RunWithVolumes(_ context.Context, _ types.ServiceConfig, _ []types.ServiceVolumeConfig, cmd []string, in io.Reader) error {
+	r.cmds = append(r.cmds, cmd)
+	if in != nil {
+		_, err := io.Copy(io.Discard, in)
+		return err
+	}
+	return nil
+}
+
+func TestTarSync_ExitedServiceVolume(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	apiClient := mocks.NewMockAPIClient(mockCtrl)
+	cli.EXPECT().Client().Return(apiClient).AnyTimes()
+	exited := testContainer("test", "123", false)
+	exited.State = "exited"
+	// no exec is expected in the exited container
+	apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return([]moby.Container{exited}, nil)
+	service := &composeService{dockerCli: cli}
+	proj := &types.Project{Name: "myproject", Services: []types.ServiceConfig{{
+		Name:    "test",
+		Volumes: []types.ServiceVolumeConfig{{Type: types.VolumeTypeVolume, Source: "data", Target: "/data"}},
+	}}}
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "seed.sql"), nil, 0o644))
+
+	helper := &recordingVolumeHelper{}
+	syncer := sync.NewTar(proj.Name, runningContainersClient{newTarDockerClient(service, proj, api.WatchOptions{})}).WithVolumeHelper(helper)
+	err := syncer.Sync(context.Background(), proj.Services[0], []sync.PathMapping{
+		{HostPath: filepath.Join(dir, "seed.sql"), ContainerPath: "/data/seed.sql"},
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, len(helper.cmds), 1, "the files are copied into the volume by the helper")
+}
+
+// runningContainer returns a running container of the service in the test project.
+func runningContainer(service string, id string) moby.Container {
+	c := testContainer(service, id, false)
+	c.State = ContainerRunning
+	return c
+}
+
+func TestTarDockerClient_ContainersForService(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	apiClient := mocks.NewMockAPIClient(mockCtrl)
+	var replicas []moby.Container
+	for i, id := range []string{"aaa111", "bbb222", "bbb333"} {
+		c := runningContainer("test", id)
+		c.Labels[api.ContainerNumberLabel] = strconv.Itoa(i + 1)
+		replicas = append(replicas, c)
+	}
+	// returned to the exec action as-is, only the sync skips the stopped containers
+	exited := testContainer("test", "ccc444", false)
+	exited.State = "exited"
+	replicas = append(replicas, exited)
+	apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return(replicas, nil).AnyTimes()
+	cli.EXPECT().Client().Return(apiClient).AnyTimes()
+	service := &composeService{dockerCli: cli}
+	proj := &types.Project{Name: "myproject", Services: []types.ServiceConfig{{Name: "test"}}}
+
+	ids := func(options api.WatchOptions) ([]string, error) {
+		containers, err := newTarDockerClient(service, proj, options).ContainersForService(context.Background(), proj.Name, "test")
+		var ids []string
+		for _, c := range containers {
+			ids = append(ids, c.ID)
+		}
+		return ids, err
+	}
+
+	all, err := ids(api.WatchOptions{})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, all, []string{"aaa111", "bbb222", "bbb333", "ccc444"})
+
+	running, err := runningContainersClient{newTarDockerClient(service, proj, api.WatchOptions{})}.ContainersForService(context.Background(), proj.Name, "test")
+	assert.NilError(t, err)
+	assert.Equal(t, len(running), 3)
+	assert.Check(t, !slices.ContainsFunc(running, func(c moby.Container) bool { return c.ID == "ccc444" }))
+
+	second, err := ids(api.WatchOptions{Index: 2})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, second, []string{"bbb222"})
+
+	prefixed, err := ids(api.WatchOptions{ContainerID: "bbb"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, prefixed, []string{"bbb222", "bbb333"})
+
+	both, err := ids(api.WatchOptions{Index: 3, ContainerID: "bbb"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, both, []string{"bbb333"})
+
+	_, err = ids(api.WatchOptions{Index: 1, ContainerID: "bbb"})
+	assert.ErrorContains(t, err, `service "test" has no container matching index 1 and ID "bbb"`)
+}
+
+func TestLoadDevelopmentConfig_Actions(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	newService := func(action string) types.ServiceConfig {
+		return types.ServiceConfig{
+			Name: "test",
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{
+					"watch": []interface{}{
+						map[string]interface{}{"path": "src", "action": action},
+					},
+				},
+			},
+		}
+	}
+
+	// restart doesn't require a build section
+	config, err := loadDevelopmentConfig(newService("restart"), project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.Watch[0].Action, string(WatchActionRestart))
+
+	// neither does sync+restart
+	config, err = loadDevelopmentConfig(newService("sync+restart"), project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.Watch[0].Action, string(WatchActionSyncRestart))
+	assert.Check(t, !config.requiresBuild())
+
+	_, err = loadDevelopmentConfig(newService("rebuild"), project)
+	assert.ErrorContains(t, err, "doesn't have a build section")
+
+	_, err = loadDevelopmentConfig(newService("reload"), project)
+	assert.ErrorContains(t, err, `invalid action "reload"`)
+}
+
+func TestLoadDevelopmentConfig_Debounce(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	newService := func(debounce interface{}) types.ServiceConfig {
+		develop := map[string]interface{}{
+			"watch": []interface{}{
+				map[string]interface{}{"path": "src", "action": "sync", "target": "/app"},
+			},
+		}
+		if debounce != nil {
+			develop["debounce"] = debounce
+		}
+		return types.ServiceConfig{
+			Name:       "test",
+			Extensions: map[string]interface{}{"x-develop": develop},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService(nil), project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.quietPeriod(), 500*time.Millisecond)
+
+	config, err = loadDevelopmentConfig(newService("2s"), project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.quietPeriod(), 2*time.Second)
+
+	_, err = loadDevelopmentConfig(newService("-1s"), project)
+	assert.ErrorContains(t, err, "debounce duration can't be negative")
+
+	_, err = loadDevelopmentConfig(newService("soon"), project)
+	assert.ErrorContains(t, err, "invalid duration")
+
+	service := newService(nil)
+	service.Extensions["x-develop"].(map[string]interface{})["debounce_max_wait"] = "5s"
+	config, err = loadDevelopmentConfig(service, project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.DebounceMaxWait, 5*time.Second)
+
+	service.Extensions["x-develop"].(map[string]interface{})["debounce_max_wait"] = "-5s"
+	_, err = loadDevelopmentConfig(service, project)
+	assert.ErrorContains(t, err, "debounce_max_wait can't be negative")
+}
+
+func TestWatch_CustomDebounce(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(os.Stderr).AnyTimes()
+
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	syncer := newFakeSyncer()
+	clock := clockwork.NewFakeClock()
+	service := composeService{
+		dockerCli: cli,
+		clock:     clock,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	proj := &types.Project{Services: []types.ServiceConfig{{Name: "test"}}}
+	go func() {
+		err := service.watch(ctx, proj, "test", api.WatchOptions{}, watcher, syncer, &serviceWatch{}, DevelopmentConfig{
+			Debounce: 2 * time.Second,
+			Watch:    []Trigger{{Path: "/sync", Action: "sync", Target: "/work"}},
+		})
+		assert.NilError(t, err)
+	}()
+
+	watcher.Events() <- watch.NewFileEvent("/sync/changed")
+	clock.BlockUntil(1)
+	clock.Advance(quietPeriod)
+	select {
+	case actual := <-syncer.synced:
+		t.Fatalf("unexpected sync before the debounce period: %v", actual)
+	case <-time.After(100 * time.Millisecond):
+		// expected
+	}
+
+	clock.Advance(2*time.Second - quietPeriod)
+	select {
+	case actual := <-syncer.synced:
+		assert.DeepEqual(t, []sync.PathMapping{{HostPath: "/sync/changed", ContainerPath: "/work/changed"}}, actual)
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("timed out waiting for events")
+	}
+}
+
+func TestMaybeFileEvents_MultipleTargets(t *testing.T) {
+	trigger := Trigger{Path: "/src/lib", Action: "sync", Targets: []string{"/app1/lib", "/app2/lib"}}
+	events := maybeFileEvents(trigger, "/src/lib/util.py", watch.EmptyMatcher{}, nil, nil)
+	mappings := make([]sync.PathMapping, len(events))
+	for i := range events {
+		mappings[i] = events[i].PathMapping
+	}
+	assert.DeepEqual(t, mappings, []sync.PathMapping{
+		{HostPath: "/src/lib/util.py", ContainerPath: "/app1/lib/util.py"},
+		{HostPath: "/src/lib/util.py", ContainerPath: "/app2/lib/util.py"},
+	})
+}
+
+func TestMaybeFileEvents_TargetPaths(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, "src", "lib"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main"), 0o644))
+
+	tests := []struct {
+		name     string
+		path     string
+		target   string
+		changed  string
+		expected string
+	}{
+		{
+			name:     "file to file",
+			path:     "src/main.go",
+			target:   "/app/server.go",
+			changed:  "src/main.go",
+			expected: "/app/server.go",
+		},
+		{
+			name:     "file to directory",
+			path:     "src/main.go",
+			target:   "/app/",
+			changed:  "src/main.go",
+			expected: "/app/main.go",
+		},
+		{
+			name:     "deleted file to directory",
+			path:     "src/deleted.go",
+			target:   "/app/",
+			changed:  "src/deleted.go",
+			expected: "/app/deleted.go",
+		},
+		{
+			name:     "directory to directory",
+			path:     "src",
+			target:   "/app",
+			changed:  "src/lib/util.go",
+			expected: "/app/lib/util.go",
+		},
+		{
+			name:     "directory to directory with a trailing slash",
+			path:     "src",
+			target:   "/app/",
+			changed:  "src/lib/util.go",
+			expected: "/app/lib/util.go",
+		},
+		{
+			name:     "directory itself",
+			path:     "src/lib",
+			target:   "/app/lib/",
+			changed:  "src/lib",
+			expected: "/app/lib",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trigger := Trigger{Path: filepath.Join(dir, tt.path), Action: "sync", Target: tt.target}
+			events := maybeFileEvents(trigger, filepath.Join(dir, tt.changed), watch.EmptyMatcher{}, nil, nil)
+			assert.Equal(t, len(events), 1)
+			assert.Equal(t, events[0].ContainerPath, tt.expected)
+		})
+	}
+}
+
+func TestMaybeFileEvents_SingleFileTrigger(t *testing.T) {
+	dir := t.TempDir()
+	hostPath := filepath.Join(dir, "config.yaml")
+	assert.NilError(t, os.WriteFile(hostPath, []byte("debug: true"), 0o644))
+
+	for _, target := range []string{"/etc/app/config.yaml", "/etc/app/settings", "/config.yaml"} {
+		t.Run(target, func(t *testing.T) {
+			for _, triggerPath := range []string{hostPath, hostPath + string(filepath.Separator)} {
+				trigger := Trigger{Path: triggerPath, Action: "sync+restart", Target: target}
+				events := maybeFileEvents(trigger, hostPath, watch.EmptyMatcher{}, nil, nil)
+				assert.Equal(t, len(events), 1)
+				assert.Equal(t, events[0].HostPath, hostPath)
+				assert.Equal(t, events[0].ContainerPath, target, "the single file must be synced to the target itself")
+			}
+		})
+	}
+}
+
+func TestMaybeFileEvents_Extensions(t *testing.T) {
+	trigger := Trigger{Path: "/src", Action: "sync", Target: "/app", Extensions: []string{".go", ".html"}}
+	assert.Equal(t, len(maybeFileEvents(trigger, "/src/main.go", watch.EmptyMatcher{}, nil, nil)), 1)
+	assert.Equal(t, len(maybeFileEvents(trigger, "/src/www/index.html", watch.EmptyMatcher{}, nil, nil)), 1)
+	assert.Check(t, maybeFileEvents(trigger, "/src/www/style.css", watch.EmptyMatcher{}, nil, nil) == nil)
+	assert.Check(t, maybeFileEvents(trigger, "/src/Makefile", watch.EmptyMatcher{}, nil, nil) == nil)
+
+	trigger.Extensions = nil
+	assert.Equal(t, len(maybeFileEvents(trigger, "/src/www/style.css", watch.EmptyMatcher{}, nil, nil)), 1)
+}
+
+func TestValidateWatchConfig(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	develop := func(triggers ...map[string]interface{}) map[string]interface{} {
+		watch := make([]interface{}, len(triggers))
+		for i := range triggers {
+			watch[i] = triggers[i]
+		}
+		return map[string]interface{}{"x-develop": map[string]interface{}{"watch": watch}}
+	}
+	newProject := func() *types.Project {
+		return &types.Project{
+			WorkingDir: dir,
+			Services: []types.ServiceConfig{
+				{
+					Name:       "invalid-action",
+					Build:      &types.BuildConfig{Context: dir},
+					Extensions: develop(map[string]interface{}{"path": "src", "action": "copy"}),
+				},
+				{
+					Name:       "no-build",
+					Extensions: develop(map[string]interface{}{"path": "go.mod", "action": "rebuild"}),
+				},
+				{
+					Name:       "invalid-extension",
+					Build:      &types.BuildConfig{Context: dir},
+					Extensions: develop(map[string]interface{}{"path": "src", "action": "sync", "target": "/app", "extensions": []interface{}{"go"}}),
+				},
+				{
+					Name:       "valid",
+					Build:      &types.BuildConfig{Context: dir},
+					Extensions: develop(map[string]interface{}{"path": "src", "action": "sync", "target": "/app"}),
+				},
+				{Name: "not-watched"},
+			},
+		}
+	}
+
+	err = ValidateWatchConfig(newProject(), nil)
+	assert.ErrorContains(t, err, "3 errors occurred")
+	assert.ErrorContains(t, err, `service invalid-action: watch rule for `+filepath.Join(dir, "src")+`: invalid action "copy"`)
+	assert.ErrorContains(t, err, "service no-build: service no-build doesn't have a build section")
+	assert.ErrorContains(t, err, `service invalid-extension: watch rule for `+filepath.Join(dir, "src")+`: invalid extension "go"`)
+
+	assert.NilError(t, ValidateWatchConfig(newProject(), []string{"valid", "not-watched"}))
+
+	err = ValidateWatchConfig(newProject(), []string{"not-watched"})
+	assert.ErrorContains(t, err, "none of the selected services is configured for watch")
+}
+
+func TestLoadWatchedServiceConfig_UnknownKeys(t *testing.T) {
+	var out lockedBuffer
+	logrus.SetOutput(&out)
+	t.Cleanup(func() { logrus.SetOutput(os.Stderr) })
+
+	project := &types.Project{WorkingDir: t.TempDir()}
+	service := types.ServiceConfig{
+		Name:  "test",
+		Build: &types.BuildConfig{Context: "."},
+		Extensions: map[string]interface{}{
+			"x-develop": map[string]interface{}{
+				"watchh":          []interface{}{},
+				"ignore_patterns": []interface{}{"*.log"},
+				"watch": []interface{}{
+					map[string]interface{}{"path": "src", "action": "sync", "target": "/app", "ignroe": []interface{}{"*.tmp"}},
+				},
+			},
+		},
+	}
+
+	config, err := loadWatchedServiceConfig(service, project, false)
+	assert.NilError(t, err)
+	assert.Equal(t, len(config.Watch), 1)
+	for _, key := range []string{"ignore_patterns", "watch[0].ignroe", "watchh"} {
+		assert.Check(t, strings.Contains(out.String(), fmt.Sprintf(`service test: unknown x-develop key \"%s\"`, key)), out.String())
+	}
+
+	_, err = loadWatchedServiceConfig(service, project, true)
+	assert.Error(t, err, "unknown x-develop key(s) for service test: ignore_patterns, watch[0].ignroe, watchh")
+
+	project.Services = []types.ServiceConfig{service}
+	err = ValidateWatchConfig(project, nil)
+	assert.ErrorContains(t, err, "service test: unknown x-develop key(s) for service test")
+}
+
+func TestMaybeFileEvents_MaxFileSize(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "small.js"), make([]byte, 1024), 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "bundle.js"), make([]byte, 4096), 0o644))
+
+	trigger := Trigger{Path: dir, Action: "sync", Target: "/app", MaxFileSize: 2048}
+	assert.Equal(t, len(maybeFileEvents(trigger, filepath.Join(dir, "small.js"), watch.EmptyMatcher{}, nil, nil)), 1)
+	assert.Check(t, maybeFileEvents(trigger, filepath.Join(dir, "bundle.js"), watch.EmptyMatcher{}, nil, nil) == nil)
+	// deletions are still synced
+	assert.Equal(t, len(maybeFileEvents(trigger, filepath.Join(dir, "deleted.js"), watch.EmptyMatcher{}, nil, nil)), 1)
+
+	trigger.MaxFileSize = 0
+	assert.Equal(t, len(maybeFileEvents(trigger, filepath.Join(dir, "bundle.js"), watch.EmptyMatcher{}, nil, nil)), 1)
+}
+
+func TestLoadDevelopmentConfig_MaxFileSize(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	newService := func(size interface{}) types.ServiceConfig {
+		return types.ServiceConfig{
+			Name: "test",
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{
+					"watch": []interface{}{
+						map[string]interface{}{"path": "dist", "action": "sync", "target": "/app", "max_file_size": size},
+					},
+				},
+			},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService("10mb"), project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.Watch[0].MaxFileSize, types.UnitBytes(10*1024*1024))
+
+	config, err = loadDevelopmentConfig(newService(4096), project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.Watch[0].MaxFileSize, types.UnitBytes(4096))
+
+	_, err = loadDevelopmentConfig(newService("big"), project)
+	assert.ErrorContains(t, err, "invalid size: 'big'")
+
+	_, err = loadDevelopmentConfig(newService(-1), project)
+	assert.ErrorContains(t, err, "max_file_size can't be negative")
+}
+
+func TestSelectServicesByLabels(t *testing.T) {
+	newProject := func() *types.Project {
+		return &types.Project{
+			Name: "myproject",
+			Services: []types.ServiceConfig{
+				{Name: "web", Labels: types.Labels{"dev": "true"}},
+				{Name: "api", Labels: types.Labels{"dev": "true", "tier": "backend"}},
+				{Name: "worker", Labels: types.Labels{"dev": "false", "tier": "backend"}},
+				{Name: "db"},
+			},
+		}
+	}
+	tests := []struct {
+		services []string
+		labels   []string
+		expected []string
+	}{
+		{labels: []string{"dev=true"}, expected: []string{"api", "web"}},
+		{labels: []string{"tier"}, expected: []string{"api", "worker"}},
+		{labels: []string{"dev=true", "tier=backend"}, expected: []string{"api"}},
+		// intersected with the service names
+		{services: []string{"api", "worker", "db"}, labels: []string{"dev=true"}, expected: []string{"api"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.labels, ","), func(t *testing.T) {
+			project := newProject()
+			assert.NilError(t, project.ForServices(tt.services))
+			assert.NilError(t, selectServicesByLabels(project, tt.labels))
+			assert.DeepEqual(t, project.ServiceNames(), tt.expected)
+		})
+	}
+
+	project := newProject()
+	assert.NilError(t, project.ForServices([]string{"worker"}))
+	err := selectServicesByLabels(project, []string{"dev=true"})
+	assert.ErrorContains(t, err, "none of the selected services has the labels dev=true")
+}
+
+func TestLoadDevelopmentConfig_Extensions(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	newService := func(extensions ...interface{}) types.ServiceConfig {
+		return types.ServiceConfig{
+			Name: "test",
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{
+					"watch": []interface{}{
+						map[string]interface{}{"path": "src", "action": "sync", "target": "/app", "extensions": extensions},
+					},
+				},
+			},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService(".go", ".html"), project)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, config.Watch[0].Extensions, []string{".go", ".html"})
+
+	_, err = loadDevelopmentConfig(newService("go"), project)
+	assert.ErrorContains(t, err, `invalid extension "go", must start with a dot`)
+}
+
+func TestMaybeFileEvents_Symlinks(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	src := filepath.Join(dir, "src")
+	shared := filepath.Join(dir, "shared")
+	assert.NilError(t, os.MkdirAll(src, 0o700))
+	assert.NilError(t, os.MkdirAll(shared, 0o700))
+	assert.NilError(t, os.WriteFile(filepath.Join(src, "main.go"), nil, 0o600))
+	assert.NilError(t, os.WriteFile(filepath.Join(shared, "util.go"), nil, 0o600))
+	// a symlinked subdirectory of the watched path, and a symlink to the watched path itself
+	assert.NilError(t, os.Symlink(shared, filepath.Join(src, "lib")))
+	assert.NilError(t, os.Symlink(src, filepath.Join(dir, "alias")))
+	assert.NilError(t, os.Symlink("main.go", filepath.Join(src, "link.go")))
+
+	trigger := Trigger{Path: src, Action: "sync", Target: "/app"}
+	mapping := func(hostPath string) []sync.PathMapping {
+		var mappings []sync.PathMapping
+		for _, e := range maybeFileEvents(trigger, hostPath, watch.EmptyMatcher{}, nil, nil) {
+			mappings = append(mappings, e.PathMapping)
+		}
+		return mappings
+	}
+	assert.DeepEqual(t, mapping(filepath.Join(src, "lib", "util.go")), []sync.PathMapping{
+		{HostPath: filepath.Join(src, "lib", "util.go"), ContainerPath: "/app/lib/util.go"},
+	})
+	assert.DeepEqual(t, mapping(filepath.Join(src, "link.go")), []sync.PathMapping{
+		{HostPath: filepath.Join(src, "link.go"), ContainerPath: "/app/link.go"},
+	})
+	assert.DeepEqual(t, mapping(filepath.Join(dir, "alias", "main.go")), []sync.PathMapping{
+		{HostPath: filepath.Join(src, "main.go"), ContainerPath: "/app/main.go"},
+	})
+	// deleted files are resolved through their closest existing parent
+	assert.DeepEqual(t, mapping(filepath.Join(dir, "alias", "gone", "old.go")), []sync.PathMapping{
+		{HostPath: filepath.Join(src, "gone", "old.go"), ContainerPath: "/app/gone/old.go"},
+	})
+	assert.Check(t, len(mapping(filepath.Join(shared, "util.go"))) == 0)
+}
+
+func TestLoadDevelopmentConfig_TargetList(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	newService := func(trigger map[string]interface{}) types.ServiceConfig {
+		return types.ServiceConfig{
+			Name: "test",
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{
+					"watch": []interface{}{trigger},
+				},
+			},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService(map[string]interface{}{
+		"path": "lib", "action": "sync", "target": "/app/lib",
+	}), project)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, config.Watch[0].targets(), []string{"/app/lib"})
+
+	config, err = loadDevelopmentConfig(newService(map[string]interface{}{
+		"path": "lib", "action": "sync", "target": []interface{}{"/app1/lib", "/app2/lib"},
+	}), project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.Watch[0].Target, "")
+	assert.DeepEqual(t, config.Watch[0].targets(), []string{"/app1/lib", "/app2/lib"})
+
+	_, err = loadDevelopmentConfig(newService(map[string]interface{}{
+		"path": "lib", "action": "sync", "target": []interface{}{"/app1/lib"}, "targets": []interface{}{"/app2/lib"},
+	}), project)
+	assert.ErrorContains(t, err, "can't set both")
+}
+
+func TestLoadDevelopmentConfig_OnSync(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	newService := func(develop map[string]interface{}) types.ServiceConfig {
+		develop["watch"] = []interface{}{
+			map[string]interface{}{"path": "src", "action": "sync", "target": "/app"},
+		}
+		return types.ServiceConfig{
+			Name:       "test",
+			Extensions: map[string]interface{}{"x-develop": develop},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService(map[string]interface{}{"on_sync": "./notify.sh --reload", "on_sync_required": true}), project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.OnSync, "./notify.sh --reload")
+	assert.Check(t, config.OnSyncRequired)
+
+	_, err = loadDevelopmentConfig(newService(map[string]interface{}{"on_sync": "'unterminated"}), project)
+	assert.ErrorContains(t, err, "parsing on_sync command")
+
+	_, err = loadDevelopmentConfig(newService(map[string]interface{}{"on_sync_required": true}), project)
+	assert.ErrorContains(t, err, "on_sync_required requires an on_sync command")
+}
+
+func TestLoadDevelopmentConfig_ProjectDefaults(t *testing.T) {
+	dir := t.TempDir()
+	project := &types.Project{
+		WorkingDir: dir,
+		Extensions: map[string]interface{}{
+			"x-develop": map[string]interface{}{
+				"debounce": "1s",
+				"watch": []interface{}{
+					map[string]interface{}{"path": "src", "action": "sync", "target": "/app", "ignore": []interface{}{"node_modules/"}},
+				},
+			},
+		},
+	}
+	newService := func(develop interface{}) types.ServiceConfig {
+		service := types.ServiceConfig{Name: "test"}
+		if develop != nil {
+			service.Extensions = map[string]interface{}{"x-develop": develop}
+		}
+		return service
+	}
+
+	config, err := loadDevelopmentConfig(newService(map[string]interface{}{}), project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.Debounce, time.Second)
+	assert.DeepEqual(t, config.Watch, []Trigger{
+		{Path: filepath.Join(dir, "src"), Action: "sync", Target: "/app", Ignore: []string{"node_modules/"}},
+	})
+
+	config, err = loadDevelopmentConfig(newService(map[string]interface{}{
+		"debounce": "2s",
+		"watch": []interface{}{
+			map[string]interface{}{"path": "lib", "action": "sync", "target": "/lib"},
+		},
+	}), project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.Debounce, 2*time.Second)
+	assert.DeepEqual(t, config.Watch, []Trigger{
+		{Path: filepath.Join(dir, "lib"), Action: "sync", Target: "/lib"},
+	})
+
+	config, err = loadDevelopmentConfig(newService(nil), project)
+	assert.NilError(t, err)
+	assert.Check(t, config == nil, "services without x-develop aren't watched")
+
+	_, err = loadDevelopmentConfig(newService("watch"), project)
+	assert.ErrorContains(t, err, "x-develop must be a mapping")
+}
+
+func TestLoadDevelopmentConfig_UnresolvableWorkingDir(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	// resolving the symlinks of a missing directory fails
+	project := &types.Project{WorkingDir: filepath.Join(dir, "missing")}
+	newService := func(path string) types.ServiceConfig {
+		return types.ServiceConfig{
+			Name: "test",
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{
+					"watch": []interface{}{
+						map[string]interface{}{"path": path, "action": "sync", "target": "/app"},
+					},
+				},
+			},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService(dir), project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.Watch[0].Path, dir)
+
+	config, err = loadDevelopmentConfig(newService("src"), project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.Watch[0].Path, filepath.Join(dir, "missing", "src"))
+
+	_, err = loadDevelopmentConfig(newService("src"), &types.Project{})
+	assert.ErrorContains(t, err, "relative watch paths require a project directory")
+}
+
+func TestLoadDevelopmentConfig_ActionList(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	project := &types.Project{WorkingDir: dir}
+	newService := func(trigger map[string]interface{}) types.ServiceConfig {
+		trigger["path"] = "src"
+		return types.ServiceConfig{
+			Name: "test",
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{
+					"watch": []interface{}{trigger},
+				},
+			},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService(map[string]interface{}{
+		"action": []interface{}{"sync", "exec", "restart"},
+		"target": "/app",
+		"filter": "cat",
+		"exec":   map[string]interface{}{"command": "npm run build"},
+	}), project)
+	assert.NilError(t, err)
+	src := filepath.Join(dir, "src")
+	exec := &TriggerExec{Command: types.ShellCommand{"npm", "run", "build"}}
+	assert.DeepEqual(t, config.Watch, []Trigger{
+		{Path: src, Action: "sync", Target: "/app", Filter: "cat"},
+		{Path: src, Action: "exec", Exec: exec},
+		{Path: src, Action: "restart"},
+	})
+
+	// a single changed file produces the events of all the actions, in order
+	var actions []WatchAction
+	for _, trigger := range config.Watch {
+		for _, e := range maybeFileEvents(trigger, filepath.Join(src, "main.go"), watch.EmptyMatcher{}, nil, nil) {
+			actions = append(actions, e.Action)
+		}
+	}
+	assert.DeepEqual(t, actions, []WatchAction{WatchActionSync, WatchActionExec, WatchActionRestart})
+
+	for _, tc := range []struct {
+		actions []interface{}
+		err     string
+	}{
+		{actions: []interface{}{"exec", "sync"}, err: "invalid order of actions"},
+		{actions: []interface{}{"sync", "sync"}, err: `action "sync" is listed twice`},
+		{actions: []interface{}{"restart", "rebuild"}, err: `"restart" and "rebuild" can't be combined`},
+		{actions: []interface{}{"sync+restart", "exec"}, err: `"sync+restart" can't be combined with other actions`},
+		{actions: []interface{}{"sync", "exec", "reload"}, err: `invalid action "reload"`},
+	} {
+		_, err = loadDevelopmentConfig(newService(map[string]interface{}{
+			"action": tc.actions,
+			"target": "/app",
+			"exec":   map[string]interface{}{"command": "npm run build"},
+		}), project)
+		assert.ErrorContains(t, err, tc.err, "%v", tc.actions)
+	}
+
+	// the settings of the actions not listed are still reported
+	_, err = loadDevelopmentConfig(newService(map[string]interface{}{
+		"actions": []interface{}{"sync", "restart"},
+		"target":  "/app",
+		"exec":    map[string]interface{}{"command": "npm run build"},
+	}), project)
+	assert.ErrorContains(t, err, "exec only applies to the 'exec' action")
+}
+
+func TestLoadDevelopmentConfig_BroadPath(t *testing.T) {
+	home, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	root := home
+	for filepath.Dir(root) != root {
+		root = filepath.Dir(root)
+	}
+	project := &types.Project{WorkingDir: filepath.Join(home, "project")}
+	loadAction := func(path string, action string) error {
+		_, err := loadDevelopmentConfig(types.ServiceConfig{
+			Name: "test",
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{
+					"watch": []interface{}{
+						map[string]interface{}{"path": path, "action": action, "target": "/app"},
+					},
+				},
+			},
+		}, project)
+		return err
+	}
+	load := func(path string) error {
+		return loadAction(path, "sync")
+	}
+
+	assert.ErrorContains(t, load(root), "refusing to watch the filesystem root, set COMPOSE_WATCH_ALLOW_BROAD_PATHS=true")
+	assert.ErrorContains(t, load(home), "refusing to watch the home directory")
+	assert.ErrorContains(t, load(".."), "refusing to watch the home directory")
+	assert.NilError(t, load("."))
+	assert.NilError(t, load(filepath.Join(home, "src")))
+	// the files extracted from the containers can't be written all over the host either
+	assert.ErrorContains(t, loadAction(home, "extract"), "refusing to watch the home directory")
+
+	t.Setenv(allowBroadWatchPathsEnvVar, "true")
+	assert.NilError(t, load(root))
+	assert.NilError(t, load(home))
+}
+
+func TestLoadDevelopmentConfig_RelativeTarget(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	newService := func(workingDir string, trigger map[string]interface{}) types.ServiceConfig {
+		return types.ServiceConfig{
+			Name:       "test",
+			WorkingDir: workingDir,
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{
+					"watch": []interface{}{trigger},
+				},
+			},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService("", map[string]interface{}{
+		"path": "lib", "action": "sync", "target": "/app/lib",
+	}), project)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, config.Watch[0].targets(), []string{"/app/lib"})
+
+	config, err = loadDevelopmentConfig(newService("/app", map[string]interface{}{
+		"path": "lib", "action": "sync", "target": []interface{}{"lib", "../shared/lib", "/opt/lib"},
+	}), project)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, config.Watch[0].targets(), []string{"/app/lib", "/shared/lib", "/opt/lib"})
+
+	config, err = loadDevelopmentConfig(newService("/app", map[string]interface{}{
+		"path": "lib", "action": "sync", "target": "config/",
+	}), project)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, config.Watch[0].targets(), []string{"/app/config/"})
+
+	_, err = loadDevelopmentConfig(newService("", map[string]interface{}{
+		"path": "lib", "action": "sync", "target": "app/lib",
+	}), project)
+	assert.ErrorContains(t, err, `target "app/lib" must be an absolute path, or service test must set an absolute working_dir`)
+}
+
+type watchEventRecorder struct {
+	mu     gosync.Mutex
+	events []api.WatchEvent
+}
+
+func (r *watchEventRecorder) handle(event api.WatchEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *watchEventRecorder) types() []api.WatchEventType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	types := make([]api.WatchEventType, len(r.events))
+	for i := range r.events {
+		types[i] = r.events[i].Type
+	}
+	return types
+}
+
+func TestWatch_EventHandler(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(os.Stderr).AnyTimes()
+
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	clock := clockwork.NewFakeClock()
+	service := composeService{
+		dockerCli: cli,
+		clock:     clock,
+	}
+	proj := &types.Project{Services: []types.ServiceConfig{{Name: "test"}}}
+	recorder := &watchEventRecorder{}
+	options := api.WatchOptions{EventHandler: recorder.handle}
+	syncErr := errors.New("sync failed")
+
+	done := make(chan error)
+	go func() {
+		done <- service.watch(context.Background(), proj, "test", options, watcher, failingSyncer{syncErr}, &serviceWatch{}, DevelopmentConfig{
+			Watch: []Trigger{{Path: "/sync", Action: "sync", Target: "/work", OnError: "stop"}},
+		})
+	}()
+
+	watcher.Events() <- watch.NewFileEvent("/sync/changed")
+	clock.BlockUntil(1)
+	clock.Advance(quietPeriod)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watch didn't stop on error")
+	}
+
+	assert.DeepEqual(t, recorder.types(), []api.WatchEventType{
+		api.WatchEventBatch,
+		api.WatchEventSyncStarted,
+		api.WatchEventSyncCompleted,
+		api.WatchEventError,
+	})
+	syncCompleted := recorder.events[2]
+	assert.Equal(t, syncCompleted.Service, "test")
+	assert.Equal(t, syncCompleted.Action, "sync")
+	assert.DeepEqual(t, syncCompleted.Paths, []string{"/sync/changed"})
+	assert.Equal(t, syncCompleted.Err, syncErr)
+}
+
+func TestWatch_PathCreatedAfterStart(t *testing.T) {
+	backends := map[string]func(paths []string) (watch.Notify, error){
+		"native": func(paths []string) (watch.Notify, error) {
+			return watch.NewWatcher(paths, watch.EmptyMatcher{})
+		},
+		"polling": func(paths []string) (watch.Notify, error) {
+			return watch.NewPollingWatcher(paths, watch.EmptyMatcher{}, 10*time.Millisecond)
+		},
+	}
+	for name, newWatcher := range backends {
+		t.Run(name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			cli := mocks.NewMockCli(mockCtrl)
+			cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+
+			dir := t.TempDir()
+			dist := filepath.Join(dir, "dist")
+			watcher, err := newWatcher([]string{dist})
+			assert.NilError(t, err)
+			assert.NilError(t, watcher.Start())
+			t.Cleanup(func() {
+				_ = watcher.Close()
+			})
+
+			syncer := newFakeSyncer()
+			clock := clockwork.NewFakeClock()
+			service := composeService{
+				dockerCli: cli,
+				clock:     clock,
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			t.Cleanup(cancel)
+			proj := &types.Project{Services: []types.ServiceConfig{{Name: "test"}}}
+			go func() {
+				err := service.watch(ctx, proj, "test", api.WatchOptions{}, watcher, syncer, &serviceWatch{}, DevelopmentConfig{
+					Watch: []Trigger{{Path: dist, Action: "sync", Target: "/app/dist"}},
+				})
+				assert.NilError(t, err)
+			}()
+
+			assert.NilError(t, os.Mkdir(dist, 0o755))
+			assert.NilError(t, os.WriteFile(filepath.Join(dist, "app.js"), []byte("console.log()"), 0o644))
+
+			expected := sync.PathMapping{HostPath: filepath.Join(dist, "app.js"), ContainerPath: "/app/dist/app.js"}
+			assert.Assert(t, poll(func() bool {
+				clock.Advance(quietPeriod)
+				select {
+				case actual := <-syncer.synced:
+					// the kind of change depends on how the watcher reports the write
+					return slices.ContainsFunc(actual, func(m sync.PathMapping) bool {
+						return m.HostPath == expected.HostPath && m.ContainerPath == expected.ContainerPath
+					})
+				default:
+					return false
+				}
+			}), "the created path wasn't synced")
+		})
+	}
+}
+
+func TestHandleWatchBatch_DryRun(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	var stderr bytes.Buffer
+	cli.EXPECT().Err().Return(&stderr).AnyTimes()
+	// no API calls are expected, any restart or rebuild attempt fails the test
+	cli.EXPECT().Client().Times(0)
+	service := composeService{dockerCli: cli}
+	proj := &types.Project{
+		Name:     "myproject",
+		Services: []types.ServiceConfig{{Name: "test"}},
+	}
+	syncer := &recordingSyncer{}
+	options := api.WatchOptions{DryRun: true}
+
+	err := service.handleWatchBatch(context.Background(), proj, "test", options, []fileEvent{
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/sync/a", ContainerPath: "/work/a"}},
+		{Action: WatchActionRestart, PathMapping: sync.PathMapping{HostPath: "/config/x"}},
+	}, syncer, nil)
+	assert.NilError(t, err)
+
+	err = service.handleWatchBatch(context.Background(), proj, "test", options, []fileEvent{
+		{Action: WatchActionRebuild, PathMapping: sync.PathMapping{HostPath: "/rebuild/Dockerfile"}},
+	}, syncer, nil)
+	assert.NilError(t, err)
+
+	assert.Equal(t, len(syncer.synced), 0)
+	assert.Equal(t, stderr.String(), `(dry run) would sync test after changes were detected:
+  - /sync/a -> /work/a
+(dry run) would restart test after changes were detected
+(dry run) would rebuild test after changes were detected:
+  - /rebuild/Dockerfile
+`)
+}
+
+func TestWatch_DryRunExtract(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	var stderr bytes.Buffer
+	cli.EXPECT().Err().Return(&stderr).AnyTimes()
+	// no API calls are expected, any extraction attempt fails the test
+	cli.EXPECT().Client().Times(0)
+	service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+
+	dir := t.TempDir()
+	project := &types.Project{
+		Name:       "myproject",
+		WorkingDir: dir,
+		Services: []types.ServiceConfig{{
+			Name: "web",
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{
+					"watch": []interface{}{
+						map[string]interface{}{"path": "dist", "action": "extract", "target": "/app/dist"},
+					},
+				},
+			},
+		}},
+	}
+	// the session ends right away, without extraction to poll for
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := service.Watch(ctx, project, nil, api.WatchOptions{DryRun: true})
+	assert.NilError(t, err)
+	assert.Check(t, ctx.Err() == nil)
+	assert.Check(t, strings.Contains(stderr.String(), "(dry run) would extract /app/dist from service web to "+filepath.Join(dir, "dist")), stderr.String())
+	_, err = os.Stat(filepath.Join(dir, "dist"))
+	assert.Check(t, os.IsNotExist(err))
+}
+
+func TestWatchStatus(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(os.Stderr).AnyTimes()
+
+	dir := t.TempDir()
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	syncer := newFakeSyncer()
+	clock := clockwork.NewFakeClock()
+	service := composeService{
+		dockerCli: cli,
+		clock:     clock,
+		watches:   &watchRegistry{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	proj := &types.Project{Name: "myproject", Services: []types.ServiceConfig{{Name: "test"}}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := service.watch(ctx, proj, "test", api.WatchOptions{}, watcher, syncer, &serviceWatch{}, DevelopmentConfig{
+			Watch: []Trigger{{Path: dir, Action: "sync", Target: "/app"}},
+		})
+		assert.NilError(t, err)
+	}()
+
+	for i := 0; i < 2; i++ {
+		watcher.Events() <- watch.NewFileEvent(filepath.Join(dir, "main.go"))
+		// the event may not have reached the debouncer yet, so advance until the batch is flushed
+		assert.Check(t, poll(func() bool {
+			clock.Advance(quietPeriod)
+			select {
+			case <-syncer.synced:
+				return true
+			case <-time.After(10 * time.Millisecond):
+				return false
+			}
+		}), "timed out waiting for events")
+	}
+
+	assert.Check(t, poll(func() bool {
+		status, err := service.WatchStatus(ctx)
+		return err == nil && len(status) == 1 && status[0].Syncs == 2
+	}), "sync counter should have been incremented")
+	status, err := service.WatchStatus(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, status, []api.WatchSession{{
+		Project:   "myproject",
+		Service:   "test",
+		Paths:     []string{dir},
+		Syncs:     2,
+		Batches:   2,
+		LastBatch: clock.Now(),
+	}})
+
+	cancel()
+	<-done
+	status, err = service.WatchStatus(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, len(status), 0)
+}
+
+// poll checks condition until it's met or a short timeout expires.
+func poll(condition func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return condition()
+}
+
+// discardTarClient syncs into a single container, discarding the synced archives
+type discardTarClient struct{}
+
+func (discardTarClient) ContainersForService(context.Context, string, string) ([]moby.Container, error) {
+	return []moby.Container{{ID: "123"}}, nil
+}
+
+func (discardTarClient) Exec(_ context.Context, _ string, _ []string, in io.Reader) error {
+	if in != nil {
+		_, err := io.Copy(io.Discard, in)
+		return err
+	}
+	return nil
+}
+
+func TestWatchStatus_SyncStats(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(os.Stderr).AnyTimes()
+
+	dir := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, "lib"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "lib", "util.go"), []byte("package lib"), 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "lib", "lib.go"), []byte("package lib"), 0o644))
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	clock := clockwork.NewFakeClock()
+	service := composeService{
+		dockerCli: cli,
+		clock:     clock,
+		watches:   &watchRegistry{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	proj := &types.Project{Name: "myproject", Services: []types.ServiceConfig{{Name: "test"}}}
+	go func() {
+		stats := &sync.Stats{}
+		err := service.watch(ctx, proj, "test", api.WatchOptions{}, watcher, sync.NewTar(proj.Name, discardTarClient{}).WithStats(stats), &serviceWatch{stats: stats}, DevelopmentConfig{
+			Watch: []Trigger{{Path: dir, Action: "sync", Target: "/app"}},
+		})
+		assert.NilError(t, err)
+	}()
+
+	watcher.Events() <- watch.NewFileEvent(filepath.Join(dir, "lib"))
+	var status []api.WatchSession
+	assert.Check(t, poll(func() bool {
+		clock.Advance(quietPeriod)
+		var err error
+		status, err = service.WatchStatus(ctx)
+		return err == nil && len(status) == 1 && status[0].Batches == 1
+	}), "timed out waiting for the batch to be handled")
+	assert.Equal(t, status[0].Syncs, 1)
+	assert.Equal(t, status[0].FilesSynced, int64(2))
+	// headers of the directory and files, contents of the files and the two end blocks
+	assert.Equal(t, status[0].BytesSynced, int64(7*512))
+}
+
+func TestWatch_BatchDuration(t *testing.T) {
+	level := logrus.GetLevel()
+	var logs lockedBuffer
+	logrus.SetOutput(&logs)
+	logrus.SetLevel(logrus.DebugLevel)
+	t.Cleanup(func() {
+		logrus.SetLevel(level)
+		logrus.SetOutput(os.Stderr)
+	})
+
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	clock := clockwork.NewFakeClock()
+	service := composeService{
+		dockerCli: cli,
+		clock:     clock,
+		watches:   &watchRegistry{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	proj := &types.Project{Name: "myproject", Services: []types.ServiceConfig{{Name: "test"}}}
+	syncer := newBlockingSyncer()
+	go func() {
+		err := service.watch(ctx, proj, "test", api.WatchOptions{}, watcher, syncer, &serviceWatch{}, DevelopmentConfig{
+			Watch: []Trigger{{Path: "/src", Action: "sync", Target: "/app"}},
+		})
+		assert.NilError(t, err)
+	}()
+
+	watcher.Events() <- watch.NewFileEvent("/src/main.go")
+	assert.Check(t, poll(func() bool {
+		clock.Advance(quietPeriod)
+		select {
+		case <-syncer.started:
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}), "sync didn't start")
+	// the batch takes as long as the fake clock advanced while it's handled
+	clock.Advance(3 * time.Second)
+	close(syncer.release)
+	assert.Check(t, poll(func() bool {
+		return strings.Contains(logs.String(), "batch complete: service[test] duration[3s] count[1]")
+	}), logs.String())
+}
+
+func TestWatch_Summary(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+
+	dir := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, "lib"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "lib", "util.go"), []byte("package lib"), 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "lib", "lib.go"), []byte("package lib"), 0o644))
+	clock := clockwork.NewFakeClock()
+	service := composeService{
+		dockerCli: cli,
+		clock:     clock,
+		watches:   &watchRegistry{},
+	}
+	summary := &watchSummary{}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	proj := &types.Project{Name: "myproject", Services: []types.ServiceConfig{{Name: "web"}, {Name: "api"}}}
+
+	// both services are totalled, only the tar syncer records its stats
+	stats := &sync.Stats{}
+	for name, syncer := range map[string]sync.Syncer{
+		"web": sync.NewTar(proj.Name, discardTarClient{}).WithStats(stats),
+		"api": failingSyncer{err: errors.New("sync failed")},
+	} {
+		watcher := testWatcher{
+			events: make(chan watch.FileEvent),
+			errors: make(chan error),
+		}
+		go func(name string, syncer sync.Syncer) {
+			err := service.watch(ctx, proj, name, api.WatchOptions{}, watcher, syncer, &serviceWatch{summary: summary, stats: stats}, DevelopmentConfig{
+				Watch: []Trigger{{Path: dir, Action: "sync", Target: "/app"}},
+			})
+			assert.NilError(t, err)
+		}(name, syncer)
+		watcher.Events() <- watch.NewFileEvent(filepath.Join(dir, "lib"))
+		assert.Check(t, poll(func() bool {
+			clock.Advance(quietPeriod)
+			status, err := service.WatchStatus(ctx)
+			return err == nil && slices.ContainsFunc(status, func(session api.WatchSession) bool {
+				return session.Service == name && session.Batches > 0
+			})
+		}), "timed out waiting for the batch of %s to be handled", name)
+	}
+
+	var out bytes.Buffer
+	summary.write(&out, 90*time.Second)
+	assert.Equal(t, out.String(), "watch session ended after 1m30s: 2 file(s) synced, 0 rebuild(s), 0 restart(s), 1 error(s), 0 warning(s)\n")
+}
+
+func TestWatch_SummaryFailedRebuild(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+	apiClient := mocks.NewMockAPIClient(mockCtrl)
+	// fail the rebuild as soon as the service containers are listed
+	apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return(nil, errors.New("engine unavailable")).AnyTimes()
+	cli.EXPECT().Client().Return(apiClient).AnyTimes()
+
+	clock := clockwork.NewFakeClock()
+	service := composeService{
+		dockerCli: cli,
+		clock:     clock,
+		watches:   &watchRegistry{},
+	}
+	summary := &watchSummary{}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	proj := &types.Project{Name: "myproject", Services: []types.ServiceConfig{{Name: "web"}}}
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	var events watchEventRecorder
+	go func() {
+		// the rebuild failure doesn't stop the watch with the continue policy
+		err := service.watch(ctx, proj, "web", api.WatchOptions{EventHandler: events.handle}, watcher, newFakeSyncer(), &serviceWatch{summary: summary}, DevelopmentConfig{
+			Watch: []Trigger{{Path: "/src", Action: "rebuild"}},
+		})
+		assert.NilError(t, err)
+	}()
+	watcher.Events() <- watch.NewFileEvent("/src/go.mod")
+	assert.Check(t, poll(func() bool {
+		clock.Advance(quietPeriod)
+		status, err := service.WatchStatus(ctx)
+		return err == nil && len(status) == 1 && status[0].Errors == 1
+	}), "timed out waiting for the failed rebuild to be counted")
+	assert.Check(t, slices.Contains(events.types(), api.WatchEventError))
+
+	var out bytes.Buffer
+	summary.write(&out, time.Minute)
+	assert.Equal(t, out.String(), "watch session ended after 1m0s: 0 file(s) synced, 1 rebuild(s), 0 restart(s), 1 error(s), 0 warning(s)\n")
+}
+
+func TestWatch_SummaryWarnings(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+
+	dir := t.TempDir()
+	large := filepath.Join(dir, "large.bin")
+	assert.NilError(t, os.WriteFile(large, make([]byte, 2048), 0o644))
+	clock := clockwork.NewFakeClock()
+	service := composeService{dockerCli: cli, clock: clock}
+	summary := &watchSummary{}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	go func() {
+		err := service.watch(ctx, &types.Project{Services: []types.ServiceConfig{{Name: "test"}}}, "test", api.WatchOptions{},
+			watcher, newFakeSyncer(), &serviceWatch{summary: summary}, DevelopmentConfig{Watch: []Trigger{{Path: dir, Action: "sync", Target: "/app", MaxFileSize: 1024}}})
+		assert.NilError(t, err)
+	}()
+
+	// a missed change and a skipped one
+	watcher.errors <- fmt.Errorf("watching %s: %w", dir, syscall.EMFILE)
+	watcher.Events() <- watch.NewFileEvent(large)
+	assert.Check(t, poll(func() bool {
+		summary.mu.Lock()
+		defer summary.mu.Unlock()
+		return summary.warnings == 2
+	}), "timed out waiting for the warnings to be counted")
+
+	var out bytes.Buffer
+	summary.write(&out, time.Minute)
+	assert.Equal(t, out.String(), "watch session ended after 1m0s: 0 file(s) synced, 0 rebuild(s), 0 restart(s), 0 error(s), 2 warning(s)\n")
+}
+
+// fakeExtractClient archives the files of a fake container path
+type fakeExtractClient struct {
+	mu    gosync.Mutex
+	files map[string]string
+	cmds  [][]string
+}
+
+func (f *fakeExtractClient) ContainersForService(context.Context, string, string) ([]moby.Container, error) {
+	return []moby.Container{{ID: "123"}}, nil
+}
+
+func (f *fakeExtractClient) ExecOutput(_ context.Context, _ string, cmd []string, out io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cmds = append(f.cmds, cmd)
+	tw := tar.NewWriter(out)
+	if err := tw.WriteHeader(&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0o755}); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(f.files))
+	for name := range f.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		content := f.files[name]
+		header := &tar.Header{Name: "./" + name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content))}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func (f *fakeExtractClient) set(name, content string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[name] = content
+}
+
+func TestExtractOnce(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	var stderr bytes.Buffer
+	cli.EXPECT().Err().Return(&stderr).AnyTimes()
+	service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+
+	dir := t.TempDir()
+	trigger := Trigger{Path: filepath.Join(dir, "dist"), Action: "extract", Target: "/app/dist"}
+	client := &fakeExtractClient{files: map[string]string{
+		"bundle.js":      "console.log()",
+		"css/styles.css": "body {}",
+	}}
+	digest, err := service.extractOnce(context.Background(), "myproject", "web", api.WatchOptions{}, trigger, client, "")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, client.cmds, [][]string{{"tar", "-c", "-C", "/app/dist", "."}})
+	for name, content := range client.files {
+		actual, err := os.ReadFile(filepath.Join(trigger.Path, filepath.FromSlash(name)))
+		assert.NilError(t, err)
+		assert.Equal(t, string(actual), content)
+	}
+	assert.Equal(t, stderr.String(), fmt.Sprintf("Extracted 2 file(s) from /app/dist in web to %s\n", trigger.Path))
+
+	// unchanged content isn't extracted again
+	stderr.Reset()
+	again, err := service.extractOnce(context.Background(), "myproject", "web", api.WatchOptions{}, trigger, client, digest)
+	assert.NilError(t, err)
+	assert.Equal(t, again, digest)
+	assert.Equal(t, stderr.String(), "")
+
+	client.set("bundle.js", "console.log('changed')")
+	changed, err := service.extractOnce(context.Background(), "myproject", "web", api.WatchOptions{}, trigger, client, digest)
+	assert.NilError(t, err)
+	assert.Check(t, changed != digest)
+	actual, err := os.ReadFile(filepath.Join(trigger.Path, "bundle.js"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(actual), "console.log('changed')")
+}
+
+func TestExtractArchive_InvalidPath(t *testing.T) {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	assert.NilError(t, tw.WriteHeader(&tar.Header{Name: "../escape.txt", Typeflag: tar.TypeReg, Mode: 0o644}))
+	assert.NilError(t, tw.Close())
+
+	dir := t.TempDir()
+	_, err := extractArchive(&archive, filepath.Join(dir, "dist"))
+	assert.ErrorContains(t, err, `invalid path "../escape.txt" in the archive`)
+	_, err = os.Stat(filepath.Join(dir, "escape.txt"))
+	assert.Check(t, os.IsNotExist(err))
+}
+
+func TestExtractArchive_Symlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks requires privileges on Windows")
+	}
+	dir := t.TempDir()
+	outside := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(outside, "x"), []byte("host"), 0o644))
+	assert.NilError(t, os.Symlink(outside, filepath.Join(dir, "link")))
+
+	for _, header := range []*tar.Header{
+		{Name: "link/x", Typeflag: tar.TypeReg, Mode: 0o644, Size: 9},
+		{Name: "link/sub", Typeflag: tar.TypeDir, Mode: 0o755},
+	} {
+		var archive bytes.Buffer
+		tw := tar.NewWriter(&archive)
+		assert.NilError(t, tw.WriteHeader(header))
+		if header.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte("container"))
+			assert.NilError(t, err)
+		}
+		assert.NilError(t, tw.Close())
+
+		_, err := extractArchive(&archive, dir)
+		assert.ErrorContains(t, err, "refusing to extract through the symlink "+filepath.Join(dir, "link"))
+	}
+	content, err := os.ReadFile(filepath.Join(outside, "x"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), "host")
+	_, err = os.Stat(filepath.Join(outside, "sub"))
+	assert.Check(t, os.IsNotExist(err))
+}
+
+func TestExtractArchive_ReplacesSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks requires privileges on Windows")
+	}
+	dir := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "x")
+	assert.NilError(t, os.WriteFile(outside, []byte("host"), 0o644))
+	assert.NilError(t, os.Symlink(outside, filepath.Join(dir, "x")))
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	assert.NilError(t, tw.WriteHeader(&tar.Header{Name: "x", Typeflag: tar.TypeReg, Mode: 0o644, Size: 9}))
+	_, err := tw.Write([]byte("container"))
+	assert.NilError(t, err)
+	assert.NilError(t, tw.Close())
+
+	// the symlink is replaced by the extracted file, the file it pointed to is left as is
+	files, err := extractArchive(&archive, dir)
+	assert.NilError(t, err)
+	assert.Equal(t, files, 1)
+	content, err := os.ReadFile(outside)
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), "host")
+	fi, err := os.Lstat(filepath.Join(dir, "x"))
+	assert.NilError(t, err)
+	assert.Check(t, fi.Mode().IsRegular())
+}
+
+func TestExtractArchive_Truncated(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "bundle.js"), []byte("previous"), 0o644))
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	assert.NilError(t, tw.WriteHeader(&tar.Header{Name: "bundle.js", Typeflag: tar.TypeReg, Mode: 0o644, Size: 100}))
+	_, err := tw.Write([]byte("partial"))
+	assert.NilError(t, err)
+
+	// a failed extraction leaves the previous file in place
+	_, err = extractArchive(bytes.NewReader(archive.Bytes()), dir)
+	assert.Check(t, err != nil)
+	content, err := os.ReadFile(filepath.Join(dir, "bundle.js"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), "previous")
+	entries, err := os.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 1)
+}
+
+func TestWatchExtract(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+	clock := clockwork.NewFakeClock()
+	service := composeService{dockerCli: cli, clock: clock}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	dir := t.TempDir()
+	trigger := Trigger{Path: dir, Action: "extract", Target: "/app/dist", PollInterval: 5 * time.Second}
+	client := &fakeExtractClient{files: map[string]string{"bundle.js": "console.log()"}}
+	go service.watchExtract(ctx, "myproject", "web", api.WatchOptions{}, trigger, client, nil)
+
+	read := func() string {
+		content, _ := os.ReadFile(filepath.Join(dir, "bundle.js"))
+		return string(content)
+	}
+	assert.Check(t, poll(func() bool {
+		clock.Advance(5 * time.Second)
+		return read() == "console.log()"
+	}), "the file wasn't extracted")
+
+	client.set("bundle.js", "console.log('changed')")
+	assert.Check(t, poll(func() bool {
+		clock.Advance(5 * time.Second)
+		return read() == "console.log('changed')"
+	}), "the changed file wasn't extracted")
+}
+
+func TestLoadDevelopmentConfig_Extract(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	project := &types.Project{WorkingDir: dir}
+	newService := func(triggers ...map[string]interface{}) types.ServiceConfig {
+		watch := make([]interface{}, len(triggers))
+		for i := range triggers {
+			watch[i] = triggers[i]
+		}
+		return types.ServiceConfig{
+			Name:       "test",
+			WorkingDir: "/app",
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{"watch": watch},
+			},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService(
+		map[string]interface{}{"path": "dist", "action": "extract", "target": "dist", "poll_interval": "5s"},
+		map[string]interface{}{"path": "src", "action": "sync", "target": "src"},
+	), project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.Watch[0].Target, "/app/dist")
+	assert.Equal(t, config.Watch[0].PollInterval, 5*time.Second)
+	extracts := config.extractTriggers()
+	assert.Equal(t, len(extracts), 1)
+	assert.Equal(t, extracts[0].Path, filepath.Join(project.WorkingDir, "dist"))
+	assert.Equal(t, len(config.Watch), 1)
+	assert.Equal(t, config.Watch[0].Action, "sync")
+
+	for _, tc := range []struct {
+		triggers []map[string]interface{}
+		err      string
+	}{
+		{
+			triggers: []map[string]interface{}{{"path": "dist", "action": "extract"}},
+			err:      "'extract' action requires a single container path as target",
+		},
+		{
+			triggers: []map[string]interface{}{{"path": "src", "action": "sync", "target": "/app", "poll_interval": "5s"}},
+			err:      "poll_interval only applies to the 'extract' action",
+		},
+		{
+			triggers: []map[string]interface{}{{"path": "src", "action": []interface{}{"extract", "restart"}, "target": "/app"}},
+			err:      `"extract" can't be combined with other actions`,
+		},
+		{
+			triggers: []map[string]interface{}{
+				{"path": "dist", "action": "extract", "target": "/app/dist"},
+				{"path": ".", "action": "sync", "target": "/app"},
+			},
+			err: "would be handled as changes by the sync rule for " + project.WorkingDir,
+		},
+	} {
+		_, err := loadDevelopmentConfig(newService(tc.triggers...), project)
+		assert.ErrorContains(t, err, tc.err)
+	}
+}
+
+func TestCountWatchBatch(t *testing.T) {
+	var session api.WatchSession
+	countWatchBatch(&session, []fileEvent{{Action: WatchActionSync}, {Action: WatchActionSync}, {Action: WatchActionRestart}})
+	countWatchBatch(&session, []fileEvent{{Action: WatchActionSync}, {Action: WatchActionRebuild}, {Action: WatchActionRestart}})
+	countWatchBatch(&session, []fileEvent{{Action: WatchActionRestart}})
+	assert.DeepEqual(t, session, api.WatchSession{Syncs: 2, Rebuilds: 1, Restarts: 2, Batches: 3})
+}
+
+func TestForceSync(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+	service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	for name, content := range map[string]string{
+		".dockerignore":               "node_modules\n",
+		"main.go":                     "package main",
+		"lib/util.go":                 "package lib",
+		"lib/util.tmp":                "",
+		"node_modules/dep/index.js":   "",
+		".cache/data":                 "",
+		"config/settings.yaml":        "debug: true",
+		"lib/.idea/workspace.xml":     "",
+		"lib/nested/deeper/readme.md": "",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NilError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		assert.NilError(t, os.WriteFile(p, []byte(content), 0o644))
+	}
+	proj := newWatchProject(t, dir)
+	proj.Services[0].Extensions["x-develop"] = map[string]interface{}{
+		"watch": []interface{}{
+			map[string]interface{}{"path": dir, "action": "sync", "target": "/app", "ignore": []interface{}{"config/", "**/*.tmp"}},
+			map[string]interface{}{"path": filepath.Join(dir, "config"), "action": "restart"},
+		},
+	}
+
+	config, err := loadDevelopmentConfig(proj.Services[0], proj)
+	assert.NilError(t, err)
+	syncer := &recordingSyncer{}
+	err = service.forceSync(context.Background(), proj, proj.Services[0], *config, api.WatchOptions{}, syncer, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, len(syncer.synced), 1, "the syncer must be invoked once")
+	require.ElementsMatch(t, []sync.PathMapping{
+		{HostPath: filepath.Join(dir, ".dockerignore"), ContainerPath: "/app/.dockerignore"},
+		{HostPath: filepath.Join(dir, "main.go"), ContainerPath: "/app/main.go"},
+		{HostPath: filepath.Join(dir, "lib", "util.go"), ContainerPath: "/app/lib/util.go"},
+		{HostPath: filepath.Join(dir, "lib", "nested", "deeper", "readme.md"), ContainerPath: "/app/lib/nested/deeper/readme.md"},
+	}, syncer.synced[0])
+
+	err = service.ForceSync(context.Background(), proj, "unknown", api.WatchOptions{})
+	assert.ErrorContains(t, err, "unknown")
+}
+
+func TestRebuildLimiter(t *testing.T) {
+	const limit, rebuilds = 2, 8
+	limiter := newRebuildLimiter(limit)
+
+	var (
+		mu            gosync.Mutex
+		running, peak int
+		wg            gosync.WaitGroup
+	)
+	release := make(chan struct{})
+	for i := 0; i < rebuilds; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := limiter.run(context.Background(), "test", func() error {
+				mu.Lock()
+				running++
+				peak = max(peak, running)
+				mu.Unlock()
+				<-release
+				mu.Lock()
+				running--
+				mu.Unlock()
+				return nil
+			})
+			assert.NilError(t, err)
+		}()
+	}
+
+	assert.Check(t, poll(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return running == limit
+	}))
+	close(release)
+	wg.Wait()
+	assert.Equal(t, peak, limit)
+}
+
+func TestRebuildLimiter_Unbounded(t *testing.T) {
+	assert.Check(t, newRebuildLimiter(-1) == nil)
+	called := false
+	err := newRebuildLimiter(-1).run(context.Background(), "test", func() error {
+		called = true
+		return nil
+	})
+	assert.NilError(t, err)
+	assert.Check(t, called)
+}
+
+func TestRebuildLimiter_Canceled(t *testing.T) {
+	limiter := newRebuildLimiter(1)
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		_ = limiter.run(context.Background(), "first", func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	defer close(release)
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := limiter.run(ctx, "second", func() error {
+		t.Error("rebuild must not run once the context is canceled")
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRebuildWatchedService_SameTarget(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+	apiClient := mocks.NewMockAPIClient(mockCtrl)
+	var (
+		mu            gosync.Mutex
+		running, peak int
+	)
+	release := make(chan struct{})
+	// the rebuild fails once the service containers are listed, after the release
+	apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, moby.ContainerListOptions) ([]moby.Container, error) {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil, errors.New("engine unavailable")
+	}).AnyTimes()
+	cli.EXPECT().Client().Return(apiClient).AnyTimes()
+
+	service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+	proj := &types.Project{
+		Name:     "myproject",
+		Services: []types.ServiceConfig{{Name: "web"}, {Name: "worker"}, {Name: "api"}},
+	}
+	// the triggers of both watched services rebuild the api service
+	var wg gosync.WaitGroup
+	for _, watched := range []string{"web", "worker"} {
+		wg.Add(1)
+		go func(watched string) {
+			defer wg.Done()
+			err := service.rebuildWatchedService(context.Background(), proj, watched, api.WatchOptions{}, []fileEvent{
+				{Action: WatchActionRebuild, PathMapping: sync.PathMapping{HostPath: "/src/go.mod"}, RebuildTargets: "api"},
+			}, nil)
+			assert.ErrorContains(t, err, "engine unavailable")
+		}(watched)
+	}
+
+	assert.Check(t, poll(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return running == 1
+	}))
+	// the second rebuild waits for the in-flight one
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, peak, 1)
+}
+
+func TestServiceLocks_Canceled(t *testing.T) {
+	var locks serviceLocks
+	unlock, err := locks.lock(context.Background(), []string{"p/api", "p/web"})
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = locks.lock(ctx, []string{"p/db", "p/web"})
+	assert.ErrorIs(t, err, context.Canceled)
+	// the lock taken before the cancellation is released
+	unlockDB, err := locks.lock(context.Background(), []string{"p/db"})
+	assert.NilError(t, err)
+	unlockDB()
+
+	unlock()
+	unlock, err = locks.lock(context.Background(), []string{"p/web"})
+	assert.NilError(t, err)
+	unlock()
+}
+
+func TestGetSyncImplementation(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+	service := composeService{dockerCli: cli}
+	proj := &types.Project{Name: "myproject"}
+	tests := []struct {
+		name     string
+		env      string
+		backend  SyncBackend
+		expected sync.Syncer
+	}{
+		{name: "default", expected: &sync.Tar{}},
+		{name: "env disables tar", env: "0", expected: &sync.DockerCopy{}},
+		{name: "service selects tar", env: "0", backend: SyncBackendTar, expected: &sync.Tar{}},
+		{name: "service selects cp", env: "1", backend: SyncBackendCopy, expected: &sync.DockerCopy{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("COMPOSE_EXPERIMENTAL_WATCH_TAR", tt.env)
+			}
+			syncer := service.getSyncImplementation(proj, api.WatchOptions{}, DevelopmentConfig{SyncBackend: tt.backend}, nil)
+			assert.Equal(t, reflect.TypeOf(syncer), reflect.TypeOf(tt.expected))
+		})
+	}
+}
+
+func TestLoadDevelopmentConfig_SyncBackend(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	newService := func(backend string) types.ServiceConfig {
+		return types.ServiceConfig{
+			Name: "test",
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{"sync_backend": backend},
+			},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService("cp"), project)
+	assert.NilError(t, err)
+	assert.Equal(t, config.SyncBackend, SyncBackendCopy)
+
+	_, err = loadDevelopmentConfig(newService("rsync"), project)
+	assert.ErrorContains(t, err, `invalid sync_backend "rsync"`)
+}
+
+func TestLoadDevelopmentConfig_Exec(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	newService := func(trigger map[string]interface{}) types.ServiceConfig {
+		return types.ServiceConfig{
+			Name: "test",
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{
+					"watch": []interface{}{trigger},
+				},
+			},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService(map[string]interface{}{
+		"path": "src", "action": "exec", "exec": map[string]interface{}{"command": "npm run 'build:dev'", "working_dir": "/app"},
+	}), project)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, config.Watch[0].Exec, &TriggerExec{Command: types.ShellCommand{"npm", "run", "build:dev"}, WorkingDir: "/app"})
+
+	config, err = loadDevelopmentConfig(newService(map[string]interface{}{
+		"path": "src", "action": "exec", "exec": map[string]interface{}{"command": []interface{}{"flask", "db", "upgrade"}},
+	}), project)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, config.Watch[0].Exec, &TriggerExec{Command: types.ShellCommand{"flask", "db", "upgrade"}})
+
+	config, err = loadDevelopmentConfig(newService(map[string]interface{}{
+		"path": "src", "action": "exec", "exec": map[string]interface{}{"command": "npm test", "tty": true},
+	}), project)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, config.Watch[0].Exec, &TriggerExec{Command: types.ShellCommand{"npm", "test"}, Tty: true})
+
+	_, err = loadDevelopmentConfig(newService(map[string]interface{}{
+		"path": "src", "action": "exec",
+	}), project)
+	assert.ErrorContains(t, err, "'exec' action requires a command")
+
+	_, err = loadDevelopmentConfig(newService(map[string]interface{}{
+		"path": "src", "action": "sync", "target": "/app", "exec": map[string]interface{}{"command": "make"},
+	}), project)
+	assert.ErrorContains(t, err, "exec only applies to the 'exec' action")
+}
+
+func TestHandleWatchBatch_Exec(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	var stderr bytes.Buffer
+	cli.EXPECT().Err().Return(&stderr).AnyTimes()
+	apiClient := mocks.NewMockAPIClient(mockCtrl)
+	apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return([]moby.Container{
+		runningContainer("test", "123"),
+	}, nil).AnyTimes()
+	cli.EXPECT().Client().Return(apiClient).AnyTimes()
+	syncer := &recordingSyncer{}
+
+	exec := &TriggerExec{Command: types.ShellCommand{"npm", "run", "build"}, WorkingDir: "/app"}
+	apiClient.EXPECT().ContainerExecCreate(gomock.Any(), "123", moby.ExecConfig{
+		Cmd:          []string{"npm", "run", "build"},
+		WorkingDir:   "/app",
+		AttachStdout: true,
+		AttachStderr: true,
+	}).DoAndReturn(func(_ context.Context, _ string, _ moby.ExecConfig) (moby.IDResponse, error) {
+		assert.Equal(t, len(syncer.synced), 1, "sync must happen before exec")
+		return moby.IDResponse{ID: "exec1"}, nil
+	}).Times(1)
+	apiClient.EXPECT().ContainerExecAttach(gomock.Any(), "exec1", gomock.Any()).DoAndReturn(
+		func(context.Context, string, moby.ExecStartCheck) (moby.HijackedResponse, error) {
+			client, server := net.Pipe()
+			go func() {
+				_, _ = stdcopy.NewStdWriter(server, stdcopy.Stdout).Write([]byte("build done\n"))
+				_ = server.Close()
+			}()
+			return moby.NewHijackedResponse(client, ""), nil
+		}).Times(1)
+	apiClient.EXPECT().ContainerExecStart(gomock.Any(), "exec1", gomock.Any()).Return(nil).Times(1)
+	apiClient.EXPECT().ContainerExecInspect(gomock.Any(), "exec1").Return(moby.ContainerExecInspect{ExitCode: 0}, nil).Times(1)
+	service := composeService{dockerCli: cli}
+
+	proj := &types.Project{
+		Name:     "myproject",
+		Services: []types.ServiceConfig{{Name: "test"}},
+	}
+	err := service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{}, []fileEvent{
+		{Action: WatchActionExec, Exec: exec, PathMapping: sync.PathMapping{HostPath: "/src/a.ts"}},
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/src/a.ts", ContainerPath: "/app/a.ts"}},
+		{Action: WatchActionExec, Exec: exec, PathMapping: sync.PathMapping{HostPath: "/src/b.ts"}},
+	}, syncer, nil)
+	assert.NilError(t, err)
+	assert.Check(t, strings.Contains(stderr.String(), `Running "npm run build" in test after changes were detected`))
+	assert.Check(t, strings.Contains(stderr.String(), "build done\n"))
+}
+
+func TestTriggerExecConfig(t *testing.T) {
+	exec := &TriggerExec{Command: types.ShellCommand{"npm", "test"}, WorkingDir: "/app"}
+	assert.DeepEqual(t, triggerExecConfig(exec), moby.ExecConfig{
+		Cmd:          []string{"npm", "test"},
+		WorkingDir:   "/app",
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	exec.Tty = true
+	assert.Check(t, triggerExecConfig(exec).Tty)
+}
+
+func TestHandleWatchBatch_ExecTty(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	var stderr bytes.Buffer
+	cli.EXPECT().Err().Return(&stderr).AnyTimes()
+	apiClient := mocks.NewMockAPIClient(mockCtrl)
+	apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return([]moby.Container{
+		runningContainer("test", "123"),
+	}, nil).AnyTimes()
+	cli.EXPECT().Client().Return(apiClient).AnyTimes()
+
+	exec := &TriggerExec{Command: types.ShellCommand{"npm", "test"}, Tty: true}
+	apiClient.EXPECT().ContainerExecCreate(gomock.Any(), "123", moby.ExecConfig{
+		Cmd:          []string{"npm", "test"},
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          true,
+	}).Return(moby.IDResponse{ID: "exec1"}, nil).Times(1)
+	startCheck := moby.ExecStartCheck{Tty: true}
+	apiClient.EXPECT().ContainerExecAttach(gomock.Any(), "exec1", startCheck).DoAndReturn(
+		func(context.Context, string, moby.ExecStartCheck) (moby.HijackedResponse, error) {
+			client, server := net.Pipe()
+			go func() {
+				// a terminal output isn't multiplexed
+				_, _ = server.Write([]byte("\x1b[32m3 passing\x1b[0m\n"))
+				_ = server.Close()
+			}()
+			return moby.NewHijackedResponse(client, ""), nil
+		}).Times(1)
+	apiClient.EXPECT().ContainerExecStart(gomock.Any(), "exec1", startCheck).Return(nil).Times(1)
+	apiClient.EXPECT().ContainerExecInspect(gomock.Any(), "exec1").Return(moby.ContainerExecInspect{ExitCode: 0}, nil).Times(1)
+	service := composeService{dockerCli: cli}
+
+	proj := &types.Project{
+		Name:     "myproject",
+		Services: []types.ServiceConfig{{Name: "test"}},
+	}
+	err := service.handleWatchBatch(context.Background(), proj, "test", api.WatchOptions{}, []fileEvent{
+		{Action: WatchActionExec, Exec: exec, PathMapping: sync.PathMapping{HostPath: "/src/a.ts"}},
+	}, &recordingSyncer{}, nil)
+	assert.NilError(t, err)
+	assert.Check(t, strings.Contains(stderr.String(), "\x1b[32m3 passing\x1b[0m\n"), stderr.String())
+}
+
+func TestDebounceBatching_ExecTriggers(t *testing.T) {
+	ch := make(chan fileEvent)
+	clock := clockwork.NewFakeClock()
+	ctx, stop := context.WithCancel(context.Background())
+	t.Cleanup(stop)
+
+	eventBatchCh := batchDebounceEvents(ctx, clock, quietPeriod, 0, 0, ch, nil)
+	lint := &TriggerExec{Command: types.ShellCommand{"npm", "run", "lint"}}
+	build := &TriggerExec{Command: types.ShellCommand{"npm", "run", "build"}}
+	// the same path matched by two exec triggers runs both commands
+	ch <- fileEvent{Action: WatchActionExec, Exec: lint, PathMapping: sync.PathMapping{HostPath: "/src/a.ts"}}
+	ch <- fileEvent{Action: WatchActionExec, Exec: build, PathMapping: sync.PathMapping{HostPath: "/src/a.ts"}}
+	clock.BlockUntil(1)
+	clock.Advance(quietPeriod)
+	select {
+	case batch := <-eventBatchCh:
+		assert.Equal(t, len(batch), 2)
+	case <-time.After(50 * time.Millisecond):
+		t.Fatal("timed out waiting for events")
+	}
+}
+
+func TestWatch_WatcherErrors(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+
+	start := func(t *testing.T, options api.WatchOptions) (testWatcher, *fakeSyncer, clockwork.FakeClock, chan error) {
+		watcher := testWatcher{
+			events: make(chan watch.FileEvent),
+			errors: make(chan error),
+		}
+		syncer := newFakeSyncer()
+		clock := clockwork.NewFakeClock()
+		service := composeService{dockerCli: cli, clock: clock}
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		done := make(chan error, 1)
+		go func() {
+			done <- service.watch(ctx, &types.Project{Services: []types.ServiceConfig{{Name: "test"}}}, "test", options,
+				watcher, syncer, &serviceWatch{}, DevelopmentConfig{Watch: []Trigger{{Path: "/sync", Action: "sync", Target: "/work"}}})
+		}()
+		return watcher, syncer, clock, done
+	}
+	recoverable := fmt.Errorf("watching /sync: %w", syscall.EMFILE)
+
+	t.Run("recoverable error keeps watching", func(t *testing.T) {
+		watcher, syncer, clock, done := start(t, api.WatchOptions{})
+		watcher.errors <- recoverable
+		watcher.Events() <- watch.NewFileEvent("/sync/changed")
+		assert.Check(t, poll(func() bool {
+			clock.Advance(quietPeriod)
+			select {
+			case actual := <-syncer.synced:
+				assert.DeepEqual(t, actual, []sync.PathMapping{{HostPath: "/sync/changed", ContainerPath: "/work/changed"}})
+				return true
+			case <-time.After(10 * time.Millisecond):
+				return false
+			}
+		}), "changes must still be synced after a recoverable error")
+		select {
+		case err := <-done:
+			t.Fatalf("watch stopped: %v", err)
+		default:
+		}
+	})
+
+	t.Run("fatal error stops watching the service only", func(t *testing.T) {
+		watcher, _, _, done := start(t, api.WatchOptions{})
+		watcher.errors <- errors.New("watched directory removed")
+		select {
+		case err := <-done:
+			assert.Check(t, errors.As(err, &watcherStoppedError{}), "other services must keep being watched: %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("watch didn't stop")
+		}
+	})
+
+	t.Run("strict mode makes any error fatal", func(t *testing.T) {
+		watcher, _, _, done := start(t, api.WatchOptions{Strict: true})
+		watcher.errors <- recoverable
+		select {
+		case err := <-done:
+			assert.ErrorIs(t, err, syscall.EMFILE)
+		case <-time.After(time.Second):
+			t.Fatal("watch didn't stop")
+		}
+	})
+}
+
+func TestWatch_WatcherFailed(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+	clock := clockwork.NewFakeClock()
+	service := composeService{dockerCli: cli, clock: clock}
+
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	newServiceWatcher = func([]string, watch.PathMatcher) (watch.Notify, error) {
+		return watcher, nil
+	}
+	t.Cleanup(func() {
+		newServiceWatcher = startWatcher
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- service.Watch(context.Background(), newWatchProject(t, t.TempDir()), nil, api.WatchOptions{})
+	}()
+	// the only watched service isn't watched anymore
+	watcher.errors <- errors.New("watched directory removed")
+	select {
+	case err := <-done:
+		assert.ErrorContains(t, err, "service test: watching files: watched directory removed")
+	case <-time.After(time.Second):
+		t.Fatal("watch didn't stop")
+	}
+}
+
+func TestWatchIgnoreMatcher_Custom(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "main.go"), nil, 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "main.tmp"), nil, 0o644))
+	proj := newWatchProject(t, dir)
+	service := proj.Services[0]
+	config := DevelopmentConfig{Watch: []Trigger{{Path: dir, Action: "sync", Target: "/app"}}}
+	var requested []string
+	options := api.WatchOptions{
+		IgnoreMatcher: func(service types.ServiceConfig) (api.WatchPathMatcher, error) {
+			requested = append(requested, service.Name)
+			return watch.NewDockerPatternMatcher(dir, []string{"**/*.tmp"})
+		},
+	}
+
+	t.Run("watcher", func(t *testing.T) {
+		ignore, err := watchIgnoreMatcher(proj, service, config, options)
+		assert.NilError(t, err)
+		watcher, err := startWatcher([]string{dir}, ignore)
+		assert.NilError(t, err)
+		t.Cleanup(func() {
+			_ = watcher.Close()
+		})
+
+		assert.NilError(t, os.WriteFile(filepath.Join(dir, "scratch.tmp"), []byte("ignored"), 0o644))
+		assert.NilError(t, os.WriteFile(filepath.Join(dir, "util.go"), []byte("package main"), 0o644))
+		for {
+			select {
+			case e := <-watcher.Events():
+				assert.Check(t, filepath.Ext(e.Path()) != ".tmp", "unexpected event for %s", e.Path())
+				if e.Path() == filepath.Join(dir, "util.go") {
+					return
+				}
+			case err := <-watcher.Errors():
+				t.Fatal(err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the change to be detected")
+			}
+		}
+	})
+
+	t.Run("initial sync", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		cli := mocks.NewMockCli(mockCtrl)
+		cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+		s := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+		syncer := &recordingSyncer{}
+		assert.NilError(t, s.forceSync(context.Background(), proj, service, config, options, syncer, nil))
+		for _, mappings := range syncer.synced {
+			for _, m := range mappings {
+				assert.Check(t, filepath.Ext(m.HostPath) != ".tmp", "unexpected sync of %s", m.HostPath)
+			}
+		}
+		assert.Check(t, len(syncer.synced) == 1)
+	})
+	assert.DeepEqual(t, requested, []string{"test", "test"})
+
+	ignore, err := watchIgnoreMatcher(proj, service, config, api.WatchOptions{
+		IgnoreMatcher: func(types.ServiceConfig) (api.WatchPathMatcher, error) {
+			return nil, errors.New("invalid pattern")
+		},
+	})
+	assert.Check(t, ignore == nil)
+	assert.Error(t, err, "service test: invalid pattern")
+}
+
+func TestCheckSyncFeedbackLoop(t *testing.T) {
+	volumes := []types.ServiceVolumeConfig{
+		{Type: types.VolumeTypeBind, Source: "/project/src", Target: "/app", Bind: &types.ServiceVolumeBind{}},
+		{Type: types.VolumeTypeVolume, Source: "cache", Target: "/cache"},
+	}
+	assets := Trigger{Path: "/project/assets", Action: "sync", Target: "/app/public"}
+	src := Trigger{Path: "/project/src/lib", Action: "rebuild"}
+
+	// the synced assets are written to /project/src/public, which is watched
+	err := checkSyncFeedbackLoop([]Trigger{assets, src}, []string{assets.Path, "/project/src"}, volumes)
+	assert.ErrorContains(t, err, "watch rule for /project/assets syncs to /app/public, bind mounted from /project/src/public "+
+		"which is watched as /project/src: each sync would trigger another one")
+	// the synced directory contains a watched path
+	err = checkSyncFeedbackLoop([]Trigger{assets}, []string{assets.Path, "/project/src/public/img"}, volumes)
+	assert.ErrorContains(t, err, "each sync would trigger another one")
+
+	// not watched, or not bind mounted
+	assert.NilError(t, checkSyncFeedbackLoop([]Trigger{assets, src}, []string{assets.Path, src.Path}, volumes))
+	assert.NilError(t, checkSyncFeedbackLoop([]Trigger{{Path: "/project/assets", Action: "sync", Target: "/cache/assets"}},
+		[]string{"/project/assets", "/project/src"}, volumes))
+	// only syncs write to the containers
+	assert.NilError(t, checkSyncFeedbackLoop([]Trigger{{Path: "/project/assets", Action: "restart", Target: "/app"}},
+		[]string{"/project/assets", "/project/src"}, volumes))
+	// a forced rule syncing over its own bind mount
+	forced := Trigger{Path: "/project/src", Action: "sync", Target: "/app", Force: true}
+	assert.NilError(t, checkSyncFeedbackLoop([]Trigger{forced}, []string{forced.Path}, volumes))
+}
+
+func TestIsRemoteDockerHost(t *testing.T) {
+	for host, remote := range map[string]bool{
+		"":                               false,
+		"unix:///var/run/docker.sock":    false,
+		"npipe:////./pipe/docker_engine": false,
+		"tcp://127.0.0.1:2375":           false,
+		"tcp://localhost:2375":           false,
+		"tcp://[::1]:2375":               false,
+		"tcp://192.168.1.10:2376":        true,
+		"tcp://devbox:2376":              true,
+		"ssh://user@devbox":              true,
+	} {
+		assert.Equal(t, isRemoteDockerHost(host), remote, host)
+	}
+}
+
+func TestCheckIfPathAlreadyBindMounted(t *testing.T) {
+	volumes := func(source string) []types.ServiceVolumeConfig {
+		return []types.ServiceVolumeConfig{
+			{Type: types.VolumeTypeVolume, Source: "data", Target: "/data"},
+			{Type: types.VolumeTypeBind, Source: source, Target: "/app", Bind: &types.ServiceVolumeBind{}},
+		}
+	}
+	tests := []struct {
+		source    string
+		watchPath string
+		expected  bool
+	}{
+		{source: "/src/app", watchPath: "/src/app", expected: true},
+		{source: "/src/app", watchPath: "/src/app/lib", expected: true},
+		{source: "/src/app/", watchPath: "/src/app/lib", expected: true},
+		{source: "/src/app", watchPath: "/src/app-extra", expected: false},
+		{source: "/src/app", watchPath: "/src/app2/lib", expected: false},
+		{source: "/src/app", watchPath: "/src", expected: false},
+		{source: "/", watchPath: "/src/app", expected: true},
+		{source: `C:\Users\me\app`, watchPath: `C:\Users\me\app\lib`, expected: true},
+		{source: `C:\Users\me\app`, watchPath: `c:\users\ME\App\lib`, expected: true},
+		{source: `C:\Users\me\app`, watchPath: `C:/Users/me/app/lib`, expected: true},
+		{source: `C:\Users\me\app`, watchPath: `C:\Users\me\app-extra`, expected: false},
+		{source: `\\server\share\app`, watchPath: `\\server\share\app\lib`, expected: true},
+	}
+	for _, tt := range tests {
+		assert.Check(t, checkIfPathAlreadyBindMounted(tt.watchPath, volumes(tt.source)) == tt.expected,
+			"%s in %s", tt.watchPath, tt.source)
+	}
+	assert.Check(t, !checkIfPathAlreadyBindMounted("/data", volumes("/src/app")), "named volumes aren't bind mounts")
+}
+
+func TestServiceIgnoreMatcher_OutsideBuildContext(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	buildContext := filepath.Join(dir, "app")
+	shared := filepath.Join(dir, "shared")
+	assert.NilError(t, os.MkdirAll(buildContext, 0o755))
+	assert.NilError(t, os.MkdirAll(shared, 0o755))
+	// patterns resolved relative to the context can still match paths outside of it
+	assert.NilError(t, os.WriteFile(filepath.Join(buildContext, ".dockerignore"), []byte("*.log\n../shared\n"), 0o644))
+
+	service := types.ServiceConfig{Name: "test", Build: &types.BuildConfig{Context: buildContext}}
+	trigger := Trigger{Path: shared, Action: "sync", Target: "/shared", Ignore: []string{"*.tmp"}}
+	assert.Check(t, isOutsideBuildContext(service, trigger.Path))
+	assert.Check(t, !isOutsideBuildContext(service, filepath.Join(buildContext, "src")))
+
+	serviceIgnore, err := serviceIgnoreMatcher(&types.Project{}, service, DevelopmentConfig{})
+	assert.NilError(t, err)
+	triggerIgnore, err := triggerIgnoreMatcher(trigger)
+	assert.NilError(t, err)
+	ignore := watch.NewCompositeMatcher(serviceIgnore, triggerIgnore)
+
+	ignored, err := serviceIgnore.Matches(filepath.Join(buildContext, "debug.log"))
+	assert.NilError(t, err)
+	assert.Check(t, ignored, ".dockerignore applies in the build context")
+
+	events := maybeFileEvents(trigger, filepath.Join(shared, "lib.go"), ignore, nil, nil)
+	assert.Check(t, len(events) == 1, ".dockerignore doesn't apply outside the build context")
+	ignoredDir, err := serviceIgnore.MatchesEntireDir(shared)
+	assert.NilError(t, err)
+	assert.Check(t, !ignoredDir)
+
+	events = maybeFileEvents(trigger, filepath.Join(shared, "scratch.tmp"), ignore, nil, nil)
+	assert.Check(t, len(events) == 0, "trigger ignores still apply outside the build context")
+}
+
+func TestWatch_InitialSync(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	for _, name := range []string{"main.go", "lib/util.go", "lib/util.tmp"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NilError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		assert.NilError(t, os.WriteFile(p, nil, 0o644))
+	}
+	proj := newWatchProject(t, dir)
+	config := DevelopmentConfig{Watch: []Trigger{{Path: dir, Action: "sync", Target: "/app", Ignore: []string{"**/*.tmp"}}}}
+
+	start := func(t *testing.T, options api.WatchOptions) (testWatcher, *fakeSyncer, clockwork.FakeClock) {
+		watcher := testWatcher{
+			events: make(chan watch.FileEvent),
+			errors: make(chan error),
+		}
+		syncer := newFakeSyncer()
+		clock := clockwork.NewFakeClock()
+		service := composeService{dockerCli: cli, clock: clock}
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		go func() {
+			_ = service.watch(ctx, proj, "test", options, watcher, syncer, &serviceWatch{}, config)
+		}()
+		return watcher, syncer, clock
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		_, syncer, _ := start(t, api.WatchOptions{InitialSync: true})
+		select {
+		case actual := <-syncer.synced:
+			require.ElementsMatch(t, []sync.PathMapping{
+				{HostPath: filepath.Join(dir, "main.go"), ContainerPath: "/app/main.go"},
+				{HostPath: filepath.Join(dir, "lib", "util.go"), ContainerPath: "/app/lib/util.go"},
+			}, actual)
+		case <-time.After(time.Second):
+			t.Fatal("existing files weren't synced on start")
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		watcher, syncer, clock := start(t, api.WatchOptions{})
+		watcher.Events() <- watch.NewFileEvent(filepath.Join(dir, "main.go"))
+		assert.Check(t, poll(func() bool {
+			clock.Advance(quietPeriod)
+			select {
+			case actual := <-syncer.synced:
+				// the first sync must be the change, not the existing files
+				assert.DeepEqual(t, actual, []sync.PathMapping{{HostPath: filepath.Join(dir, "main.go"), ContainerPath: "/app/main.go"}})
+				return true
+			case <-time.After(10 * time.Millisecond):
+				return false
+			}
+		}))
+	})
+}
+
+func TestOverlappingTriggers(t *testing.T) {
+	triggers := []Trigger{
+		{Path: "/src", Action: "sync", Target: "/app"},
+		{Path: "/src/lib", Action: "sync", Target: "/app/lib"},
+		{Path: "/src/package.json", Action: "rebuild"},
+		{Path: "/srcs", Action: "sync", Target: "/other"},
+	}
+	assert.DeepEqual(t, overlappingTriggers(triggers), [][2]int{{0, 1}})
+}
+
+func TestWatch_OverlappingTriggers(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	syncer := newFakeSyncer()
+	clock := clockwork.NewFakeClock()
+	service := composeService{dockerCli: cli, clock: clock}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		_ = service.watch(ctx, &types.Project{Services: []types.ServiceConfig{{Name: "test"}}}, "test", api.WatchOptions{},
+			watcher, syncer, &serviceWatch{}, DevelopmentConfig{Watch: []Trigger{
+				{Path: "/src", Action: "sync", Target: "/app"},
+				{Path: "/src/lib", Action: "sync", Target: "/app/lib"},
+			}})
+	}()
+
+	watcher.Events() <- watch.NewFileEvent("/src/lib/util.go")
+	assert.Check(t, poll(func() bool {
+		clock.Advance(quietPeriod)
+		select {
+		case actual := <-syncer.synced:
+			assert.DeepEqual(t, actual, []sync.PathMapping{{HostPath: "/src/lib/util.go", ContainerPath: "/app/lib/util.go"}})
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}), "the change must be synced once")
+	clock.Advance(quietPeriod)
+	select {
+	case actual := <-syncer.synced:
+		t.Fatalf("unexpected second sync: %v", actual)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestForceSyncEvents_OverlappingTriggers(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, "lib"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "lib", "util.go"), nil, 0o644))
+	proj := newWatchProject(t, dir)
+	config := DevelopmentConfig{Watch: []Trigger{
+		{Path: dir, Action: "sync", Target: "/app"},
+		{Path: filepath.Join(dir, "lib"), Action: "sync", Target: "/app/lib"},
+	}}
+
+	events, err := (&composeService{}).forceSyncEvents(proj, proj.Services[0], config, api.WatchOptions{}, nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, events, []fileEvent{{
+		Action:      WatchActionSync,
+		Trigger:     "sync " + dir,
+		PathMapping: sync.PathMapping{HostPath: filepath.Join(dir, "lib", "util.go"), ContainerPath: "/app/lib/util.go"},
+	}})
+}
+
+func TestForceSyncEvents_ContainerPathResolver(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NilError(t, err)
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, "src", "pkg"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "src", "pkg", "util.py"), nil, 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "setup.py"), nil, 0o644))
+	proj := newWatchProject(t, dir)
+	config := DevelopmentConfig{Watch: []Trigger{{Path: dir, Action: "sync", Target: "/"}}}
+	var resolved []string
+	options := api.WatchOptions{
+		ContainerPathResolver: func(service string, hostPath string, containerPath string) string {
+			resolved = append(resolved, service+" "+hostPath)
+			if rel, ok := strings.CutPrefix(containerPath, "/src/"); ok {
+				return "/app/" + rel
+			}
+			return containerPath
+		},
+	}
+
+	events, err := (&composeService{}).forceSyncEvents(proj, proj.Services[0], config, options, nil)
+	assert.NilError(t, err)
+	containerPaths := map[string]string{}
+	for _, e := range events {
+		containerPaths[e.HostPath] = e.ContainerPath
+	}
+	assert.DeepEqual(t, containerPaths, map[string]string{
+		filepath.Join(dir, "src", "pkg", "util.py"): "/app/pkg/util.py",
+		filepath.Join(dir, "setup.py"):              "/setup.py",
+	})
+	assert.Check(t, slices.Contains(resolved, proj.Services[0].Name+" "+filepath.Join(dir, "setup.py")), resolved)
+
+	// the container paths are kept as is without a resolver
+	events, err = (&composeService{}).forceSyncEvents(proj, proj.Services[0], config, api.WatchOptions{}, nil)
+	assert.NilError(t, err)
+	for _, e := range events {
+		assert.Check(t, !strings.HasPrefix(e.ContainerPath, "/app/"), e.ContainerPath)
+	}
+}
+
+func TestResolveContainerPaths_SkipsRebuildEvents(t *testing.T) {
+	options := api.WatchOptions{
+		ContainerPathResolver: func(string, string, string) string {
+			return "/resolved"
+		},
+	}
+	events := resolveContainerPaths(options, "api", []fileEvent{
+		{Action: WatchActionRebuild, PathMapping: sync.PathMapping{HostPath: "/src/go.mod"}},
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/src/main.go", ContainerPath: "/app/main.go"}},
+	})
+	assert.Equal(t, events[0].ContainerPath, "")
+	assert.Equal(t, events[1].ContainerPath, "/resolved")
+}
+
+// blockingSyncer blocks each sync until released, reporting the context error once released.
+type blockingSyncer struct {
+	started  chan struct{}
+	release  chan struct{}
+	finished chan error
+}
+
+func newBlockingSyncer() *blockingSyncer {
+	return &blockingSyncer{
+		started:  make(chan struct{}, 1),
+		release:  make(chan struct{}),
+		finished: make(chan error, 1),
+	}
+}
+
+func (b *blockingSyncer) Sync(ctx context.Context, _ types.ServiceConfig, _ []sync.PathMapping) error {
+	b.started <- struct{}{}
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+	}
+	b.finished <- ctx.Err()
+	return ctx.Err()
+}
+
+func TestWatch_DrainOnCancel(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+
+	start := func(t *testing.T) (*blockingSyncer, clockwork.FakeClock, context.CancelFunc, chan error) {
+		watcher := testWatcher{
+			events: make(chan watch.FileEvent),
+			errors: make(chan error),
+		}
+		syncer := newBlockingSyncer()
+		clock := clockwork.NewFakeClock()
+		service := composeService{dockerCli: cli, clock: clock}
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		done := make(chan error, 1)
+		go func() {
+			done <- service.watch(ctx, &types.Project{Services: []types.ServiceConfig{{Name: "test"}}}, "test", api.WatchOptions{},
+				watcher, syncer, &serviceWatch{}, DevelopmentConfig{Watch: []Trigger{{Path: "/sync", Action: "sync", Target: "/work"}}})
+		}()
+		watcher.Events() <- watch.NewFileEvent("/sync/changed")
+		assert.Check(t, poll(func() bool {
+			clock.Advance(quietPeriod)
+			select {
+			case <-syncer.started:
+				return true
+			case <-time.After(10 * time.Millisecond):
+				return false
+			}
+		}), "sync didn't start")
+		return syncer, clock, cancel, done
+	}
+
+	t.Run("in-flight sync completes", func(t *testing.T) {
+		syncer, _, cancel, done := start(t)
+		cancel()
+		select {
+		case err := <-done:
+			t.Fatalf("watch returned before the sync completed: %v", err)
+		case <-time.After(20 * time.Millisecond):
+		}
+		close(syncer.release)
+		assert.NilError(t, <-syncer.finished, "the sync must not be cancelled")
+		select {
+		case err := <-done:
+			assert.NilError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("watch didn't stop")
+		}
+	})
+
+	t.Run("in-flight sync is cancelled after the timeout", func(t *testing.T) {
+		syncer, clock, cancel, done := start(t)
+		cancel()
+		assert.Check(t, poll(func() bool {
+			clock.Advance(watchDrainTimeout)
+			select {
+			case err := <-syncer.finished:
+				assert.ErrorIs(t, err, context.Canceled)
+				return true
+			case <-time.After(10 * time.Millisecond):
+				return false
+			}
+		}), "the sync must be cancelled")
+		select {
+		case err := <-done:
+			assert.NilError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("watch didn't stop")
+		}
+	})
+}
+
+func TestWatch_Deletion(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	cli.EXPECT().Err().Return(io.Discard).AnyTimes()
+
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	syncer := newFakeSyncer()
+	clock := clockwork.NewFakeClock()
+	service := composeService{dockerCli: cli, clock: clock}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		_ = service.watch(ctx, &types.Project{Services: []types.ServiceConfig{{Name: "test"}}}, "test", api.WatchOptions{},
+			watcher, syncer, &serviceWatch{}, DevelopmentConfig{Watch: []Trigger{{Path: "/sync", Action: "sync", Target: "/work"}}})
+	}()
+
+	watcher.Events() <- watch.NewFileEventWithKind("/sync/removed", watch.FileDeleted)
+	assert.Check(t, poll(func() bool {
+		clock.Advance(quietPeriod)
+		select {
+		case actual := <-syncer.synced:
+			assert.DeepEqual(t, actual, []sync.PathMapping{
+				{HostPath: "/sync/removed", ContainerPath: "/work/removed", Kind: watch.FileDeleted},
+			})
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}), "the deletion must be synced")
+}
+
+func TestStartWatcher_Polling(t *testing.T) {
+	t.Setenv(watch.PollEnvVar, "1")
+	dir := t.TempDir()
+	watcher, err := startWatcher([]string{dir}, watch.EmptyMatcher{})
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		_ = watcher.Close()
+	})
+	assert.Equal(t, fmt.Sprintf("%T", watcher), "*watch.pollNotify")
+}
+
+func TestDebounceBatching_MaxSize(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	t.Cleanup(stop)
+
+	ch := make(chan fileEvent)
+	clock := clockwork.NewFakeClock()
+	eventBatchCh := batchDebounceEvents(ctx, clock, quietPeriod, 0, 1000, ch, nil)
+	go func() {
+		for i := 0; i < 5000; i++ {
+			ch <- fileEvent{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: fmt.Sprintf("/src/%d", i)}}
+		}
+	}()
+
+	// batches are flushed as soon as they reach the max size, without waiting for the quiet period
+	seen := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		select {
+		case batch := <-eventBatchCh:
+			assert.Equal(t, len(batch), 1000)
+			for _, e := range batch {
+				seen[e.HostPath] = true
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for batch %d", i)
+		}
+	}
+	assert.Equal(t, len(seen), 5000)
+}
+
+func TestWatchRebuildUpOptions(t *testing.T) {
+	proj := &types.Project{Name: "myproject", Services: []types.ServiceConfig{{Name: "test"}}}
+	options := watchRebuildUpOptions(proj, []string{"test"}, false)
+	assert.DeepEqual(t, options.Create.Build, &api.BuildOptions{Services: []string{"test"}})
+	assert.DeepEqual(t, options.Create.Services, []string{"test"})
+	assert.DeepEqual(t, options.Start.Services, []string{"test"})
+
+	options = watchRebuildUpOptions(proj, []string{"test"}, true)
+	assert.Check(t, options.Create.Build.NoCache)
+}
+
+func TestLoadDevelopmentConfig_RebuildTarget(t *testing.T) {
+	project := &types.Project{
+		WorkingDir: t.TempDir(),
+		Services: []types.ServiceConfig{
+			{Name: "api", Build: &types.BuildConfig{Context: "api"}},
+			{Name: "worker", Build: &types.BuildConfig{Context: "worker"}},
+			{Name: "db", Image: "postgres"},
+		},
+	}
+	load := func(trigger map[string]interface{}) (*DevelopmentConfig, error) {
+		trigger["path"] = "lib"
+		return loadWatchedServiceConfig(types.ServiceConfig{
+			Name:  "lib",
+			Image: "lib",
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{"watch": []interface{}{trigger}},
+			},
+		}, project, false)
+	}
+
+	config, err := load(map[string]interface{}{"action": "rebuild", "rebuild_target": "api"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, config.Watch[0].RebuildTarget, types.StringList{"api"})
+	assert.Check(t, !config.requiresBuild(), "the watched service doesn't need a build section")
+
+	config, err = load(map[string]interface{}{"action": "rebuild", "rebuild_target": []interface{}{"api", "worker"}})
+	assert.NilError(t, err)
+	events := maybeFileEvents(config.Watch[0], filepath.Join(config.Watch[0].Path, "lib.go"), watch.EmptyMatcher{}, nil, nil)
+	assert.Check(t, len(events) == 1 && events[0].RebuildTargets == "api,worker")
+
+	_, err = load(map[string]interface{}{"action": "rebuild", "rebuild_target": "unknown"})
+	assert.ErrorContains(t, err, `rebuild_target "unknown" isn't a service of the project`)
+	_, err = load(map[string]interface{}{"action": "rebuild", "rebuild_target": "db"})
+	assert.ErrorContains(t, err, "service db doesn't have a build section, can't be a rebuild_target")
+	_, err = load(map[string]interface{}{"action": "restart", "rebuild_target": "api"})
+	assert.ErrorContains(t, err, "rebuild_target only applies to the 'rebuild' action")
+
+	// only the rebuild action of a list uses it
+	config, err = load(map[string]interface{}{"action": []interface{}{"sync", "rebuild"}, "target": "/lib", "rebuild_target": "api"})
+	assert.NilError(t, err)
+	assert.Equal(t, len(config.Watch), 2)
+	assert.Check(t, config.Watch[0].RebuildTarget == nil)
+	assert.DeepEqual(t, config.Watch[1].RebuildTarget, types.StringList{"api"})
+}
+
+func TestRebuildServices(t *testing.T) {
+	batch := []fileEvent{
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/lib/sync"}},
+		{Action: WatchActionRebuild, RebuildTargets: "api,worker", PathMapping: sync.PathMapping{HostPath: "/lib/a"}},
+		{Action: WatchActionRebuild, RebuildTargets: "api", PathMapping: sync.PathMapping{HostPath: "/lib/b"}},
+	}
+	assert.DeepEqual(t, rebuildServices("lib", batch), []string{"api", "worker"})
+
+	batch = append(batch, fileEvent{Action: WatchActionRebuild, PathMapping: sync.PathMapping{HostPath: "/lib/Dockerfile"}})
+	assert.DeepEqual(t, rebuildServices("lib", batch), []string{"api", "worker", "lib"})
+	assert.DeepEqual(t, watchRebuildUpOptions(&types.Project{}, rebuildServices("lib", batch[:2]), false).Start.Services, []string{"api", "worker"})
+}
+
+func TestHandleWatchBatch_RebuildTarget(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	var out bytes.Buffer
+	cli.EXPECT().Err().Return(&out).AnyTimes()
+
+	service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+	project := &types.Project{Services: []types.ServiceConfig{{Name: "lib"}, {Name: "api"}}}
+	err := service.handleWatchBatch(context.Background(), project, "lib", api.WatchOptions{DryRun: true}, []fileEvent{
+		{Action: WatchActionRebuild, RebuildTargets: "api", PathMapping: sync.PathMapping{HostPath: "/lib/a.go"}},
+	}, newFakeSyncer(), nil)
+	assert.NilError(t, err)
+	assert.Check(t, strings.Contains(out.String(), "would rebuild api after changes were detected"), out.String())
+}
+
+func TestLoadDevelopmentConfig_NoCache(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	newService := func(trigger map[string]interface{}) types.ServiceConfig {
+		trigger["path"] = "src"
+		trigger["no_cache"] = true
+		return types.ServiceConfig{
+			Name:  "test",
+			Build: &types.BuildConfig{Context: "."},
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{"watch": []interface{}{trigger}},
+			},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService(map[string]interface{}{"action": "rebuild"}), project)
+	assert.NilError(t, err)
+	assert.Check(t, config.Watch[0].NoCache)
+	events := maybeFileEvents(config.Watch[0], filepath.Join(config.Watch[0].Path, "go.mod"), watch.EmptyMatcher{}, nil, nil)
+	assert.Check(t, len(events) == 1 && events[0].NoCache)
+
+	_, err = loadDevelopmentConfig(newService(map[string]interface{}{"action": "restart"}), project)
+	assert.ErrorContains(t, err, "no_cache only applies to the 'rebuild' action")
+}
+
+func TestOverflowRebuildBatch(t *testing.T) {
+	batch := []fileEvent{
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/src/a", ContainerPath: "/app/a"}},
+		{Action: WatchActionSync, PathMapping: sync.PathMapping{HostPath: "/src/a", ContainerPath: "/backup/a"}},
+		{Action: WatchActionRestart, OnError: WatchOnErrorStop, PathMapping: sync.PathMapping{HostPath: "/src/config.yaml"}},
+	}
+	assert.DeepEqual(t, overflowRebuildBatch(batch), []fileEvent{
+		{Action: WatchActionRebuild, PathMapping: sync.PathMapping{HostPath: "/src/a"}},
+		{Action: WatchActionRebuild, OnError: WatchOnErrorStop, PathMapping: sync.PathMapping{HostPath: "/src/config.yaml"}},
+	})
+	batch = []fileEvent{{Action: WatchActionRebuild, RebuildTargets: "api", PathMapping: sync.PathMapping{HostPath: "/lib/a.go"}}}
+	assert.DeepEqual(t, overflowRebuildBatch(batch), batch)
+}
+
+// lockedBuffer is a buffer which can be written and read concurrently.
+type lockedBuffer struct {
+	mu  gosync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWriteWatchMessage_Concurrent(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	var out lockedBuffer
+	cli.EXPECT().Err().Return(&out).AnyTimes()
+	service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+
+	const services, lines = 20, 5
+	var wg gosync.WaitGroup
+	for i := 0; i < services; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			service.writeWatchMessage(api.WatchOptions{}, watchMessage{}, func(w io.Writer) {
+				// several writes, which would interleave with the ones of the other services
+				for j := 0; j < lines; j++ {
+					fmt.Fprintf(w, "service %d line %d\n", i, j)
+					runtime.Gosched()
+				}
+			})
+		}()
+	}
+	wg.Wait()
+
+	output := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Equal(t, len(output), services*lines)
+	for start := 0; start < len(output); start += lines {
+		var i int
+		_, err := fmt.Sscanf(output[start], "service %d line 0", &i)
+		assert.NilError(t, err, output[start])
+		for j := 0; j < lines; j++ {
+			assert.Equal(t, output[start+j], fmt.Sprintf("service %d line %d", i, j))
+		}
+	}
+}
+
+func TestWatch_BatchOverflowRebuild(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	var out lockedBuffer
+	cli.EXPECT().Err().Return(&out).AnyTimes()
+
+	watcher := testWatcher{
+		events: make(chan watch.FileEvent),
+		errors: make(chan error),
+	}
+	clock := clockwork.NewFakeClock()
+	service := composeService{dockerCli: cli, clock: clock}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		_ = service.watch(ctx, &types.Project{Services: []types.ServiceConfig{{Name: "test"}}}, "test", api.WatchOptions{DryRun: true},
+			watcher, newFakeSyncer(), &serviceWatch{}, DevelopmentConfig{
+				MaxBatchSize:  1000,
+				BatchOverflow: BatchOverflowRebuild,
+				Watch:         []Trigger{{Path: "/sync", Action: "sync", Target: "/work"}},
+			})
+	}()
+
+	for i := 0; i < 5000; i++ {
+		watcher.Events() <- watch.NewFileEvent(fmt.Sprintf("/sync/%d", i))
+	}
+	// not watched: once received, all the previous changes have been passed to the debouncer
+	watcher.Events() <- watch.NewFileEvent("/elsewhere")
+	clock.Advance(quietPeriod)
+
+	assert.Check(t, poll(func() bool {
+		return strings.Contains(out.String(), "would rebuild test")
+	}), out.String())
+	output := out.String()
+	assert.Check(t, strings.Contains(output, "5000 changes exceed the max batch size of 1000, rebuilding test"))
+	assert.Check(t, !strings.Contains(output, "would sync"), "changes must not be synced")
+}
+
+func TestLoadDevelopmentConfig_BatchOverflow(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	load := func(develop map[string]interface{}, build *types.BuildConfig) (*DevelopmentConfig, error) {
+		develop["watch"] = []interface{}{
+			map[string]interface{}{"path": ".", "action": "sync", "target": "/app"},
+		}
+		return loadDevelopmentConfig(types.ServiceConfig{
+			Name:       "test",
+			Build:      build,
+			Extensions: map[string]interface{}{"x-develop": develop},
+		}, project)
+	}
+
+	config, err := load(map[string]interface{}{"max_batch_size": 1000, "batch_overflow": "rebuild"}, &types.BuildConfig{Context: "."})
+	assert.NilError(t, err)
+	assert.Equal(t, config.MaxBatchSize, 1000)
+	assert.Equal(t, config.BatchOverflow, BatchOverflowRebuild)
+	assert.Check(t, config.requiresBuild())
+
+	_, err = load(map[string]interface{}{"max_batch_size": 1000, "batch_overflow": "rebuild"}, nil)
+	assert.ErrorContains(t, err, "doesn't have a build section")
+	_, err = load(map[string]interface{}{"batch_overflow": "drop"}, nil)
+	assert.ErrorContains(t, err, `invalid batch_overflow "drop"`)
+	_, err = load(map[string]interface{}{"max_batch_size": -1}, nil)
+	assert.ErrorContains(t, err, "max_batch_size can't be negative")
+}
+
+// terminalBuffer is an output reporting to be a terminal, like the streams of the Docker CLI
+type terminalBuffer struct {
+	bytes.Buffer
+}
+
+func (*terminalBuffer) IsTerminal() bool {
+	return true
+}
+
+func TestWatchMessageRenderer(t *testing.T) {
+	newService := func(out io.Writer) *composeService {
+		mockCtrl := gomock.NewController(t)
+		cli := mocks.NewMockCli(mockCtrl)
+		cli.EXPECT().Err().Return(out).AnyTimes()
+		return &composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+	}
+
+	service := newService(&bytes.Buffer{})
+	assert.Equal(t, reflect.TypeOf(service.watchMessageRenderer(api.WatchOptions{})), reflect.TypeOf(logWatchRenderer{}))
+	assert.Equal(t, reflect.TypeOf(service.watchMessageRenderer(api.WatchOptions{JSON: true})), reflect.TypeOf(jsonWatchRenderer{}))
+
+	service = newService(&terminalBuffer{})
+	assert.Equal(t, reflect.TypeOf(service.watchMessageRenderer(api.WatchOptions{})), reflect.TypeOf(statusLineRenderer{}))
+	// JSON lines are written as-is to a terminal
+	assert.Equal(t, reflect.TypeOf(service.watchMessageRenderer(api.WatchOptions{JSON: true})), reflect.TypeOf(jsonWatchRenderer{}))
+}
+
+func TestWriteWatchMessage_StatusLine(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	out := &terminalBuffer{}
+	cli.EXPECT().Err().Return(out).AnyTimes()
+	service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+	prose := func(w io.Writer) {
+		fmt.Fprintln(w, "Syncing web after changes were detected")
+	}
+	paths := []string{"/src/main.go", "/src/util.go"}
+
+	service.writeWatchMessage(api.WatchOptions{}, watchMessage{Service: "web", Action: WatchActionSync, Status: watchMessageStarted, Paths: paths}, prose)
+	service.writeWatchMessage(api.WatchOptions{}, completedWatchMessage("web", WatchActionSync, paths, 0, nil), nil)
+	assert.Equal(t, out.String(), "\r\x1b[Kweb: syncing 2 file(s)...\r\x1b[Kweb: synced 2 file(s), watching for changes")
+
+	// the status line is cleared before the other messages, which scroll
+	out.Reset()
+	fmt.Fprintf(service.watchOutput(), "Restarting web after changes were detected\n")
+	service.writeWatchMessage(api.WatchOptions{}, watchMessage{Service: "web", Action: WatchActionRebuild, Status: watchMessageStarted}, func(w io.Writer) {
+		fmt.Fprintln(w, "Rebuilding web after changes were detected")
+	})
+	assert.Equal(t, out.String(), "\r\x1b[KRestarting web after changes were detected\nRebuilding web after changes were detected\n")
+}
+
+func TestHandleWatchBatch_StatusLineCleared(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	out := &terminalBuffer{}
+	cli.EXPECT().Err().Return(out).AnyTimes()
+	service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+	proj := &types.Project{Services: []types.ServiceConfig{{Name: "web"}}}
+
+	err := service.handleWatchBatch(context.Background(), proj, "web", api.WatchOptions{}, []fileEvent{{
+		Action:      WatchActionSync,
+		PathMapping: sync.PathMapping{HostPath: "/src/main.go", ContainerPath: "/app/main.go"},
+	}}, failingSyncer{sync.ErrNoContainers}, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, out.String(), "\r\x1b[Kweb: syncing 1 file(s)...\r\x1b[Kno running containers for service web; changes not synced\n")
+
+	// the status line of a service instance doesn't leak into another one
+	service.writeWatchMessage(api.WatchOptions{}, completedWatchMessage("web", WatchActionSync, []string{"/src/main.go"}, 0, nil), nil)
+	other := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+	out.Reset()
+	fmt.Fprintln(other.watchOutput(), "watching")
+	assert.Equal(t, out.String(), "watching\n")
+}
+
+func TestLoadDevelopmentConfig_When(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	newService := func(trigger map[string]interface{}) types.ServiceConfig {
+		trigger["path"] = "."
+		return types.ServiceConfig{
+			Name:  "api",
+			Build: &types.BuildConfig{Context: "."},
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{"watch": []interface{}{trigger}},
+			},
+		}
+	}
+
+	config, err := loadDevelopmentConfig(newService(map[string]interface{}{
+		"action": "rebuild", "target": "/app", "when": []interface{}{"go.mod", "go.sum"},
+	}), project)
+	assert.NilError(t, err)
+	trigger := config.Watch[0]
+	when, err := triggerWhenMatcher(trigger)
+	assert.NilError(t, err)
+	var batch []fileEvent
+	for _, p := range []string{"go.mod", "main.go", "internal/util.go"} {
+		batch = append(batch, maybeFileEvents(trigger, filepath.Join(trigger.Path, p), watch.EmptyMatcher{}, when, nil)...)
+	}
+	assert.Equal(t, len(batch), 3)
+	assert.Equal(t, batch[0].Action, WatchActionRebuild)
+	assert.Equal(t, batch[1].Action, WatchActionSync)
+	assert.Equal(t, batch[1].ContainerPath, "/app/main.go")
+	assert.Equal(t, batch[2].Action, WatchActionSync)
+	assert.Equal(t, batch[2].ContainerPath, "/app/internal/util.go")
+
+	mockCtrl := gomock.NewController(t)
+	cli := mocks.NewMockCli(mockCtrl)
+	var out bytes.Buffer
+	cli.EXPECT().Err().Return(&out).AnyTimes()
+	service := composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}
+	proj := &types.Project{Services: []types.ServiceConfig{{Name: "api"}}}
+
+	// only the go.mod change rebuilds the service
+	err = service.handleWatchBatch(context.Background(), proj, "api", api.WatchOptions{DryRun: true}, batch[1:], newFakeSyncer(), nil)
+	assert.NilError(t, err)
+	assert.Check(t, strings.Contains(out.String(), "(dry run) would sync"), out.String())
+	assert.Check(t, !strings.Contains(out.String(), "would rebuild"), out.String())
+	out.Reset()
+	err = service.handleWatchBatch(context.Background(), proj, "api", api.WatchOptions{DryRun: true}, batch, newFakeSyncer(), nil)
+	assert.NilError(t, err)
+	assert.Check(t, strings.Contains(out.String(), "would rebuild api after changes were detected"), out.String())
+
+	// changes not matching are ignored without a target
+	config, err = loadDevelopmentConfig(newService(map[string]interface{}{"action": "rebuild", "when": "go.mod"}), project)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, config.Watch[0].When, types.StringList{"go.mod"})
+	when, err = triggerWhenMatcher(config.Watch[0])
+	assert.NilError(t, err)
+	assert.Check(t, len(maybeFileEvents(config.Watch[0], filepath.Join(config.Watch[0].Path, "main.go"), watch.EmptyMatcher{}, when, nil)) == 0)
+
+	_, err = loadDevelopmentConfig(newService(map[string]interface{}{"action": "sync", "target": "/app", "when": "go.mod"}), project)
+	assert.ErrorContains(t, err, "when only applies to the 'rebuild' action")
+}
+
+func TestWaitForRebuiltServices(t *testing.T) {
+	proj := &types.Project{Name: strings.ToLower(testProject), Services: []types.ServiceConfig{{Name: "api", Scale: 1}}}
+	inspect := func(health string) moby.ContainerJSON {
+		return moby.ContainerJSON{
+			ContainerJSONBase: &moby.ContainerJSONBase{
+				Name:  "/api-1",
+				State: &moby.ContainerState{Status: "running", Health: &moby.Health{Status: health}},
+			},
+			Config: &container.Config{Healthcheck: &container.HealthConfig{Test: []string{"CMD", "true"}}},
+		}
+	}
+	newService := func(t *testing.T) (composeService, *mocks.MockAPIClient) {
+		mockCtrl := gomock.NewController(t)
+		cli := mocks.NewMockCli(mockCtrl)
+		apiClient := mocks.NewMockAPIClient(mockCtrl)
+		cli.EXPECT().Client().Return(apiClient).AnyTimes()
+		apiClient.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return([]moby.Container{testContainer("api", "123", false)}, nil)
+		return composeService{dockerCli: cli, clock: clockwork.NewFakeClock()}, apiClient
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		service, apiClient := newService(t)
+		gomock.InOrder(
+			apiClient.EXPECT().ContainerInspect(gomock.Any(), "123").Return(inspect(moby.Starting), nil),
+			apiClient.EXPECT().ContainerInspect(gomock.Any(), "123").Return(inspect(moby.Healthy), nil),
+		)
+		assert.NilError(t, service.waitForRebuiltServices(context.Background(), proj, []string{"api"}, 10*time.Second))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		service, apiClient := newService(t)
+		apiClient.EXPECT().ContainerInspect(gomock.Any(), "123").Return(inspect(moby.Starting), nil).AnyTimes()
+		err := service.waitForRebuiltServices(context.Background(), proj, []string{"api"}, 700*time.Millisecond)
+		assert.Error(t, err, "api not healthy after 700ms")
+	})
+}
+
+func TestLoadDevelopmentConfig_Wait(t *testing.T) {
+	project := &types.Project{WorkingDir: t.TempDir()}
+	newService := func(trigger map[string]interface{}) types.ServiceConfig {
+		trigger["path"] = "src"
+		return types.ServiceConfig{
+			Name:  "test",
+			Build: &types.BuildConfig{Context: "."},
+			Extensions: map[string]interface{}{
+				"x-develop": map[string]interface{}{"watch": []interface{}{trigger}},
+			},
+		}
+	}
+	waitTimeout := func(trigger map[string]interface{}) time.Duration {
+		config, err := loadDevelopmentConfig(newService(trigger), project)
+		assert.NilError(t, err)
+		events := maybeFileEvents(config.Watch[0], filepath.Join(config.Watch[0].Path, "main.go"), watch.EmptyMatcher{}, nil, nil)
+		assert.Equal(t, len(events), 1)
+		return events[0].WaitTimeout
+	}
+
+	assert.Equal(t, waitTimeout(map[string]interface{}{"action": "rebuild"}), time.Duration(0))
+	assert.Equal(t, waitTimeout(map[string]interface{}{"action": "rebuild", "wait": true}), defaultRebuildWaitTimeout)
+	assert.Equal(t, waitTimeout(map[string]interface{}{"action": "rebuild", "wait": true, "wait_timeout": "30s"}), 30*time.Second)
+
+	_, err := loadDevelopmentConfig(newService(map[string]interface{}{"action": "restart", "wait": true}), project)
+	assert.ErrorContains(t, err, "wait only applies to the 'rebuild' action")
+	_, err = loadDevelopmentConfig(newService(map[string]interface{}{"action": "rebuild", "wait_timeout": "30s"}), project)
+	assert.ErrorContains(t, err, "wait_timeout requires wait to be enabled")
+}
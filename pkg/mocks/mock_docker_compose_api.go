@@ -151,6 +151,20 @@ func (mr *MockServiceMockRecorder) Exec(ctx, projectName, options interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exec", reflect.TypeOf((*MockService)(nil).Exec), ctx, projectName, options)
 }
 
+// ForceSync mocks base method.
+func (m *MockService) ForceSync(ctx context.Context, project *types.Project, service string, options api.WatchOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ForceSync", ctx, project, service, options)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForceSync indicates an expected call of ForceSync.
+func (mr *MockServiceMockRecorder) ForceSync(ctx, project, service, options interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForceSync", reflect.TypeOf((*MockService)(nil).ForceSync), ctx, project, service, options)
+}
+
 // Images mocks base method.
 func (m *MockService) Images(ctx context.Context, projectName string, options api.ImagesOptions) ([]api.ImageSummary, error) {
 	m.ctrl.T.Helper()
@@ -452,6 +466,21 @@ func (mr *MockServiceMockRecorder) Watch(ctx, project, services, options interfa
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Watch", reflect.TypeOf((*MockService)(nil).Watch), ctx, project, services, options)
 }
 
+// WatchStatus mocks base method.
+func (m *MockService) WatchStatus(ctx context.Context) ([]api.WatchSession, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WatchStatus", ctx)
+	ret0, _ := ret[0].([]api.WatchSession)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WatchStatus indicates an expected call of WatchStatus.
+func (mr *MockServiceMockRecorder) WatchStatus(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WatchStatus", reflect.TypeOf((*MockService)(nil).WatchStatus), ctx)
+}
+
 // MockLogConsumer is a mock of LogConsumer interface.
 type MockLogConsumer struct {
 	ctrl     *gomock.Controller
@@ -74,6 +74,26 @@ func LoadDockerIgnore(repoRoot string) (*dockerPathMatcher, error) {
 	return NewDockerPatternMatcher(absRoot, patterns)
 }
 
+// LoadIgnoreFile returns a matcher for the patterns of an ignore file using the `.dockerignore`
+// syntax, relative to the directory of the file.
+func LoadIgnoreFile(path string) (*dockerPathMatcher, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(absPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	patterns, err := ignorefile.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return NewDockerPatternMatcher(filepath.Dir(absPath), patterns)
+}
+
 // Make all the patterns use absolute paths.
 func absPatterns(absRoot string, patterns []string) []string {
 	absPatterns := make([]string, 0, len(patterns))
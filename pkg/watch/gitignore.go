@@ -0,0 +1,90 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package watch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadGitIgnore returns a matcher for the patterns of the `.gitignore` file located at repoRoot.
+//
+// Nested `.gitignore` files are not loaded.
+func LoadGitIgnore(repoRoot string) (*dockerPathMatcher, error) {
+	absRoot, err := filepath.Abs(repoRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(absRoot, ".gitignore"))
+	switch {
+	case os.IsNotExist(err):
+		return NewDockerPatternMatcher(absRoot, nil)
+	case err != nil:
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	patterns, err := readGitIgnorePatterns(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading .gitignore: %w", err)
+	}
+	return NewDockerPatternMatcher(absRoot, patterns)
+}
+
+// readGitIgnorePatterns reads gitignore patterns and translates them to the dockerignore syntax.
+//
+// Unlike dockerignore, a gitignore pattern without a slash matches at any depth, and a
+// pattern with a leading or inner slash is relative to the `.gitignore` location.
+func readGitIgnorePatterns(r io.Reader) ([]string, error) {
+	var patterns []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		negate := false
+		if strings.HasPrefix(line, "!") {
+			negate = true
+			line = line[1:]
+		}
+		// escaped leading characters
+		if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+
+		trimmed := strings.TrimSuffix(line, "/")
+		switch {
+		case strings.HasPrefix(trimmed, "/"):
+			line = strings.TrimPrefix(line, "/")
+		case !strings.Contains(trimmed, "/") && !strings.HasPrefix(trimmed, "**"):
+			line = "**/" + line
+		}
+
+		if negate {
+			line = "!" + line
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
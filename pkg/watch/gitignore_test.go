@@ -0,0 +1,91 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package watch
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadGitIgnorePatterns(t *testing.T) {
+	patterns, err := readGitIgnorePatterns(strings.NewReader(`
+# dependencies
+node_modules/
+/dist
+docs/build
+*.log
+!keep.log
+**/tmp
+\#hash
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"**/node_modules/",
+		"dist",
+		"docs/build",
+		"**/*.log",
+		"!**/keep.log",
+		"**/tmp",
+		"**/#hash",
+	}, patterns)
+}
+
+func TestLoadGitIgnore(t *testing.T) {
+	f := NewTempDirFixture(t)
+	f.WriteFile(".gitignore", "node_modules/\n/dist\n*.log\n!keep.log\n")
+
+	matcher, err := LoadGitIgnore(f.Path())
+	require.NoError(t, err)
+
+	ignored := []string{
+		f.JoinPath("node_modules", "lib", "index.js"),
+		f.JoinPath("web", "node_modules", "lib", "index.js"),
+		f.JoinPath("dist", "app.js"),
+		f.JoinPath("debug.log"),
+		f.JoinPath("logs", "debug.log"),
+	}
+	for _, p := range ignored {
+		ok, err := matcher.Matches(p)
+		if assert.NoErrorf(t, err, "Matching %s", p) {
+			assert.Truef(t, ok, "Path %s should have matched", p)
+		}
+	}
+
+	included := []string{
+		f.JoinPath("src", "main.go"),
+		f.JoinPath("web", "dist", "app.js"),
+		f.JoinPath("keep.log"),
+	}
+	for _, p := range included {
+		ok, err := matcher.Matches(p)
+		if assert.NoErrorf(t, err, "Matching %s", p) {
+			assert.Falsef(t, ok, "Path %s should NOT have matched", p)
+		}
+	}
+}
+
+func TestLoadGitIgnoreMissingFile(t *testing.T) {
+	f := NewTempDirFixture(t)
+	matcher, err := LoadGitIgnore(f.Path())
+	require.NoError(t, err)
+	ok, err := matcher.Matches(f.JoinPath("main.go"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
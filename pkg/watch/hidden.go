@@ -0,0 +1,144 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// HiddenDirPathMatcher ignores every path located in a dot-directory (e.g.
+// `.cache`, `.vscode`) below root, including the directory itself.
+//
+// Dot-files (e.g. `.env`) that are not inside a dot-directory are NOT matched,
+// and paths outside root are never matched. The dot-directories named in allowed
+// (e.g. `.git`) are not considered hidden.
+//
+// The matcher remembers the dot-directories it has seen, from the paths below
+// them or from their type when they existed, so that a deleted dot-directory is
+// still matched rather than taken for a deleted dot-file.
+func HiddenDirPathMatcher(root string, allowed ...string) (PathMatcher, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	return &hiddenDirMatcher{root: absRoot, allowed: allowed, dirs: map[string]struct{}{}}, nil
+}
+
+type hiddenDirMatcher struct {
+	root    string
+	allowed []string
+
+	mu sync.Mutex
+	// dirs are the dot-directories seen, by absolute path
+	dirs map[string]struct{}
+}
+
+func (m *hiddenDirMatcher) Matches(f string) (bool, error) {
+	parts, ok := m.relativeParts(f)
+	if !ok {
+		return false, nil
+	}
+	for i, p := range parts {
+		if !m.isHidden(p) {
+			continue
+		}
+		dir := filepath.Join(m.root, filepath.Join(parts[:i+1]...))
+		if i < len(parts)-1 {
+			m.addDir(dir)
+			return true, nil
+		}
+		// the last element is only ignored if it's a directory, otherwise
+		// it's a plain dot-file
+		if m.isDir(dir) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *hiddenDirMatcher) MatchesEntireDir(f string) (bool, error) {
+	parts, ok := m.relativeParts(f)
+	if !ok {
+		return false, nil
+	}
+	for i, p := range parts {
+		if m.isHidden(p) {
+			m.addDir(filepath.Join(m.root, filepath.Join(parts[:i+1]...)))
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *hiddenDirMatcher) addDir(dir string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dirs[dir] = struct{}{}
+}
+
+// isDir reports whether the path is a directory, or was one when seen last if it
+// no longer exists.
+func (m *hiddenDirMatcher) isDir(p string) bool {
+	info, err := os.Stat(p)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case err == nil && info.IsDir():
+		m.dirs[p] = struct{}{}
+		return true
+	case err == nil:
+		delete(m.dirs, p)
+		return false
+	default:
+		_, seen := m.dirs[p]
+		return seen
+	}
+}
+
+func (m *hiddenDirMatcher) abs(f string) string {
+	if !filepath.IsAbs(f) {
+		return filepath.Join(m.root, f)
+	}
+	return f
+}
+
+// relativeParts splits the path of f relative to the matcher root.
+func (m *hiddenDirMatcher) relativeParts(f string) ([]string, bool) {
+	f = m.abs(f)
+	if !IsChild(m.root, f) {
+		return nil, false
+	}
+	rel, err := filepath.Rel(m.root, f)
+	if err != nil || rel == "." {
+		return nil, false
+	}
+	return strings.Split(rel, string(filepath.Separator)), true
+}
+
+func (m *hiddenDirMatcher) isHidden(name string) bool {
+	return isHiddenName(name) && !slices.Contains(m.allowed, name)
+}
+
+func isHiddenName(name string) bool {
+	return len(name) > 1 && strings.HasPrefix(name, ".") && name != ".."
+}
+
+var _ PathMatcher = &hiddenDirMatcher{}
@@ -0,0 +1,121 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package watch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHiddenDirPathMatcher(t *testing.T) {
+	f := NewTempDirFixture(t)
+	f.MkdirAll(".cache")
+	f.WriteFile(".env", "FOO=bar")
+	f.WriteFile("src/main.go", "package main")
+	f.WriteFile("src/.vscode/settings.json", "{}")
+
+	matcher, err := HiddenDirPathMatcher(f.Path())
+	require.NoError(t, err)
+
+	ignored := []string{
+		f.JoinPath(".cache"),
+		f.JoinPath(".cache", "data.bin"),
+		f.JoinPath("src", ".vscode"),
+		f.JoinPath("src", ".vscode", "settings.json"),
+		"src/.vscode/settings.json",
+	}
+	for _, p := range ignored {
+		ok, err := matcher.Matches(p)
+		if assert.NoErrorf(t, err, "Matching %s", p) {
+			assert.Truef(t, ok, "Path %s should have matched", p)
+		}
+	}
+
+	included := []string{
+		f.Path(),
+		f.JoinPath(".env"),
+		f.JoinPath("src", "main.go"),
+		"/outside/.cache/data.bin",
+	}
+	for _, p := range included {
+		ok, err := matcher.Matches(p)
+		if assert.NoErrorf(t, err, "Matching %s", p) {
+			assert.Falsef(t, ok, "Path %s should NOT have matched", p)
+		}
+	}
+
+	ok, err := matcher.MatchesEntireDir(f.JoinPath(".cache"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = matcher.MatchesEntireDir(f.JoinPath("src"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestHiddenDirPathMatcher_Allowed(t *testing.T) {
+	f := NewTempDirFixture(t)
+	f.WriteFile(".git/HEAD", "ref: refs/heads/main")
+	f.WriteFile(".cache/data.bin", "")
+
+	matcher, err := HiddenDirPathMatcher(f.Path(), ".git")
+	require.NoError(t, err)
+
+	ok, err := matcher.Matches(f.JoinPath(".git", "HEAD"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = matcher.MatchesEntireDir(f.JoinPath(".git"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = matcher.Matches(f.JoinPath(".cache", "data.bin"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestHiddenDirPathMatcher_Deleted(t *testing.T) {
+	f := NewTempDirFixture(t)
+	f.MkdirAll(".cache")
+	f.WriteFile(".tmp/data.bin", "")
+	f.WriteFile(".env", "FOO=bar")
+
+	matcher, err := HiddenDirPathMatcher(f.Path())
+	require.NoError(t, err)
+
+	// seen as a directory, or from a path below it
+	ok, err := matcher.Matches(f.JoinPath(".cache"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = matcher.Matches(f.JoinPath(".tmp", "data.bin"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = matcher.Matches(f.JoinPath(".env"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	f.Rm(".cache")
+	f.Rm(".tmp")
+	f.Rm(".env")
+	for _, p := range []string{".cache", ".tmp"} {
+		ok, err := matcher.Matches(f.JoinPath(p))
+		require.NoError(t, err)
+		assert.Truef(t, ok, "Deleted directory %s should have matched", p)
+	}
+	ok, err = matcher.Matches(f.JoinPath(".env"))
+	require.NoError(t, err)
+	assert.False(t, ok, "Deleted dot-file should NOT have matched")
+}
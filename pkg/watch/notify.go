@@ -23,6 +23,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"syscall"
 
 	"github.com/pkg/errors"
 	"github.com/tilt-dev/fsnotify"
@@ -32,21 +33,73 @@ var (
 	numberOfWatches = expvar.NewInt("watch.naive.numberOfWatches")
 )
 
+// FileEventKind is the kind of change reported for a path.
+type FileEventKind int
+
+const (
+	// FileModified is reported when the content of a path changed, or when the kind of
+	// change is unknown
+	FileModified FileEventKind = iota
+	// FileCreated is reported when a path is created
+	FileCreated
+	// FileDeleted is reported when a path is removed or renamed
+	FileDeleted
+	// FileChmod is reported when only the metadata of a path changed, e.g. its mode bits
+	FileChmod
+)
+
+func (k FileEventKind) String() string {
+	switch k {
+	case FileCreated:
+		return "created"
+	case FileDeleted:
+		return "deleted"
+	case FileChmod:
+		return "chmod"
+	default:
+		return "modified"
+	}
+}
+
+// fsnotifyEventKind returns the kind of change reported by an fsnotify operation, a renamed path
+// being gone from its previous location.
+func fsnotifyEventKind(op fsnotify.Op) FileEventKind {
+	switch {
+	case op&fsnotify.Create == fsnotify.Create:
+		return FileCreated
+	case op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		return FileDeleted
+	case op == fsnotify.Chmod:
+		return FileChmod
+	default:
+		return FileModified
+	}
+}
+
 type FileEvent struct {
 	path string
+	kind FileEventKind
 }
 
 func NewFileEvent(p string) FileEvent {
+	return NewFileEventWithKind(p, FileModified)
+}
+
+func NewFileEventWithKind(p string, kind FileEventKind) FileEvent {
 	if !filepath.IsAbs(p) {
 		panic(fmt.Sprintf("NewFileEvent only accepts absolute paths. Actual: %s", p))
 	}
-	return FileEvent{path: p}
+	return FileEvent{path: p, kind: kind}
 }
 
 func (e FileEvent) Path() string {
 	return e.path
 }
 
+func (e FileEvent) Kind() FileEventKind {
+	return e.kind
+}
+
 type Notify interface {
 	// Start watching the paths set at init time
 	Start() error
@@ -107,6 +160,17 @@ func IsWindowsShortReadError(err error) bool {
 	return runtime.GOOS == "windows" && !errors.Is(err, fsnotify.ErrEventOverflow)
 }
 
+// IsRecoverableError returns true for watcher errors which don't prevent further changes from
+// being detected, even though some changes may have been missed (e.g. event queue overflow or
+// transient file descriptor exhaustion).
+func IsRecoverableError(err error) bool {
+	return errors.Is(err, fsnotify.ErrEventOverflow) ||
+		errors.Is(err, syscall.EMFILE) ||
+		errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.EINTR) ||
+		errors.Is(err, syscall.EAGAIN)
+}
+
 type CompositePathMatcher struct {
 	Matchers []PathMatcher
 }
@@ -142,3 +206,44 @@ func (c CompositePathMatcher) MatchesEntireDir(f string) (bool, error) {
 }
 
 var _ PathMatcher = CompositePathMatcher{}
+
+// NewScopedMatcher returns a matcher applying matcher to root and the paths below it only, e.g.
+// for `.dockerignore` rules which are meaningless outside the build context. Paths below root
+// with its symlinks evaluated are in scope as well.
+func NewScopedMatcher(root string, matcher PathMatcher) PathMatcher {
+	roots := []string{root}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil && resolved != root {
+		roots = append(roots, resolved)
+	}
+	return scopedPathMatcher{roots: roots, matcher: matcher}
+}
+
+type scopedPathMatcher struct {
+	roots   []string
+	matcher PathMatcher
+}
+
+func (s scopedPathMatcher) Matches(f string) (bool, error) {
+	if !s.inScope(f) {
+		return false, nil
+	}
+	return s.matcher.Matches(f)
+}
+
+func (s scopedPathMatcher) MatchesEntireDir(f string) (bool, error) {
+	if !s.inScope(f) {
+		return false, nil
+	}
+	return s.matcher.MatchesEntireDir(f)
+}
+
+func (s scopedPathMatcher) inScope(f string) bool {
+	for _, root := range s.roots {
+		if IsChild(root, f) {
+			return true
+		}
+	}
+	return false
+}
+
+var _ PathMatcher = scopedPathMatcher{}
@@ -24,16 +24,46 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tilt-dev/fsnotify"
 )
 
 // Each implementation of the notify interface should have the same basic
 // behavior.
 
+func TestIsRecoverableError(t *testing.T) {
+	assert.True(t, IsRecoverableError(fsnotify.ErrEventOverflow))
+	assert.True(t, IsRecoverableError(fmt.Errorf("adding watch: %w", syscall.EMFILE)))
+	assert.False(t, IsRecoverableError(syscall.ENOENT))
+	assert.False(t, IsRecoverableError(fmt.Errorf("watcher closed")))
+}
+
+func TestScopedMatcher(t *testing.T) {
+	root := t.TempDir()
+	inner, err := NewDockerPatternMatcher(root, []string{"../shared", "*.log"})
+	require.NoError(t, err)
+	m := NewScopedMatcher(root, inner)
+
+	for path, expected := range map[string]bool{
+		filepath.Join(root, "debug.log"):                     true,
+		filepath.Join(root, "main.go"):                       false,
+		filepath.Join(filepath.Dir(root), "shared"):          false,
+		filepath.Join(filepath.Dir(root), "shared", "a.log"): false,
+	} {
+		matches, err := m.Matches(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, matches, path)
+		entireDir, err := m.MatchesEntireDir(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, entireDir, path)
+	}
+}
+
 func TestWindowsBufferSize(t *testing.T) {
 	orig := os.Getenv(WindowsBufferSizeEnvVar)
 	defer os.Setenv(WindowsBufferSizeEnvVar, orig) //nolint:errcheck
@@ -243,6 +273,9 @@ func TestRemove(t *testing.T) {
 		t.Fatal(err)
 	}
 	f.assertEvents(path)
+	if kind := f.events[0].Kind(); kind != FileDeleted {
+		t.Fatalf("Got event kind %v (expected %v)", kind, FileDeleted)
+	}
 }
 
 func TestRemoveAndAddBack(t *testing.T) {
@@ -575,6 +608,22 @@ func TestIgnoreInitialDir(t *testing.T) {
 	assert.Equal(t, expectedWatches, int(numberOfWatches.Value()))
 }
 
+func TestChmodEvent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows doesn't have mode bits to change")
+	}
+	f := newNotifyFixture(t)
+	root := f.paths[0]
+	script := filepath.Join(root, "run.sh")
+	f.WriteFile(script, "#!/bin/sh")
+	f.fsync()
+	f.events = nil
+
+	require.NoError(t, os.Chmod(script, 0o755))
+	f.assertEvents(script)
+	assert.Equal(t, FileChmod, f.events[0].Kind())
+}
+
 func isRecursiveWatcher() bool {
 	return runtime.GOOS == "darwin" || runtime.GOOS == "windows"
 }
@@ -648,9 +697,8 @@ func (f *notifyFixture) assertEvents(expected ...string) {
 	}
 
 	for i, actual := range f.events {
-		e := FileEvent{expected[i]}
-		if actual != e {
-			f.T().Fatalf("Got event %v (expected %v)", actual, e)
+		if actual.Path() != expected[i] {
+			f.T().Fatalf("Got event %v (expected %v)", actual.Path(), expected[i])
 		}
 	}
 }
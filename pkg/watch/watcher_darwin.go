@@ -69,12 +69,29 @@ func (d *fseventNotify) loop() {
 					continue
 				}
 
-				d.events <- NewFileEvent(e.Path)
+				d.events <- NewFileEventWithKind(e.Path, fseventKind(e))
 			}
 		}
 	}
 }
 
+// fseventKind returns the kind of change of an event. As flags are coalesced for the changes of
+// a path in quick succession, a removed or renamed path is only deleted if it's gone.
+func fseventKind(e fsevents.Event) FileEventKind {
+	if e.Flags&(fsevents.ItemRemoved|fsevents.ItemRenamed) != 0 {
+		if _, err := os.Lstat(e.Path); os.IsNotExist(err) {
+			return FileDeleted
+		}
+	}
+	if e.Flags&fsevents.ItemCreated == fsevents.ItemCreated {
+		return FileCreated
+	}
+	if e.Flags&(fsevents.ItemInodeMetaMod|fsevents.ItemChangeOwner) != 0 && e.Flags&fsevents.ItemModified == 0 {
+		return FileChmod
+	}
+	return FileModified
+}
+
 // Add a path to be watched. Should only be called during initialization.
 func (d *fseventNotify) initAdd(name string) {
 	d.stream.Paths = append(d.stream.Paths, name)
@@ -169,14 +169,14 @@ func (d *naiveNotify) loop() { //nolint:gocyclo
 
 		if e.Op&fsnotify.Create != fsnotify.Create {
 			if d.shouldNotify(e.Name) {
-				d.wrappedEvents <- FileEvent{e.Name}
+				d.wrappedEvents <- FileEvent{e.Name, fsnotifyEventKind(e.Op)}
 			}
 			continue
 		}
 
 		if d.isWatcherRecursive {
 			if d.shouldNotify(e.Name) {
-				d.wrappedEvents <- FileEvent{e.Name}
+				d.wrappedEvents <- FileEvent{e.Name, FileCreated}
 			}
 			continue
 		}
@@ -192,7 +192,7 @@ func (d *naiveNotify) loop() { //nolint:gocyclo
 			}
 
 			if d.shouldNotify(path) {
-				d.wrappedEvents <- FileEvent{path}
+				d.wrappedEvents <- FileEvent{path, FileCreated}
 			}
 
 			// TODO(dmiller): symlinks 😭
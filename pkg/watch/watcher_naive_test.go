@@ -27,6 +27,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/tilt-dev/fsnotify"
 )
 
 func TestDontWatchEachFile(t *testing.T) {
@@ -157,3 +158,13 @@ func TestDontRecurseWhenWatchingParentsOfNonExistentFiles(t *testing.T) {
 		t.Fatalf("watching more than 5 files: %d", n)
 	}
 }
+
+func TestFsnotifyEventKind(t *testing.T) {
+	require.Equal(t, FileCreated, fsnotifyEventKind(fsnotify.Create))
+	require.Equal(t, FileCreated, fsnotifyEventKind(fsnotify.Create|fsnotify.Write))
+	require.Equal(t, FileModified, fsnotifyEventKind(fsnotify.Write))
+	require.Equal(t, FileChmod, fsnotifyEventKind(fsnotify.Chmod))
+	require.Equal(t, FileModified, fsnotifyEventKind(fsnotify.Write|fsnotify.Chmod))
+	require.Equal(t, FileDeleted, fsnotifyEventKind(fsnotify.Remove))
+	require.Equal(t, FileDeleted, fsnotifyEventKind(fsnotify.Rename))
+}
@@ -0,0 +1,216 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PollEnvVar selects the polling watcher when set to a true value, for file systems which don't
+// report changes (e.g. SMB or NFS mounts).
+const PollEnvVar = "COMPOSE_WATCH_POLL"
+
+// DefaultPollInterval is the delay between two scans of the polling watcher.
+const DefaultPollInterval = time.Second
+
+// IsUnsupportedError returns true for errors of native watchers which can't watch the paths, in
+// which case the polling watcher can be used instead.
+func IsUnsupportedError(err error) bool {
+	return errors.Is(err, errors.ErrUnsupported) ||
+		errors.Is(err, syscall.ENOSYS) ||
+		errors.Is(err, syscall.ENOTSUP) ||
+		errors.Is(err, syscall.EOPNOTSUPP)
+}
+
+// A watcher which periodically walks the watched paths and compares the modification time and
+// size of the files with the previous scan. It works on any file system, at the cost of latency
+// and of the I/O required by the scans.
+type pollNotify struct {
+	paths    []string
+	ignore   PathMatcher
+	interval time.Duration
+
+	// files are the paths found by the previous scan
+	files map[string]pollState
+
+	events chan FileEvent
+	errors chan error
+	stop   chan struct{}
+	once   sync.Once
+}
+
+type pollState struct {
+	modTime time.Time
+	size    int64
+	isDir   bool
+	mode    fs.FileMode
+}
+
+// NewPollingWatcher returns a watcher scanning the paths for changes every interval.
+func NewPollingWatcher(paths []string, ignore PathMatcher, interval time.Duration) (Notify, error) {
+	if ignore == nil {
+		return nil, fmt.Errorf("NewPollingWatcher: ignore is nil")
+	}
+	absPaths := make([]string, 0, len(paths))
+	for _, p := range paths {
+		p, err := filepath.Abs(p)
+		if err != nil {
+			return nil, fmt.Errorf("NewPollingWatcher: %w", err)
+		}
+		absPaths = append(absPaths, p)
+	}
+	return &pollNotify{
+		paths:    dedupePathsForRecursiveWatcher(absPaths),
+		ignore:   ignore,
+		interval: interval,
+		events:   make(chan FileEvent),
+		errors:   make(chan error),
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+func (p *pollNotify) Start() error {
+	// the initial scan is the reference for the changes, it doesn't produce any event
+	p.files = p.walk()
+	go p.loop()
+	return nil
+}
+
+func (p *pollNotify) Close() error {
+	p.once.Do(func() {
+		close(p.stop)
+	})
+	return nil
+}
+
+func (p *pollNotify) Events() chan FileEvent {
+	return p.events
+}
+
+func (p *pollNotify) Errors() chan error {
+	return p.errors
+}
+
+func (p *pollNotify) loop() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			for _, e := range p.scan() {
+				select {
+				case p.events <- e:
+				case <-p.stop:
+					return
+				}
+			}
+		}
+	}
+}
+
+// scan walks the watched paths and returns the changes since the previous scan.
+func (p *pollNotify) scan() []FileEvent {
+	files := p.walk()
+	var events []FileEvent
+	for path, current := range files {
+		previous, ok := p.files[path]
+		switch {
+		case !ok:
+			events = append(events, FileEvent{path, FileCreated})
+		case current.isDir != previous.isDir:
+			events = append(events, FileEvent{path, FileCreated})
+		case !current.isDir && (!current.modTime.Equal(previous.modTime) || current.size != previous.size):
+			// the modification time of a directory only reflects changes to its entries
+			events = append(events, FileEvent{path, FileModified})
+		case current.mode != previous.mode:
+			events = append(events, FileEvent{path, FileChmod})
+		}
+	}
+	for path := range p.files {
+		if _, ok := files[path]; !ok {
+			events = append(events, FileEvent{path, FileDeleted})
+		}
+	}
+	p.files = files
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].path < events[j].path
+	})
+	return events
+}
+
+// walk returns the state of all the paths below the watched paths, except the ignored ones and
+// the watched directories themselves.
+func (p *pollNotify) walk() map[string]pollState {
+	files := map[string]pollState{}
+	for _, root := range p.paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				skip, err := p.ignore.MatchesEntireDir(path)
+				if err != nil {
+					return err
+				}
+				if skip {
+					return filepath.SkipDir
+				}
+				if path == root {
+					// we don't care when directories change at the root of a watched path
+					return nil
+				}
+			}
+			ignored, err := p.ignore.Matches(path)
+			if err != nil {
+				return err
+			}
+			if ignored {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			files[path] = pollState{modTime: info.ModTime(), size: info.Size(), isDir: d.IsDir(), mode: info.Mode()}
+			return nil
+		})
+		if err != nil {
+			logrus.Debugf("Error scanning %s for changes: %v", root, err)
+		}
+	}
+	return files
+}
+
+var _ Notify = &pollNotify{}
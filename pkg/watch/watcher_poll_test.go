@@ -0,0 +1,128 @@
+/*
+   Copyright 2023 Docker Compose CLI authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPollingWatcher_Scan(t *testing.T) {
+	f := NewTempDirFixture(t)
+	f.WriteFile("src/main.go", "package main")
+	f.WriteFile("src/lib/util.go", "package lib")
+	f.WriteFile("src/deleted.go", "package main")
+	f.WriteFile("src/node_modules/dep/index.js", "")
+
+	ignore, err := NewDockerPatternMatcher(f.Path(), []string{"src/node_modules"})
+	require.NoError(t, err)
+	w, err := NewPollingWatcher([]string{f.JoinPath("src"), f.JoinPath("missing")}, ignore, time.Hour)
+	require.NoError(t, err)
+	p := w.(*pollNotify)
+	require.NoError(t, p.Start())
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+	assert.Empty(t, p.scan(), "no changes since the initial scan")
+
+	// modified content with the same size, only the modification time changes
+	f.WriteFile("src/main.go", "package niam")
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(f.JoinPath("src", "main.go"), later, later))
+	f.WriteFile("src/lib/added.go", "package lib")
+	f.WriteFile("src/lib/new/sub.go", "package sub")
+	f.Rm("src/deleted.go")
+	f.WriteFile("src/node_modules/dep/other.js", "")
+
+	assert.Equal(t, []FileEvent{
+		{f.JoinPath("src", "deleted.go"), FileDeleted},
+		{f.JoinPath("src", "lib", "added.go"), FileCreated},
+		{f.JoinPath("src", "lib", "new"), FileCreated},
+		{f.JoinPath("src", "lib", "new", "sub.go"), FileCreated},
+		{f.JoinPath("src", "main.go"), FileModified},
+	}, p.scan())
+	assert.Empty(t, p.scan(), "changes are only reported once")
+
+	require.NoError(t, os.Chmod(f.JoinPath("src", "main.go"), 0o400))
+	assert.Equal(t, []FileEvent{
+		{f.JoinPath("src", "main.go"), FileChmod},
+	}, p.scan(), "a change of mode only is reported as such")
+
+	f.Rm("src/lib")
+	f.WriteFile("missing/created.txt", "")
+	assert.Equal(t, []FileEvent{
+		{f.JoinPath("missing", "created.txt"), FileCreated},
+		{f.JoinPath("src", "lib"), FileDeleted},
+		{f.JoinPath("src", "lib", "added.go"), FileDeleted},
+		{f.JoinPath("src", "lib", "new"), FileDeleted},
+		{f.JoinPath("src", "lib", "new", "sub.go"), FileDeleted},
+		{f.JoinPath("src", "lib", "util.go"), FileDeleted},
+	}, p.scan())
+}
+
+func TestPollingWatcher_Events(t *testing.T) {
+	f := NewTempDirFixture(t)
+	f.MkdirAll("src")
+
+	w, err := NewPollingWatcher([]string{f.JoinPath("src")}, EmptyMatcher{}, 10*time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, w.Start())
+	t.Cleanup(func() {
+		_ = w.Close()
+	})
+
+	f.WriteFile("src/main.go", "package main")
+	select {
+	case e := <-w.Events():
+		assert.Equal(t, NewFileEventWithKind(f.JoinPath("src", "main.go"), FileCreated), e)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the change to be detected")
+	}
+}
+
+func TestPollingWatcher_NonexistentPath(t *testing.T) {
+	f := NewTempDirFixture(t)
+
+	w, err := NewPollingWatcher([]string{f.JoinPath("dist")}, EmptyMatcher{}, 10*time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, w.Start())
+	t.Cleanup(func() {
+		_ = w.Close()
+	})
+
+	f.WriteFile("dist/index.html", "<html></html>")
+	select {
+	case e := <-w.Events():
+		assert.Equal(t, NewFileEventWithKind(f.JoinPath("dist", "index.html"), FileCreated), e)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the created path to be detected")
+	}
+}
+
+func TestIsUnsupportedError(t *testing.T) {
+	assert.True(t, IsUnsupportedError(fmt.Errorf("watching /mnt/share: %w", syscall.ENOSYS)))
+	assert.True(t, IsUnsupportedError(errors.ErrUnsupported))
+	assert.False(t, IsUnsupportedError(syscall.EMFILE))
+	assert.False(t, IsUnsupportedError(errors.New("boom")))
+}